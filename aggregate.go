@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== PRICE ROLLUPS ====================
+
+// Aggregation levels of /api/prices/aggregate.
+const (
+	LevelCounty   = "county"
+	LevelRegion   = "region"
+	LevelNational = "national"
+)
+
+// nationalArea names the single area of the national level.
+const nationalArea = "Kenya"
+
+// aggregateKey identifies one rolled-up series. Prices are only pooled
+// across markets in the same normalized unit and price type.
+type aggregateKey struct {
+	Area        string
+	Region      string // parent region of a county
+	CommodityID int
+	Unit        string // normalized unit
+	PriceType   PriceType
+}
+
+// marketMonth accumulates one market's prices of a month.
+type marketMonth struct {
+	sum          float64
+	count        int
+	estimated    bool
+	cpiEstimated bool
+}
+
+// MarketPrice is the price of one market in a rollup.
+type MarketPrice struct {
+	MarketID int     `json:"market_id"`
+	Market   string  `json:"market"`
+	Price    float64 `json:"price"`
+}
+
+// AggregatePoint is one month of a rolled-up series. Each market counts
+// once, with the mean of its prices that month.
+type AggregatePoint struct {
+	Month        string      `json:"month"` // "YYYY-MM"
+	Label        string      `json:"label"` // e.g. "Jan 2025"
+	Median       float64     `json:"median"`
+	Mean         float64     `json:"mean"`
+	Markets      int         `json:"markets"` // markets reporting that month
+	Min          MarketPrice `json:"min"`
+	Max          MarketPrice `json:"max"`
+	Estimated    bool        `json:"estimated"` // some normalization used a typical weight or density
+	CPIEstimated bool        `json:"cpi_estimated,omitempty"`
+}
+
+// AggregateSeries is a commodity's price across the markets of an area.
+type AggregateSeries struct {
+	Area        string           `json:"area"`
+	Region      string           `json:"region,omitempty"` // set at county level
+	CommodityID int              `json:"commodity_id"`
+	Commodity   string           `json:"commodity"`
+	Canonical   string           `json:"canonical,omitempty"`
+	Unit        string           `json:"unit"` // normalized unit
+	PriceType   string           `json:"price_type"`
+	Points      []AggregatePoint `json:"points"`
+}
+
+// AggregateResponse is the body of /api/prices/aggregate.
+type AggregateResponse struct {
+	Level    string            `json:"level"`
+	Currency string            `json:"currency"`
+	Real     bool              `json:"real"`
+	BaseYear int               `json:"base_year,omitempty"`
+	From     string            `json:"from,omitempty"`
+	To       string            `json:"to,omitempty"`
+	Series   []AggregateSeries `json:"series"`
+}
+
+// areaOf returns the area and parent region of market at level.
+func areaOf(market *MarketData, level string) (area, region string) {
+	switch level {
+	case LevelCounty:
+		return market.Admin2, market.Admin1
+	case LevelRegion:
+		return market.Admin1, ""
+	}
+	return nationalArea, ""
+}
+
+// aggregatePoint rolls up the markets of one month.
+func aggregatePoint(foodData *FoodData, month string, markets map[int]*marketMonth) AggregatePoint {
+	point := AggregatePoint{
+		Month:   month,
+		Label:   formatMonth(month + "-01"),
+		Markets: len(markets),
+	}
+	ids := make([]int, 0, len(markets))
+	for id := range markets {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	values := make([]float64, 0, len(ids))
+	sum := 0.0
+	for i, id := range ids {
+		m := markets[id]
+		price := MarketPrice{MarketID: id, Price: m.sum / float64(m.count)}
+		if market, ok := foodData.MarketByID(id); ok {
+			price.Market = market.Name
+		}
+		if i == 0 || price.Price < point.Min.Price {
+			point.Min = price
+		}
+		if i == 0 || price.Price > point.Max.Price {
+			point.Max = price
+		}
+		values = append(values, price.Price)
+		sum += price.Price
+		point.Estimated = point.Estimated || m.estimated
+		point.CPIEstimated = point.CPIEstimated || m.cpiEstimated
+	}
+	point.Median = median(values)
+	point.Mean = sum / float64(len(values))
+	return point
+}
+
+// aggregateHandler serves /api/prices/aggregate?level=county|region|national
+// (default national). commodity= (with variants=), pricetype=, region=,
+// county=, from= and to= narrow the series; every month is kept.
+func aggregateHandler(store *DatasetStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		foodData := store.Load()
+		opts, err := parsePriceOptions(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		expand, err := parseVariantsParam(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		priceType, err := parsePriceTypeParam(c.Query("pricetype"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		level := strings.ToLower(c.DefaultQuery("level", LevelNational))
+		switch level {
+		case LevelCounty, LevelRegion, LevelNational:
+		default:
+			c.JSON(400, gin.H{"error": fmt.Sprintf("unsupported level %q (use county, region or national)", level)})
+			return
+		}
+		from, to := c.Query("from"), c.Query("to")
+		for _, d := range []string{from, to} {
+			if d != "" && !datePattern.MatchString(d) {
+				c.JSON(400, gin.H{"error": fmt.Sprintf("invalid date %q (use YYYY, YYYY-MM or YYYY-MM-DD)", d)})
+				return
+			}
+		}
+		terms := splitTerms(c.Query("commodity"))
+		filter := foodData.Taxonomy.Filter(terms, expand)
+		if len(terms) > 0 && len(filter) == 0 {
+			c.JSON(404, gin.H{"error": "Commodity not found"})
+			return
+		}
+		county, region := c.Query("county"), c.Query("region")
+
+		months := make(map[aggregateKey]map[string]map[int]*marketMonth)
+		names := make(map[int]string)
+		for _, series := range foodData.AllSeries() {
+			if !filter.Match(series.Key.CommodityID) || (priceType != nil && series.Key.PriceType != *priceType) {
+				continue
+			}
+			market, ok := foodData.MarketByID(series.Key.MarketID)
+			if !ok ||
+				(county != "" && !strings.EqualFold(market.Admin2, county)) ||
+				(region != "" && !strings.EqualFold(market.Admin1, region)) {
+				continue
+			}
+			area, parent := areaOf(market, level)
+			names[series.Key.CommodityID] = series.Name
+
+			for _, comm := range series.Between(from, to) {
+				key := aggregateKey{
+					Area:        area,
+					Region:      parent,
+					CommodityID: comm.CommodityID,
+					Unit:        comm.NormalizedUnit,
+					PriceType:   comm.PriceType,
+				}
+				price, cpiEstimated, err := opts.convert(foodData, comm.NormalizedPrice, comm.Currency, comm.Date)
+				if err != nil {
+					c.JSON(422, gin.H{"error": err.Error()})
+					return
+				}
+
+				byMonth, ok := months[key]
+				if !ok {
+					byMonth = make(map[string]map[int]*marketMonth)
+					months[key] = byMonth
+				}
+				month := monthOf(comm.Date)
+				if byMonth[month] == nil {
+					byMonth[month] = make(map[int]*marketMonth)
+				}
+				m := byMonth[month][market.ID]
+				if m == nil {
+					m = &marketMonth{}
+					byMonth[month][market.ID] = m
+				}
+				m.sum += price
+				m.count++
+				m.estimated = m.estimated || comm.UnitEstimated
+				m.cpiEstimated = m.cpiEstimated || cpiEstimated
+			}
+		}
+
+		response := AggregateResponse{
+			Level:    level,
+			Currency: opts.Currency.String(),
+			Real:     opts.Real,
+			From:     from,
+			To:       to,
+			Series:   []AggregateSeries{},
+		}
+		if opts.Real {
+			response.BaseYear = opts.baseYear(foodData)
+		}
+		for key, byMonth := range months {
+			series := AggregateSeries{
+				Area:        key.Area,
+				Region:      key.Region,
+				CommodityID: key.CommodityID,
+				Commodity:   foodData.Taxonomy.LocalName(key.CommodityID, names[key.CommodityID], opts.Lang),
+				Unit:        key.Unit,
+				PriceType:   key.PriceType.String(),
+				Points:      []AggregatePoint{},
+			}
+			if v, ok := foodData.Taxonomy.Variant(key.CommodityID); ok {
+				series.Canonical = v.Canonical.ID
+			}
+			list := make([]string, 0, len(byMonth))
+			for month := range byMonth {
+				list = append(list, month)
+			}
+			sort.Strings(list)
+			for _, month := range list {
+				series.Points = append(series.Points, aggregatePoint(foodData, month, byMonth[month]))
+			}
+			response.Series = append(response.Series, series)
+		}
+
+		sort.Slice(response.Series, func(i, j int) bool {
+			a, b := response.Series[i], response.Series[j]
+			switch {
+			case a.Area != b.Area:
+				return a.Area < b.Area
+			case a.Region != b.Region: // counties of the same name
+				return a.Region < b.Region
+			case a.Commodity != b.Commodity:
+				return a.Commodity < b.Commodity
+			case a.CommodityID != b.CommodityID:
+				return a.CommodityID < b.CommodityID
+			case a.Unit != b.Unit:
+				return a.Unit < b.Unit
+			}
+			return a.PriceType < b.PriceType
+		})
+		c.JSON(200, response)
+	}
+}
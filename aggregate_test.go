@@ -0,0 +1,117 @@
+package main
+
+import (
+	"testing"
+)
+
+// aggregateRows price maize at five markets. Market 1 reports retail twice
+// in January, and market 5 is in a county named like the one of markets 1
+// and 2 but in another region.
+const aggregateRows = `2024-01-01,Coast,Mombasa,Kongowea,1,-4.04,39.68,cereals and tubers,Maize,51,KG,actual,Retail,KES,40,0.3
+2024-01-15,Coast,Mombasa,Kongowea,1,-4.04,39.68,cereals and tubers,Maize,51,KG,actual,Retail,KES,60,0.46
+2024-01-15,Coast,Mombasa,Marikiti,2,-4.06,39.66,cereals and tubers,Maize,51,KG,actual,Retail,KES,30,0.23
+2024-01-15,Coast,Kilifi,Kilifi,3,-3.63,39.85,cereals and tubers,Maize,51,KG,actual,Retail,KES,90,0.69
+2024-01-15,Nairobi,Nairobi,Kibera,4,-1.31,36.78,cereals and tubers,Maize,51,KG,actual,Retail,KES,100,0.77
+2024-01-15,Eastern,Mombasa,Mombasa Junction,5,-0.5,38.0,cereals and tubers,Maize,51,KG,actual,Retail,KES,10,0.08
+2024-02-15,Coast,Mombasa,Kongowea,1,-4.04,39.68,cereals and tubers,Maize,51,KG,actual,Retail,KES,45,0.35
+2024-01-15,Coast,Mombasa,Kongowea,1,-4.04,39.68,cereals and tubers,Maize,51,KG,actual,Wholesale,KES,20,0.15
+2024-01-15,Coast,Mombasa,Marikiti,2,-4.06,39.66,cereals and tubers,Maize,51,KG,actual,Wholesale,KES,25,0.19
+`
+
+func TestAggregateRollup(t *testing.T) {
+	srv := newTestServerOn(t, csvTestStore(t, aggregateRows))
+
+	var resp AggregateResponse
+	if code := getJSON(t, srv.router, "/api/prices/aggregate?commodity=maize", &resp); code != 200 {
+		t.Fatalf("status %d", code)
+	}
+	if len(resp.Series) != 2 {
+		t.Fatalf("%d series, want retail and wholesale", len(resp.Series))
+	}
+	retail, wholesale := resp.Series[0], resp.Series[1]
+	if retail.PriceType != "Retail" || wholesale.PriceType != "Wholesale" || retail.Area != nationalArea {
+		t.Fatalf("series %s %s and %s %s", retail.Area, retail.PriceType, wholesale.Area, wholesale.PriceType)
+	}
+	if len(retail.Points) != 2 || retail.Points[0].Month != "2024-01" || retail.Points[1].Month != "2024-02" {
+		t.Fatalf("retail points %+v", retail.Points)
+	}
+
+	tests := []struct {
+		name     string
+		point    AggregatePoint
+		median   float64
+		mean     float64
+		markets  int
+		min, max MarketPrice
+	}{
+		// Kongowea counts once, at the mean of 40 and 60
+		{"retail", retail.Points[0], 50, 56, 5,
+			MarketPrice{5, "Mombasa Junction", 10}, MarketPrice{4, "Kibera", 100}},
+		{"one market", retail.Points[1], 45, 45, 1,
+			MarketPrice{1, "Kongowea", 45}, MarketPrice{1, "Kongowea", 45}},
+		{"wholesale", wholesale.Points[0], 22.5, 22.5, 2,
+			MarketPrice{1, "Kongowea", 20}, MarketPrice{2, "Marikiti", 25}},
+	}
+	for _, tt := range tests {
+		p := tt.point
+		if p.Median != tt.median || p.Mean != tt.mean || p.Markets != tt.markets || p.Min != tt.min || p.Max != tt.max {
+			t.Errorf("%s: median %v mean %v of %d markets, min %+v max %+v; want %v %v of %d, %+v %+v",
+				tt.name, p.Median, p.Mean, p.Markets, p.Min, p.Max, tt.median, tt.mean, tt.markets, tt.min, tt.max)
+		}
+	}
+
+	if code := getJSON(t, srv.router, "/api/prices/aggregate?commodity=maize&pricetype=wholesale", &resp); code != 200 {
+		t.Fatalf("pricetype=wholesale: status %d", code)
+	}
+	if len(resp.Series) != 1 || resp.Series[0].PriceType != "Wholesale" || resp.Series[0].Points[0].Markets != 2 {
+		t.Errorf("pricetype=wholesale: %+v", resp.Series)
+	}
+}
+
+func TestAggregateLevels(t *testing.T) {
+	srv := newTestServerOn(t, csvTestStore(t, aggregateRows))
+
+	type area struct{ area, region, priceType string }
+	tests := []struct {
+		level string
+		want  []area
+	}{
+		{"national", []area{{"Kenya", "", "Retail"}, {"Kenya", "", "Wholesale"}}},
+		{"region", []area{
+			{"Coast", "", "Retail"}, {"Coast", "", "Wholesale"},
+			{"Eastern", "", "Retail"}, {"Nairobi", "", "Retail"},
+		}},
+		// The two Mombasa counties stay apart, ordered by region
+		{"county", []area{
+			{"Kilifi", "Coast", "Retail"},
+			{"Mombasa", "Coast", "Retail"}, {"Mombasa", "Coast", "Wholesale"},
+			{"Mombasa", "Eastern", "Retail"},
+			{"Nairobi", "Nairobi", "Retail"},
+		}},
+	}
+	for _, tt := range tests {
+		var resp AggregateResponse
+		if code := getJSON(t, srv.router, "/api/prices/aggregate?commodity=maize&level="+tt.level, &resp); code != 200 {
+			t.Fatalf("level=%s: status %d", tt.level, code)
+		}
+		var got []area
+		for _, s := range resp.Series {
+			got = append(got, area{s.Area, s.Region, s.PriceType})
+		}
+		if len(got) != len(tt.want) {
+			t.Fatalf("level=%s: series %v, want %v", tt.level, got, tt.want)
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("level=%s: series %v, want %v", tt.level, got, tt.want)
+				break
+			}
+		}
+	}
+
+	for _, query := range []string{"level=street", "from=January", "commodity=tractor"} {
+		if code := getJSON(t, srv.router, "/api/prices/aggregate?"+query, nil); code == 200 {
+			t.Errorf("%s: status 200", query)
+		}
+	}
+}
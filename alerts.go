@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== PRICE SPIKE ALERTS (ALPS) ====================
+
+// ALPS levels, from WFP's Alert for Price Spikes methodology.
+const (
+	AlertNormal = "normal"
+	AlertStress = "stress"
+	AlertAlert  = "alert"
+	AlertCrisis = "crisis"
+)
+
+var alertLevels = []string{AlertNormal, AlertStress, AlertAlert, AlertCrisis}
+
+const (
+	alertMinMonths = 36 // months with a price needed to fit the seasonal trend
+	alertMaxGap    = 2  // longest gap, in months, filled by interpolation
+)
+
+// alertLevel classifies an ALPS indicator: below 0.25 is normal, then
+// stress up to 1, alert up to 2 and crisis beyond.
+func alertLevel(indicator float64) string {
+	switch {
+	case indicator >= 2:
+		return AlertCrisis
+	case indicator >= 1:
+		return AlertAlert
+	case indicator >= 0.25:
+		return AlertStress
+	}
+	return AlertNormal
+}
+
+// PriceAlert is the ALPS indicator of the latest month of one series.
+type PriceAlert struct {
+	Market    MarketSummary `json:"market"`
+	Series    SeriesInfo    `json:"series"`
+	Canonical string        `json:"canonical"`
+	Month     string        `json:"month"`    // "YYYY-MM" of the latest price
+	Price     float64       `json:"price"`    // mean normalized price of that month
+	Expected  float64       `json:"expected"` // seasonal trend for that month
+	Unit      string        `json:"unit"`
+	Indicator float64       `json:"indicator"` // (price - expected) / residual sd
+	Level     string        `json:"level"`
+	Months    int           `json:"months"` // months the model was fitted on
+
+	currency Currency
+}
+
+// seasonalTrend is ALPS's model of a monthly series: a linear trend plus
+// one dummy per calendar month, fitted by least squares.
+type seasonalTrend struct {
+	beta   []float64
+	dummy  map[int]int // calendar month -> column; the first month seen is the baseline
+	sd     float64     // standard deviation of the residuals
+	months int
+}
+
+// row returns the regressors of month i of m.
+func (s seasonalTrend) row(m MonthlySeries, i int) []float64 {
+	row := make([]float64, 2+len(s.dummy))
+	row[0] = 1
+	row[1] = float64(i) / 12 // years, to keep the normal equations well scaled
+	if col, ok := s.dummy[m.calendarMonth(i)]; ok {
+		row[col] = 1
+	}
+	return row
+}
+
+// fitSeasonalTrend fits the ALPS model to the months of m with a value.
+func fitSeasonalTrend(m MonthlySeries) (seasonalTrend, error) {
+	s := seasonalTrend{dummy: make(map[int]int)}
+	baseline := 0
+	for i, v := range m.Values {
+		if math.IsNaN(v) {
+			continue
+		}
+		cm := m.calendarMonth(i)
+		if baseline == 0 {
+			baseline = cm
+		}
+		if _, ok := s.dummy[cm]; !ok && cm != baseline {
+			s.dummy[cm] = 2 + len(s.dummy)
+		}
+	}
+
+	var x [][]float64
+	var y []float64
+	for i, v := range m.Values {
+		if !math.IsNaN(v) {
+			x = append(x, s.row(m, i))
+			y = append(y, v)
+		}
+	}
+	k := 2 + len(s.dummy)
+	if len(y) <= k {
+		return s, fmt.Errorf("%d months for %d coefficients", len(y), k)
+	}
+	beta, err := olsFit(x, y)
+	if err != nil {
+		return s, err
+	}
+	s.beta = beta
+	s.months = len(y)
+
+	ssr := 0.0
+	for r := range x {
+		e := y[r] - dot(beta, x[r])
+		ssr += e * e
+	}
+	s.sd = math.Sqrt(ssr / float64(len(y)-k))
+	return s, nil
+}
+
+// predict returns the model's value for month i of m.
+func (s seasonalTrend) predict(m MonthlySeries, i int) float64 {
+	return dot(s.beta, s.row(m, i))
+}
+
+// computeAlerts evaluates ALPS for the latest month of every series with
+// enough history, highest indicator first.
+func computeAlerts(foodData *FoodData) []PriceAlert {
+	var alerts []PriceAlert
+	for _, series := range foodData.AllSeries() {
+		m := monthlySeries(series.Observations, alertMaxGap)
+		if m.Valid() < alertMinMonths {
+			continue
+		}
+		model, err := fitSeasonalTrend(m)
+		if err != nil || model.sd <= 0 {
+			continue
+		}
+
+		last := len(m.Values) - 1
+		expected := model.predict(m, last)
+		indicator := (m.Values[last] - expected) / model.sd
+
+		alert := PriceAlert{
+			Series:    series.Info(),
+			Month:     m.Month(last),
+			Price:     m.Values[last],
+			Expected:  expected,
+			Unit:      series.Latest().NormalizedUnit,
+			Indicator: math.Round(indicator*100) / 100,
+			Level:     alertLevel(indicator),
+			Months:    model.months,
+			currency:  series.Latest().Currency,
+		}
+		if market, ok := foodData.MarketByID(series.Key.MarketID); ok {
+			alert.Market = market.Summary()
+		}
+		if v, ok := foodData.Taxonomy.Variant(series.Key.CommodityID); ok {
+			alert.Canonical = v.Canonical.ID
+		}
+		alerts = append(alerts, alert)
+	}
+	sort.SliceStable(alerts, func(i, j int) bool { return alerts[i].Indicator > alerts[j].Indicator })
+	return alerts
+}
+
+// ==================== ALERTS ENDPOINT ====================
+
+// AlertsResponse is the body of /api/alerts.
+type AlertsResponse struct {
+	LatestMonth string         `json:"latest_month"` // latest month of the dataset
+	Currency    string         `json:"currency"`
+	Counts      map[string]int `json:"counts"` // alerts per level, after filters
+	Alerts      []PriceAlert   `json:"alerts"`
+}
+
+// alertsHandler serves /api/alerts. Filters: county=, region=,
+// commodity= (with variants=), pricetype=, level= (the lowest level to
+// return) and max_age_months= (default 3) against the dataset's latest
+// month.
+func alertsHandler(store *DatasetStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		foodData := store.Load()
+		opts, err := parsePriceOptions(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		expand, err := parseVariantsParam(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		priceType, err := parsePriceTypeParam(c.Query("pricetype"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		minLevel := 0
+		if v := strings.ToLower(c.Query("level")); v != "" {
+			if minLevel = slices.Index(alertLevels, v); minLevel < 0 {
+				c.JSON(400, gin.H{"error": fmt.Sprintf("unsupported level %q (use normal, stress, alert or crisis)", v)})
+				return
+			}
+		}
+		maxAge := 3
+		if v := c.Query("max_age_months"); v != "" {
+			if maxAge, err = strconv.Atoi(v); err != nil || maxAge < 0 {
+				c.JSON(400, gin.H{"error": fmt.Sprintf("invalid max_age_months %q", v)})
+				return
+			}
+		}
+		filter := foodData.Taxonomy.Filter(splitTerms(c.Query("commodity")), expand)
+		county, region := c.Query("county"), c.Query("region")
+
+		latest := monthOf(foodData.LastDate())
+		response := AlertsResponse{
+			LatestMonth: latest,
+			Currency:    opts.Currency.String(),
+			Counts:      make(map[string]int),
+			Alerts:      []PriceAlert{},
+		}
+		for _, level := range alertLevels {
+			response.Counts[level] = 0
+		}
+		for _, alert := range foodData.Alerts {
+			switch {
+			case county != "" && !strings.EqualFold(alert.Market.Admin2, county):
+			case region != "" && !strings.EqualFold(alert.Market.Admin1, region):
+			case !filter.Match(alert.Series.CommodityID):
+			case priceType != nil && alert.Series.PriceType != priceType.String():
+			case slices.Index(alertLevels, alert.Level) < minLevel:
+			case monthsBetween(alert.Month, latest) > maxAge:
+			default:
+				date := alert.Month + "-15"
+				if alert.Price, _, err = opts.convert(foodData, alert.Price, alert.currency, date); err == nil {
+					alert.Expected, _, err = opts.convert(foodData, alert.Expected, alert.currency, date)
+				}
+				if err != nil {
+					c.JSON(422, gin.H{"error": err.Error()})
+					return
+				}
+				alert.Series = foodData.Taxonomy.localizeSeries(alert.Series, opts.Lang)
+				response.Counts[alert.Level]++
+				response.Alerts = append(response.Alerts, alert)
+			}
+		}
+		c.JSON(200, response)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestAlertLevel(t *testing.T) {
+	tests := []struct {
+		indicator float64
+		want      string
+	}{
+		{-3, AlertNormal},
+		{0, AlertNormal},
+		{0.2499, AlertNormal},
+		{0.25, AlertStress},
+		{0.99, AlertStress},
+		{1, AlertAlert},
+		{1.99, AlertAlert},
+		{2, AlertCrisis},
+		{5, AlertCrisis},
+	}
+	for _, tt := range tests {
+		if got := alertLevel(tt.indicator); got != tt.want {
+			t.Errorf("alertLevel(%v) = %s, want %s", tt.indicator, got, tt.want)
+		}
+	}
+}
+
+// seasonalSeries is 100 + 12 a year + a seasonal swing peaking in
+// August, from January 2020, with the months in gaps missing.
+func seasonalSeries(months int, gaps ...int) MonthlySeries {
+	m := MonthlySeries{Start: "2020-01"}
+	for i := 0; i < months; i++ {
+		v := 100 + float64(i) + 20*math.Sin(2*math.Pi*float64(i-4)/12)
+		if slices.Contains(gaps, i) {
+			v = math.NaN()
+		}
+		m.Values = append(m.Values, v)
+		m.Filled = append(m.Filled, false)
+	}
+	return m
+}
+
+func TestFitSeasonalTrend(t *testing.T) {
+	julys := []int{6, 18, 30, 42}
+	tests := []struct {
+		name    string
+		m       MonthlySeries
+		dummies int
+		ok      bool
+	}{
+		{"four years", seasonalSeries(48), 11, true},
+		{"with gaps", seasonalSeries(48, 3, 10, 11, 25), 11, true},
+		{"a calendar month never observed", seasonalSeries(48, julys...), 10, true},
+		{"starting after the first month", seasonalSeries(48, 0, 1), 11, true},
+		{"as many months as coefficients", seasonalSeries(13), 11, false},
+		{"one more month", seasonalSeries(14), 11, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model, err := fitSeasonalTrend(tt.m)
+			if (err == nil) != tt.ok {
+				t.Fatalf("got %v, want ok=%v", err, tt.ok)
+			}
+			if len(model.dummy) != tt.dummies {
+				t.Errorf("%d month dummies, want %d", len(model.dummy), tt.dummies)
+			}
+			if !tt.ok {
+				return
+			}
+			if model.months != tt.m.Valid() {
+				t.Errorf("fitted on %d months, want %d", model.months, tt.m.Valid())
+			}
+			// The data is exactly trend plus season, so the fit is too
+			if math.Abs(model.beta[1]-12) > 1e-6 || model.sd > 1e-6 {
+				t.Errorf("trend %v a year, sd %v; want 12 and 0", model.beta[1], model.sd)
+			}
+			full := seasonalSeries(len(tt.m.Values))
+			for i, v := range full.Values {
+				if _, ok := model.dummy[tt.m.calendarMonth(i)]; !ok && math.IsNaN(tt.m.Values[i]) {
+					continue // a calendar month never observed has no seasonal term
+				}
+				if got := model.predict(tt.m, i); math.Abs(got-v) > 1e-6 {
+					t.Errorf("month %d: predicted %v, want %v", i, got, v)
+				}
+			}
+		})
+	}
+}
+
+func TestFitSeasonalTrendIndicator(t *testing.T) {
+	// Residuals of ±1 that don't follow the seasons
+	m := seasonalSeries(48)
+	for i := range m.Values {
+		if i%5 < 2 {
+			m.Values[i]++
+		} else {
+			m.Values[i]--
+		}
+	}
+	indicator := func() float64 {
+		t.Helper()
+		model, err := fitSeasonalTrend(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if model.sd < 0.5 || model.sd > 2 {
+			t.Fatalf("sd %v, want about 1", model.sd)
+		}
+		last := len(m.Values) - 1
+		return (m.Values[last] - model.predict(m, last)) / model.sd
+	}
+
+	if got := indicator(); alertLevel(got) != AlertNormal && alertLevel(got) != AlertStress {
+		t.Errorf("noise alone: indicator %v", got)
+	}
+	m.Values[len(m.Values)-1] += 10
+	if got := indicator(); alertLevel(got) != AlertCrisis {
+		t.Errorf("a spike of 10: indicator %v, want a crisis", got)
+	}
+}
+
+func TestComputeAlerts(t *testing.T) {
+	alerts := computeAlerts(testStore(t).Load())
+	if len(alerts) == 0 {
+		t.Fatal("no alerts on the shipped data")
+	}
+	for i, a := range alerts {
+		if a.Months < alertMinMonths {
+			t.Errorf("%s in market %d fitted on %d months", a.Series.Commodity, a.Series.MarketID, a.Months)
+		}
+		if !slices.Contains(alertLevels, a.Level) {
+			t.Errorf("unknown level %q", a.Level)
+		}
+		if i > 0 && a.Indicator > alerts[i-1].Indicator {
+			t.Fatalf("alert %d has a higher indicator than the one before", i)
+		}
+	}
+}
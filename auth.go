@@ -0,0 +1,600 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== SMS ====================
+
+// SMSSender delivers text messages. Production deployments plug in their
+// SMS gateway; the log and file senders are for development.
+type SMSSender interface {
+	Send(phone, message string) error
+}
+
+// logSMSSender writes messages to the server log.
+type logSMSSender struct{}
+
+func (logSMSSender) Send(phone, message string) error {
+	log.Printf("📱 SMS to %s: %s", phone, message)
+	return nil
+}
+
+// fileSMSSender appends messages to a file, one line each.
+type fileSMSSender struct {
+	path string
+	mu   sync.Mutex
+}
+
+func (s *fileSMSSender) Send(phone, message string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f, "%s\t%s\t%s\n", time.Now().UTC().Format(time.RFC3339), phone, message)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// newSMSSender returns the sender named by the sms_sender setting.
+func newSMSSender(kind, path string) (SMSSender, error) {
+	switch kind {
+	case "log":
+		return logSMSSender{}, nil
+	case "file":
+		return &fileSMSSender{path: path}, nil
+	}
+	return nil, fmt.Errorf("unsupported sms sender %q (use log or file)", kind)
+}
+
+// ==================== RATE LIMITING ====================
+
+// rateLimiter allows limit hits per key in any sliding window.
+type rateLimiter struct {
+	limit  int
+	window time.Duration
+
+	mu   sync.Mutex
+	hits map[string][]time.Time
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{limit: limit, window: window, hits: make(map[string][]time.Time)}
+}
+
+// Allow records a hit of key at now if the key is under its limit.
+// Otherwise it returns how long until the next hit is allowed.
+func (r *rateLimiter) Allow(key string, now time.Time) (bool, time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Forget idle keys now and then, so the map can't grow without bound
+	if len(r.hits) > 10000 {
+		for k, hits := range r.hits {
+			if now.Sub(hits[len(hits)-1]) >= r.window {
+				delete(r.hits, k)
+			}
+		}
+	}
+	hits := r.hits[key]
+	for len(hits) > 0 && now.Sub(hits[0]) >= r.window {
+		hits = hits[1:]
+	}
+	if len(hits) >= r.limit {
+		r.hits[key] = hits
+		return false, hits[0].Add(r.window).Sub(now)
+	}
+	r.hits[key] = append(hits, now)
+	return true, 0
+}
+
+// ==================== PHONE SIGN-IN ====================
+
+const (
+	otpDigits       = 6
+	otpTTL          = 5 * time.Minute
+	maxOTPAttempts  = 5
+	accessTokenTTL  = time.Hour
+	refreshTokenTTL = 30 * 24 * time.Hour
+	// How long the refresh token a refresh replaced is refused without
+	// ending the session, for a concurrent refresh of the same device
+	refreshReuseGrace = time.Minute
+)
+
+// normalizePhone returns a Kenyan mobile number in E.164 form, accepting
+// "0712 345 678", "712345678", "254712345678" and "+254712345678".
+func normalizePhone(s string) (string, error) {
+	digits := strings.NewReplacer(" ", "", "-", "", "(", "", ")", "").Replace(strings.TrimSpace(s))
+	digits = strings.TrimPrefix(digits, "+")
+	switch {
+	case strings.HasPrefix(digits, "254"):
+		digits = digits[3:]
+	case strings.HasPrefix(digits, "0"):
+		digits = digits[1:]
+	}
+	if len(digits) != 9 || (digits[0] != '7' && digits[0] != '1') {
+		return "", fmt.Errorf("invalid phone number %q (use a Kenyan mobile number, e.g. 0712345678)", s)
+	}
+	if _, err := strconv.Atoi(digits); err != nil {
+		return "", fmt.Errorf("invalid phone number %q (use a Kenyan mobile number, e.g. 0712345678)", s)
+	}
+	return "+254" + digits, nil
+}
+
+// pendingOTP is a code sent to a phone and not used yet.
+type pendingOTP struct {
+	hash      [32]byte
+	expiresAt time.Time
+	attempts  int
+}
+
+// tokenClaims is the payload of an access token.
+type tokenClaims struct {
+	UserID    string `json:"sub"`
+	SessionID string `json:"sid"`
+	Phone     string `json:"phone"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// Auth signs users in with one-time codes sent to their phone, and issues
+// the session tokens of the API: short-lived access tokens, signed with
+// key, and refresh tokens that rotate on every use.
+type Auth struct {
+	users    UserRepository
+	sessions SessionRepository
+	sms      SMSSender
+	key      []byte
+
+	mu   sync.Mutex
+	otps map[string]*pendingOTP // by phone
+
+	otpPerPhone *rateLimiter
+	otpPerIP    *rateLimiter
+	verifyPerIP *rateLimiter
+
+	purgeMu   sync.Mutex
+	lastPurge time.Time
+}
+
+// NewAuth returns an Auth keeping its users and sessions in the given
+// repositories.
+func NewAuth(users UserRepository, sessions SessionRepository, sms SMSSender, key []byte) *Auth {
+	return &Auth{
+		users:       users,
+		sessions:    sessions,
+		sms:         sms,
+		key:         key,
+		otps:        make(map[string]*pendingOTP),
+		otpPerPhone: newRateLimiter(3, 15*time.Minute),
+		otpPerIP:    newRateLimiter(10, time.Hour),
+		verifyPerIP: newRateLimiter(20, 15*time.Minute),
+	}
+}
+
+// errRateLimited is returned when a phone or client asks too often.
+type errRateLimited struct {
+	retryAfter time.Duration
+}
+
+func (e errRateLimited) Error() string {
+	return fmt.Sprintf("too many requests, retry in %d seconds", int(e.retryAfter.Seconds())+1)
+}
+
+// SendCode texts a new one-time code to phone, replacing any earlier one.
+func (a *Auth) SendCode(phone, ip string, now time.Time) error {
+	if ok, wait := a.otpPerIP.Allow(ip, now); !ok {
+		return errRateLimited{wait}
+	}
+	if ok, wait := a.otpPerPhone.Allow(phone, now); !ok {
+		return errRateLimited{wait}
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return err
+	}
+	code := fmt.Sprintf("%0*d", otpDigits, n.Int64())
+
+	a.mu.Lock()
+	for p, otp := range a.otps {
+		if now.After(otp.expiresAt) {
+			delete(a.otps, p)
+		}
+	}
+	a.otps[phone] = &pendingOTP{hash: sha256.Sum256([]byte(phone + ":" + code)), expiresAt: now.Add(otpTTL)}
+	a.mu.Unlock()
+
+	message := fmt.Sprintf("Your Klimatt code is %s. It expires in %d minutes.", code, int(otpTTL.Minutes()))
+	return a.sms.Send(phone, message)
+}
+
+// checkCode reports whether code is the pending code of phone, using it
+// up if so. A code stops working after maxOTPAttempts wrong guesses.
+func (a *Auth) checkCode(phone, code string, now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	otp, ok := a.otps[phone]
+	if !ok || now.After(otp.expiresAt) {
+		return false
+	}
+	hash := sha256.Sum256([]byte(phone + ":" + strings.TrimSpace(code)))
+	if !hmac.Equal(hash[:], otp.hash[:]) {
+		if otp.attempts++; otp.attempts >= maxOTPAttempts {
+			delete(a.otps, phone)
+		}
+		return false
+	}
+	delete(a.otps, phone)
+	return true
+}
+
+// TokenResponse is the answer to a sign-in or refresh.
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"` // Bearer
+	ExpiresIn    int    `json:"expires_in"` // seconds of the access token
+	User         *User  `json:"user"`
+}
+
+var errInvalidCode = errors.New("invalid or expired code")
+
+// Verify signs in the owner of phone with the code sent to it, creating
+// their user on first sign-in, and opens a session.
+func (a *Auth) Verify(phone, code, ip string, now time.Time) (*TokenResponse, error) {
+	if ok, wait := a.verifyPerIP.Allow(ip, now); !ok {
+		return nil, errRateLimited{wait}
+	}
+	if !a.checkCode(phone, code, now) {
+		return nil, errInvalidCode
+	}
+	user, ok, err := a.users.UserByPhone(phone)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		user = &User{ID: randomID(16), Phone: phone, CreatedAt: now}
+		if err := a.users.PutUser(user); err != nil {
+			return nil, err
+		}
+	}
+	session := &Session{ID: randomID(16), UserID: user.ID, Phone: phone, CreatedAt: now}
+	secret := rotateRefresh(session, now)
+	if err := a.sessions.PutSession(session); err != nil {
+		return nil, err
+	}
+	return a.issue(session, secret, user, now)
+}
+
+// Refresh trades a refresh token for new tokens. Each refresh token works
+// once: presenting the one it was traded for again ends the session, as it
+// may have leaked, unless that refresh was less than refreshReuseGrace
+// ago. Other wrong tokens are just refused, so knowing a session ID isn't
+// enough to end it.
+func (a *Auth) Refresh(refreshToken string, now time.Time) (*TokenResponse, error) {
+	a.purgeSessions(now)
+	sessionID, secret, ok := strings.Cut(refreshToken, ".")
+	if !ok {
+		return nil, errInvalidToken
+	}
+	hash := []byte(hashSecret(secret))
+	var refreshed *Session
+	var newSecret string
+	reused := false
+	// Compare and rotate in one transaction, so of two refreshes with the
+	// same token only one wins
+	err := a.sessions.UpdateSession(sessionID, func(session *Session) (*Session, error) {
+		switch {
+		case session == nil || now.After(session.ExpiresAt):
+			return nil, errInvalidToken
+		case hmac.Equal(hash, []byte(session.RefreshHash)):
+			newSecret = rotateRefresh(session, now)
+			refreshed = session
+			return session, nil
+		case session.PrevHash != "" && hmac.Equal(hash, []byte(session.PrevHash)):
+			if now.Sub(session.RefreshedAt) < refreshReuseGrace {
+				return nil, errInvalidToken
+			}
+			reused = true
+			return nil, nil
+		}
+		return nil, errInvalidToken
+	})
+	if err != nil {
+		return nil, err
+	}
+	if reused {
+		log.Printf("⚠️  refresh token of session %s reused, session ended", sessionID)
+		return nil, errInvalidToken
+	}
+	user := &User{ID: refreshed.UserID, Phone: refreshed.Phone}
+	if u, ok, err := a.users.UserByPhone(refreshed.Phone); err == nil && ok {
+		user = u
+	}
+	return a.issue(refreshed, newSecret, user, now)
+}
+
+// rotateRefresh gives session a new refresh token, remembering the one it
+// replaces, and returns the new token's secret. The caller stores session.
+func rotateRefresh(session *Session, now time.Time) string {
+	secret := randomID(32)
+	session.PrevHash = session.RefreshHash
+	session.RefreshHash = hashSecret(secret)
+	session.RefreshedAt = now
+	session.ExpiresAt = now.Add(refreshTokenTTL)
+	return secret
+}
+
+// hashSecret returns the hex SHA-256 of a refresh token secret.
+func hashSecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+// issue signs an access token for a stored session and pairs it with the
+// refresh token of secret.
+func (a *Auth) issue(session *Session, secret string, user *User, now time.Time) (*TokenResponse, error) {
+	access, err := a.sign(tokenClaims{
+		UserID:    session.UserID,
+		SessionID: session.ID,
+		Phone:     session.Phone,
+		ExpiresAt: now.Add(accessTokenTTL).Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &TokenResponse{
+		AccessToken:  access,
+		RefreshToken: session.ID + "." + secret,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(accessTokenTTL.Seconds()),
+		User:         user,
+	}, nil
+}
+
+// SignOut ends a session.
+func (a *Auth) SignOut(sessionID string) error {
+	return a.sessions.DeleteSession(sessionID)
+}
+
+// purgeSessions forgets expired sessions, at most hourly.
+func (a *Auth) purgeSessions(now time.Time) {
+	a.purgeMu.Lock()
+	defer a.purgeMu.Unlock()
+	if now.Sub(a.lastPurge) < time.Hour {
+		return
+	}
+	a.lastPurge = now
+	if err := a.sessions.PurgeSessions(now); err != nil {
+		log.Printf("⚠️  purging sessions: %v", err)
+	}
+}
+
+var (
+	errInvalidToken = errors.New("invalid or expired token")
+	tokenEncoding   = base64.RawURLEncoding
+)
+
+// sign encodes claims as "payload.signature", both base64url.
+func (a *Auth) sign(claims tokenClaims) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write(payload)
+	return tokenEncoding.EncodeToString(payload) + "." + tokenEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// Authenticate checks an access token and that its session is still open.
+func (a *Auth) Authenticate(token string, now time.Time) (tokenClaims, error) {
+	var claims tokenClaims
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return claims, errInvalidToken
+	}
+	payload, err1 := tokenEncoding.DecodeString(encoded)
+	sig, err2 := tokenEncoding.DecodeString(signature)
+	if err1 != nil || err2 != nil {
+		return claims, errInvalidToken
+	}
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write(payload)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return claims, errInvalidToken
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || now.Unix() >= claims.ExpiresAt {
+		return claims, errInvalidToken
+	}
+	if _, ok, err := a.sessions.Session(claims.SessionID); err != nil {
+		return claims, err
+	} else if !ok {
+		return claims, errInvalidToken
+	}
+	return claims, nil
+}
+
+// randomID returns n random bytes, hex-encoded.
+func randomID(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err) // crypto/rand doesn't fail on supported platforms
+	}
+	return hex.EncodeToString(b)
+}
+
+// ==================== AUTH MIDDLEWARE ====================
+
+// Context keys set by authenticate.
+const (
+	ctxUserID    = "user_id"
+	ctxPhone     = "phone"
+	ctxSessionID = "session_id"
+	ctxAuthError = "auth_error"
+)
+
+// authenticate identifies the user of requests with a session token in
+// their Authorization header. Requests without one pass on anonymously,
+// and so do other bearer tokens, such as the admin token; requireUser
+// turns those away where a user is needed.
+func authenticate(auth *Auth) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok {
+			c.Next()
+			return
+		}
+		claims, err := auth.Authenticate(token, time.Now())
+		if err != nil {
+			c.Set(ctxAuthError, err)
+			c.Next()
+			return
+		}
+		c.Set(ctxUserID, claims.UserID)
+		c.Set(ctxPhone, claims.Phone)
+		c.Set(ctxSessionID, claims.SessionID)
+		c.Next()
+	}
+}
+
+// requireUser rejects requests that authenticate didn't sign in.
+func requireUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if requestUserID(c) != "" {
+			c.Next()
+			return
+		}
+		c.Header("WWW-Authenticate", `Bearer realm="klimatt"`)
+		if err, ok := c.Get(ctxAuthError); ok && !errors.Is(err.(error), errInvalidToken) {
+			log.Printf("⚠️  %v", err)
+			c.AbortWithStatusJSON(500, gin.H{"error": "could not check the session, retry later"})
+			return
+		}
+		if _, ok := c.Get(ctxAuthError); ok {
+			c.AbortWithStatusJSON(401, gin.H{"error": "invalid or expired session token, refresh it or sign in again"})
+			return
+		}
+		c.AbortWithStatusJSON(401, gin.H{"error": "sign in with your phone number first"})
+	}
+}
+
+// requestUserID returns the user a request acts for, set by authenticate.
+// It is empty for anonymous requests.
+func requestUserID(c *gin.Context) string {
+	return c.GetString(ctxUserID)
+}
+
+// ==================== AUTH ENDPOINTS ====================
+
+// authError answers a failed auth request.
+func authError(c *gin.Context, err error) {
+	var limited errRateLimited
+	switch {
+	case errors.As(err, &limited):
+		c.Header("Retry-After", strconv.Itoa(int(limited.retryAfter.Seconds())+1))
+		c.JSON(429, gin.H{"error": err.Error()})
+	case errors.Is(err, errInvalidCode), errors.Is(err, errInvalidToken):
+		c.JSON(401, gin.H{"error": err.Error()})
+	default:
+		log.Printf("⚠️  %v", err)
+		c.JSON(500, gin.H{"error": "sign-in failed, retry later"})
+	}
+}
+
+// registerAuthRoutes adds the phone sign-in endpoints.
+func registerAuthRoutes(router *gin.Engine, auth *Auth) {
+	group := router.Group("/api/auth")
+
+	// A one-time code by SMS
+	group.POST("/otp", func(c *gin.Context) {
+		var req struct {
+			Phone string `json:"phone"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("invalid request: %v", err)})
+			return
+		}
+		phone, err := normalizePhone(req.Phone)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if err := auth.SendCode(phone, c.ClientIP(), time.Now()); err != nil {
+			authError(c, err)
+			return
+		}
+		c.JSON(202, gin.H{"phone": phone, "expires_in": int(otpTTL.Seconds())})
+	})
+
+	// The code for a session
+	group.POST("/verify", func(c *gin.Context) {
+		var req struct {
+			Phone string `json:"phone"`
+			Code  string `json:"code"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("invalid request: %v", err)})
+			return
+		}
+		phone, err := normalizePhone(req.Phone)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		tokens, err := auth.Verify(phone, req.Code, c.ClientIP(), time.Now().UTC())
+		if err != nil {
+			authError(c, err)
+			return
+		}
+		c.JSON(200, tokens)
+	})
+
+	// A refresh token for new tokens
+	group.POST("/refresh", func(c *gin.Context) {
+		var req struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("invalid request: %v", err)})
+			return
+		}
+		tokens, err := auth.Refresh(req.RefreshToken, time.Now().UTC())
+		if err != nil {
+			authError(c, err)
+			return
+		}
+		c.JSON(200, tokens)
+	})
+
+	// The signed-in user
+	group.GET("/me", requireUser(), func(c *gin.Context) {
+		c.JSON(200, gin.H{"id": requestUserID(c), "phone": c.GetString(ctxPhone)})
+	})
+
+	group.POST("/logout", requireUser(), func(c *gin.Context) {
+		if err := auth.SignOut(c.GetString(ctxSessionID)); err != nil {
+			authError(c, err)
+			return
+		}
+		c.Status(204)
+	})
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingSMS keeps the last message sent to each phone.
+type recordingSMS struct {
+	mu   sync.Mutex
+	last map[string]string
+}
+
+func (s *recordingSMS) Send(phone, message string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.last[phone] = message
+	return nil
+}
+
+// code returns the one-time code of the last message to phone.
+func (s *recordingSMS) code(t testing.TB, phone string) string {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fields := strings.Fields(s.last[phone])
+	for i, f := range fields {
+		if f == "is" && i+1 < len(fields) {
+			return strings.TrimSuffix(fields[i+1], ".")
+		}
+	}
+	t.Fatalf("no code sent to %s", phone)
+	return ""
+}
+
+func newTestAuth(t *testing.T) (*Auth, *recordingSMS) {
+	t.Helper()
+	storage, err := OpenStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { storage.Close() })
+	repos := storage.Repositories()
+	sms := &recordingSMS{last: make(map[string]string)}
+	return NewAuth(repos.Users, repos.Sessions, sms, []byte("test key")), sms
+}
+
+// signIn sends a code to phone and verifies it at now.
+func signIn(t testing.TB, auth *Auth, sms *recordingSMS, phone string, now time.Time) *TokenResponse {
+	t.Helper()
+	if err := auth.SendCode(phone, "10.0.0.1", now); err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := auth.Verify(phone, sms.code(t, phone), "10.0.0.1", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokens
+}
+
+func TestVerifyCode(t *testing.T) {
+	const phone = "+254712345678"
+	now := time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		wrong   int           // wrong guesses before the right code
+		after   time.Duration // from sending to the right guess
+		wantErr error
+	}{
+		{"right code", 0, time.Minute, nil},
+		{"some wrong guesses", maxOTPAttempts - 1, time.Minute, nil},
+		{"too many wrong guesses", maxOTPAttempts, time.Minute, errInvalidCode},
+		{"just before expiry", 0, otpTTL, nil},
+		{"expired", 0, otpTTL + time.Second, errInvalidCode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth, sms := newTestAuth(t)
+			if err := auth.SendCode(phone, "10.0.0.1", now); err != nil {
+				t.Fatal(err)
+			}
+			code := sms.code(t, phone)
+			wrong := "000000"
+			if code == wrong {
+				wrong = "111111"
+			}
+			for i := 0; i < tt.wrong; i++ {
+				if _, err := auth.Verify(phone, wrong, "10.0.0.1", now); !errors.Is(err, errInvalidCode) {
+					t.Fatalf("wrong guess %d: got %v, want %v", i+1, err, errInvalidCode)
+				}
+			}
+			_, err := auth.Verify(phone, code, "10.0.0.1", now.Add(tt.after))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyCodeOnce(t *testing.T) {
+	const phone = "+254712345678"
+	now := time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC)
+	auth, sms := newTestAuth(t)
+	if err := auth.SendCode(phone, "10.0.0.1", now); err != nil {
+		t.Fatal(err)
+	}
+	code := sms.code(t, phone)
+	if _, err := auth.Verify(phone, code, "10.0.0.1", now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.Verify(phone, code, "10.0.0.1", now); !errors.Is(err, errInvalidCode) {
+		t.Fatalf("second use: got %v, want %v", err, errInvalidCode)
+	}
+}
+
+func TestSendCodeRateLimits(t *testing.T) {
+	now := time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC)
+
+	t.Run("per phone", func(t *testing.T) {
+		auth, _ := newTestAuth(t)
+		for i := 0; i < 3; i++ {
+			if err := auth.SendCode("+254712345678", "10.0.0.1", now); err != nil {
+				t.Fatalf("send %d: %v", i+1, err)
+			}
+		}
+		var limited errRateLimited
+		if err := auth.SendCode("+254712345678", "10.0.0.2", now); !errors.As(err, &limited) {
+			t.Fatalf("4th send from another IP: got %v, want rate limited", err)
+		}
+		if err := auth.SendCode("+254722345678", "10.0.0.1", now); err != nil {
+			t.Fatalf("another phone: %v", err)
+		}
+		if err := auth.SendCode("+254712345678", "10.0.0.1", now.Add(15*time.Minute)); err != nil {
+			t.Fatalf("after the window: %v", err)
+		}
+	})
+
+	t.Run("per IP", func(t *testing.T) {
+		auth, _ := newTestAuth(t)
+		for i := 0; i < 10; i++ {
+			phone := "+25471234560" + string(rune('0'+i))
+			if err := auth.SendCode(phone, "10.0.0.1", now); err != nil {
+				t.Fatalf("send %d: %v", i+1, err)
+			}
+		}
+		var limited errRateLimited
+		if err := auth.SendCode("+254799999999", "10.0.0.1", now); !errors.As(err, &limited) {
+			t.Fatalf("11th send: got %v, want rate limited", err)
+		}
+		if limited.retryAfter <= 0 || limited.retryAfter > time.Hour {
+			t.Errorf("retry after %v, want within the hour", limited.retryAfter)
+		}
+		if err := auth.SendCode("+254799999999", "10.0.0.2", now); err != nil {
+			t.Fatalf("another IP: %v", err)
+		}
+	})
+
+	t.Run("verify per IP", func(t *testing.T) {
+		auth, _ := newTestAuth(t)
+		for i := 0; i < 20; i++ {
+			if _, err := auth.Verify("+254712345678", "000000", "10.0.0.1", now); !errors.Is(err, errInvalidCode) {
+				t.Fatalf("guess %d: got %v, want %v", i+1, err, errInvalidCode)
+			}
+		}
+		var limited errRateLimited
+		if _, err := auth.Verify("+254712345678", "000000", "10.0.0.1", now); !errors.As(err, &limited) {
+			t.Fatalf("21st guess: got %v, want rate limited", err)
+		}
+	})
+}
+
+func TestAuthenticateTampering(t *testing.T) {
+	now := time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC)
+	auth, sms := newTestAuth(t)
+	tokens := signIn(t, auth, sms, "+254712345678", now)
+	other := signIn(t, auth, sms, "+254722345678", now)
+
+	claims, err := auth.Authenticate(tokens.AccessToken, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.UserID != tokens.User.ID || claims.Phone != "+254712345678" {
+		t.Fatalf("claims %+v, want user %s", claims, tokens.User.ID)
+	}
+
+	payload, signature, _ := strings.Cut(tokens.AccessToken, ".")
+	otherPayload, _, _ := strings.Cut(other.AccessToken, ".")
+	flipped := []byte(signature)
+	if flipped[0] == 'A' {
+		flipped[0] = 'B'
+	} else {
+		flipped[0] = 'A'
+	}
+	forged, err := (&Auth{key: []byte("another key")}).sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		token string
+		at    time.Time
+	}{
+		{"other user's payload", otherPayload + "." + signature, now},
+		{"flipped signature", payload + "." + string(flipped), now},
+		{"no signature", payload, now},
+		{"signed with another key", forged, now},
+		{"not base64", "!!." + signature, now},
+		{"expired", tokens.AccessToken, now.Add(accessTokenTTL)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := auth.Authenticate(tt.token, tt.at); !errors.Is(err, errInvalidToken) {
+				t.Fatalf("got %v, want %v", err, errInvalidToken)
+			}
+		})
+	}
+
+	t.Run("signed out", func(t *testing.T) {
+		if err := auth.SignOut(claims.SessionID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := auth.Authenticate(tokens.AccessToken, now); !errors.Is(err, errInvalidToken) {
+			t.Fatalf("got %v, want %v", err, errInvalidToken)
+		}
+	})
+}
+
+func TestRefreshRotation(t *testing.T) {
+	now := time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC)
+	auth, sms := newTestAuth(t)
+	first := signIn(t, auth, sms, "+254712345678", now)
+	sessionID, _, _ := strings.Cut(first.RefreshToken, ".")
+
+	second, err := auth.Refresh(first.RefreshToken, now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.RefreshToken == first.RefreshToken || second.User.ID != first.User.ID {
+		t.Fatalf("refresh returned %+v", second)
+	}
+
+	// A guess at the secret of a known session is refused and leaves it open
+	if _, err := auth.Refresh(sessionID+".guess", now.Add(2*time.Minute)); !errors.Is(err, errInvalidToken) {
+		t.Fatalf("wrong secret: got %v, want %v", err, errInvalidToken)
+	}
+	// So is the replaced token shortly after, as from a concurrent refresh
+	if _, err := auth.Refresh(first.RefreshToken, now.Add(time.Minute+refreshReuseGrace/2)); !errors.Is(err, errInvalidToken) {
+		t.Fatalf("replaced token within the grace: got %v, want %v", err, errInvalidToken)
+	}
+	if _, err := auth.Authenticate(second.AccessToken, now.Add(2*time.Minute)); err != nil {
+		t.Fatalf("session ended by a refused refresh: %v", err)
+	}
+
+	third, err := auth.Refresh(second.RefreshToken, now.Add(3*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Reusing a replaced token later means it leaked: the session ends
+	if _, err := auth.Refresh(second.RefreshToken, now.Add(10*time.Minute)); !errors.Is(err, errInvalidToken) {
+		t.Fatalf("reused token: got %v, want %v", err, errInvalidToken)
+	}
+	if _, err := auth.Refresh(third.RefreshToken, now.Add(11*time.Minute)); !errors.Is(err, errInvalidToken) {
+		t.Fatalf("refresh after reuse: got %v, want %v", err, errInvalidToken)
+	}
+	if _, err := auth.Authenticate(third.AccessToken, now.Add(11*time.Minute)); !errors.Is(err, errInvalidToken) {
+		t.Fatalf("access after reuse: got %v, want %v", err, errInvalidToken)
+	}
+}
+
+func TestRefreshExpired(t *testing.T) {
+	now := time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC)
+	auth, sms := newTestAuth(t)
+	tokens := signIn(t, auth, sms, "+254712345678", now)
+	if _, err := auth.Refresh(tokens.RefreshToken, now.Add(refreshTokenTTL+time.Second)); !errors.Is(err, errInvalidToken) {
+		t.Fatalf("got %v, want %v", err, errInvalidToken)
+	}
+}
+
+func TestRefreshConcurrent(t *testing.T) {
+	now := time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC)
+	auth, sms := newTestAuth(t)
+	tokens := signIn(t, auth, sms, "+254712345678", now)
+
+	const n = 8
+	results := make([]*TokenResponse, n)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = auth.Refresh(tokens.RefreshToken, now.Add(time.Minute))
+		}(i)
+	}
+	wg.Wait()
+
+	var winner *TokenResponse
+	for _, r := range results {
+		if r == nil {
+			continue
+		}
+		if winner != nil {
+			t.Fatal("the same refresh token was traded twice")
+		}
+		winner = r
+	}
+	if winner == nil {
+		t.Fatal("no refresh succeeded")
+	}
+	if _, err := auth.Refresh(winner.RefreshToken, now.Add(2*time.Minute)); err != nil {
+		t.Fatalf("the winner's token stopped working: %v", err)
+	}
+}
@@ -0,0 +1,624 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== FOOD BASKETS ====================
+
+// Where the price of a basket item came from.
+const (
+	SourceMarket  = "market"         // the market itself
+	SourceNearest = "nearest_market" // the nearest market with a price that month
+	SourceCounty  = "county_median"  // the median of the county's other markets
+	SourceMissing = "missing"
+)
+
+// monthPattern matches a "YYYY-MM" month.
+var monthPattern = regexp.MustCompile(`^\d{4}-\d{2}$`)
+
+// defaultFillRadiusKm is how far away the nearest market may be to fill
+// in a missing item.
+const defaultFillRadiusKm = 100
+
+// BasketFile is the format of the baskets file.
+type BasketFile struct {
+	Version int       `json:"version"`
+	Baskets []*Basket `json:"baskets"`
+}
+
+// Basket is a set of commodities and the quantity of each bought over a
+// period, e.g. a household's monthly food basket or a Minimum Expenditure
+// Basket.
+type Basket struct {
+	ID          string        `json:"id,omitempty"` // required in the baskets file
+	Name        string        `json:"name"`
+	Description string        `json:"description,omitempty"`
+	Items       []*BasketItem `json:"items"`
+}
+
+// BasketItem is one commodity of a basket. The commodity is a taxonomy id
+// or name, which takes in every variant, or an exact WFP name.
+type BasketItem struct {
+	Commodity string  `json:"commodity"` // e.g. "maize-flour"
+	Quantity  float64 `json:"quantity"`
+	Unit      string  `json:"unit"` // normalized unit, e.g. "kg" or "l"
+
+	filter commodityFilter
+}
+
+// LoadBaskets reads the baskets file and checks every item against the
+// taxonomy. An empty path gives no baskets, leaving only posted ones.
+func LoadBaskets(path string, taxonomy *Taxonomy) ([]*Basket, error) {
+	if path == "" {
+		return nil, nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	var file BasketFile
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var errs []error
+	ids := make(map[string]bool)
+	for _, b := range file.Baskets {
+		switch {
+		case b.ID == "":
+			errs = append(errs, fmt.Errorf("basket %q: id is required", b.Name))
+		case ids[b.ID]:
+			errs = append(errs, fmt.Errorf("basket %q: duplicate id", b.ID))
+		}
+		ids[b.ID] = true
+		if err := b.resolve(taxonomy); err != nil {
+			errs = append(errs, fmt.Errorf("basket %q: %w", b.ID, err))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("%s: %w", path, errors.Join(errs...))
+	}
+	return file.Baskets, nil
+}
+
+// resolve validates the basket and looks up the WFP commodities of its
+// items.
+func (b *Basket) resolve(taxonomy *Taxonomy) error {
+	var errs []error
+	if b.Name == "" {
+		errs = append(errs, fmt.Errorf("name is required"))
+	}
+	if len(b.Items) == 0 {
+		errs = append(errs, fmt.Errorf("no items"))
+	}
+	seen := make(map[string]bool)
+	for i, item := range b.Items {
+		if item == nil {
+			errs = append(errs, fmt.Errorf("item %d is empty", i+1))
+			continue
+		}
+		item.Unit = strings.ToLower(strings.TrimSpace(item.Unit))
+		key := strings.ToLower(item.Commodity) + "/" + item.Unit
+		switch {
+		case item.Commodity == "":
+			errs = append(errs, fmt.Errorf("item %d: commodity is required", i+1))
+			continue
+		case seen[key]:
+			errs = append(errs, fmt.Errorf("item %q: listed twice", item.Commodity))
+		case !(item.Quantity > 0):
+			errs = append(errs, fmt.Errorf("item %q: quantity must be positive", item.Commodity))
+		case item.Unit == "":
+			errs = append(errs, fmt.Errorf("item %q: unit is required", item.Commodity))
+		}
+		seen[key] = true
+		if item.filter = taxonomy.Filter([]string{item.Commodity}, true); len(item.filter) == 0 {
+			errs = append(errs, fmt.Errorf("item %q: unknown commodity", item.Commodity))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// localName names the item's commodity in lang.
+func (item *BasketItem) localName(taxonomy *Taxonomy, lang string) string {
+	if c, ok := taxonomy.Canonical(item.Commodity); ok {
+		return c.localName(lang)
+	}
+	for id := range item.filter {
+		if v, ok := taxonomy.Variant(id); ok {
+			return taxonomy.LocalName(id, v.WFPName, lang)
+		}
+	}
+	return item.Commodity
+}
+
+// basketPrices holds the monthly price of every basket item in every
+// market, indexed by item, then market ID, then month ("YYYY-MM").
+type basketPrices []map[int]map[string]*marketMonth
+
+// collectBasketPrices converts the prices of priceType dated from..to of
+// every item of basket. Variants of an item reported by the same market in
+// the same month are averaged.
+func collectBasketPrices(foodData *FoodData, opts priceOptions, basket *Basket, priceType PriceType, from, to string) (basketPrices, error) {
+	prices := make(basketPrices, len(basket.Items))
+	for i, item := range basket.Items {
+		prices[i] = make(map[int]map[string]*marketMonth)
+		for _, series := range foodData.AllSeries() {
+			if !item.filter.Match(series.Key.CommodityID) || series.Key.PriceType != priceType {
+				continue
+			}
+			for _, comm := range series.Between(from, to) {
+				if !strings.EqualFold(comm.NormalizedUnit, item.Unit) {
+					continue
+				}
+				price, cpiEstimated, err := opts.convert(foodData, comm.NormalizedPrice, comm.Currency, comm.Date)
+				if err != nil {
+					return nil, err
+				}
+				byMonth := prices[i][series.Key.MarketID]
+				if byMonth == nil {
+					byMonth = make(map[string]*marketMonth)
+					prices[i][series.Key.MarketID] = byMonth
+				}
+				month := monthOf(comm.Date)
+				m := byMonth[month]
+				if m == nil {
+					m = &marketMonth{}
+					byMonth[month] = m
+				}
+				m.sum += price
+				m.count++
+				m.estimated = m.estimated || comm.UnitEstimated
+				m.cpiEstimated = m.cpiEstimated || cpiEstimated
+			}
+		}
+	}
+	return prices, nil
+}
+
+// own reports whether market has a price of at least one item in month.
+func (p basketPrices) own(marketID int, month string) bool {
+	for _, byMarket := range p {
+		if byMarket[marketID][month] != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// months returns every month with a price of some item in market, or in
+// any market when marketID is 0, in order.
+func (p basketPrices) months(marketID int) []string {
+	seen := make(map[string]bool)
+	for _, byMarket := range p {
+		for id, byMonth := range byMarket {
+			if marketID != 0 && id != marketID {
+				continue
+			}
+			for month := range byMonth {
+				seen[month] = true
+			}
+		}
+	}
+	months := make([]string, 0, len(seen))
+	for month := range seen {
+		months = append(months, month)
+	}
+	sort.Strings(months)
+	return months
+}
+
+// BasketItemCost is the cost of one item of a basket.
+type BasketItemCost struct {
+	Commodity     string   `json:"commodity"` // as given in the basket
+	Name          string   `json:"name"`
+	Quantity      float64  `json:"quantity"`
+	Unit          string   `json:"unit"`
+	Price         *float64 `json:"price"` // per unit, nil when missing
+	Cost          *float64 `json:"cost"`
+	Source        string   `json:"source"` // market, nearest_market, county_median or missing
+	SourceMarket  string   `json:"source_market,omitempty"`
+	DistanceKm    float64  `json:"distance_km,omitempty"`
+	CountyMarkets int      `json:"county_markets,omitempty"` // markets in the county median
+	Estimated     bool     `json:"estimated"`                // normalization used a typical weight or density
+	CPIEstimated  bool     `json:"cpi_estimated,omitempty"`
+}
+
+// BasketCost is the cost of a basket in one market and month. Cost only
+// sums the items that could be priced.
+type BasketCost struct {
+	Month    string           `json:"month"` // "YYYY-MM"
+	Label    string           `json:"label"`
+	Cost     float64          `json:"cost"`
+	Complete bool             `json:"complete"` // every item priced
+	Filled   int              `json:"filled"`   // items priced from other markets
+	Missing  int              `json:"missing"`
+	Items    []BasketItemCost `json:"items"`
+}
+
+// basketCost prices basket in market in month. Items the market has no
+// price for are taken from the nearest market within fillRadiusKm, or
+// else from the median of the other markets of its county.
+func basketCost(foodData *FoodData, basket *Basket, prices basketPrices, market *MarketData, month, lang string, fillRadiusKm float64) BasketCost {
+	cost := BasketCost{Month: month, Label: formatMonth(month + "-01"), Complete: true}
+	for i, item := range basket.Items {
+		ic := BasketItemCost{
+			Commodity: item.Commodity,
+			Name:      item.localName(foodData.Taxonomy, lang),
+			Quantity:  item.Quantity,
+			Unit:      item.Unit,
+			Source:    SourceMissing,
+		}
+		var found *marketMonth
+		price := 0.0
+		if m := prices[i][market.ID][month]; m != nil {
+			found, price = m, m.sum/float64(m.count)
+			ic.Source = SourceMarket
+		}
+		if found == nil {
+			nearest := -1.0
+			for id, byMonth := range prices[i] {
+				m := byMonth[month]
+				other, ok := foodData.MarketByID(id)
+				if m == nil || !ok || id == market.ID {
+					continue
+				}
+				d := distanceKm(market.Location, other.Location)
+				if d <= fillRadiusKm && (nearest < 0 || d < nearest) {
+					nearest, found, price = d, m, m.sum/float64(m.count)
+					ic.Source, ic.SourceMarket, ic.DistanceKm = SourceNearest, other.Name, d
+				}
+			}
+		}
+		if found == nil {
+			var values []float64
+			estimated, cpiEstimated := false, false
+			for id, byMonth := range prices[i] {
+				m := byMonth[month]
+				other, ok := foodData.MarketByID(id)
+				if m == nil || !ok || id == market.ID || other.Admin2 != market.Admin2 {
+					continue
+				}
+				values = append(values, m.sum/float64(m.count))
+				estimated = estimated || m.estimated
+				cpiEstimated = cpiEstimated || m.cpiEstimated
+			}
+			if len(values) > 0 {
+				found = &marketMonth{estimated: estimated, cpiEstimated: cpiEstimated}
+				price = median(values)
+				ic.Source, ic.CountyMarkets = SourceCounty, len(values)
+			}
+		}
+
+		if found == nil {
+			cost.Complete = false
+			cost.Missing++
+		} else {
+			total := price * item.Quantity
+			ic.Price, ic.Cost = &price, &total
+			ic.Estimated, ic.CPIEstimated = found.estimated, found.cpiEstimated
+			cost.Cost += total
+			if ic.Source != SourceMarket {
+				cost.Filled++
+			}
+		}
+		cost.Items = append(cost.Items, ic)
+	}
+	return cost
+}
+
+// ==================== BASKET ENDPOINTS ====================
+
+// requestBasket returns the basket of a request: the body of a POST, or
+// else the basket= of the baskets file. The int is the status to answer
+// with on error.
+func requestBasket(c *gin.Context, foodData *FoodData) (*Basket, int, error) {
+	if c.Request.Method == "POST" {
+		var basket Basket
+		dec := json.NewDecoder(io.LimitReader(c.Request.Body, 1<<20))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&basket); err != nil {
+			return nil, 400, fmt.Errorf("invalid basket: %w", err)
+		}
+		if err := basket.resolve(foodData.Taxonomy); err != nil {
+			return nil, 400, fmt.Errorf("invalid basket: %w", err)
+		}
+		return &basket, 0, nil
+	}
+	id := c.Query("basket")
+	if id == "" {
+		return nil, 400, fmt.Errorf("basket is required (or POST one)")
+	}
+	for _, b := range foodData.Baskets {
+		if strings.EqualFold(b.ID, id) {
+			return b, 0, nil
+		}
+	}
+	return nil, 404, fmt.Errorf("Basket not found")
+}
+
+// basketParams are the options shared by the basket endpoints.
+type basketParams struct {
+	opts         priceOptions
+	priceType    PriceType
+	fillRadiusKm float64
+}
+
+// parseBasketParams reads pricetype= (default retail), fill_radius_km=
+// and the price options.
+func parseBasketParams(c *gin.Context) (basketParams, error) {
+	var p basketParams
+	var err error
+	if p.opts, err = parsePriceOptions(c); err != nil {
+		return p, err
+	}
+	p.priceType = Retail
+	if priceType, err := parsePriceTypeParam(c.Query("pricetype")); err != nil {
+		return p, err
+	} else if priceType != nil {
+		p.priceType = *priceType
+	}
+	p.fillRadiusKm = defaultFillRadiusKm
+	if v := c.Query("fill_radius_km"); v != "" {
+		if p.fillRadiusKm, err = strconv.ParseFloat(v, 64); err != nil || p.fillRadiusKm < 0 {
+			return p, fmt.Errorf("invalid fill_radius_km %q", v)
+		}
+	}
+	return p, nil
+}
+
+// parseMarketParam reads the optional market= or market_id=. The bool is
+// false when neither was given.
+func parseMarketParam(c *gin.Context, foodData *FoodData) (*MarketData, bool, error) {
+	q := seriesQuery{Market: c.Query("market")}
+	if v := c.Query("market_id"); v != "" {
+		var err error
+		if q.MarketID, err = strconv.Atoi(v); err != nil {
+			return nil, true, fmt.Errorf("invalid market_id %q", v)
+		}
+	}
+	if q.Market == "" && q.MarketID == 0 {
+		return nil, false, nil
+	}
+	market, ok := historyMarket(foodData, q)
+	if !ok {
+		return nil, true, nil
+	}
+	return market, true, nil
+}
+
+// basketsHandler serves /api/baskets, the baskets of the baskets file.
+func basketsHandler(store *DatasetStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		foodData := store.Load()
+		baskets := foodData.Baskets
+		if baskets == nil {
+			baskets = []*Basket{}
+		}
+		c.JSON(200, baskets)
+	}
+}
+
+// MarketBasketCost is the cost of a basket in one market.
+type MarketBasketCost struct {
+	Market MarketSummary `json:"market"`
+	BasketCost
+}
+
+// BasketCostResponse is the body of /api/basket/cost.
+type BasketCostResponse struct {
+	Basket       *Basket            `json:"basket"`
+	Month        string             `json:"month"`
+	Label        string             `json:"label"`
+	Currency     string             `json:"currency"`
+	Real         bool               `json:"real"`
+	BaseYear     int                `json:"base_year,omitempty"`
+	PriceType    string             `json:"price_type"`
+	FillRadiusKm float64            `json:"fill_radius_km"`
+	Markets      []MarketBasketCost `json:"markets"` // complete baskets first, cheapest first
+}
+
+// basketCostHandler serves /api/basket/cost, the cost of a basket in each
+// market that reported a price of one of its items in month= (default
+// the latest such month). The basket is basket= of the baskets file, or
+// the body of a POST. market=, market_id=, county= and region= narrow the
+// markets listed; missing items are filled from any market.
+func basketCostHandler(store *DatasetStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		foodData := store.Load()
+		params, err := parseBasketParams(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		month := c.Query("month")
+		if month != "" && !monthPattern.MatchString(month) {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("invalid month %q (use YYYY-MM)", month)})
+			return
+		}
+		only, given, err := parseMarketParam(c, foodData)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if given && only == nil {
+			c.JSON(404, gin.H{"error": "Market not found"})
+			return
+		}
+		basket, status, err := requestBasket(c, foodData)
+		if err != nil {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		prices, err := collectBasketPrices(foodData, params.opts, basket, params.priceType, month, month)
+		if err != nil {
+			c.JSON(422, gin.H{"error": err.Error()})
+			return
+		}
+		if month == "" {
+			months := prices.months(0)
+			if len(months) == 0 {
+				c.JSON(404, gin.H{"error": "No prices of the basket's items"})
+				return
+			}
+			month = months[len(months)-1]
+		}
+		county, region := c.Query("county"), c.Query("region")
+
+		response := BasketCostResponse{
+			Basket:       basket,
+			Month:        month,
+			Label:        formatMonth(month + "-01"),
+			Currency:     params.opts.Currency.String(),
+			Real:         params.opts.Real,
+			PriceType:    params.priceType.String(),
+			FillRadiusKm: params.fillRadiusKm,
+			Markets:      []MarketBasketCost{},
+		}
+		if params.opts.Real {
+			response.BaseYear = params.opts.baseYear(foodData)
+		}
+		for i := range foodData.Markets {
+			market := &foodData.Markets[i]
+			if (only != nil && market.ID != only.ID) ||
+				(county != "" && !strings.EqualFold(market.Admin2, county)) ||
+				(region != "" && !strings.EqualFold(market.Admin1, region)) ||
+				!prices.own(market.ID, month) {
+				continue
+			}
+			response.Markets = append(response.Markets, MarketBasketCost{
+				Market:     market.Summary(),
+				BasketCost: basketCost(foodData, basket, prices, market, month, params.opts.Lang, params.fillRadiusKm),
+			})
+		}
+		sort.SliceStable(response.Markets, func(i, j int) bool {
+			a, b := response.Markets[i], response.Markets[j]
+			if a.Complete != b.Complete {
+				return a.Complete
+			}
+			return a.Cost < b.Cost
+		})
+		c.JSON(200, response)
+	}
+}
+
+// BasketTrendPoint is the cost of a basket in one month.
+type BasketTrendPoint struct {
+	BasketCost
+	ChangePercent *float64 `json:"change_percent"` // since the previous point, nil unless both are complete
+}
+
+// BasketTrendResponse is the body of /api/basket/trend.
+type BasketTrendResponse struct {
+	Basket        *Basket            `json:"basket"`
+	Market        MarketSummary      `json:"market"`
+	Currency      string             `json:"currency"`
+	Real          bool               `json:"real"`
+	BaseYear      int                `json:"base_year,omitempty"`
+	PriceType     string             `json:"price_type"`
+	FillRadiusKm  float64            `json:"fill_radius_km"`
+	From          string             `json:"from,omitempty"`
+	To            string             `json:"to,omitempty"`
+	ChangePercent *float64           `json:"change_percent"` // first to last complete month
+	Points        []BasketTrendPoint `json:"points"`
+}
+
+// basketTrendHandler serves /api/basket/trend?market=, the monthly cost
+// of a basket in a market over the months it reported a price of one of
+// its items. The basket is chosen as for /api/basket/cost; from= and to=
+// narrow the months.
+func basketTrendHandler(store *DatasetStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		foodData := store.Load()
+		params, err := parseBasketParams(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		from, to, err := spreadWindow(c, "")
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		market, given, err := parseMarketParam(c, foodData)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if !given {
+			c.JSON(400, gin.H{"error": "market or market_id is required"})
+			return
+		}
+		if market == nil {
+			c.JSON(404, gin.H{"error": "Market not found"})
+			return
+		}
+		basket, status, err := requestBasket(c, foodData)
+		if err != nil {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		prices, err := collectBasketPrices(foodData, params.opts, basket, params.priceType, from, to)
+		if err != nil {
+			c.JSON(422, gin.H{"error": err.Error()})
+			return
+		}
+
+		response := BasketTrendResponse{
+			Basket:       basket,
+			Market:       market.Summary(),
+			Currency:     params.opts.Currency.String(),
+			Real:         params.opts.Real,
+			PriceType:    params.priceType.String(),
+			FillRadiusKm: params.fillRadiusKm,
+			From:         from,
+			To:           to,
+			Points:       []BasketTrendPoint{},
+		}
+		if params.opts.Real {
+			response.BaseYear = params.opts.baseYear(foodData)
+		}
+		var first, prev *BasketCost
+		for _, month := range prices.months(market.ID) {
+			point := BasketTrendPoint{
+				BasketCost: basketCost(foodData, basket, prices, market, month, params.opts.Lang, params.fillRadiusKm),
+			}
+			if point.Complete && prev != nil && prev.Complete && prev.Cost > 0 {
+				change := math.Round((point.Cost/prev.Cost-1)*1000) / 10
+				point.ChangePercent = &change
+			}
+			if point.Complete && first == nil {
+				first = &point.BasketCost
+			}
+			prev = &point.BasketCost
+			response.Points = append(response.Points, point)
+		}
+		// The last complete month against the first
+		for i := len(response.Points) - 1; i >= 0; i-- {
+			last := response.Points[i].BasketCost
+			if !last.Complete {
+				continue
+			}
+			if first != nil && last.Month != first.Month && first.Cost > 0 {
+				change := math.Round((last.Cost/first.Cost-1)*1000) / 10
+				response.ChangePercent = &change
+			}
+			break
+		}
+		c.JSON(200, response)
+	}
+}
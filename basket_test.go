@@ -0,0 +1,182 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// basketTestData has markets along the equator: Near is about 50km from
+// Home, Far about 200km and Farther about 300km. Home, Far and Farther
+// are in the same county.
+func basketTestData(t *testing.T) *FoodData {
+	t.Helper()
+	taxonomy, err := LoadTaxonomy("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	foodData := &FoodData{
+		Markets: []MarketData{
+			{ID: 1, Name: "Home", Location: Location{Lat: 0, Long: 0}, Admin2: "Kitui"},
+			{ID: 2, Name: "Near", Location: Location{Lat: 0, Long: 0.45}, Admin2: "Machakos"},
+			{ID: 3, Name: "Far", Location: Location{Lat: 0, Long: 1.8}, Admin2: "Kitui"},
+			{ID: 4, Name: "Farther", Location: Location{Lat: 0, Long: 2.7}, Admin2: "Kitui"},
+		},
+		Taxonomy: taxonomy,
+	}
+	foodData.Index = buildIndex(foodData)
+	return foodData
+}
+
+// priced is one month's price of a market.
+func priced(price float64) *marketMonth {
+	return &marketMonth{sum: price, count: 1}
+}
+
+func TestBasketCostFill(t *testing.T) {
+	foodData := basketTestData(t)
+	home := &foodData.Markets[0]
+	basket := &Basket{Items: []*BasketItem{{Commodity: "maize", Quantity: 2, Unit: "kg"}}}
+
+	tests := []struct {
+		name          string
+		byMarket      map[int]map[string]*marketMonth
+		radius        float64
+		source        string
+		sourceMarket  string
+		countyMarkets int
+		price         float64
+		estimated     bool
+		cpiEstimated  bool
+	}{
+		{
+			name:     "own price",
+			byMarket: map[int]map[string]*marketMonth{1: {"2024-03": priced(100)}, 2: {"2024-03": priced(80)}},
+			radius:   100,
+			source:   SourceMarket, price: 100,
+		},
+		{
+			name:     "own prices of a month are averaged",
+			byMarket: map[int]map[string]*marketMonth{1: {"2024-03": {sum: 210, count: 2, estimated: true}}},
+			radius:   100,
+			source:   SourceMarket, price: 105, estimated: true,
+		},
+		{
+			name:     "own price of another month",
+			byMarket: map[int]map[string]*marketMonth{1: {"2024-02": priced(100)}, 2: {"2024-03": priced(80)}},
+			radius:   100,
+			source:   SourceNearest, sourceMarket: "Near", price: 80,
+		},
+		{
+			name:     "the nearest within the radius wins",
+			byMarket: map[int]map[string]*marketMonth{2: {"2024-03": priced(80)}, 3: {"2024-03": priced(60)}},
+			radius:   250,
+			source:   SourceNearest, sourceMarket: "Near", price: 80,
+		},
+		{
+			name:     "nearest keeps its flags",
+			byMarket: map[int]map[string]*marketMonth{3: {"2024-03": {sum: 60, count: 1, estimated: true, cpiEstimated: true}}, 4: {"2024-03": priced(70)}},
+			radius:   400,
+			source:   SourceNearest, sourceMarket: "Far", price: 60, estimated: true, cpiEstimated: true,
+		},
+		{
+			name:     "beyond the radius, the county median",
+			byMarket: map[int]map[string]*marketMonth{3: {"2024-03": priced(60)}, 4: {"2024-03": {sum: 70, count: 1, cpiEstimated: true}}},
+			radius:   100,
+			source:   SourceCounty, countyMarkets: 2, price: 65, cpiEstimated: true,
+		},
+		{
+			name:     "the county median leaves out other counties",
+			byMarket: map[int]map[string]*marketMonth{2: {"2024-03": priced(80)}, 3: {"2024-03": {sum: 60, count: 1, estimated: true}}},
+			radius:   10,
+			source:   SourceCounty, countyMarkets: 1, price: 60, estimated: true,
+		},
+		{
+			name:     "only another county beyond the radius",
+			byMarket: map[int]map[string]*marketMonth{2: {"2024-03": priced(80)}},
+			radius:   10,
+			source:   SourceMissing,
+		},
+		{
+			name:     "unknown markets are left out",
+			byMarket: map[int]map[string]*marketMonth{99: {"2024-03": priced(80)}},
+			radius:   100,
+			source:   SourceMissing,
+		},
+		{
+			name:   "no prices",
+			radius: 100,
+			source: SourceMissing,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prices := basketPrices{tt.byMarket}
+			if prices[0] == nil {
+				prices[0] = map[int]map[string]*marketMonth{}
+			}
+			cost := basketCost(foodData, basket, prices, home, "2024-03", "en", tt.radius)
+			if len(cost.Items) != 1 {
+				t.Fatalf("%d items, want 1", len(cost.Items))
+			}
+			ic := cost.Items[0]
+			if ic.Source != tt.source || ic.SourceMarket != tt.sourceMarket || ic.CountyMarkets != tt.countyMarkets {
+				t.Errorf("source %s %q of %d markets, want %s %q of %d",
+					ic.Source, ic.SourceMarket, ic.CountyMarkets, tt.source, tt.sourceMarket, tt.countyMarkets)
+			}
+			if ic.Estimated != tt.estimated || ic.CPIEstimated != tt.cpiEstimated {
+				t.Errorf("estimated=%v cpi_estimated=%v, want %v and %v", ic.Estimated, ic.CPIEstimated, tt.estimated, tt.cpiEstimated)
+			}
+			if tt.source == SourceMissing {
+				if ic.Price != nil || ic.Cost != nil || cost.Complete || cost.Missing != 1 {
+					t.Errorf("missing item priced: %+v", cost)
+				}
+				return
+			}
+			if ic.Price == nil || *ic.Price != tt.price || *ic.Cost != 2*tt.price || cost.Cost != 2*tt.price {
+				t.Errorf("price %v cost %v, want %v and %v", ic.Price, ic.Cost, tt.price, 2*tt.price)
+			}
+			if tt.source == SourceNearest {
+				want := distanceKm(home.Location, foodData.MarketsByName(tt.sourceMarket)[0].Location)
+				if math.Abs(ic.DistanceKm-want) > 1e-9 {
+					t.Errorf("%v km away, want %v", ic.DistanceKm, want)
+				}
+			}
+		})
+	}
+}
+
+func TestBasketCostTotals(t *testing.T) {
+	foodData := basketTestData(t)
+	basket := &Basket{Items: []*BasketItem{
+		{Commodity: "maize", Quantity: 10, Unit: "kg"},
+		{Commodity: "beans", Quantity: 2, Unit: "kg"},
+		{Commodity: "oil", Quantity: 1, Unit: "l"},
+		{Commodity: "sugar", Quantity: 3, Unit: "kg"},
+	}}
+	prices := basketPrices{
+		{1: {"2024-03": priced(50)}},
+		{2: {"2024-03": priced(120)}},
+		{3: {"2024-03": priced(300)}},
+		{},
+	}
+
+	cost := basketCost(foodData, basket, prices, &foodData.Markets[0], "2024-03", "en", 100)
+	sources := []string{SourceMarket, SourceNearest, SourceCounty, SourceMissing}
+	for i, ic := range cost.Items {
+		if ic.Source != sources[i] || ic.Name != basket.Items[i].Commodity {
+			t.Errorf("%s from %s, want %s", ic.Name, ic.Source, sources[i])
+		}
+	}
+	if cost.Cost != 10*50+2*120+300 || cost.Filled != 2 || cost.Missing != 1 || cost.Complete {
+		t.Errorf("cost %v, %d filled, %d missing, complete=%v; want 1040, 2, 1, false", cost.Cost, cost.Filled, cost.Missing, cost.Complete)
+	}
+	if cost.Month != "2024-03" || cost.Label == "" {
+		t.Errorf("month %q labelled %q", cost.Month, cost.Label)
+	}
+
+	prices[3] = map[int]map[string]*marketMonth{1: {"2024-03": priced(150)}}
+	cost = basketCost(foodData, basket, prices, &foodData.Markets[0], "2024-03", "en", 100)
+	if cost.Cost != 1040+3*150 || cost.Filled != 2 || cost.Missing != 0 || !cost.Complete {
+		t.Errorf("cost %v, %d filled, %d missing, complete=%v; want 1490, 2, 0, true", cost.Cost, cost.Filled, cost.Missing, cost.Complete)
+	}
+}
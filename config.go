@@ -0,0 +1,363 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/goccy/go-yaml"
+	"github.com/pelletier/go-toml/v2"
+)
+
+// ==================== CONFIGURATION ====================
+
+// Config is the server configuration. Values are layered, later ones
+// winning: built-in defaults, the config file (-config or KLIMAT_CONFIG),
+// KLIMAT_* environment variables, then command-line flags.
+type Config struct {
+	DataFile      string   `yaml:"data_file" toml:"data_file"`
+	CPIFile       string   `yaml:"cpi_file" toml:"cpi_file"`
+	TaxonomyFile  string   `yaml:"taxonomy_file" toml:"taxonomy_file"`
+	BasketsFile   string   `yaml:"baskets_file" toml:"baskets_file"`
+	DataDir       string   `yaml:"data_dir" toml:"data_dir"`
+	Listen        string   `yaml:"listen" toml:"listen"`
+	TLSCert       string   `yaml:"tls_cert" toml:"tls_cert"`
+	TLSKey        string   `yaml:"tls_key" toml:"tls_key"`
+	CORSOrigins   []string `yaml:"cors_origins" toml:"cors_origins"`
+	StaticRoot    string   `yaml:"static_root" toml:"static_root"`
+	LogLevel      string   `yaml:"log_level" toml:"log_level"`
+	LogFormat     string   `yaml:"log_format" toml:"log_format"`
+	AdminToken    string   `yaml:"admin_token" toml:"admin_token"`
+	WatchInterval duration `yaml:"watch_interval" toml:"watch_interval"`
+
+	AuthSecret     string   `yaml:"auth_secret" toml:"auth_secret"`         // signs session tokens; generated into data_dir if empty
+	SMSSender      string   `yaml:"sms_sender" toml:"sms_sender"`           // log or file
+	SMSFile        string   `yaml:"sms_file" toml:"sms_file"`               // default: sms.log in data_dir
+	TrustedProxies []string `yaml:"trusted_proxies" toml:"trusted_proxies"` // whose X-Forwarded-For gives the client IP
+
+	TransportCostPerKmKg float64 `yaml:"transport_cost_per_km_kg" toml:"transport_cost_per_km_kg"` // KES, for /api/prices/best and /api/analysis/spread
+}
+
+// duration is a time.Duration written as "30s" in config files.
+type duration time.Duration
+
+func (d *duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+func (d duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func defaultConfig() Config {
+	return Config{
+		DataFile:      "./wfp_food_prices_ken(1).csv",
+		CPIFile:       "./kenya.json",
+		TaxonomyFile:  "./commodity_taxonomy.json",
+		BasketsFile:   "./baskets.json",
+		DataDir:       "./data",
+		Listen:        ":8080",
+		CORSOrigins:   []string{"*"},
+		StaticRoot:    "./ui/dist",
+		LogLevel:      "info",
+		LogFormat:     "text",
+		WatchInterval: duration(30 * time.Second),
+		SMSSender:     "log",
+
+		// Roughly KES 400 to move a 90 kg bag 100 km
+		TransportCostPerKmKg: 0.045,
+	}
+}
+
+// configOption binds one setting to its flag and environment variable.
+type configOption struct {
+	Flag  string
+	Usage string
+	Set   func(cfg *Config, value string) error
+}
+
+// Env is the option's environment variable, e.g. KLIMAT_DATA_FILE.
+func (o configOption) Env() string {
+	return "KLIMAT_" + strings.ToUpper(strings.ReplaceAll(o.Flag, "-", "_"))
+}
+
+func setString(field func(*Config) *string) func(*Config, string) error {
+	return func(cfg *Config, v string) error {
+		*field(cfg) = v
+		return nil
+	}
+}
+
+var configOptions = []configOption{
+	{"data-file", "WFP food price CSV", setString(func(c *Config) *string { return &c.DataFile })},
+	{"cpi-file", "World Bank CPI JSON for real prices (empty disables)", setString(func(c *Config) *string { return &c.CPIFile })},
+	{"taxonomy-file", "commodity taxonomy JSON (empty leaves every commodity unclassified)", setString(func(c *Config) *string { return &c.TaxonomyFile })},
+	{"baskets-file", "food baskets JSON for /api/basket (empty leaves only posted baskets)", setString(func(c *Config) *string { return &c.BasketsFile })},
+	{"data-dir", "directory of the embedded storage for data written through the API", setString(func(c *Config) *string { return &c.DataDir })},
+	{"listen", "listen address, host:port", setString(func(c *Config) *string { return &c.Listen })},
+	{"tls-cert", "TLS certificate file", setString(func(c *Config) *string { return &c.TLSCert })},
+	{"tls-key", "TLS private key file", setString(func(c *Config) *string { return &c.TLSKey })},
+	{"cors-origins", "comma-separated allowed CORS origins, * for any", func(c *Config, v string) error {
+		c.CORSOrigins = splitList(v)
+		return nil
+	}},
+	{"static-root", "directory of the built PWA (empty serves the API only)", setString(func(c *Config) *string { return &c.StaticRoot })},
+	{"log-level", "debug, info, warn or error", setString(func(c *Config) *string { return &c.LogLevel })},
+	{"log-format", "text or json", setString(func(c *Config) *string { return &c.LogFormat })},
+	{"admin-token", "bearer token for /api/admin (empty disables)", setString(func(c *Config) *string { return &c.AdminToken })},
+	{"transport-cost-per-km-kg", "transport cost in KES per km per kg for /api/prices/best", func(c *Config, v string) error {
+		f, err := strconv.ParseFloat(v, 64)
+		c.TransportCostPerKmKg = f
+		return err
+	}},
+	{"watch-interval", "how often to check the data files for changes, 0 disables", func(c *Config, v string) error {
+		return c.WatchInterval.UnmarshalText([]byte(v))
+	}},
+	{"auth-secret", "secret signing session tokens, at least 32 characters (empty generates one in data-dir)", setString(func(c *Config) *string { return &c.AuthSecret })},
+	{"sms-sender", "how sign-in codes are sent: log or file", setString(func(c *Config) *string { return &c.SMSSender })},
+	{"sms-file", "file the file SMS sender appends to (empty for sms.log in data-dir)", setString(func(c *Config) *string { return &c.SMSFile })},
+	{"trusted-proxies", "comma-separated proxy IPs or CIDRs whose X-Forwarded-For is trusted (empty trusts none)", func(c *Config, v string) error {
+		c.TrustedProxies = splitList(v)
+		return nil
+	}},
+}
+
+// LoadConfig builds the configuration from args (without the program
+// name) and the environment, and validates it.
+func LoadConfig(args []string) (Config, error) {
+	fs := flag.NewFlagSet("klimat", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("KLIMAT_CONFIG"), "YAML or TOML config file (env KLIMAT_CONFIG)")
+
+	// Flags are applied last, so just record them while parsing
+	type setting struct {
+		opt   configOption
+		value string
+	}
+	var flagged []setting
+	for _, opt := range configOptions {
+		opt := opt
+		fs.Func(opt.Flag, fmt.Sprintf("%s (env %s)", opt.Usage, opt.Env()), func(v string) error {
+			flagged = append(flagged, setting{opt, v})
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	cfg := defaultConfig()
+	if *configFile != "" {
+		if err := loadConfigFile(*configFile, &cfg); err != nil {
+			return Config{}, err
+		}
+	}
+	for _, opt := range configOptions {
+		if v, ok := os.LookupEnv(opt.Env()); ok {
+			if err := opt.Set(&cfg, v); err != nil {
+				return Config{}, fmt.Errorf("%s: %w", opt.Env(), err)
+			}
+		}
+	}
+	for _, s := range flagged {
+		if err := s.opt.Set(&cfg, s.value); err != nil {
+			return Config{}, fmt.Errorf("-%s: %w", s.opt.Flag, err)
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// loadConfigFile overlays the settings in a .yaml/.yml or .toml file onto cfg.
+func loadConfigFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalWithOptions(data, cfg, yaml.DisallowUnknownField())
+	case ".toml":
+		err = toml.NewDecoder(strings.NewReader(string(data))).DisallowUnknownFields().Decode(cfg)
+	default:
+		return fmt.Errorf("config file %s: unsupported format (use .yaml, .yml or .toml)", path)
+	}
+	if err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate reports every invalid setting at once.
+func (cfg Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	fileExists := func(path string) bool {
+		info, err := os.Stat(path)
+		return err == nil && !info.IsDir()
+	}
+
+	check(cfg.DataFile != "", "data_file is required")
+	check(cfg.DataFile == "" || fileExists(cfg.DataFile), "data_file %q does not exist", cfg.DataFile)
+	check(cfg.CPIFile == "" || fileExists(cfg.CPIFile), "cpi_file %q does not exist", cfg.CPIFile)
+	check(cfg.TaxonomyFile == "" || fileExists(cfg.TaxonomyFile), "taxonomy_file %q does not exist", cfg.TaxonomyFile)
+	check(cfg.BasketsFile == "" || fileExists(cfg.BasketsFile), "baskets_file %q does not exist", cfg.BasketsFile)
+	if info, err := os.Stat(cfg.DataDir); cfg.DataDir == "" || (err == nil && !info.IsDir()) {
+		check(false, "data_dir %q is not a directory", cfg.DataDir)
+	}
+
+	_, _, err := net.SplitHostPort(cfg.Listen)
+	check(err == nil, "listen %q is not a host:port address", cfg.Listen)
+
+	check((cfg.TLSCert == "") == (cfg.TLSKey == ""), "tls_cert and tls_key must be set together")
+	check(cfg.TLSCert == "" || fileExists(cfg.TLSCert), "tls_cert %q does not exist", cfg.TLSCert)
+	check(cfg.TLSKey == "" || fileExists(cfg.TLSKey), "tls_key %q does not exist", cfg.TLSKey)
+
+	check(len(cfg.CORSOrigins) > 0, "cors_origins must list at least one origin (or *)")
+	for _, origin := range cfg.CORSOrigins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && (u.Path == "" || u.Path == "/"),
+			"cors origin %q must look like https://example.org", origin)
+	}
+
+	if cfg.StaticRoot != "" {
+		info, err := os.Stat(cfg.StaticRoot)
+		check(err == nil && info.IsDir(), "static_root %q is not a directory", cfg.StaticRoot)
+	}
+
+	_, err = parseLogLevel(cfg.LogLevel)
+	check(err == nil, "log_level %q must be debug, info, warn or error", cfg.LogLevel)
+	check(cfg.LogFormat == "text" || cfg.LogFormat == "json", "log_format %q must be text or json", cfg.LogFormat)
+	check(cfg.WatchInterval >= 0, "watch_interval must not be negative")
+	check(cfg.TransportCostPerKmKg >= 0, "transport_cost_per_km_kg must not be negative")
+
+	check(cfg.AuthSecret == "" || len(cfg.AuthSecret) >= 32, "auth_secret must be at least 32 characters")
+	check(cfg.SMSSender == "log" || cfg.SMSSender == "file", "sms_sender %q must be log or file", cfg.SMSSender)
+	for _, proxy := range cfg.TrustedProxies {
+		_, _, err := net.ParseCIDR(proxy)
+		check(err == nil || net.ParseIP(proxy) != nil, "trusted proxy %q is not an IP or CIDR", proxy)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// ==================== LOGGING ====================
+
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(s))
+	return level, err
+}
+
+// setupLogging installs the configured slog handler as the default
+// logger. The standard log package writes through it as well.
+func setupLogging(cfg Config) {
+	level, _ := parseLogLevel(cfg.LogLevel)
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler = slog.NewTextHandler(os.Stderr, opts)
+	if cfg.LogFormat == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+// requestLogger logs one line per request through slog.
+func requestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		level := slog.LevelInfo
+		switch {
+		case c.Writer.Status() >= 500:
+			level = slog.LevelError
+		case c.Writer.Status() >= 400:
+			level = slog.LevelWarn
+		}
+		slog.Log(c.Request.Context(), level, "request",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"query", c.Request.URL.RawQuery,
+			"status", c.Writer.Status(),
+			"duration", time.Since(start),
+			"client", c.ClientIP(),
+		)
+	}
+}
+
+// ==================== CORS ====================
+
+// corsMiddleware answers CORS requests from the allowed origins. "*"
+// allows any origin; otherwise the request's Origin is echoed back only
+// when it is on the list.
+func corsMiddleware(origins []string) gin.HandlerFunc {
+	allowAll := false
+	allowed := make(map[string]bool)
+	for _, origin := range origins {
+		if origin == "*" {
+			allowAll = true
+		}
+		allowed[strings.TrimSuffix(origin, "/")] = true
+	}
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		switch {
+		case allowAll:
+			c.Header("Access-Control-Allow-Origin", "*")
+		case origin != "" && allowed[origin]:
+			c.Header("Access-Control-Allow-Origin", origin)
+			c.Header("Vary", "Origin")
+		case origin != "":
+			c.Header("Vary", "Origin")
+			if c.Request.Method == "OPTIONS" {
+				c.AbortWithStatus(403)
+				return
+			}
+		}
+		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		c.Header("Access-Control-Expose-Headers", "Retry-After, X-Commodity-Id, X-Commodity-Match, X-Commodity-Matched-Alias")
+		if c.Request.Method == "OPTIONS" {
+			c.AbortWithStatus(204)
+			return
+		}
+		c.Next()
+	}
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
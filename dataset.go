@@ -0,0 +1,276 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== DATASET HOLDER ====================
+
+// DatasetStore holds the FoodData currently served by the API and swaps
+// in a freshly parsed copy on reload. Handlers call Load once per request
+// and work on that snapshot, so a reload never changes data underneath a
+// request that is already running.
+type DatasetStore struct {
+	sources DataSources
+	current atomic.Pointer[FoodData]
+
+	reloading sync.Mutex // serializes reloads, held while parsing
+
+	mu          sync.Mutex // guards the fields below, never held while parsing
+	status      DatasetStatus
+	seen        map[string]fileStamp // file versions the last reload was attempted for
+	subscribers []chan *FoodData
+}
+
+// DatasetStatus describes the dataset being served and the last reload attempt.
+type DatasetStatus struct {
+	Source         string    `json:"source"`
+	CPISource      string    `json:"cpi_source,omitempty"`
+	TaxonomySource string    `json:"taxonomy_source,omitempty"`
+	BasketsSource  string    `json:"baskets_source,omitempty"`
+	Markets        int       `json:"markets"`
+	Commodities    int       `json:"commodities"`
+	LoadedAt       time.Time `json:"loaded_at"`
+	Trigger        string    `json:"trigger"`
+	LastAttempt    time.Time `json:"last_attempt"`
+	LastError      string    `json:"last_error,omitempty"`
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// DataSources are the files a snapshot is built from. Only Prices is
+// required.
+type DataSources struct {
+	Prices   string // WFP price CSV
+	CPI      string // World Bank CPI response, for real prices
+	Taxonomy string // commodity taxonomy, see LoadTaxonomy
+	Baskets  string // food baskets, see LoadBaskets
+}
+
+// NewDatasetStore loads the dataset from sources. It fails if the initial
+// load fails, since there is no previous snapshot to fall back to.
+func NewDatasetStore(sources DataSources) (*DatasetStore, error) {
+	s := &DatasetStore{sources: sources, seen: make(map[string]fileStamp)}
+	if err := s.Reload("startup"); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Load returns the current snapshot. Callers must treat it as read-only.
+func (s *DatasetStore) Load() *FoodData {
+	return s.current.Load()
+}
+
+// Subscribe returns a channel receiving every snapshot loaded from now on.
+// A receiver that falls behind only misses snapshots that a newer one has
+// already replaced.
+func (s *DatasetStore) Subscribe() <-chan *FoodData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch := make(chan *FoodData, 1)
+	s.subscribers = append(s.subscribers, ch)
+	return ch
+}
+
+// Status returns a copy of the current dataset status.
+func (s *DatasetStore) Status() DatasetStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+// Reload parses the data files again and, if that succeeds, atomically
+// replaces the current snapshot. On failure the previous snapshot stays
+// in place and the error is recorded in the status. Parsing happens
+// outside mu, so Status and Subscribe don't wait for it.
+func (s *DatasetStore) Reload(trigger string) error {
+	s.reloading.Lock()
+	defer s.reloading.Unlock()
+
+	attempt := time.Now()
+	stamps := make(map[string]fileStamp)
+	for _, path := range s.files() {
+		if stamp, err := statFile(path); err == nil {
+			stamps[path] = stamp
+		}
+	}
+	s.mu.Lock()
+	s.status.LastAttempt = attempt
+	for path, stamp := range stamps {
+		s.seen[path] = stamp
+	}
+	s.mu.Unlock()
+
+	foodData, err := s.load()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		err = fmt.Errorf("reload (%s): %w", trigger, err)
+		s.status.LastError = err.Error()
+		log.Printf("⚠️  %v; keeping previous dataset", err)
+		return err
+	}
+
+	s.current.Store(foodData)
+	s.status = DatasetStatus{
+		Source:         s.sources.Prices,
+		CPISource:      s.sources.CPI,
+		TaxonomySource: s.sources.Taxonomy,
+		BasketsSource:  s.sources.Baskets,
+		Markets:        len(foodData.Markets),
+		Commodities:    len(foodData.Commodities),
+		LoadedAt:       attempt,
+		Trigger:        trigger,
+		LastAttempt:    attempt,
+	}
+	log.Printf("🔄 Dataset loaded from %s (%s)", s.sources.Prices, trigger)
+	for _, ch := range s.subscribers {
+		select {
+		case <-ch: // not received yet, replace it
+		default:
+		}
+		ch <- foodData
+	}
+	return nil
+}
+
+// load reads every data file into a new snapshot.
+func (s *DatasetStore) load() (*FoodData, error) {
+	foodData, err := ReadData(s.sources.Prices)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.sources.Prices, err)
+	}
+	if len(foodData.Commodities) == 0 {
+		return nil, fmt.Errorf("%s: no valid rows", s.sources.Prices)
+	}
+
+	taxonomy, err := LoadTaxonomy(s.sources.Taxonomy)
+	if err != nil {
+		return nil, err
+	}
+	taxonomy.Classify(foodData.Commodities)
+	foodData.Taxonomy = taxonomy
+
+	if foodData.Baskets, err = LoadBaskets(s.sources.Baskets, taxonomy); err != nil {
+		return nil, err
+	}
+
+	if s.sources.CPI != "" {
+		cpi, err := LoadWorldBankIndicator(s.sources.CPI)
+		if err != nil {
+			return nil, err
+		}
+		// Prices usually run ahead of the published CPI
+		cpi.ExtendTo(foodData.LastYear())
+		foodData.CPI = cpi
+	}
+
+	foodData.Alerts = computeAlerts(&foodData)
+	return &foodData, nil
+}
+
+// files returns the paths the store loads from.
+func (s *DatasetStore) files() []string {
+	files := []string{s.sources.Prices}
+	for _, path := range []string{s.sources.CPI, s.sources.Taxonomy, s.sources.Baskets} {
+		if path != "" {
+			files = append(files, path)
+		}
+	}
+	return files
+}
+
+// Watch polls the data files every interval and reloads when the size or
+// modification time of one of them changes. Files that failed to load are
+// not retried until they change again.
+func (s *DatasetStore) Watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		changed := false
+		s.mu.Lock()
+		for _, path := range s.files() {
+			if stamp, err := statFile(path); err == nil && stamp != s.seen[path] {
+				changed = true
+			}
+		}
+		s.mu.Unlock()
+		if changed {
+			s.Reload("file change")
+		}
+	}
+}
+
+// ReloadOnSignal reloads the dataset every time the process receives SIGHUP.
+func (s *DatasetStore) ReloadOnSignal() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	for range ch {
+		s.Reload("SIGHUP")
+	}
+}
+
+func statFile(path string) (fileStamp, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+// ==================== ADMIN ENDPOINTS ====================
+
+// requireAdminToken only lets through requests carrying
+// "Authorization: Bearer <token>". An empty token disables the endpoints.
+func requireAdminToken(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin endpoints are disabled"})
+			return
+		}
+		got, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid admin token"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// registerAdminRoutes adds the dataset status and reload endpoints.
+func registerAdminRoutes(router *gin.Engine, store *DatasetStore, token string) {
+	admin := router.Group("/api/admin", requireAdminToken(token))
+
+	admin.GET("/dataset", func(c *gin.Context) {
+		c.JSON(200, store.Status())
+	})
+
+	admin.POST("/reload", func(c *gin.Context) {
+		if err := store.Reload("admin endpoint"); err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error":  err.Error(),
+				"status": store.Status(),
+			})
+			return
+		}
+		c.JSON(200, store.Status())
+	})
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+const (
+	oneMarketRows = `2024-01-15,Coast,Mombasa,Kongowea,1,-4.04,39.68,cereals and tubers,Maize,51,KG,actual,Retail,KES,50,0.38
+`
+	twoMarketRows = oneMarketRows + `2024-01-15,Nairobi,Nairobi,Kibera,4,-1.31,36.78,cereals and tubers,Beans,66,KG,actual,Retail,KES,120,0.92
+`
+)
+
+func TestReloadSwap(t *testing.T) {
+	store := csvTestStore(t, oneMarketRows)
+	path := store.Status().Source
+	updates := store.Subscribe()
+	old := store.Load()
+
+	if err := os.WriteFile(path, []byte(pricesHeader+twoMarketRows), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Reload("test"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A request holding the old snapshot keeps seeing it whole
+	if len(old.Markets) != 1 || len(old.Commodities) != 1 {
+		t.Errorf("old snapshot changed: %d markets, %d commodities", len(old.Markets), len(old.Commodities))
+	}
+	current := store.Load()
+	if current == old || len(current.Markets) != 2 || len(current.Commodities) != 2 {
+		t.Errorf("reloaded snapshot has %d markets, %d commodities", len(current.Markets), len(current.Commodities))
+	}
+	select {
+	case got := <-updates:
+		if got != current {
+			t.Error("subscriber got another snapshot")
+		}
+	default:
+		t.Error("subscriber not told")
+	}
+
+	status := store.Status()
+	if status.Markets != 2 || status.Commodities != 2 || status.Trigger != "test" || status.LastError != "" ||
+		!status.LoadedAt.Equal(status.LastAttempt) {
+		t.Errorf("status %+v", status)
+	}
+}
+
+func TestReloadFailure(t *testing.T) {
+	store := csvTestStore(t, oneMarketRows)
+	path := store.Status().Source
+	updates := store.Subscribe()
+	old, loaded := store.Load(), store.Status()
+
+	for _, content := range []string{pricesHeader, "not,a,price,export\n"} {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		err := store.Reload("test")
+		if err == nil || !strings.Contains(err.Error(), "reload (test)") {
+			t.Fatalf("reload of %q: %v", content, err)
+		}
+		if store.Load() != old {
+			t.Error("failed reload replaced the snapshot")
+		}
+		status := store.Status()
+		if status.LastError != err.Error() || !status.LastAttempt.After(loaded.LoadedAt) {
+			t.Errorf("status %+v after %v", status, err)
+		}
+		// The status still describes the dataset being served
+		if status.Markets != 1 || status.Trigger != "startup" || !status.LoadedAt.Equal(loaded.LoadedAt) {
+			t.Errorf("status %+v, loaded %+v", status, loaded)
+		}
+		select {
+		case <-updates:
+			t.Error("subscriber told of a failed reload")
+		default:
+		}
+	}
+
+	// Fixing the file clears the error
+	if err := os.WriteFile(path, []byte(pricesHeader+twoMarketRows), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Reload("fixed"); err != nil {
+		t.Fatal(err)
+	}
+	if status := store.Status(); status.LastError != "" || status.Markets != 2 || status.Trigger != "fixed" {
+		t.Errorf("status %+v", status)
+	}
+}
@@ -0,0 +1,65 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestStatusDuringReload(t *testing.T) {
+	store := csvTestStore(t, oneMarketRows)
+	old, loaded := store.Load(), store.Status()
+
+	// Parsing a FIFO blocks until something is written to it
+	fifo := filepath.Join(t.TempDir(), "prices.csv")
+	if err := syscall.Mkfifo(fifo, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	store.sources.Prices = fifo
+	done := make(chan error, 1)
+	go func() { done <- store.Reload("fifo") }()
+
+	// Status and Subscribe answer while the reload is parsing, which the
+	// status shows as a new attempt
+	answered := make(chan DatasetStatus, 1)
+	go func() {
+		store.Subscribe()
+		for {
+			if status := store.Status(); status.LastAttempt.After(loaded.LastAttempt) {
+				answered <- status
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	select {
+	case status := <-answered:
+		if status.Trigger != "startup" || status.Markets != 1 || status.LastError != "" {
+			t.Errorf("status %+v during the reload", status)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Status blocked on the reload")
+	}
+	if store.Load() != old {
+		t.Error("snapshot replaced before the reload finished")
+	}
+
+	w, err := os.OpenFile(fifo, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.WriteString(pricesHeader + twoMarketRows); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if status := store.Status(); status.Trigger != "fifo" || status.Markets != 2 || len(store.Load().Markets) != 2 {
+		t.Errorf("status %+v after the reload", status)
+	}
+}
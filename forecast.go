@@ -0,0 +1,403 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== FORECASTING ====================
+
+const (
+	seasonLength      = 12
+	forecastMaxGap    = 2  // longest gap, in months, filled by interpolation
+	forecastMaxMonths = 24 // longest horizon
+)
+
+// Forecast methods, best first. Holt-Winters needs two seasons of history
+// that are at least half observed, seasonal naive a price for every
+// calendar month and one year-on-year pair. Both carry on through months
+// without a price.
+const (
+	MethodHoltWinters   = "holt-winters"
+	MethodSeasonalNaive = "seasonal-naive-drift"
+)
+
+// forecastModel predicts the months after a history.
+type forecastModel interface {
+	// Forecast returns point forecasts and their standard errors for the
+	// next h months.
+	Forecast(h int) (points, stderr []float64)
+}
+
+// holtWinters is additive Holt-Winters exponential smoothing fitted to a
+// history.
+type holtWinters struct {
+	Alpha        float64 `json:"alpha"` // level smoothing
+	Beta         float64 `json:"beta"`  // trend smoothing
+	Gamma        float64 `json:"gamma"` // seasonal smoothing
+	level, trend float64
+	season       []float64 // seasonal terms, season[t%seasonLength] for month t
+	n            int       // months of history
+	sigma        float64   // sd of the one-step-ahead errors
+}
+
+// smoothingGrid holds the candidate alpha, beta and gamma values.
+var smoothingGrid = []float64{0.01, 0.05, 0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9}
+
+// fitHoltWinters grid-searches the smoothing parameters with the lowest
+// one-step-ahead squared error. Months before the first two seasons that
+// are at least half observed are dropped.
+func fitHoltWinters(y []float64) (*holtWinters, error) {
+	m := seasonLength
+	start := 0
+	for start+2*m <= len(y) && (observed(y[start:start+m]) < m/2 || observed(y[start+m:start+2*m]) < m/2) {
+		start++
+	}
+	if start+2*m > len(y) {
+		return nil, fmt.Errorf("holt-winters needs two seasons with %d months of prices each", m/2)
+	}
+	y = y[start:]
+
+	var best *holtWinters
+	bestSSE := math.Inf(1)
+	for _, alpha := range smoothingGrid {
+		for _, beta := range smoothingGrid {
+			for _, gamma := range smoothingGrid {
+				hw := &holtWinters{Alpha: alpha, Beta: beta, Gamma: gamma}
+				if sse := hw.fit(y); sse < bestSSE {
+					best, bestSSE = hw, sse
+				}
+			}
+		}
+	}
+	return best, nil
+}
+
+// fit runs the smoothing over y and returns the sum of squared one-step
+// errors after the first season, which only initializes the state. A
+// month without a price advances the level by the trend and updates
+// nothing else.
+func (hw *holtWinters) fit(y []float64) float64 {
+	m := seasonLength
+	first, second := nanMean(y[:m]), nanMean(y[m:2*m])
+
+	hw.level = first
+	hw.trend = (second - first) / float64(m)
+	hw.season = make([]float64, m)
+	for i := 0; i < m; i++ {
+		if !math.IsNaN(y[i]) {
+			hw.season[i] = y[i] - first
+		}
+	}
+
+	sse, errs := 0.0, 0
+	for t := m; t < len(y); t++ {
+		s := hw.season[t%m]
+		if math.IsNaN(y[t]) {
+			hw.level += hw.trend
+			continue
+		}
+		e := y[t] - (hw.level + hw.trend + s)
+		sse += e * e
+		errs++
+
+		level := hw.Alpha*(y[t]-s) + (1-hw.Alpha)*(hw.level+hw.trend)
+		hw.trend = hw.Beta*(level-hw.level) + (1-hw.Beta)*hw.trend
+		hw.season[t%m] = hw.Gamma*(y[t]-level) + (1-hw.Gamma)*s
+		hw.level = level
+	}
+	hw.n = len(y)
+	hw.sigma = math.Sqrt(sse / float64(max(1, errs)))
+	return sse
+}
+
+// Forecast uses the variance of the additive model's h-step errors,
+// sigma² (1 + sum over j<h of (alpha + alpha beta j + gamma [j is a whole season])²).
+func (hw *holtWinters) Forecast(h int) ([]float64, []float64) {
+	points := make([]float64, h)
+	stderr := make([]float64, h)
+	variance := 1.0
+	for i := 1; i <= h; i++ {
+		points[i-1] = hw.level + float64(i)*hw.trend + hw.season[(hw.n+i-1)%seasonLength]
+		stderr[i-1] = hw.sigma * math.Sqrt(variance)
+
+		c := hw.Alpha + hw.Alpha*hw.Beta*float64(i)
+		if i%seasonLength == 0 {
+			c += hw.Gamma
+		}
+		variance += c * c
+	}
+	return points, stderr
+}
+
+// seasonalNaive forecasts each month as the latest price of the same
+// calendar month plus the average yearly drift since.
+type seasonalNaive struct {
+	lastSeason []float64 // latest price of each of the last seasonLength calendar months
+	age        []int     // years between lastSeason[i] and the last season
+	drift      float64   // mean year-on-year change
+	sigma      float64   // sd of the year-on-year changes around the drift
+}
+
+func fitSeasonalNaive(y []float64) (*seasonalNaive, error) {
+	m := seasonLength
+	var diffs []float64
+	for t := m; t < len(y); t++ {
+		if !math.IsNaN(y[t]) && !math.IsNaN(y[t-m]) {
+			diffs = append(diffs, y[t]-y[t-m])
+		}
+	}
+	if len(diffs) == 0 {
+		return nil, fmt.Errorf("seasonal naive needs a month with a price a year earlier")
+	}
+
+	sn := &seasonalNaive{lastSeason: make([]float64, m), age: make([]int, m)}
+	for i := 0; i < m; i++ {
+		t := len(y) - m + i
+		for t >= 0 && math.IsNaN(y[t]) {
+			t -= m
+		}
+		if t < 0 {
+			return nil, fmt.Errorf("seasonal naive needs a price for every calendar month")
+		}
+		sn.lastSeason[i] = y[t]
+		sn.age[i] = (len(y) - m + i - t) / m
+	}
+	for _, d := range diffs {
+		sn.drift += d
+	}
+	sn.drift /= float64(len(diffs))
+	for _, d := range diffs {
+		sn.sigma += (d - sn.drift) * (d - sn.drift)
+	}
+	sn.sigma = math.Sqrt(sn.sigma / float64(max(1, len(diffs)-1)))
+	return sn, nil
+}
+
+func (sn *seasonalNaive) Forecast(h int) ([]float64, []float64) {
+	points := make([]float64, h)
+	stderr := make([]float64, h)
+	for i := 0; i < h; i++ {
+		years := i/seasonLength + 1 + sn.age[i%seasonLength]
+		points[i] = sn.lastSeason[i%seasonLength] + float64(years)*sn.drift
+		stderr[i] = sn.sigma * math.Sqrt(float64(years))
+	}
+	return points, stderr
+}
+
+// fitForecastModel fits the best method the history allows.
+func fitForecastModel(y []float64) (forecastModel, string, error) {
+	if hw, err := fitHoltWinters(y); err == nil {
+		return hw, MethodHoltWinters, nil
+	}
+	sn, err := fitSeasonalNaive(y)
+	if err != nil {
+		return nil, "", err
+	}
+	return sn, MethodSeasonalNaive, nil
+}
+
+// ForecastBacktest is the error of forecasting the last months of the
+// history from the months before them.
+type ForecastBacktest struct {
+	Months    int     `json:"months"` // held-out months
+	Method    string  `json:"method"`
+	MAPE      float64 `json:"mape"`       // mean absolute percentage error, in percent
+	NaiveMAPE float64 `json:"naive_mape"` // the same for a plain seasonal naive forecast
+}
+
+// backtest holds out the last holdout months of y, forecasts them from the
+// rest and scores the forecast.
+func backtest(y []float64, holdout int) (ForecastBacktest, bool) {
+	train, test := y[:len(y)-holdout], y[len(y)-holdout:]
+	model, method, err := fitForecastModel(train)
+	if err != nil {
+		return ForecastBacktest{}, false
+	}
+	points, _ := model.Forecast(holdout)
+
+	// Seasonal naive without drift, the benchmark a model has to beat
+	naive := make([]float64, holdout)
+	if sn, err := fitSeasonalNaive(train); err == nil {
+		for i := range naive {
+			naive[i] = sn.lastSeason[i%seasonLength]
+		}
+	}
+	return ForecastBacktest{
+		Months:    observed(test),
+		Method:    method,
+		MAPE:      mape(test, points),
+		NaiveMAPE: mape(test, naive),
+	}, true
+}
+
+// mape returns the mean absolute percentage error of forecast against
+// actual, over the months with a price.
+func mape(actual, forecast []float64) float64 {
+	sum, n := 0.0, 0
+	for i, a := range actual {
+		if a != 0 && !math.IsNaN(a) {
+			sum += math.Abs((a - forecast[i]) / a)
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return math.Round(sum/float64(n)*10000) / 100
+}
+
+// observed counts the values that aren't NaN.
+func observed(values []float64) int {
+	n := 0
+	for _, v := range values {
+		if !math.IsNaN(v) {
+			n++
+		}
+	}
+	return n
+}
+
+// nanMean averages the values that aren't NaN.
+func nanMean(values []float64) float64 {
+	sum := 0.0
+	for _, v := range values {
+		if !math.IsNaN(v) {
+			sum += v
+		}
+	}
+	return sum / float64(observed(values))
+}
+
+// ==================== FORECAST ENDPOINT ====================
+
+// ForecastPoint is the forecast of one month.
+type ForecastPoint struct {
+	Date    string  `json:"date"`  // ISO start of the month
+	Label   string  `json:"label"` // e.g. "Jan 2026"
+	Price   float64 `json:"price"`
+	Lower80 float64 `json:"lower80"`
+	Upper80 float64 `json:"upper80"`
+	Lower95 float64 `json:"lower95"`
+	Upper95 float64 `json:"upper95"`
+}
+
+// ForecastResponse is the body of /api/prices/forecast.
+type ForecastResponse struct {
+	Market       MarketSummary     `json:"market"`
+	Series       SeriesInfo        `json:"series"`
+	Alternatives []SeriesInfo      `json:"alternatives"`
+	Method       string            `json:"method"`
+	Parameters   *holtWinters      `json:"parameters,omitempty"` // smoothing parameters of Holt-Winters
+	Currency     string            `json:"currency"`
+	Real         bool              `json:"real"`
+	BaseYear     int               `json:"base_year,omitempty"`
+	Unit         string            `json:"unit"`
+	LastMonth    string            `json:"last_month"`  // last month of the history
+	MonthsUsed   int               `json:"months_used"` // months of the history with a price
+	Stale        bool              `json:"stale"`       // the history ends well before the dataset
+	Backtest     *ForecastBacktest `json:"backtest"`    // nil when no held-out month has a price to score
+	Points       []ForecastPoint   `json:"points"`
+}
+
+// forecastHandler serves /api/prices/forecast. The series is selected as
+// for /api/prices/history; horizon= is the number of months (default 6).
+func forecastHandler(store *DatasetStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		foodData := store.Load()
+		opts, err := parsePriceOptions(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		q, err := parseSeriesQuery(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		horizon := 6
+		if v := c.Query("horizon"); v != "" {
+			if horizon, err = strconv.Atoi(v); err != nil || horizon < 1 || horizon > forecastMaxMonths {
+				c.JSON(400, gin.H{"error": fmt.Sprintf("invalid horizon %q (1 to %d months)", v, forecastMaxMonths)})
+				return
+			}
+		}
+
+		market, ok := historyMarket(foodData, q)
+		if !ok {
+			c.JSON(404, gin.H{"error": "Market not found"})
+			return
+		}
+		matches := matchSeries(foodData, market.ID, q)
+		if len(matches) == 0 {
+			c.JSON(404, gin.H{"error": "No price series matches the query"})
+			return
+		}
+		series := matches[0]
+
+		// Model the prices as they will be shown, so real and USD
+		// forecasts come out in base-year prices and dollars
+		observations, err := opts.commodities(foodData, series.Observations)
+		if err != nil {
+			c.JSON(422, gin.H{"error": err.Error()})
+			return
+		}
+		monthly := monthlySeries(observations, forecastMaxGap)
+		history := monthly.Values
+		model, method, err := fitForecastModel(history)
+		if err != nil {
+			c.JSON(422, gin.H{"error": fmt.Sprintf("not enough monthly history to forecast: %v", err)})
+			return
+		}
+
+		lastMonth := monthly.Month(len(monthly.Values) - 1)
+		response := ForecastResponse{
+			Market:       market.Summary(),
+			Series:       foodData.Taxonomy.localizeSeries(series.Info(), opts.Lang),
+			Alternatives: []SeriesInfo{},
+			Method:       method,
+			Currency:     opts.Currency.String(),
+			Real:         opts.Real,
+			Unit:         series.Latest().NormalizedUnit,
+			LastMonth:    lastMonth,
+			MonthsUsed:   monthly.Valid(),
+			Stale:        monthsBetween(lastMonth, monthOf(foodData.LastDate())) > 3,
+			Points:       []ForecastPoint{},
+		}
+		if opts.Real {
+			response.BaseYear = opts.baseYear(foodData)
+		}
+		for _, alt := range matches[1:] {
+			response.Alternatives = append(response.Alternatives, foodData.Taxonomy.localizeSeries(alt.Info(), opts.Lang))
+		}
+		if hw, ok := model.(*holtWinters); ok {
+			response.Parameters = hw
+		}
+
+		// Hold out as many months as are forecast, if enough remain to fit
+		holdout := min(horizon, len(history)-seasonLength-1)
+		if holdout > 0 {
+			if bt, ok := backtest(history, holdout); ok && bt.Months > 0 {
+				response.Backtest = &bt
+			}
+		}
+
+		points, stderr := model.Forecast(horizon)
+		for i, p := range points {
+			date := addMonths(lastMonth, i+1) + "-01"
+			response.Points = append(response.Points, ForecastPoint{
+				Date:    date,
+				Label:   formatMonth(date),
+				Price:   math.Max(0, p),
+				Lower80: math.Max(0, p-1.2816*stderr[i]),
+				Upper80: p + 1.2816*stderr[i],
+				Lower95: math.Max(0, p-1.96*stderr[i]),
+				Upper95: p + 1.96*stderr[i],
+			})
+		}
+		c.JSON(200, response)
+	}
+}
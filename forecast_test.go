@@ -0,0 +1,143 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// trendSeason is months of 100 + slope a month + a seasonal swing of
+// amplitude, with the months in gaps missing.
+func trendSeason(months int, slope, amplitude float64, gaps ...int) []float64 {
+	y := make([]float64, months)
+	for i := range y {
+		y[i] = 100 + slope*float64(i) + amplitude*math.Sin(2*math.Pi*float64(i)/seasonLength)
+	}
+	for _, i := range gaps {
+		y[i] = math.NaN()
+	}
+	return y
+}
+
+func TestMAPE(t *testing.T) {
+	nan := math.NaN()
+	tests := []struct {
+		name             string
+		actual, forecast []float64
+		want             float64
+	}{
+		{"exact", []float64{100, 200}, []float64{100, 200}, 0},
+		{"ten percent either way", []float64{100, 200}, []float64{110, 180}, 10},
+		{"months without a price are skipped", []float64{100, nan, 200}, []float64{150, 1, 200}, 25},
+		{"zero prices are skipped", []float64{0, 100}, []float64{5, 90}, 10},
+		{"rounded to two decimals", []float64{300}, []float64{301}, 0.33},
+		{"nothing to score", []float64{nan}, []float64{1}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mape(tt.actual, tt.forecast); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBacktest(t *testing.T) {
+	tests := []struct {
+		name      string
+		y         []float64
+		holdout   int
+		ok        bool
+		method    string
+		months    int
+		maxMAPE   float64
+		beatNaive bool
+	}{
+		{
+			name:    "seasonal with trend",
+			y:       trendSeason(60, 1, 20),
+			holdout: 12,
+			ok:      true, method: MethodHoltWinters, months: 12,
+			maxMAPE: 2, beatNaive: true,
+		},
+		{
+			name:    "flat seasonal",
+			y:       trendSeason(48, 0, 20),
+			holdout: 6,
+			ok:      true, method: MethodHoltWinters, months: 6,
+			maxMAPE: 1,
+		},
+		{
+			name:    "gaps in the history and the holdout",
+			y:       trendSeason(60, 1, 20, 5, 17, 30, 31, 50, 55),
+			holdout: 12,
+			ok:      true, method: MethodHoltWinters, months: 10,
+			maxMAPE: 3, beatNaive: true,
+		},
+		{
+			name:    "too short for Holt-Winters",
+			y:       trendSeason(30, 1, 20),
+			holdout: 12,
+			ok:      true, method: MethodSeasonalNaive, months: 12,
+			maxMAPE: 2,
+		},
+		{
+			name:    "too short for anything",
+			y:       trendSeason(20, 1, 20),
+			holdout: 12,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := backtest(tt.y, tt.holdout)
+			if ok != tt.ok {
+				t.Fatalf("ok %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if got.Method != tt.method || got.Months != tt.months {
+				t.Errorf("method %s over %d months, want %s over %d", got.Method, got.Months, tt.method, tt.months)
+			}
+			if got.MAPE > tt.maxMAPE {
+				t.Errorf("MAPE %v%%, want at most %v%%", got.MAPE, tt.maxMAPE)
+			}
+			if tt.beatNaive && got.MAPE >= got.NaiveMAPE {
+				t.Errorf("MAPE %v%% doesn't beat the naive %v%%", got.MAPE, got.NaiveMAPE)
+			}
+		})
+	}
+}
+
+func TestFitHoltWinters(t *testing.T) {
+	// Months are dropped until the first season is half observed, here
+	// months 4 to 15 with prices from month 10
+	sparse := make([]int, 0, 10)
+	for i := 0; i < 10; i++ {
+		sparse = append(sparse, i)
+	}
+	hw, err := fitHoltWinters(trendSeason(48, 1, 20, sparse...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hw.n != 48-4 {
+		t.Errorf("fitted on %d months, want %d", hw.n, 48-4)
+	}
+
+	points, stderr := hw.Forecast(24)
+	want := trendSeason(48+24, 1, 20)[48:]
+	for i := range points {
+		if math.Abs(points[i]-want[i])/want[i] > 0.02 {
+			t.Errorf("month %d: forecast %v, want about %v", i, points[i], want[i])
+		}
+		if i > 0 && stderr[i] < stderr[i-1] {
+			t.Errorf("month %d: stderr %v below the month before's %v", i, stderr[i], stderr[i-1])
+		}
+	}
+
+	if _, err := fitHoltWinters(trendSeason(23, 1, 20)); err == nil {
+		t.Error("fitted less than two seasons")
+	}
+	if _, err := fitHoltWinters(trendSeason(36, 1, 20, 0, 1, 2, 3, 4, 5, 6, 14, 15, 16, 17, 18, 19, 20, 26, 27, 28, 29, 30, 31, 32)); err == nil {
+		t.Error("fitted seasons less than half observed")
+	}
+}
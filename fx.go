@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ==================== EXCHANGE RATES ====================
+
+// FXRate is the KES/USD rate implied by one month of WFP observations.
+type FXRate struct {
+	Month     string  `json:"month"` // "2006-01"
+	KESPerUSD float64 `json:"kes_per_usd"`
+	Samples   int     `json:"samples"`
+}
+
+// FXRates is the monthly implied exchange rate series. WFP publishes
+// both price and usdprice on every row, so the rate for a month is the
+// median of price/usdprice across that month's KES rows.
+type FXRates struct {
+	months []string // sorted ascending
+	rates  map[string]FXRate
+}
+
+// buildFXRates derives the monthly rate series from parsed commodities.
+// Rows without a USD price are skipped.
+func buildFXRates(commodities []Commodity) FXRates {
+	ratios := make(map[string][]float64)
+	for _, c := range commodities {
+		if c.Currency != KES || c.USDPrice <= 0 || c.Price <= 0 {
+			continue
+		}
+		month := monthOf(c.Date)
+		ratios[month] = append(ratios[month], c.Price/c.USDPrice)
+	}
+
+	fx := FXRates{rates: make(map[string]FXRate, len(ratios))}
+	for month, values := range ratios {
+		fx.rates[month] = FXRate{Month: month, KESPerUSD: median(values), Samples: len(values)}
+		fx.months = append(fx.months, month)
+	}
+	sort.Strings(fx.months)
+	return fx
+}
+
+// Series returns the rates in month order.
+func (fx FXRates) Series() []FXRate {
+	series := make([]FXRate, 0, len(fx.months))
+	for _, m := range fx.months {
+		series = append(series, fx.rates[m])
+	}
+	return series
+}
+
+// Rate returns the KES per USD rate for the month of date. Months without
+// observations use the nearest month that has one.
+func (fx FXRates) Rate(date string) (float64, bool) {
+	if len(fx.months) == 0 {
+		return 0, false
+	}
+	month := monthOf(date)
+	if r, ok := fx.rates[month]; ok {
+		return r.KESPerUSD, true
+	}
+
+	i := sort.SearchStrings(fx.months, month)
+	switch {
+	case i == 0:
+		return fx.rates[fx.months[0]].KESPerUSD, true
+	case i == len(fx.months):
+		return fx.rates[fx.months[i-1]].KESPerUSD, true
+	}
+	before, after := fx.months[i-1], fx.months[i]
+	if monthsBetween(before, month) <= monthsBetween(month, after) {
+		return fx.rates[before].KESPerUSD, true
+	}
+	return fx.rates[after].KESPerUSD, true
+}
+
+// Convert expresses amount, priced in from on the given date, in to.
+func (fx FXRates) Convert(amount float64, from, to Currency, date string) (float64, error) {
+	if from == to {
+		return amount, nil
+	}
+	rate, ok := fx.Rate(date)
+	if !ok || rate <= 0 {
+		return 0, fmt.Errorf("no exchange rate available for %s", monthOf(date))
+	}
+	if from == KES && to == USD {
+		return amount / rate, nil
+	}
+	return amount * rate, nil
+}
+
+// ==================== HELPERS ====================
+
+// parseCurrencyParam validates a currency= query value. Empty means KES.
+func parseCurrencyParam(s string) (Currency, error) {
+	switch strings.ToUpper(strings.TrimSpace(s)) {
+	case "", "KES":
+		return KES, nil
+	case "USD":
+		return USD, nil
+	default:
+		return KES, fmt.Errorf("unsupported currency %q (use KES or USD)", s)
+	}
+}
+
+// monthOf returns the "YYYY-MM" part of a "YYYY-MM-DD" date.
+func monthOf(date string) string {
+	if len(date) >= 7 {
+		return date[:7]
+	}
+	return date
+}
+
+// monthsBetween returns the number of months from a to b ("YYYY-MM...").
+func monthsBetween(a, b string) int {
+	var ay, am, by, bm int
+	fmt.Sscanf(a, "%d-%d", &ay, &am)
+	fmt.Sscanf(b, "%d-%d", &by, &bm)
+	return (by-ay)*12 + (bm - am)
+}
+
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 1 {
+		return sorted[mid]
+	}
+	return (sorted[mid-1] + sorted[mid]) / 2
+}
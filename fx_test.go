@@ -0,0 +1,93 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFXRates(t *testing.T) {
+	fx := buildFXRates([]Commodity{
+		{Date: "2024-01-15", Currency: KES, Price: 160, USDPrice: 1},
+		{Date: "2024-01-15", Currency: KES, Price: 320, USDPrice: 2},
+		{Date: "2024-01-15", Currency: KES, Price: 170, USDPrice: 1},
+		{Date: "2024-01-15", Currency: KES, Price: 100, USDPrice: 0}, // no USD price
+		{Date: "2024-01-15", Currency: USD, Price: 5, USDPrice: 5},
+		{Date: "2024-04-15", Currency: KES, Price: 130, USDPrice: 1},
+		{Date: "2024-04-15", Currency: KES, Price: 140, USDPrice: 1},
+		{Date: "2024-06-15", Currency: KES, Price: 150, USDPrice: 1},
+	})
+
+	series := fx.Series()
+	if len(series) != 3 || series[0].Month != "2024-01" || series[0].Samples != 3 || series[1].KESPerUSD != 135 {
+		t.Fatalf("series %+v", series)
+	}
+
+	tests := []struct {
+		date string
+		want float64
+	}{
+		{"2024-01-15", 160},       // median of the month
+		{"2024-04-01", 135},       // even count: mean of the middle two
+		{"2023-06-15", 160},       // before the first month
+		{"2025-01-15", 150},       // after the last month
+		{"2024-02-15", 160},       // nearer January than April
+		{"2024-03-15", 135},       // nearer April
+		{"2024-05-15", 135},       // as near April as June: the earlier wins
+		{"2024-06", 150},          // month only
+		{"2024-06-30T00:00", 150}, // longer dates
+	}
+	for _, tt := range tests {
+		if got, ok := fx.Rate(tt.date); !ok || got != tt.want {
+			t.Errorf("Rate(%q) = %v, %v; want %v", tt.date, got, ok, tt.want)
+		}
+	}
+
+	if _, ok := (FXRates{}).Rate("2024-01-15"); ok {
+		t.Error("empty rates found a rate")
+	}
+}
+
+func TestFXConvert(t *testing.T) {
+	fx := buildFXRates([]Commodity{{Date: "2024-01-15", Currency: KES, Price: 160, USDPrice: 1}})
+	tests := []struct {
+		amount   float64
+		from, to Currency
+		want     float64
+	}{
+		{320, KES, USD, 2},
+		{2, USD, KES, 320},
+		{320, KES, KES, 320},
+		{2, USD, USD, 2},
+	}
+	for _, tt := range tests {
+		got, err := fx.Convert(tt.amount, tt.from, tt.to, "2024-03-01")
+		if err != nil || math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("Convert(%v %s -> %s) = %v, %v; want %v", tt.amount, tt.from, tt.to, got, err, tt.want)
+		}
+	}
+	if _, err := (FXRates{}).Convert(1, KES, USD, "2024-01-15"); err == nil {
+		t.Error("converted without rates")
+	}
+	if got, err := (FXRates{}).Convert(1, KES, KES, "2024-01-15"); err != nil || got != 1 {
+		t.Errorf("same currency without rates: %v, %v", got, err)
+	}
+}
+
+func TestParseCurrencyParam(t *testing.T) {
+	tests := []struct {
+		s    string
+		want Currency
+		ok   bool
+	}{
+		{"", KES, true},
+		{"kes", KES, true},
+		{" USD ", USD, true},
+		{"EUR", KES, false},
+	}
+	for _, tt := range tests {
+		got, err := parseCurrencyParam(tt.s)
+		if got != tt.want || (err == nil) != tt.ok {
+			t.Errorf("parseCurrencyParam(%q) = %v, %v", tt.s, got, err)
+		}
+	}
+}
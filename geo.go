@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== DISTANCES ====================
+
+const earthRadiusKm = 6371.0
+
+// distanceKm returns the great-circle (haversine) distance between a and b.
+func distanceKm(a, b Location) float64 {
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := rad(b.Lat - a.Lat)
+	dLong := rad(b.Long - a.Long)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(rad(a.Lat))*math.Cos(rad(b.Lat))*math.Sin(dLong/2)*math.Sin(dLong/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(min(1, h)))
+}
+
+// NearbyMarket is a market with its distance from the caller.
+type NearbyMarket struct {
+	MarketSummary
+	DistanceKm float64 `json:"distance_km"`
+}
+
+// nearbyMarkets returns the markets within radiusKm of from, nearest
+// first. A radius of 0 means no limit.
+func nearbyMarkets(markets []MarketData, from Location, radiusKm float64) []NearbyMarket {
+	var nearby []NearbyMarket
+	for _, m := range markets {
+		d := distanceKm(from, m.Location)
+		if radiusKm > 0 && d > radiusKm {
+			continue
+		}
+		nearby = append(nearby, NearbyMarket{MarketSummary: m.Summary(), DistanceKm: d})
+	}
+	sort.SliceStable(nearby, func(i, j int) bool { return nearby[i].DistanceKm < nearby[j].DistanceKm })
+	return nearby
+}
+
+// geoQuery holds the location parameters shared by the nearby endpoints.
+type geoQuery struct {
+	From     Location
+	RadiusKm float64
+	Limit    int
+}
+
+// parseGeoQuery reads lat=, long=, radius_km= and limit=.
+func parseGeoQuery(c *gin.Context, defaultRadiusKm float64, defaultLimit int) (geoQuery, error) {
+	q := geoQuery{RadiusKm: defaultRadiusKm, Limit: defaultLimit}
+	var err error
+	if c.Query("lat") == "" || c.Query("long") == "" {
+		return q, fmt.Errorf("lat and long are required")
+	}
+	if q.From.Lat, err = strconv.ParseFloat(c.Query("lat"), 64); err != nil || math.Abs(q.From.Lat) > 90 {
+		return q, fmt.Errorf("invalid lat %q", c.Query("lat"))
+	}
+	if q.From.Long, err = strconv.ParseFloat(c.Query("long"), 64); err != nil || math.Abs(q.From.Long) > 180 {
+		return q, fmt.Errorf("invalid long %q", c.Query("long"))
+	}
+	if v := c.Query("radius_km"); v != "" {
+		if q.RadiusKm, err = strconv.ParseFloat(v, 64); err != nil || q.RadiusKm < 0 {
+			return q, fmt.Errorf("invalid radius_km %q", v)
+		}
+	}
+	if v := c.Query("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit <= 0 {
+			return q, fmt.Errorf("invalid limit %q", v)
+		}
+	}
+	return q, nil
+}
+
+// nearbyMarketsHandler serves /api/markets/nearby?lat=&long=&radius_km=&limit=.
+func nearbyMarketsHandler(store *DatasetStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		foodData := store.Load()
+		q, err := parseGeoQuery(c, 0, 10)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		markets := nearbyMarkets(foodData.Markets, q.From, q.RadiusKm)
+		if len(markets) > q.Limit {
+			markets = markets[:q.Limit]
+		}
+		c.JSON(200, append([]NearbyMarket{}, markets...))
+	}
+}
+
+// ==================== BEST PRICE NEAR ME ====================
+
+// BestPrice is the latest price of a series in a nearby market, net of
+// the cost of getting the produce there.
+type BestPrice struct {
+	NearbyMarket
+	Series             SeriesInfo `json:"series"`
+	Date               string     `json:"date"`
+	Price              float64    `json:"price"` // latest normalized price
+	Unit               string     `json:"unit"`
+	TransportCost      float64    `json:"transport_cost"`      // per normalized unit
+	TransportEstimated bool       `json:"transport_estimated"` // the unit's weight was assumed
+	NetPrice           float64    `json:"net_price"`           // price minus transport cost
+	Estimated          bool       `json:"estimated"`           // normalization used a typical weight or density
+	CPIEstimated       bool       `json:"cpi_estimated,omitempty"`
+	MonthsSinceLatest  int        `json:"months_since_latest"` // age of the price relative to the dataset
+}
+
+// BestPriceResponse is the body of /api/prices/best.
+type BestPriceResponse struct {
+	Commodity            string      `json:"commodity"`
+	From                 Location    `json:"from"`
+	RadiusKm             float64     `json:"radius_km"`
+	PriceType            string      `json:"price_type"`
+	Currency             string      `json:"currency"`
+	TransportCostPerKmKg float64     `json:"transport_cost_per_km_kg"` // in Currency
+	MaxAgeMonths         int         `json:"max_age_months"`
+	Skipped              int         `json:"skipped"` // series whose unit has no known weight
+	Prices               []BestPrice `json:"prices"`
+}
+
+// bestPriceHandler serves /api/prices/best, which ranks the markets near
+// lat/long by what a kilo of commodity fetches there once transport is
+// paid for. Retail prices are ranked unless pricetype=wholesale, as the
+// two don't compare. costPerKmKg is the default transport cost in KES per
+// km per kg; transport_cost= overrides it per request.
+func bestPriceHandler(store *DatasetStore, costPerKmKg float64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		foodData := store.Load()
+		opts, err := parsePriceOptions(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		q, err := parseGeoQuery(c, 100, 10)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		expand, err := parseVariantsParam(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		priceType, err := parsePriceTypeParam(c.DefaultQuery("pricetype", "retail"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		commodity := c.Query("commodity")
+		if commodity == "" {
+			c.JSON(400, gin.H{"error": "commodity is required"})
+			return
+		}
+		perKmKg, err := parseTransportCostParam(c, costPerKmKg)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		maxAge := 12
+		if v := c.Query("max_age_months"); v != "" {
+			if maxAge, err = strconv.Atoi(v); err != nil || maxAge < 0 {
+				c.JSON(400, gin.H{"error": fmt.Sprintf("invalid max_age_months %q", v)})
+				return
+			}
+		}
+
+		filter := foodData.Taxonomy.Filter([]string{commodity}, expand)
+		if len(filter) == 0 {
+			c.JSON(404, gin.H{"error": "Commodity not found"})
+			return
+		}
+
+		// The transport cost is today's, so convert it at the latest date
+		lastDate := foodData.LastDate()
+		cost, _, err := opts.convert(foodData, perKmKg, KES, lastDate)
+		if err != nil {
+			c.JSON(422, gin.H{"error": err.Error()})
+			return
+		}
+
+		response := BestPriceResponse{
+			Commodity:            commodity,
+			From:                 q.From,
+			RadiusKm:             q.RadiusKm,
+			PriceType:            priceType.String(),
+			Currency:             opts.Currency.String(),
+			TransportCostPerKmKg: cost,
+			MaxAgeMonths:         maxAge,
+			Prices:               []BestPrice{},
+		}
+		for _, market := range nearbyMarkets(foodData.Markets, q.From, q.RadiusKm) {
+			for _, series := range foodData.MarketSeries(market.ID) {
+				if !filter.Match(series.Key.CommodityID) || series.Key.PriceType != *priceType {
+					continue
+				}
+				latest := series.Latest()
+				age := monthsBetween(latest.Date, lastDate)
+				if age > maxAge {
+					continue
+				}
+				kg, kgEstimated, ok := kgPerNormalizedUnit(latest.Name, latest.NormalizedUnit)
+				if !ok {
+					response.Skipped++
+					continue
+				}
+
+				price, cpiEstimated, err := opts.convert(foodData, latest.NormalizedPrice, latest.Currency, latest.Date)
+				if err != nil {
+					c.JSON(422, gin.H{"error": err.Error()})
+					return
+				}
+				info := foodData.Taxonomy.localizeSeries(series.Info(), opts.Lang)
+				transport := cost * market.DistanceKm * kg
+				response.Prices = append(response.Prices, BestPrice{
+					NearbyMarket:       market,
+					Series:             info,
+					Date:               latest.Date,
+					Price:              price,
+					Unit:               latest.NormalizedUnit,
+					TransportCost:      transport,
+					NetPrice:           price - transport,
+					Estimated:          latest.UnitEstimated,
+					CPIEstimated:       cpiEstimated,
+					MonthsSinceLatest:  age,
+					TransportEstimated: kgEstimated,
+				})
+			}
+		}
+
+		// Highest net price first: the best place to sell
+		sort.SliceStable(response.Prices, func(i, j int) bool {
+			return response.Prices[i].NetPrice > response.Prices[j].NetPrice
+		})
+		if len(response.Prices) > q.Limit {
+			response.Prices = response.Prices[:q.Limit]
+		}
+		c.JSON(200, response)
+	}
+}
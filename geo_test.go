@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestDistanceKm(t *testing.T) {
+	nairobi := Location{Lat: -1.2864, Long: 36.8172}
+	mombasa := Location{Lat: -4.0435, Long: 39.6682}
+	tests := []struct {
+		name string
+		a, b Location
+		want float64
+	}{
+		{"same place", nairobi, nairobi, 0},
+		{"a degree of the equator", Location{}, Location{Long: 1}, 111.195},
+		{"a degree of a meridian", Location{}, Location{Lat: 1}, 111.195},
+		{"Nairobi to Mombasa", nairobi, mombasa, 440.7},
+		{"antipodes", Location{Lat: 0, Long: 0}, Location{Lat: 0, Long: 180}, math.Pi * earthRadiusKm},
+	}
+	for _, tt := range tests {
+		if got := distanceKm(tt.a, tt.b); math.Abs(got-tt.want) > 0.1 {
+			t.Errorf("%s: %v km, want %v", tt.name, got, tt.want)
+		}
+		if got, back := distanceKm(tt.a, tt.b), distanceKm(tt.b, tt.a); got != back {
+			t.Errorf("%s: %v km there, %v back", tt.name, got, back)
+		}
+	}
+}
+
+// bestRows price maize along the equator: Near is about 50km from 0,0,
+// Far about 200km and Farther about 300km. Near also sells wholesale.
+const bestRows = `2024-03-15,Eastern,Kitui,Near,1,0,0.45,cereals and tubers,Maize,51,KG,actual,Retail,KES,50,0.38
+2024-03-15,Eastern,Kitui,Near,1,0,0.45,cereals and tubers,Maize,51,KG,actual,Wholesale,KES,100,0.77
+2024-03-15,Eastern,Kitui,Far,2,0,1.8,cereals and tubers,Maize,51,KG,actual,Retail,KES,60,0.46
+2024-03-15,Eastern,Kitui,Farther,3,0,2.7,cereals and tubers,Maize,51,KG,actual,Retail,KES,90,0.69
+2024-03-15,Eastern,Kitui,Far,2,0,1.8,cereals and tubers,Beans,66,KG,actual,Retail,KES,120,0.92
+`
+
+func TestNearbyMarkets(t *testing.T) {
+	foodData := csvTestStore(t, bestRows).Load()
+	tests := []struct {
+		radiusKm float64
+		want     []string
+	}{
+		{0, []string{"Near", "Far", "Farther"}},
+		{250, []string{"Near", "Far"}},
+		{10, nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		for i, m := range nearbyMarkets(foodData.Markets, Location{}, tt.radiusKm) {
+			got = append(got, m.Name)
+			if want := distanceKm(Location{}, foodData.Markets[i].Location); m.DistanceKm != want {
+				t.Errorf("%s %v km away, want %v", m.Name, m.DistanceKm, want)
+			}
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("radius %v: %v, want %v", tt.radiusKm, got, tt.want)
+		}
+	}
+}
+
+func TestBestPrice(t *testing.T) {
+	srv := newTestServerOn(t, csvTestStore(t, bestRows))
+	near, far := distanceKm(Location{}, Location{Long: 0.45}), distanceKm(Location{}, Location{Long: 1.8})
+
+	type ranked struct {
+		market string
+		net    float64
+	}
+	tests := []struct {
+		name      string
+		query     string
+		priceType string
+		want      []ranked
+	}{
+		{"free transport ranks by price", "transport_cost=0&radius_km=250", "Retail",
+			[]ranked{{"Far", 60}, {"Near", 50}}},
+		// 0.1 KES a km costs 15 more a kilo at Far than at Near
+		{"transport cost", "transport_cost=0.1&radius_km=250", "Retail",
+			[]ranked{{"Near", 50 - 0.1*near}, {"Far", 60 - 0.1*far}}},
+		// The override of the case before doesn't stick
+		{"default transport cost", "radius_km=250", "Retail",
+			[]ranked{{"Far", 60 - 0.045*far}, {"Near", 50 - 0.045*near}}},
+		{"wholesale", "transport_cost=0&pricetype=wholesale&radius_km=250", "Wholesale",
+			[]ranked{{"Near", 100}}},
+		{"radius", "transport_cost=0&radius_km=100", "Retail",
+			[]ranked{{"Near", 50}}},
+		{"limit", "transport_cost=0.1&limit=1&radius_km=250", "Retail",
+			[]ranked{{"Near", 50 - 0.1*near}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resp BestPriceResponse
+			target := "/api/prices/best?commodity=maize&lat=0&long=0&" + tt.query
+			if code := getJSON(t, srv.router, target, &resp); code != 200 {
+				t.Fatalf("status %d", code)
+			}
+			if resp.PriceType != tt.priceType {
+				t.Errorf("price type %s, want %s", resp.PriceType, tt.priceType)
+			}
+			if len(resp.Prices) != len(tt.want) {
+				t.Fatalf("%d prices, want %v", len(resp.Prices), tt.want)
+			}
+			for i, p := range resp.Prices {
+				if p.Name != tt.want[i].market || math.Abs(p.NetPrice-tt.want[i].net) > 1e-9 {
+					t.Errorf("#%d: %s at %v net, want %s at %v", i+1, p.Name, p.NetPrice, tt.want[i].market, tt.want[i].net)
+				}
+				if p.Series.PriceType != tt.priceType || p.Price-p.TransportCost != p.NetPrice {
+					t.Errorf("#%d: %s price %v less %v transport", i+1, p.Series.PriceType, p.Price, p.TransportCost)
+				}
+			}
+		})
+	}
+
+	for _, query := range []string{"lat=0&long=0", "commodity=maize", "commodity=tractor&lat=0&long=0",
+		"commodity=maize&lat=0&long=0&pricetype=farmgate", "commodity=maize&lat=0&long=0&transport_cost=-1"} {
+		if code := getJSON(t, srv.router, "/api/prices/best?"+query, nil); code == 200 {
+			t.Errorf("%s: status 200", query)
+		}
+	}
+}
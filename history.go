@@ -0,0 +1,359 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== PRICE HISTORY ====================
+
+// PriceHistoryResponse is the body of /api/prices/history?detail=true.
+// Without detail= the endpoint returns just the points.
+type PriceHistoryResponse struct {
+	Market       MarketSummary       `json:"market"`
+	Series       SeriesInfo          `json:"series"`
+	Alternatives []SeriesInfo        `json:"alternatives"` // other series matching the query
+	Resolution   string              `json:"resolution"`
+	Aggregation  string              `json:"aggregation"`
+	Currency     string              `json:"currency"`
+	Real         bool                `json:"real"`
+	BaseYear     int                 `json:"base_year,omitempty"` // base year of real prices
+	From         string              `json:"from,omitempty"`
+	To           string              `json:"to,omitempty"`
+	Points       []PriceHistoryPoint `json:"points"`
+}
+
+// seriesQuery selects a single price series: a market and a commodity,
+// narrowed down by unit, pricetype and priceflag.
+type seriesQuery struct {
+	Market      string
+	MarketID    int
+	Commodity   string
+	CommodityID int
+	Unit        string
+	PriceType   *PriceType
+	PriceFlag   *PriceFlag
+}
+
+// historyQuery holds the series selection and bucketing parameters.
+type historyQuery struct {
+	seriesQuery
+	From, To    string
+	Resolution  string // month, quarter or year
+	Aggregation string // mean, median or last
+	Limit       int    // keep only the last Limit points, 0 for all
+	Detail      bool   // answer with a PriceHistoryResponse instead of the points
+}
+
+var datePattern = regexp.MustCompile(`^\d{4}(-\d{2}(-\d{2})?)?$`)
+
+// parseSeriesQuery reads market=/market_id=, commodity=/commodity_id=,
+// unit=, pricetype= and priceflag=.
+func parseSeriesQuery(c *gin.Context) (seriesQuery, error) {
+	q := seriesQuery{
+		Market:    c.Query("market"),
+		Commodity: c.Query("commodity"),
+		Unit:      c.Query("unit"),
+	}
+
+	var err error
+	if v := c.Query("market_id"); v != "" {
+		if q.MarketID, err = strconv.Atoi(v); err != nil {
+			return q, fmt.Errorf("invalid market_id %q", v)
+		}
+	}
+	if v := c.Query("commodity_id"); v != "" {
+		if q.CommodityID, err = strconv.Atoi(v); err != nil {
+			return q, fmt.Errorf("invalid commodity_id %q", v)
+		}
+	}
+	if q.Market == "" && q.MarketID == 0 {
+		return q, fmt.Errorf("market or market_id is required")
+	}
+	if q.Commodity == "" && q.CommodityID == 0 {
+		return q, fmt.Errorf("commodity or commodity_id is required")
+	}
+
+	if q.PriceType, err = parsePriceTypeParam(c.Query("pricetype")); err != nil {
+		return q, err
+	}
+	if q.PriceFlag, err = parsePriceFlagParam(c.Query("priceflag")); err != nil {
+		return q, err
+	}
+	return q, nil
+}
+
+// parseHistoryQuery validates the query of /api/prices/history.
+func parseHistoryQuery(c *gin.Context) (historyQuery, error) {
+	q := historyQuery{
+		From:        c.Query("from"),
+		To:          c.Query("to"),
+		Resolution:  strings.ToLower(c.DefaultQuery("resolution", "month")),
+		Aggregation: strings.ToLower(c.DefaultQuery("agg", "mean")),
+	}
+	var err error
+	if q.seriesQuery, err = parseSeriesQuery(c); err != nil {
+		return q, err
+	}
+
+	for _, d := range []string{q.From, q.To} {
+		if d != "" && !datePattern.MatchString(d) {
+			return q, fmt.Errorf("invalid date %q (use YYYY, YYYY-MM or YYYY-MM-DD)", d)
+		}
+	}
+	// A day of the month to= names is still in range, as in Series.Between
+	if q.From != "" && q.To != "" && q.From > q.To && !strings.HasPrefix(q.From, q.To) {
+		return q, fmt.Errorf("from %s is after to %s", q.From, q.To)
+	}
+
+	switch q.Resolution {
+	case "month", "quarter", "year":
+	default:
+		return q, fmt.Errorf("unsupported resolution %q (use month, quarter or year)", q.Resolution)
+	}
+	switch q.Aggregation {
+	case "mean", "median", "last":
+	default:
+		return q, fmt.Errorf("unsupported agg %q (use mean, median or last)", q.Aggregation)
+	}
+
+	// Without a date range keep the endpoint's old "last 12" behaviour
+	if q.From == "" && q.To == "" {
+		q.Limit = 12
+	}
+	if v := c.Query("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit < 0 {
+			return q, fmt.Errorf("invalid limit %q", v)
+		}
+	}
+	if v := c.Query("detail"); v != "" {
+		if q.Detail, err = strconv.ParseBool(v); err != nil {
+			return q, fmt.Errorf("invalid detail %q (use true or false)", v)
+		}
+	}
+	return q, nil
+}
+
+// parsePriceTypeParam parses an optional pricetype= value.
+func parsePriceTypeParam(s string) (*PriceType, error) {
+	var p PriceType
+	switch strings.ToLower(s) {
+	case "":
+		return nil, nil
+	case "wholesale":
+		p = WholeSale
+	case "retail":
+		p = Retail
+	default:
+		return nil, fmt.Errorf("unsupported pricetype %q (use wholesale or retail)", s)
+	}
+	return &p, nil
+}
+
+// parsePriceFlagParam parses an optional priceflag= value.
+func parsePriceFlagParam(s string) (*PriceFlag, error) {
+	var f PriceFlag
+	switch strings.ToLower(s) {
+	case "":
+		return nil, nil
+	case "actual":
+		f = Actual
+	case "aggregate":
+		f = Aggregate
+	case "composite", "actual,aggregate":
+		f = Composite
+	default:
+		return nil, fmt.Errorf("unsupported priceflag %q (use actual, aggregate or composite)", s)
+	}
+	return &f, nil
+}
+
+// historyMarket resolves the market of a series query: by ID, by exact
+// name, or else the first market whose name contains the query.
+func historyMarket(foodData *FoodData, q seriesQuery) (*MarketData, bool) {
+	if q.MarketID != 0 {
+		return foodData.MarketByID(q.MarketID)
+	}
+	if markets := foodData.MarketsByName(q.Market); len(markets) > 0 {
+		return markets[0], true
+	}
+	for i, m := range foodData.Markets {
+		if strings.Contains(strings.ToLower(m.Name), strings.ToLower(q.Market)) {
+			return &foodData.Markets[i], true
+		}
+	}
+	return nil, false
+}
+
+// matchSeries returns the market's series matching the query, the most
+// recently updated (then longest) first. The commodity may be named as in
+// the taxonomy, or as lang= names it, e.g. "Mahindi (white)".
+func matchSeries(foodData *FoodData, marketID int, q seriesQuery) []*Series {
+	filter := foodData.Taxonomy.Filter([]string{q.Commodity}, false)
+	var matches []*Series
+	for _, s := range foodData.MarketSeries(marketID) {
+		switch {
+		case q.CommodityID != 0 && s.Key.CommodityID != q.CommodityID:
+		case q.CommodityID == 0 && !filter.Match(s.Key.CommodityID) && !foodData.Taxonomy.IsName(s.Key.CommodityID, s.Name, q.Commodity):
+		case q.Unit != "" && !strings.EqualFold(s.Key.Unit, q.Unit):
+		case q.PriceType != nil && s.Key.PriceType != *q.PriceType:
+		case q.PriceFlag != nil && s.Key.PriceFlag != *q.PriceFlag:
+		default:
+			matches = append(matches, s)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if a.Latest().Date != b.Latest().Date {
+			return a.Latest().Date > b.Latest().Date
+		}
+		return len(a.Observations) > len(b.Observations)
+	})
+	return matches
+}
+
+// bucketStart returns the ISO date a "YYYY-MM-DD" date falls into at the
+// given resolution, e.g. "2024-04-01" for any day of Q2 2024.
+func bucketStart(date, resolution string) string {
+	year, month := date[:4], 1
+	if len(date) >= 7 {
+		month, _ = strconv.Atoi(date[5:7])
+	}
+	switch resolution {
+	case "year":
+		month = 1
+	case "quarter":
+		month = (month-1)/3*3 + 1
+	}
+	return fmt.Sprintf("%s-%02d-01", year, month)
+}
+
+// bucketLabel returns the display label of a bucket start date.
+func bucketLabel(start, resolution string) string {
+	switch resolution {
+	case "year":
+		return start[:4]
+	case "quarter":
+		month, _ := strconv.Atoi(start[5:7])
+		return fmt.Sprintf("Q%d %s", (month-1)/3+1, start[:4])
+	}
+	return formatMonth(start)
+}
+
+func aggregateValues(values []float64, method string) float64 {
+	switch method {
+	case "median":
+		return median(values)
+	case "last":
+		return values[len(values)-1]
+	}
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// priceHistoryHandler serves /api/prices/history.
+func priceHistoryHandler(store *DatasetStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		foodData := store.Load()
+		opts, err := parsePriceOptions(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		q, err := parseHistoryQuery(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+
+		market, ok := historyMarket(foodData, q.seriesQuery)
+		if !ok {
+			c.JSON(404, gin.H{"error": "Market not found"})
+			return
+		}
+		matches := matchSeries(foodData, market.ID, q.seriesQuery)
+		if len(matches) == 0 {
+			c.JSON(404, gin.H{"error": "No price series matches the query"})
+			return
+		}
+		series := matches[0]
+
+		response := PriceHistoryResponse{
+			Market:       market.Summary(),
+			Series:       foodData.Taxonomy.localizeSeries(series.Info(), opts.Lang),
+			Alternatives: []SeriesInfo{},
+			Resolution:   q.Resolution,
+			Aggregation:  q.Aggregation,
+			Currency:     opts.Currency.String(),
+			Real:         opts.Real,
+			From:         q.From,
+			To:           q.To,
+			Points:       []PriceHistoryPoint{},
+		}
+		if opts.Real {
+			response.BaseYear = opts.baseYear(foodData)
+		}
+		for _, alt := range matches[1:] {
+			response.Alternatives = append(response.Alternatives, foodData.Taxonomy.localizeSeries(alt.Info(), opts.Lang))
+		}
+
+		// Group observations into buckets; they arrive sorted by date
+		var buckets []string
+		normalized := make(map[string][]float64)
+		original := make(map[string][]float64)
+		estimated := make(map[string]bool)
+		cpiEstimated := make(map[string]bool)
+		for _, comm := range series.Between(q.From, q.To) {
+			price, estimatedCPI, err := opts.convert(foodData, comm.NormalizedPrice, comm.Currency, comm.Date)
+			if err != nil {
+				c.JSON(422, gin.H{"error": err.Error()})
+				return
+			}
+			originalPrice, _, err := opts.convert(foodData, comm.Price, comm.Currency, comm.Date)
+			if err != nil {
+				c.JSON(422, gin.H{"error": err.Error()})
+				return
+			}
+
+			bucket := bucketStart(comm.Date, q.Resolution)
+			if _, seen := normalized[bucket]; !seen {
+				buckets = append(buckets, bucket)
+			}
+			normalized[bucket] = append(normalized[bucket], price)
+			original[bucket] = append(original[bucket], originalPrice)
+			estimated[bucket] = estimated[bucket] || comm.UnitEstimated
+			cpiEstimated[bucket] = cpiEstimated[bucket] || estimatedCPI
+		}
+
+		if q.Limit > 0 && len(buckets) > q.Limit {
+			buckets = buckets[len(buckets)-q.Limit:]
+		}
+		for _, bucket := range buckets {
+			response.Points = append(response.Points, PriceHistoryPoint{
+				Date:          bucket,
+				Label:         bucketLabel(bucket, q.Resolution),
+				Price:         aggregateValues(normalized[bucket], q.Aggregation),
+				Unit:          series.Latest().NormalizedUnit,
+				OriginalPrice: aggregateValues(original[bucket], q.Aggregation),
+				OriginalUnit:  series.Latest().Unit,
+				Estimated:     estimated[bucket],
+				Observations:  len(normalized[bucket]),
+				CPIEstimated:  cpiEstimated[bucket],
+			})
+		}
+
+		if !q.Detail {
+			c.JSON(200, response.Points)
+			return
+		}
+		c.JSON(200, response)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+// historyRows price maize at one market on the 15th of every month of
+// 2023 and 2024, at 100 in January 2023 and 1 more every month after.
+// February 2023 has a second price, of 50 on the 1st.
+func historyRows() string {
+	var rows strings.Builder
+	row := func(date string, price float64) {
+		fmt.Fprintf(&rows, "%s,Coast,Mombasa,Kongowea,1,-4.04,39.68,cereals and tubers,Maize,51,KG,actual,Retail,KES,%v,1\n", date, price)
+	}
+	for m := 0; m < 24; m++ {
+		row(fmt.Sprintf("%d-%02d-15", 2023+m/12, m%12+1), float64(100+m))
+		if m == 1 {
+			row("2023-02-01", 50)
+		}
+	}
+	return rows.String()
+}
+
+func TestPriceHistoryOptions(t *testing.T) {
+	srv := newTestServerOn(t, csvTestStore(t, historyRows()))
+
+	type point struct {
+		date         string
+		price        float64
+		observations int
+	}
+	tests := []struct {
+		name  string
+		query string
+		label string // of the first point
+		want  []point
+	}{
+		{"last 12 months by default", "", "Jan 2024", []point{
+			{"2024-01-01", 112, 1}, {"2024-02-01", 113, 1}, {"2024-03-01", 114, 1}, {"2024-04-01", 115, 1},
+			{"2024-05-01", 116, 1}, {"2024-06-01", 117, 1}, {"2024-07-01", 118, 1}, {"2024-08-01", 119, 1},
+			{"2024-09-01", 120, 1}, {"2024-10-01", 121, 1}, {"2024-11-01", 122, 1}, {"2024-12-01", 123, 1},
+		}},
+		{"months of a range", "from=2023-02&to=2023-03", "Feb 2023", []point{{"2023-02-01", 75.5, 2}, {"2023-03-01", 102, 1}}},
+		{"median", "from=2023-02&to=2023-02&agg=median", "Feb 2023", []point{{"2023-02-01", 75.5, 2}}},
+		{"last", "from=2023-02&to=2023-02&agg=last", "Feb 2023", []point{{"2023-02-01", 101, 2}}},
+		{"from a day", "from=2023-02-10&to=2023-02", "Feb 2023", []point{{"2023-02-01", 101, 1}}},
+		{"to a day", "from=2024-05&to=2024-06-15", "May 2024", []point{{"2024-05-01", 116, 1}, {"2024-06-01", 117, 1}}},
+		{"to a day before the price", "from=2024-05&to=2024-06-14", "May 2024", []point{{"2024-05-01", 116, 1}}},
+		{"from only keeps every month", "from=2024-10", "Oct 2024", []point{{"2024-10-01", 121, 1}, {"2024-11-01", 122, 1}, {"2024-12-01", 123, 1}}},
+		{"to only", "to=2023-01", "Jan 2023", []point{{"2023-01-01", 100, 1}}},
+		{"quarters", "from=2023&to=2023&resolution=quarter", "Q1 2023", []point{
+			{"2023-01-01", (100 + 50 + 101 + 102) / 4.0, 4}, {"2023-04-01", 104, 3},
+			{"2023-07-01", 107, 3}, {"2023-10-01", 110, 3},
+		}},
+		{"last of a quarter", "from=2024-04&to=2024-06&resolution=quarter&agg=last", "Q2 2024", []point{{"2024-04-01", 117, 3}}},
+		{"years", "resolution=year&agg=median", "2023", []point{{"2023-01-01", 105, 13}, {"2024-01-01", 117.5, 12}}},
+		{"limit", "from=2023&limit=2", "Nov 2024", []point{{"2024-11-01", 122, 1}, {"2024-12-01", 123, 1}}},
+		{"limit 0 keeps all", "limit=0&resolution=quarter", "Q1 2023", make([]point, 8)},
+		{"nothing in range", "from=2025", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var points []PriceHistoryPoint
+			if code := getJSON(t, srv.router, "/api/prices/history?market_id=1&commodity=maize&"+tt.query, &points); code != 200 {
+				t.Fatalf("status %d", code)
+			}
+			if len(points) != len(tt.want) {
+				t.Fatalf("%d points, want %d: %+v", len(points), len(tt.want), points)
+			}
+			if len(points) > 0 && points[0].Label != tt.label {
+				t.Errorf("labelled %q, want %q", points[0].Label, tt.label)
+			}
+			for i, p := range points {
+				want := tt.want[i]
+				if want.date == "" {
+					continue
+				}
+				if p.Date != want.date || math.Abs(p.Price-want.price) > 1e-9 || p.Observations != want.observations {
+					t.Errorf("%s: %v of %d, want %s: %v of %d", p.Date, p.Price, p.Observations, want.date, want.price, want.observations)
+				}
+			}
+		})
+	}
+
+	var resp PriceHistoryResponse
+	if code := getJSON(t, srv.router, "/api/prices/history?market_id=1&commodity=maize&from=2023&to=2024-03&resolution=Quarter&agg=LAST&detail=true", &resp); code != 200 {
+		t.Fatalf("detail: status %d", code)
+	}
+	if resp.Resolution != "quarter" || resp.Aggregation != "last" || resp.From != "2023" || resp.To != "2024-03" || len(resp.Points) != 5 {
+		t.Errorf("detail: %s of %s from %s to %s, %d points", resp.Aggregation, resp.Resolution, resp.From, resp.To, len(resp.Points))
+	}
+
+	for _, query := range []string{
+		"from=2024&to=2023", "from=2024-02-01&to=2024-01", "from=January", "to=2024-1",
+		"resolution=week", "agg=sum", "limit=-1", "limit=all", "detail=maybe",
+	} {
+		if code := getJSON(t, srv.router, "/api/prices/history?market_id=1&commodity=maize&"+query, nil); code != 400 {
+			t.Errorf("%s: status %d, want 400", query, code)
+		}
+	}
+}
+
+func TestBucketStart(t *testing.T) {
+	tests := []struct {
+		date, resolution, want, label string
+	}{
+		{"2024-01-31", "month", "2024-01-01", "Jan 2024"},
+		{"2024-03-31", "quarter", "2024-01-01", "Q1 2024"},
+		{"2024-04-01", "quarter", "2024-04-01", "Q2 2024"},
+		{"2024-09-15", "quarter", "2024-07-01", "Q3 2024"},
+		{"2024-12-31", "quarter", "2024-10-01", "Q4 2024"},
+		{"2024-12-31", "year", "2024-01-01", "2024"},
+		{"2024", "month", "2024-01-01", "Jan 2024"},
+	}
+	for _, tt := range tests {
+		got := bucketStart(tt.date, tt.resolution)
+		if got != tt.want {
+			t.Errorf("bucketStart(%s, %s) = %s, want %s", tt.date, tt.resolution, got, tt.want)
+		}
+		if label := bucketLabel(got, tt.resolution); label != tt.label {
+			t.Errorf("bucketLabel(%s, %s) = %s, want %s", got, tt.resolution, label, tt.label)
+		}
+	}
+}
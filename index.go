@@ -0,0 +1,200 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// ==================== INDEXES ====================
+
+// Series holds the observations of one SeriesKey, oldest first.
+type Series struct {
+	Key          SeriesKey
+	Name         string // WFP commodity name
+	Category     string
+	Observations []Commodity
+}
+
+// Latest returns the most recent observation of the series.
+func (s *Series) Latest() Commodity {
+	return s.Observations[len(s.Observations)-1]
+}
+
+// SeriesInfo describes a series without its observations.
+type SeriesInfo struct {
+	MarketID       int    `json:"market_id"`
+	CommodityID    int    `json:"commodity_id"`
+	Commodity      string `json:"commodity"`
+	Unit           string `json:"unit"`
+	PriceType      string `json:"price_type"`
+	PriceFlag      string `json:"price_flag"`
+	NormalizedUnit string `json:"normalized_unit"`
+	FirstDate      string `json:"first_date"`
+	LastDate       string `json:"last_date"`
+	Observations   int    `json:"observations"`
+}
+
+// Info returns the series description.
+func (s *Series) Info() SeriesInfo {
+	return SeriesInfo{
+		MarketID:       s.Key.MarketID,
+		CommodityID:    s.Key.CommodityID,
+		Commodity:      s.Name,
+		Unit:           s.Latest().Unit,
+		PriceType:      s.Key.PriceType.String(),
+		PriceFlag:      s.Key.PriceFlag.String(),
+		NormalizedUnit: s.Latest().NormalizedUnit,
+		FirstDate:      s.Observations[0].Date,
+		LastDate:       s.Latest().Date,
+		Observations:   len(s.Observations),
+	}
+}
+
+// Between returns the observations dated from..to inclusive. Dates are
+// compared as strings, so "2024-01" as from includes all of January.
+// An empty bound is open.
+func (s *Series) Between(from, to string) []Commodity {
+	obs := s.Observations
+	start := 0
+	if from != "" {
+		start = sort.Search(len(obs), func(i int) bool { return obs[i].Date >= from })
+	}
+	end := len(obs)
+	if to != "" {
+		end = sort.Search(len(obs), func(i int) bool { return obs[i].Date > to && !strings.HasPrefix(obs[i].Date, to) })
+	}
+	if start >= end {
+		return nil
+	}
+	return obs[start:end]
+}
+
+// DataIndex holds the lookup tables ReadData builds once per load, so
+// handlers don't have to scan the whole dataset.
+type DataIndex struct {
+	marketByID        map[int]int      // market_id -> position in FoodData.Markets
+	marketByName      map[string][]int // lower-cased name -> positions in FoodData.Markets
+	observationByID   map[string]int   // observation ID -> position in FoodData.Commodities
+	series            map[SeriesKey]*Series
+	seriesByMarket    map[int][]*Series // ordered by commodity name, then key
+	seriesByCommodity map[int][]*Series // ordered by market_id, then key
+}
+
+// buildIndex indexes markets, observations and series of foodData.
+func buildIndex(foodData *FoodData) *DataIndex {
+	idx := &DataIndex{
+		marketByID:        make(map[int]int, len(foodData.Markets)),
+		marketByName:      make(map[string][]int),
+		observationByID:   make(map[string]int, len(foodData.Commodities)),
+		series:            make(map[SeriesKey]*Series),
+		seriesByMarket:    make(map[int][]*Series),
+		seriesByCommodity: make(map[int][]*Series),
+	}
+
+	for i, m := range foodData.Markets {
+		idx.marketByID[m.ID] = i
+		name := strings.ToLower(m.Name)
+		idx.marketByName[name] = append(idx.marketByName[name], i)
+	}
+
+	for i, comm := range foodData.Commodities {
+		idx.observationByID[comm.ID] = i
+
+		key := comm.SeriesKey()
+		s, ok := idx.series[key]
+		if !ok {
+			s = &Series{Key: key, Name: comm.Name, Category: comm.Category}
+			idx.series[key] = s
+			idx.seriesByMarket[key.MarketID] = append(idx.seriesByMarket[key.MarketID], s)
+			idx.seriesByCommodity[key.CommodityID] = append(idx.seriesByCommodity[key.CommodityID], s)
+		}
+		s.Observations = append(s.Observations, comm)
+	}
+
+	for _, s := range idx.series {
+		sort.SliceStable(s.Observations, func(i, j int) bool {
+			return s.Observations[i].Date < s.Observations[j].Date
+		})
+	}
+	for _, list := range idx.seriesByMarket {
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].Name != list[j].Name {
+				return list[i].Name < list[j].Name
+			}
+			return seriesKeyLess(list[i].Key, list[j].Key)
+		})
+	}
+	for _, list := range idx.seriesByCommodity {
+		sort.Slice(list, func(i, j int) bool { return seriesKeyLess(list[i].Key, list[j].Key) })
+	}
+	return idx
+}
+
+func seriesKeyLess(a, b SeriesKey) bool {
+	switch {
+	case a.MarketID != b.MarketID:
+		return a.MarketID < b.MarketID
+	case a.CommodityID != b.CommodityID:
+		return a.CommodityID < b.CommodityID
+	case a.Unit != b.Unit:
+		return a.Unit < b.Unit
+	case a.PriceType != b.PriceType:
+		return a.PriceType < b.PriceType
+	}
+	return a.PriceFlag < b.PriceFlag
+}
+
+// ==================== LOOKUPS ====================
+
+// MarketByID returns the market with the given WFP market_id.
+func (f *FoodData) MarketByID(id int) (*MarketData, bool) {
+	i, ok := f.Index.marketByID[id]
+	if !ok {
+		return nil, false
+	}
+	return &f.Markets[i], true
+}
+
+// MarketsByName returns the markets whose name equals name, ignoring case.
+func (f *FoodData) MarketsByName(name string) []*MarketData {
+	var markets []*MarketData
+	for _, i := range f.Index.marketByName[strings.ToLower(name)] {
+		markets = append(markets, &f.Markets[i])
+	}
+	return markets
+}
+
+// Observation returns the price observation with the given ID.
+func (f *FoodData) Observation(id string) (Commodity, bool) {
+	i, ok := f.Index.observationByID[id]
+	if !ok {
+		return Commodity{}, false
+	}
+	return f.Commodities[i], true
+}
+
+// Series returns the series with the given key.
+func (f *FoodData) Series(key SeriesKey) (*Series, bool) {
+	s, ok := f.Index.series[key]
+	return s, ok
+}
+
+// MarketSeries returns every series observed in a market.
+func (f *FoodData) MarketSeries(marketID int) []*Series {
+	return f.Index.seriesByMarket[marketID]
+}
+
+// CommoditySeries returns every series of a WFP commodity_id.
+func (f *FoodData) CommoditySeries(commodityID int) []*Series {
+	return f.Index.seriesByCommodity[commodityID]
+}
+
+// AllSeries returns every series, ordered by market, commodity and key.
+func (f *FoodData) AllSeries() []*Series {
+	all := make([]*Series, 0, len(f.Index.series))
+	for _, s := range f.Index.series {
+		all = append(all, s)
+	}
+	sort.Slice(all, func(i, j int) bool { return seriesKeyLess(all[i].Key, all[j].Key) })
+	return all
+}
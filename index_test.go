@@ -0,0 +1,378 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// scanSeries groups the observations of the dataset by series the way
+// the handlers did before the indexes, in file order.
+func scanSeries(foodData *FoodData, keep func(Commodity) bool) map[SeriesKey][]Commodity {
+	series := make(map[SeriesKey][]Commodity)
+	for _, comm := range foodData.Commodities {
+		if keep(comm) {
+			series[comm.SeriesKey()] = append(series[comm.SeriesKey()], comm)
+		}
+	}
+	return series
+}
+
+// scanLatest returns the most recent observation, the last in file order
+// among those of the same date.
+func scanLatest(obs []Commodity) Commodity {
+	latest := obs[0]
+	for _, comm := range obs[1:] {
+		if comm.Date >= latest.Date {
+			latest = comm
+		}
+	}
+	return latest
+}
+
+// checkSeries compares indexed series with scanned ones.
+func checkSeries(t *testing.T, got []*Series, want map[SeriesKey][]Commodity) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%d series, want %d", len(got), len(want))
+	}
+	for _, s := range got {
+		obs, ok := want[s.Key]
+		if !ok {
+			t.Fatalf("unexpected series %+v", s.Key)
+		}
+		if len(s.Observations) != len(obs) {
+			t.Errorf("%+v: %d observations, want %d", s.Key, len(s.Observations), len(obs))
+		}
+		if s.Latest() != scanLatest(obs) {
+			t.Errorf("%+v: latest %+v, want %+v", s.Key, s.Latest(), scanLatest(obs))
+		}
+	}
+}
+
+func TestIndexMatchesScan(t *testing.T) {
+	foodData := testStore(t).Load()
+
+	t.Run("markets", func(t *testing.T) {
+		for i, m := range foodData.Markets {
+			got, ok := foodData.MarketByID(m.ID)
+			if !ok || got != &foodData.Markets[i] {
+				t.Errorf("MarketByID(%d) = %v, %v", m.ID, got, ok)
+			}
+			checkSeries(t, foodData.MarketSeries(m.ID), scanSeries(foodData, func(c Commodity) bool {
+				return c.MarketID == m.ID
+			}))
+		}
+	})
+
+	t.Run("commodities", func(t *testing.T) {
+		ids := make(map[int]bool)
+		for _, comm := range foodData.Commodities {
+			ids[comm.CommodityID] = true
+		}
+		for id := range ids {
+			checkSeries(t, foodData.CommoditySeries(id), scanSeries(foodData, func(c Commodity) bool {
+				return c.CommodityID == id
+			}))
+		}
+	})
+
+	t.Run("observations", func(t *testing.T) {
+		for _, comm := range foodData.Commodities {
+			got, ok := foodData.Observation(comm.ID)
+			if !ok || got.SeriesKey() != comm.SeriesKey() || got.Date != comm.Date {
+				t.Fatalf("Observation(%s) = %+v, want %+v", comm.ID, got, comm)
+			}
+		}
+		if _, ok := foodData.Observation("missing"); ok {
+			t.Error("found an observation that doesn't exist")
+		}
+	})
+}
+
+func TestLatestPricesMatchScan(t *testing.T) {
+	srv := newTestServer(t)
+	foodData := testStore(t).Load()
+	want := scanSeries(foodData, func(Commodity) bool { return true })
+
+	var rows []MarketPriceResponse
+	if code := getJSON(t, srv.router, "/api/prices/latest", &rows); code != 200 {
+		t.Fatalf("status %d", code)
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("%d rows, want one per series: %d", len(rows), len(want))
+	}
+	for _, row := range rows {
+		comm, ok := foodData.Observation(row.ID)
+		if !ok {
+			t.Fatalf("row %s is no observation", row.ID)
+		}
+		if latest := scanLatest(want[comm.SeriesKey()]); latest.ID != row.ID {
+			t.Errorf("%+v: latest %s (%s), want %s (%s)", comm.SeriesKey(), row.ID, row.LastUpdated, latest.ID, latest.Date)
+		}
+	}
+}
+
+func TestPriceHistoryMatchesScan(t *testing.T) {
+	srv := newTestServer(t)
+	foodData := testStore(t).Load()
+
+	for key, obs := range scanSeries(foodData, func(Commodity) bool { return true }) {
+		q := url.Values{
+			"market_id":    {fmt.Sprint(key.MarketID)},
+			"commodity_id": {fmt.Sprint(key.CommodityID)},
+			"unit":         {key.Unit},
+			"pricetype":    {key.PriceType.String()},
+			"priceflag":    {key.PriceFlag.String()},
+			"detail":       {"true"},
+		}
+		var resp PriceHistoryResponse
+		if code := getJSON(t, srv.router, "/api/prices/history?"+q.Encode(), &resp); code != 200 {
+			t.Fatalf("%+v: status %d", key, code)
+		}
+		latest := scanLatest(obs)
+		if resp.Series.Observations != len(obs) || resp.Series.LastDate != latest.Date {
+			t.Errorf("%+v: %d observations to %s, want %d to %s",
+				key, resp.Series.Observations, resp.Series.LastDate, len(obs), latest.Date)
+		}
+	}
+}
+
+// benchScale is how many times over the benchmarks copy the shipped
+// dataset, to show how the lookups grow with it.
+const benchScale = 10
+
+// scaledStore serves the shipped dataset copied factor times, each copy
+// in markets of its own with the IDs shifted by a multiple of 100000 and
+// " #k" added to the names.
+func scaledStore(tb testing.TB, factor int) *DatasetStore {
+	tb.Helper()
+	src := testStore(tb).Load()
+	scaled := &FoodData{
+		FXRates:  src.FXRates,
+		CPI:      src.CPI,
+		Taxonomy: src.Taxonomy,
+		Baskets:  src.Baskets,
+	}
+	markets := make(map[int]*MarketData)
+	for k := 0; k < factor; k++ {
+		for _, comm := range src.Commodities {
+			market, ok := src.MarketByID(comm.MarketID)
+			if !ok {
+				tb.Fatalf("observation %s has no market", comm.ID)
+			}
+			if k > 0 {
+				comm.ID = fmt.Sprintf("%s-%d", comm.ID, k)
+				comm.MarketID += k * 100000
+			}
+			m := markets[comm.MarketID]
+			if m == nil {
+				m = &MarketData{ID: comm.MarketID, Name: market.Name, Location: market.Location, Admin1: market.Admin1, Admin2: market.Admin2}
+				if k > 0 {
+					m.Name = fmt.Sprintf("%s #%d", market.Name, k)
+				}
+				markets[comm.MarketID] = m
+			}
+			addCommodityToMarket(m, comm.Category, comm)
+			scaled.Commodities = append(scaled.Commodities, comm)
+		}
+	}
+	for _, m := range markets {
+		scaled.Markets = append(scaled.Markets, *m)
+	}
+	sort.Slice(scaled.Markets, func(i, j int) bool { return scaled.Markets[i].ID < scaled.Markets[j].ID })
+	scaled.Index = buildIndex(scaled)
+
+	store := &DatasetStore{seen: make(map[string]fileStamp)}
+	store.current.Store(scaled)
+	return store
+}
+
+// nestedScanLatest is /api/prices/latest as it was before the indexes:
+// one pass over the foods of every market, then for every row another
+// pass to find its market and one to find its previous price.
+func nestedScanLatest(foodData *FoodData, marketFilters, commodityFilters []string) int {
+	latestPrices := make(map[string]*Commodity)
+	for _, market := range foodData.Markets {
+		if len(marketFilters) > 0 && !containsMarket(marketFilters, market.Name) {
+			continue
+		}
+		for _, category := range market.FoodCategories {
+			for _, commodity := range category.Foods {
+				if len(commodityFilters) > 0 && !slices.ContainsFunc(commodityFilters, func(f string) bool {
+					return strings.Contains(strings.ToLower(commodity.Name), f)
+				}) {
+					continue
+				}
+				key := fmt.Sprintf("%s|%s", market.Name, commodity.Name)
+				if existing, ok := latestPrices[key]; !ok || commodity.Date > existing.Date {
+					latestPrices[key] = &commodity
+				}
+			}
+		}
+	}
+
+	rows := 0
+	for _, commodity := range latestPrices {
+		var marketName string
+		for _, market := range foodData.Markets {
+			for _, category := range market.FoodCategories {
+				for _, c := range category.Foods {
+					if c.ID == commodity.ID {
+						marketName = market.Name
+					}
+				}
+			}
+		}
+		var previousDate string
+		for _, market := range foodData.Markets {
+			if !strings.EqualFold(market.Name, marketName) {
+				continue
+			}
+			for _, category := range market.FoodCategories {
+				for _, c := range category.Foods {
+					if c.Name == commodity.Name && c.Date < commodity.Date && c.Date > previousDate {
+						previousDate = c.Date
+					}
+				}
+			}
+		}
+		rows++
+	}
+	return rows
+}
+
+// indexedLatest is the lookup /api/prices/latest does with the indexes.
+func indexedLatest(foodData *FoodData, marketFilters []string, filter commodityFilter) int {
+	period, _ := parseTrendPeriod("mom")
+	rows := 0
+	for _, market := range foodData.Markets {
+		if len(marketFilters) > 0 && !containsMarket(marketFilters, market.Name) {
+			continue
+		}
+		for _, series := range foodData.MarketSeries(market.ID) {
+			if !filter.Match(series.Key.CommodityID) {
+				continue
+			}
+			calculateTrend(series.Observations, series.Latest(), period)
+			rows++
+		}
+	}
+	return rows
+}
+
+// bubbleSortHistory is /api/prices/history as it was before the indexes:
+// the prices of the first matching market, sorted by a bubble sort.
+func bubbleSortHistory(foodData *FoodData, marketName, commodityName string) []Commodity {
+	var allPrices []Commodity
+	for _, market := range foodData.Markets {
+		if strings.Contains(strings.ToLower(market.Name), strings.ToLower(marketName)) {
+			for _, category := range market.FoodCategories {
+				for _, commodity := range category.Foods {
+					if strings.Contains(strings.ToLower(commodity.Name), strings.ToLower(commodityName)) {
+						allPrices = append(allPrices, commodity)
+					}
+				}
+			}
+			break
+		}
+	}
+	for i := 0; i < len(allPrices)-1; i++ {
+		for j := i + 1; j < len(allPrices); j++ {
+			if allPrices[i].Date > allPrices[j].Date {
+				allPrices[i], allPrices[j] = allPrices[j], allPrices[i]
+			}
+		}
+	}
+	return allPrices
+}
+
+// indexedHistory is the lookup /api/prices/history does with the indexes.
+func indexedHistory(foodData *FoodData, q seriesQuery) []Commodity {
+	market, ok := historyMarket(foodData, q)
+	if !ok {
+		return nil
+	}
+	matches := matchSeries(foodData, market.ID, q)
+	if len(matches) == 0 {
+		return nil
+	}
+	return matches[0].Between("", "")
+}
+
+// BenchmarkLatestPrices compares the old scan with the indexes at
+// benchScale times the shipped dataset. The scan of every series takes
+// close to a minute an op; -bench 'Latest.*/(index|handler)' skips it.
+func BenchmarkLatestPrices(b *testing.B) {
+	store := scaledStore(b, benchScale)
+	foodData := store.Load()
+	srv := newTestServerOn(b, store)
+	for _, bm := range []struct {
+		name        string
+		markets     []string
+		commodities []string
+		query       string
+	}{
+		{"all", nil, nil, ""},
+		{"commodity", nil, []string{"maize"}, "?commodities=maize&variants=true"},
+		{"markets", []string{"Nairobi", "Kisumu"}, nil, "?markets=Nairobi,Kisumu"},
+	} {
+		filter := foodData.Taxonomy.Filter(bm.commodities, true)
+		b.Run(bm.name+"/scan", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				nestedScanLatest(foodData, bm.markets, bm.commodities)
+			}
+		})
+		b.Run(bm.name+"/index", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				indexedLatest(foodData, bm.markets, filter)
+			}
+		})
+		b.Run(bm.name+"/handler", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if code := getJSON(b, srv.router, "/api/prices/latest"+bm.query, nil); code != 200 {
+					b.Fatalf("status %d", code)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkPriceHistory compares the old bubble sort with the indexes at
+// benchScale times the shipped dataset.
+func BenchmarkPriceHistory(b *testing.B) {
+	store := scaledStore(b, benchScale)
+	foodData := store.Load()
+	srv := newTestServerOn(b, store)
+	for _, bm := range []struct {
+		name              string
+		market, commodity string
+		query             string
+	}{
+		{"nairobi maize", "Nairobi", "maize", "?market=Nairobi&commodity=maize"},
+		{"kakuma beans", "Kakuma", "beans", "?market=Kakuma&commodity=beans&resolution=quarter"},
+		{"last copy", fmt.Sprintf("Nairobi #%d", benchScale-1), "maize", fmt.Sprintf("?market=Nairobi+%%23%d&commodity=maize", benchScale-1)},
+	} {
+		b.Run(bm.name+"/bubble sort", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				bubbleSortHistory(foodData, bm.market, bm.commodity)
+			}
+		})
+		q := seriesQuery{Market: bm.market, Commodity: bm.commodity}
+		b.Run(bm.name+"/index", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				indexedHistory(foodData, q)
+			}
+		})
+		b.Run(bm.name+"/handler", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if code := getJSON(b, srv.router, "/api/prices/history"+bm.query, nil); code != 200 {
+					b.Fatalf("status %d", code)
+				}
+			}
+		})
+	}
+}
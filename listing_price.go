@@ -0,0 +1,500 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== LISTING PRICE SUGGESTIONS ====================
+
+const (
+	defaultSuggestionRadiusKm = 100
+	defaultSuggestionMaxAge   = 12  // months
+	minSuggestionSources      = 3   // prices near the county needed to stay local
+	listingPriceTolerance     = 0.5 // how far outside the suggested range a listing is flagged
+)
+
+// listingTaxonomyCategories maps the listing categories to the taxonomy
+// categories their products are priced from. Seeds aren't in the WFP data;
+// Other, like no category, accepts any food.
+var listingTaxonomyCategories = map[string][]string{
+	"Grains":     {"cereals and tubers", "pulses and nuts"},
+	"Vegetables": {"vegetables and fruits"},
+	"Fruits":     {"vegetables and fruits"},
+	"Dairy":      {"milk and dairy"},
+	"Livestock":  {"meat, fish and eggs"},
+	"Seeds":      {},
+}
+
+// listingCategoryAllows reports whether products of a listing category can
+// be priced from a commodity of taxonomy category.
+func listingCategoryAllows(category, taxonomyCategory string) bool {
+	categories, ok := listingTaxonomyCategories[category]
+	if !ok {
+		return taxonomyCategory != "non-food"
+	}
+	return slices.Contains(categories, taxonomyCategory)
+}
+
+// matchListingProduct finds the commodity a product name like "Fresh
+// Maize (Grade 1)" refers to: the best match of the whole name, or else
+// of one of its words, among the commodities its category allows.
+func matchListingProduct(t *Taxonomy, product, category string) (*CanonicalCommodity, CommodityMatch, bool) {
+	var found *CanonicalCommodity
+	var best CommodityMatch
+	consider := func(query string, weight float64, loose bool) {
+		for _, m := range t.Search(query, "") {
+			if !loose && (m.MatchType == "contains" || m.MatchType == "fuzzy") {
+				continue
+			}
+			c, ok := t.Canonical(m.ID)
+			if !ok || !listingCategoryAllows(category, c.Category) {
+				continue
+			}
+			m.Score = round2(m.Score * weight)
+			if found == nil || m.Score > best.Score {
+				found, best = c, m
+			}
+			return // results come best first
+		}
+	}
+	consider(product, 1, true)
+	for _, word := range strings.Fields(foldName(product)) {
+		if len([]rune(word)) >= 3 {
+			consider(word, 0.9, false)
+		}
+	}
+	return found, best, found != nil
+}
+
+// listingQuantityPattern splits "2 bags", "2 x 90kg bags" or "bunch" into
+// a count and a unit.
+var listingQuantityPattern = regexp.MustCompile(`^(\d+(?:[.,]\d+)?)?\s*(?:x\s+)?(.+)$`)
+
+// listingQuantity is a listing's quantity in a normalized unit.
+type listingQuantity struct {
+	Amount    float64
+	Unit      string
+	Estimated bool // a typical weight or density was used
+}
+
+// parseListingQuantity reads a free-text quantity such as "2 bags of
+// beans", "90kg bag" or "5 litres" with the unit registry, expressing it
+// in the normalized unit of commodityName.
+func parseListingQuantity(commodityName, s string) (listingQuantity, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if i := strings.Index(s, " of "); i >= 0 {
+		s = s[:i]
+	}
+	m := listingQuantityPattern.FindStringSubmatch(s)
+	if m == nil {
+		return listingQuantity{}, false
+	}
+	count := 1.0
+	if m[1] != "" {
+		var err error
+		if count, err = strconv.ParseFloat(strings.Replace(m[1], ",", ".", 1), 64); err != nil || count <= 0 {
+			return listingQuantity{}, false
+		}
+	}
+	unit := strings.TrimSpace(m[2])
+	for _, candidate := range []string{unit, strings.TrimSuffix(unit, "es"), strings.TrimSuffix(unit, "s")} {
+		if _, ok := parseUnit(candidate); !ok {
+			continue
+		}
+		// The price of one of the unit, per normalized unit, is the
+		// inverse of how many normalized units it holds
+		np := normalizePrice(commodityName, 1, candidate)
+		amount := math.Round(count/np.Price*1000) / 1000
+		return listingQuantity{Amount: amount, Unit: np.Unit, Estimated: np.Estimated}, true
+	}
+	return listingQuantity{}, false
+}
+
+// convertAmount expresses amount of a commodity, given in unit from, in
+// unit to, going through kilograms when they differ.
+func convertAmount(commodityName string, amount float64, from, to string) (float64, bool, bool) {
+	if from == to {
+		return amount, false, true
+	}
+	kgFrom, estFrom, ok := kgPerNormalizedUnit(commodityName, from)
+	if !ok {
+		return 0, false, false
+	}
+	kgTo, estTo, ok := kgPerNormalizedUnit(commodityName, to)
+	if !ok || kgTo == 0 {
+		return 0, false, false
+	}
+	return amount * kgFrom / kgTo, estFrom || estTo, true
+}
+
+// priceSuggestionQuery describes the listing to price.
+type priceSuggestionQuery struct {
+	Product  string
+	Category string // listing category, "" for any
+	Quantity string // "" prices one normalized unit
+	County   string // "" for the whole country
+	RadiusKm float64
+	MaxAge   int // months
+}
+
+// PriceSuggestionStats summarizes the prices of one price type, for the
+// listing's quantity.
+type PriceSuggestionStats struct {
+	Markets int     `json:"markets"`
+	Low     float64 `json:"low"`
+	Median  float64 `json:"median"`
+	High    float64 `json:"high"`
+}
+
+// PriceSuggestionSource is a market price a suggestion is based on.
+type PriceSuggestionSource struct {
+	Market       MarketSummary `json:"market"`
+	DistanceKm   *float64      `json:"distance_km,omitempty"` // from the county's markets
+	CommodityID  int           `json:"commodity_id"`
+	Commodity    string        `json:"commodity"`
+	PriceType    string        `json:"price_type"`
+	Date         string        `json:"date"`
+	Price        float64       `json:"price"` // per Unit
+	Unit         string        `json:"unit"`
+	Total        float64       `json:"total"`     // for the listing's quantity
+	Estimated    bool          `json:"estimated"` // normalization used a typical weight or density
+	CPIEstimated bool          `json:"cpi_estimated,omitempty"`
+}
+
+// PriceSuggestion is the body of /api/listings/price-suggestion.
+type PriceSuggestion struct {
+	Product           string                  `json:"product"`
+	Commodity         string                  `json:"commodity"` // canonical id
+	CommodityName     string                  `json:"commodity_name"`
+	MatchedAlias      string                  `json:"matched_alias"`
+	MatchScore        float64                 `json:"match_score"`
+	Quantity          string                  `json:"quantity,omitempty"`
+	Amount            float64                 `json:"amount"` // the quantity in Unit
+	Unit              string                  `json:"unit"`
+	QuantityEstimated bool                    `json:"quantity_estimated"` // converted with a typical weight or density
+	County            string                  `json:"county,omitempty"`
+	Scope             string                  `json:"scope"` // county (markets within radius_km of it) or national
+	RadiusKm          float64                 `json:"radius_km,omitempty"`
+	Currency          string                  `json:"currency"`
+	Low               float64                 `json:"low"`
+	High              float64                 `json:"high"`
+	Suggested         float64                 `json:"suggested"`
+	Wholesale         *PriceSuggestionStats   `json:"wholesale"` // nil without wholesale prices
+	Retail            *PriceSuggestionStats   `json:"retail"`
+	Sources           []PriceSuggestionSource `json:"sources"`
+}
+
+// percentile returns the p-th percentile (0 to 1) of values, interpolating
+// between ranks.
+func percentile(values []float64, p float64) float64 {
+	sorted := slices.Clone(values)
+	sort.Float64s(sorted)
+	pos := p * float64(len(sorted)-1)
+	i := int(pos)
+	if i+1 >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[i] + (pos-float64(i))*(sorted[i+1]-sorted[i])
+}
+
+// suggestListingPrice prices a listing from the latest wholesale and
+// retail prices of its commodity, no older than q.MaxAge months, in the
+// markets within q.RadiusKm of its county, or in the whole country if
+// they have fewer than minSuggestionSources. The range runs from the
+// median wholesale to the median retail price: what traders pay and what
+// shoppers pay. With one price type only, it is the type's interquartile
+// range. The int is the status to answer with on error.
+func suggestListingPrice(foodData *FoodData, opts priceOptions, q priceSuggestionQuery) (*PriceSuggestion, int, error) {
+	if strings.TrimSpace(q.Product) == "" {
+		return nil, 400, fmt.Errorf("product is required")
+	}
+	if q.Category != "" {
+		category, ok := listingCategory(q.Category)
+		if !ok {
+			return nil, 400, fmt.Errorf("unsupported category %q (use %s)", q.Category, strings.Join(listingCategories, ", "))
+		}
+		q.Category = category
+	}
+	county := ""
+	if q.County != "" {
+		var ok bool
+		if county, ok = listingCounty(foodData, q.County); !ok {
+			return nil, 400, fmt.Errorf("unknown county %q", q.County)
+		}
+	}
+	commodity, match, ok := matchListingProduct(foodData.Taxonomy, q.Product, q.Category)
+	if !ok {
+		return nil, 404, fmt.Errorf("no WFP commodity matches %q", q.Product)
+	}
+
+	suggestion := &PriceSuggestion{
+		Product:       q.Product,
+		Commodity:     commodity.ID,
+		CommodityName: commodity.localName(opts.Lang),
+		MatchedAlias:  match.MatchedAlias,
+		MatchScore:    match.Score,
+		Quantity:      q.Quantity,
+		Amount:        1,
+		County:        county,
+		Scope:         "national",
+		Currency:      opts.Currency.String(),
+		Sources:       []PriceSuggestionSource{},
+	}
+	if q.Quantity != "" {
+		quantity, ok := parseListingQuantity(commodity.Name, q.Quantity)
+		if !ok {
+			return nil, 400, fmt.Errorf("unrecognized quantity %q (e.g. 2 bags, 90kg bag, 5 litres)", q.Quantity)
+		}
+		suggestion.Amount, suggestion.Unit, suggestion.QuantityEstimated = quantity.Amount, quantity.Unit, quantity.Estimated
+	}
+
+	// The latest price of each market and price type, from any variant
+	filter := foodData.Taxonomy.Filter([]string{commodity.ID}, true)
+	lastDate := foodData.LastDate()
+	latest := make(map[[2]int]*Series)
+	for _, series := range foodData.AllSeries() {
+		if !filter.Match(series.Key.CommodityID) || monthsBetween(series.Latest().Date, lastDate) > q.MaxAge {
+			continue
+		}
+		key := [2]int{series.Key.MarketID, int(series.Key.PriceType)}
+		if other, ok := latest[key]; !ok || series.Latest().Date > other.Latest().Date {
+			latest[key] = series
+		}
+	}
+	if suggestion.Unit == "" {
+		// Without a quantity, price the unit most prices are quoted in
+		counts := make(map[string]int)
+		for _, series := range latest {
+			counts[series.Latest().NormalizedUnit]++
+		}
+		for unit, n := range counts {
+			if n > counts[suggestion.Unit] || (n == counts[suggestion.Unit] && unit < suggestion.Unit) {
+				suggestion.Unit = unit
+			}
+		}
+	}
+
+	// Markets near the county, if enough of them have prices
+	distances := make(map[int]float64)
+	if county != "" {
+		var center Location
+		n := 0
+		for _, m := range foodData.Markets {
+			if m.Admin2 == county {
+				center.Lat += m.Location.Lat
+				center.Long += m.Location.Long
+				n++
+			}
+		}
+		center.Lat /= float64(n)
+		center.Long /= float64(n)
+		for _, m := range nearbyMarkets(foodData.Markets, center, q.RadiusKm) {
+			distances[m.ID] = m.DistanceKm
+		}
+		near := 0
+		for key := range latest {
+			if _, ok := distances[key[0]]; ok {
+				near++
+			}
+		}
+		if near >= minSuggestionSources {
+			suggestion.Scope, suggestion.RadiusKm = "county", q.RadiusKm
+		}
+	}
+
+	var totals [2][]float64 // indexed by PriceType
+	for key, series := range latest {
+		d, near := distances[key[0]]
+		if suggestion.Scope == "county" && !near {
+			continue
+		}
+		market, ok := foodData.MarketByID(key[0])
+		if !ok {
+			continue
+		}
+		obs := series.Latest()
+		amount, amountEstimated, ok := convertAmount(obs.Name, suggestion.Amount, suggestion.Unit, obs.NormalizedUnit)
+		if !ok {
+			continue
+		}
+		price, cpiEstimated, err := opts.convert(foodData, obs.NormalizedPrice, obs.Currency, obs.Date)
+		if err != nil {
+			return nil, 422, err
+		}
+		source := PriceSuggestionSource{
+			Market:       market.Summary(),
+			CommodityID:  obs.CommodityID,
+			Commodity:    foodData.Taxonomy.LocalName(obs.CommodityID, obs.Name, opts.Lang),
+			PriceType:    obs.PriceType.String(),
+			Date:         obs.Date,
+			Price:        price,
+			Unit:         obs.NormalizedUnit,
+			Total:        round2(price * amount),
+			Estimated:    obs.UnitEstimated || amountEstimated,
+			CPIEstimated: cpiEstimated,
+		}
+		if suggestion.Scope == "county" {
+			source.DistanceKm = &d
+		}
+		suggestion.Sources = append(suggestion.Sources, source)
+		totals[obs.PriceType] = append(totals[obs.PriceType], source.Total)
+	}
+	if len(suggestion.Sources) == 0 {
+		return nil, 404, fmt.Errorf("no recent prices of %s", commodity.Name)
+	}
+
+	stats := func(values []float64) *PriceSuggestionStats {
+		if len(values) == 0 {
+			return nil
+		}
+		return &PriceSuggestionStats{
+			Markets: len(values),
+			Low:     slices.Min(values),
+			Median:  round2(median(values)),
+			High:    slices.Max(values),
+		}
+	}
+	suggestion.Wholesale, suggestion.Retail = stats(totals[WholeSale]), stats(totals[Retail])
+	switch {
+	case suggestion.Wholesale != nil && suggestion.Retail != nil:
+		suggestion.Low, suggestion.High = suggestion.Wholesale.Median, suggestion.Retail.Median
+		if suggestion.Low > suggestion.High {
+			suggestion.Low, suggestion.High = suggestion.High, suggestion.Low
+		}
+	case suggestion.Wholesale != nil:
+		suggestion.Low, suggestion.High = round2(percentile(totals[WholeSale], 0.25)), round2(percentile(totals[WholeSale], 0.75))
+	default:
+		suggestion.Low, suggestion.High = round2(percentile(totals[Retail], 0.25)), round2(percentile(totals[Retail], 0.75))
+	}
+	suggestion.Suggested = round2((suggestion.Low + suggestion.High) / 2)
+
+	sort.Slice(suggestion.Sources, func(i, j int) bool {
+		a, b := suggestion.Sources[i], suggestion.Sources[j]
+		switch {
+		case a.DistanceKm != nil && *a.DistanceKm != *b.DistanceKm:
+			return *a.DistanceKm < *b.DistanceKm
+		case a.Market.Name != b.Market.Name:
+			return a.Market.Name < b.Market.Name
+		}
+		return a.PriceType < b.PriceType
+	})
+	return suggestion, 0, nil
+}
+
+// ListingPriceCheck compares a listing's price with the suggestion for it
+// from the dataset being served: listings are checked when saved, and all
+// again whenever a dataset loads, see recheckListingPrices.
+type ListingPriceCheck struct {
+	Commodity string    `json:"commodity"` // canonical id
+	Low       float64   `json:"low"`
+	High      float64   `json:"high"`
+	Suggested float64   `json:"suggested"`
+	Status    string    `json:"status"`  // below, within or above the range
+	Flagged   bool      `json:"flagged"` // more than 50% outside of it
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// checkListingPrice compares the price of l with a suggestion in its own
+// currency, or returns nil when its product or quantity can't be priced.
+func checkListingPrice(foodData *FoodData, l *Listing, now time.Time) *ListingPriceCheck {
+	opts := priceOptions{Currency: parseCurrency(l.Currency)}
+	suggestion, _, err := suggestListingPrice(foodData, opts, priceSuggestionQuery{
+		Product:  l.Name,
+		Category: l.Category,
+		Quantity: l.Quantity,
+		County:   l.County,
+		RadiusKm: defaultSuggestionRadiusKm,
+		MaxAge:   defaultSuggestionMaxAge,
+	})
+	if err != nil {
+		return nil
+	}
+	check := &ListingPriceCheck{
+		Commodity: suggestion.Commodity,
+		Low:       suggestion.Low,
+		High:      suggestion.High,
+		Suggested: suggestion.Suggested,
+		Status:    "within",
+		CheckedAt: now,
+	}
+	switch {
+	case l.Price < suggestion.Low:
+		check.Status = "below"
+		check.Flagged = l.Price < suggestion.Low*(1-listingPriceTolerance)
+	case l.Price > suggestion.High:
+		check.Status = "above"
+		check.Flagged = l.Price > suggestion.High*(1+listingPriceTolerance)
+	}
+	return check
+}
+
+// recheckListingPrices checks the price of every listing against the
+// current dataset, then again against each dataset the store reloads, so
+// that checks and the flagged= filter never go stale.
+func recheckListingPrices(store *DatasetStore, listings ListingRepository) {
+	reloads := store.Subscribe()
+	for foodData := store.Load(); ; foodData = <-reloads {
+		all, err := listings.Listings()
+		if err != nil {
+			log.Printf("⚠️  checking listing prices: %v", err)
+			continue
+		}
+		now := time.Now().UTC()
+		for _, l := range all {
+			if err := listings.SetPriceCheck(l.ID, l.UpdatedAt, checkListingPrice(foodData, l, now)); err != nil {
+				log.Printf("⚠️  checking the price of listing %d: %v", l.ID, err)
+			}
+		}
+	}
+}
+
+// priceSuggestionHandler serves /api/listings/price-suggestion, the fair
+// price range of product= (with category=) in quantity= near county=.
+// radius_km= (default 100) widens the area around the county,
+// max_age_months= (default 12) how old prices may be.
+func priceSuggestionHandler(store *DatasetStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		foodData := store.Load()
+		opts, err := parsePriceOptions(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		q := priceSuggestionQuery{
+			Product:  c.Query("product"),
+			Category: c.Query("category"),
+			Quantity: c.Query("quantity"),
+			County:   c.Query("county"),
+			RadiusKm: defaultSuggestionRadiusKm,
+			MaxAge:   defaultSuggestionMaxAge,
+		}
+		if v := c.Query("radius_km"); v != "" {
+			if q.RadiusKm, err = strconv.ParseFloat(v, 64); err != nil || q.RadiusKm <= 0 || math.IsInf(q.RadiusKm, 0) {
+				c.JSON(400, gin.H{"error": fmt.Sprintf("invalid radius_km %q", v)})
+				return
+			}
+		}
+		if v := c.Query("max_age_months"); v != "" {
+			if q.MaxAge, err = strconv.Atoi(v); err != nil || q.MaxAge < 0 {
+				c.JSON(400, gin.H{"error": fmt.Sprintf("invalid max_age_months %q", v)})
+				return
+			}
+		}
+		suggestion, status, err := suggestListingPrice(foodData, opts, q)
+		if err != nil {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, suggestion)
+	}
+}
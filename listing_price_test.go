@@ -0,0 +1,222 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParseListingQuantity(t *testing.T) {
+	tests := []struct {
+		commodity, quantity string
+		amount              float64
+		unit                string
+		estimated, ok       bool
+	}{
+		{"Beans", "2 bags of beans", 180, "kg", true, true},
+		{"Milk", "5 litres", 5, "l", false, true},
+		{"Maize", "2 x 90kg bags", 180, "kg", false, true},
+		{"Maize", "90kg bag", 90, "kg", false, true},
+		{"Maize", "bag", 90, "kg", true, true},
+		{"Maize", "500 g", 0.5, "kg", false, true},
+		{"Maize", "1,5 KG", 1.5, "kg", false, true},
+		{"Kale", "3 bunches", 0.9, "kg", true, true},
+		{"Oil (vegetable)", "2 kg", 2.174, "l", true, true},
+		{"Maize flour", "2 bags", 2, "bag", false, true},
+		{"Maize", "a lot", 0, "", false, false},
+		{"Maize", "2 sacks", 0, "", false, false},
+		{"Maize", "0 bags", 0, "", false, false},
+		{"Maize", "", 0, "", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.commodity+" "+tt.quantity, func(t *testing.T) {
+			got, ok := parseListingQuantity(tt.commodity, tt.quantity)
+			if ok != tt.ok || got.Amount != tt.amount || got.Unit != tt.unit || got.Estimated != tt.estimated {
+				t.Errorf("got %v %s estimated=%v, %v; want %v %s estimated=%v, %v",
+					got.Amount, got.Unit, got.Estimated, ok, tt.amount, tt.unit, tt.estimated, tt.ok)
+			}
+		})
+	}
+}
+
+// maizeQuery prices a 90kg bag of maize near Nairobi.
+func maizeQuery() priceSuggestionQuery {
+	return priceSuggestionQuery{
+		Product:  "Fresh Maize",
+		Category: "grains",
+		Quantity: "90kg bag",
+		County:   "nairobi",
+		RadiusKm: defaultSuggestionRadiusKm,
+		MaxAge:   defaultSuggestionMaxAge,
+	}
+}
+
+func TestSuggestListingPrice(t *testing.T) {
+	foodData := testStore(t).Load()
+	opts := priceOptions{Currency: KES, Lang: "en"}
+
+	s, _, err := suggestListingPrice(foodData, opts, maizeQuery())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Commodity != "maize" || s.County != "Nairobi" || s.Amount != 90 || s.Unit != "kg" || s.QuantityEstimated {
+		t.Errorf("suggestion for %s in %s: %v %s estimated=%v", s.Commodity, s.County, s.Amount, s.Unit, s.QuantityEstimated)
+	}
+	if s.Wholesale == nil || s.Retail == nil {
+		t.Fatal("no wholesale and retail prices of maize")
+	}
+	// The range runs from the median wholesale to the median retail total
+	if s.Low != min(s.Wholesale.Median, s.Retail.Median) || s.High != max(s.Wholesale.Median, s.Retail.Median) ||
+		s.Suggested != round2((s.Low+s.High)/2) {
+		t.Errorf("range %v-%v suggesting %v from wholesale %+v and retail %+v", s.Low, s.High, s.Suggested, *s.Wholesale, *s.Retail)
+	}
+	if len(s.Sources) != s.Wholesale.Markets+s.Retail.Markets {
+		t.Errorf("%d sources, want %d", len(s.Sources), s.Wholesale.Markets+s.Retail.Markets)
+	}
+	for _, src := range s.Sources {
+		if math.Abs(src.Total-round2(src.Price*90)) > 0.01 {
+			t.Errorf("%s: total %v for 90 kg at %v", src.Market.Name, src.Total, src.Price)
+		}
+		if s.Scope == "county" && (src.DistanceKm == nil || *src.DistanceKm > s.RadiusKm) {
+			t.Errorf("%s: %v km away in a county suggestion", src.Market.Name, src.DistanceKm)
+		}
+	}
+
+	// Wide enough a radius keeps the suggestion local
+	q := maizeQuery()
+	q.RadiusKm = 400
+	if s, _, err := suggestListingPrice(foodData, opts, q); err != nil || s.Scope != "county" || s.RadiusKm != 400 {
+		t.Errorf("radius 400: %v, %v", s, err)
+	}
+
+	errors := []struct {
+		name   string
+		edit   func(*priceSuggestionQuery)
+		status int
+	}{
+		{"no product", func(q *priceSuggestionQuery) { q.Product = " " }, 400},
+		{"unknown category", func(q *priceSuggestionQuery) { q.Category = "tools" }, 400},
+		{"unknown county", func(q *priceSuggestionQuery) { q.County = "Atlantis" }, 400},
+		{"unrecognized quantity", func(q *priceSuggestionQuery) { q.Quantity = "a lot" }, 400},
+		{"no matching commodity", func(q *priceSuggestionQuery) { q.Product = "Tractor" }, 404},
+		{"a category without WFP prices", func(q *priceSuggestionQuery) { q.Category = "Seeds" }, 404},
+	}
+	for _, tt := range errors {
+		t.Run(tt.name, func(t *testing.T) {
+			q := maizeQuery()
+			tt.edit(&q)
+			if _, status, err := suggestListingPrice(foodData, opts, q); err == nil || status != tt.status {
+				t.Errorf("status %d, %v; want %d", status, err, tt.status)
+			}
+		})
+	}
+}
+
+func TestCheckListingPrice(t *testing.T) {
+	foodData := testStore(t).Load()
+	q := maizeQuery()
+	s, _, err := suggestListingPrice(foodData, priceOptions{Currency: KES}, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		price   float64
+		status  string
+		flagged bool
+	}{
+		{"far below", s.Low*(1-listingPriceTolerance) - 1, "below", true},
+		{"below", s.Low - 1, "below", false},
+		{"at the low end", s.Low, "within", false},
+		{"suggested", s.Suggested, "within", false},
+		{"at the high end", s.High, "within", false},
+		{"above", s.High + 1, "above", false},
+		{"far above", s.High*(1+listingPriceTolerance) + 1, "above", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &Listing{Name: q.Product, Category: "Grains", Quantity: q.Quantity, County: "Nairobi", Currency: "KES", Price: tt.price}
+			check := checkListingPrice(foodData, l, now)
+			if check == nil {
+				t.Fatal("not checked")
+			}
+			if check.Status != tt.status || check.Flagged != tt.flagged {
+				t.Errorf("%v against %v-%v: %s flagged=%v, want %s flagged=%v",
+					tt.price, check.Low, check.High, check.Status, check.Flagged, tt.status, tt.flagged)
+			}
+			if check.Commodity != "maize" || check.Suggested != s.Suggested || !check.CheckedAt.Equal(now) {
+				t.Errorf("check %+v", check)
+			}
+		})
+	}
+
+	for _, l := range []*Listing{
+		{Name: "Hybrid seeds", Category: "Seeds", Quantity: "2 kg", Price: 500},
+		{Name: "Maize", Category: "Grains", Quantity: "a lot", Price: 500},
+	} {
+		if check := checkListingPrice(foodData, l, now); check != nil {
+			t.Errorf("%s (%s) checked: %+v", l.Name, l.Quantity, check)
+		}
+	}
+}
+
+func TestRecheckListingPrices(t *testing.T) {
+	store, err := NewDatasetStore(DataSources{Prices: "wfp_food_prices_ken.csv", Taxonomy: "commodity_taxonomy.json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage, err := OpenStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	listings := storage.Repositories().Listings
+
+	updated := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, l := range []*Listing{
+		{ID: 1, Name: "Maize", Category: "Grains", Quantity: "90kg bag", Currency: "KES", Price: 1e6, UpdatedAt: updated},
+		{ID: 2, Name: "Hybrid seeds", Category: "Seeds", Quantity: "2 kg", Currency: "KES", Price: 500, UpdatedAt: updated},
+	} {
+		if err := listings.PutListing(l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// checks waits for listing 1 to be checked, and returns both checks
+	checks := func() (*ListingPriceCheck, *ListingPriceCheck) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			maize, _, err := listings.Listing(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			seeds, _, err := listings.Listing(2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if maize.PriceCheck != nil {
+				return maize.PriceCheck, seeds.PriceCheck
+			}
+		}
+		t.Fatal("listing prices not checked")
+		return nil, nil
+	}
+
+	go recheckListingPrices(store, listings)
+	maize, seeds := checks()
+	if maize.Status != "above" || !maize.Flagged || seeds != nil {
+		t.Errorf("checks %+v and %+v", maize, seeds)
+	}
+
+	// A reload checks them again
+	if err := listings.SetPriceCheck(1, updated, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Reload("test"); err != nil {
+		t.Fatal(err)
+	}
+	if maize, _ := checks(); !maize.Flagged {
+		t.Errorf("recheck %+v", maize)
+	}
+}
@@ -0,0 +1,549 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== MARKETPLACE LISTINGS ====================
+
+// Listing categories, as in the PWA's ProductCategory.
+var listingCategories = []string{"Grains", "Vegetables", "Fruits", "Dairy", "Livestock", "Seeds", "Other"}
+
+const (
+	listingTTL          = 30 * 24 * time.Hour // default time a listing stays up
+	maxListingTTL       = 90 * 24 * time.Hour
+	listingPurgeAfter   = 30 * 24 * time.Hour // how long expired listings are kept for renewal
+	defaultListingLimit = 20
+	maxListingLimit     = 100
+)
+
+// Listing is a product a farmer offers in the marketplace, as in the PWA's
+// MarketplaceProduct.
+type Listing struct {
+	ID             int64     `json:"id"`
+	OwnerID        string    `json:"ownerId"`
+	Name           string    `json:"name"`
+	Description    string    `json:"description"`
+	Price          float64   `json:"price"`
+	Currency       string    `json:"currency"`
+	Quantity       string    `json:"quantity"` // free text, e.g. "90kg bag"
+	ImageURL       string    `json:"imageUrl"`
+	Category       string    `json:"category"`
+	FarmerName     string    `json:"farmerName"`
+	FarmerLocation string    `json:"farmerLocation"`
+	FarmerPhone    string    `json:"farmerPhone"`
+	County         string    `json:"county"`     // WFP county of the farmer, "" if unknown
+	PostedDate     string    `json:"postedDate"` // "YYYY-MM-DD"
+	CreatedAt      time.Time `json:"createdAt"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+	ExpiresAt      time.Time `json:"expiresAt"`
+
+	PriceCheck *ListingPriceCheck `json:"priceCheck"` // nil if the product can't be priced from WFP data
+}
+
+// ListingInput is the body of POST /api/listings and PUT /api/listings/:id.
+// Empty farmer details are taken from the user's profile, or the phone
+// number they signed in with.
+type ListingInput struct {
+	Name           string     `json:"name"`
+	Description    string     `json:"description"`
+	Price          float64    `json:"price"`
+	Currency       string     `json:"currency"` // KES (default) or USD
+	Quantity       string     `json:"quantity"`
+	ImageURL       string     `json:"imageUrl"`
+	Category       string     `json:"category"`
+	FarmerName     string     `json:"farmerName"`
+	FarmerLocation string     `json:"farmerLocation"`
+	FarmerPhone    string     `json:"farmerPhone"`
+	County         string     `json:"county"`    // default: from farmerLocation
+	ExpiresAt      *time.Time `json:"expiresAt"` // default: unchanged, or 30 days from now for new listings
+}
+
+// listingCategory returns the category named s, ignoring case.
+func listingCategory(s string) (string, bool) {
+	for _, category := range listingCategories {
+		if strings.EqualFold(category, strings.TrimSpace(s)) {
+			return category, true
+		}
+	}
+	return "", false
+}
+
+// listingCounty matches a county name, or a location such as "Nakuru
+// County" or "Molo, Nakuru", against the counties of the markets.
+func listingCounty(foodData *FoodData, location string) (string, bool) {
+	for _, part := range strings.Split(location, ",") {
+		name := strings.TrimSpace(part)
+		if len(name) > 7 && strings.EqualFold(name[len(name)-7:], " county") {
+			name = strings.TrimSpace(name[:len(name)-7])
+		}
+		for _, market := range foodData.Markets {
+			if market.Admin2 != "" && strings.EqualFold(market.Admin2, name) {
+				return market.Admin2, true
+			}
+		}
+	}
+	return "", false
+}
+
+// apply validates in and writes it to l, filling empty farmer details from
+// profile (nil if the user has none).
+func (in ListingInput) apply(l *Listing, foodData *FoodData, profile *FarmerProfile, now time.Time) error {
+	var errs []error
+	text := func(field, v string, required bool, max int) string {
+		v = strings.TrimSpace(v)
+		switch {
+		case v == "" && required:
+			errs = append(errs, fmt.Errorf("%s is required", field))
+		case utf8.RuneCountInString(v) > max:
+			errs = append(errs, fmt.Errorf("%s must be at most %d characters", field, max))
+		}
+		return v
+	}
+
+	if profile != nil {
+		if strings.TrimSpace(in.FarmerName) == "" {
+			in.FarmerName = profile.Name
+		}
+		if strings.TrimSpace(in.FarmerLocation) == "" {
+			in.FarmerLocation = profile.Location
+		}
+		if strings.TrimSpace(in.FarmerPhone) == "" {
+			in.FarmerPhone = profile.Phone
+		}
+	}
+	l.Name = text("name", in.Name, true, 100)
+	l.Description = text("description", in.Description, false, 2000)
+	l.Quantity = text("quantity", in.Quantity, true, 50)
+	l.FarmerName = text("farmerName", in.FarmerName, true, 100)
+	l.FarmerLocation = text("farmerLocation", in.FarmerLocation, false, 100)
+	l.FarmerPhone = text("farmerPhone", in.FarmerPhone, true, 20)
+
+	if in.Price <= 0 {
+		errs = append(errs, fmt.Errorf("price must be positive"))
+	}
+	l.Price = in.Price
+	if currency, err := parseCurrencyParam(in.Currency); err != nil {
+		errs = append(errs, err)
+	} else {
+		l.Currency = currency.String()
+	}
+	if category, ok := listingCategory(in.Category); ok {
+		l.Category = category
+	} else {
+		errs = append(errs, fmt.Errorf("unsupported category %q (use %s)", in.Category, strings.Join(listingCategories, ", ")))
+	}
+
+	l.ImageURL = strings.TrimSpace(in.ImageURL)
+	if l.ImageURL != "" {
+		u, err := url.Parse(l.ImageURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || len(l.ImageURL) > 2048 {
+			errs = append(errs, fmt.Errorf("imageUrl must be an http or https URL"))
+		}
+	}
+
+	l.County = ""
+	if in.County != "" {
+		if county, ok := listingCounty(foodData, in.County); ok {
+			l.County = county
+		} else {
+			errs = append(errs, fmt.Errorf("unknown county %q", in.County))
+		}
+	} else if county, ok := listingCounty(foodData, l.FarmerLocation); ok {
+		l.County = county
+	}
+
+	switch {
+	case in.ExpiresAt == nil:
+		if l.ExpiresAt.IsZero() {
+			l.ExpiresAt = now.Add(listingTTL)
+		}
+	case !in.ExpiresAt.After(now):
+		errs = append(errs, fmt.Errorf("expiresAt must be in the future"))
+	case in.ExpiresAt.Sub(now) > maxListingTTL:
+		errs = append(errs, fmt.Errorf("expiresAt must be at most %d days ahead", int(maxListingTTL.Hours()/24)))
+	default:
+		l.ExpiresAt = in.ExpiresAt.UTC()
+	}
+	return errors.Join(errs...)
+}
+
+// purgeListings deletes expired listings every interval, once they have
+// been expired long enough that their owner won't renew them.
+func purgeListings(listings ListingRepository, interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := listings.PurgeListings(time.Now().Add(-listingPurgeAfter)); err != nil {
+			log.Printf("⚠️  purging listings: %v", err)
+		}
+	}
+}
+
+// ==================== LISTING ENDPOINTS ====================
+
+// ListingsResponse is the body of GET /api/listings.
+type ListingsResponse struct {
+	Listings []*Listing `json:"listings"`
+	Total    int        `json:"total"` // matching listings across all pages
+	Offset   int        `json:"offset"`
+	Limit    int        `json:"limit"`
+	HasMore  bool       `json:"has_more"`
+}
+
+// listingFilter is the search of GET /api/listings.
+type listingFilter struct {
+	categories         map[string]bool
+	county             string
+	minPrice, maxPrice float64
+	from, to           string // postedDate range, "YYYY[-MM[-DD]]"
+	words              []string
+	owner              string // "" for everyone's
+	flagged            *bool  // price far from the suggestion, see ListingPriceCheck
+	now                time.Time
+}
+
+// match reports whether l is in the search. Expired listings only show
+// up in an owner's own listings.
+func (f listingFilter) match(l *Listing) bool {
+	if f.owner != "" && l.OwnerID != f.owner {
+		return false
+	}
+	if f.owner == "" && !l.ExpiresAt.After(f.now) {
+		return false
+	}
+	if len(f.categories) > 0 && !f.categories[l.Category] {
+		return false
+	}
+	if f.county != "" && !strings.EqualFold(l.County, f.county) {
+		return false
+	}
+	if f.flagged != nil && (l.PriceCheck != nil && l.PriceCheck.Flagged) != *f.flagged {
+		return false
+	}
+	if (f.minPrice > 0 && l.Price < f.minPrice) || (f.maxPrice > 0 && l.Price > f.maxPrice) {
+		return false
+	}
+	if (f.from != "" && l.PostedDate < f.from) || (f.to != "" && !(l.PostedDate <= f.to || strings.HasPrefix(l.PostedDate, f.to))) {
+		return false
+	}
+	text := strings.ToLower(l.Name + " " + l.Description)
+	for _, word := range f.words {
+		if !strings.Contains(text, word) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseListingFilter reads category= (comma-separated), county=,
+// min_price=, max_price=, posted_from=, posted_to=, q=, flagged= and mine=.
+func parseListingFilter(c *gin.Context, now time.Time) (listingFilter, error) {
+	f := listingFilter{
+		county: strings.TrimSpace(c.Query("county")),
+		from:   c.Query("posted_from"),
+		to:     c.Query("posted_to"),
+		words:  strings.Fields(strings.ToLower(c.Query("q"))),
+		now:    now,
+	}
+	for _, term := range splitTerms(c.Query("category")) {
+		category, ok := listingCategory(term)
+		if !ok {
+			return f, fmt.Errorf("unsupported category %q (use %s)", term, strings.Join(listingCategories, ", "))
+		}
+		if f.categories == nil {
+			f.categories = make(map[string]bool)
+		}
+		f.categories[category] = true
+	}
+	for _, p := range []struct {
+		name string
+		v    *float64
+	}{{"min_price", &f.minPrice}, {"max_price", &f.maxPrice}} {
+		if v := c.Query(p.name); v != "" {
+			var err error
+			if *p.v, err = strconv.ParseFloat(v, 64); err != nil || *p.v < 0 {
+				return f, fmt.Errorf("invalid %s %q", p.name, v)
+			}
+		}
+	}
+	if f.minPrice > 0 && f.maxPrice > 0 && f.minPrice > f.maxPrice {
+		return f, fmt.Errorf("min_price %g is above max_price %g", f.minPrice, f.maxPrice)
+	}
+	for _, d := range []string{f.from, f.to} {
+		if d != "" && !datePattern.MatchString(d) {
+			return f, fmt.Errorf("invalid date %q (use YYYY, YYYY-MM or YYYY-MM-DD)", d)
+		}
+	}
+	if v := c.Query("flagged"); v != "" {
+		flagged, err := strconv.ParseBool(v)
+		if err != nil {
+			return f, fmt.Errorf("invalid flagged %q", v)
+		}
+		f.flagged = &flagged
+	}
+	if v := c.Query("mine"); v != "" {
+		mine, err := strconv.ParseBool(v)
+		if err != nil {
+			return f, fmt.Errorf("invalid mine %q", v)
+		}
+		if mine {
+			if f.owner = requestUserID(c); f.owner == "" {
+				return f, fmt.Errorf("mine=true needs a signed-in user")
+			}
+		}
+	}
+	return f, nil
+}
+
+// listingsHandler serves GET /api/listings, the open listings matching
+// the filters of parseListingFilter, newest first (sort=oldest, price_asc
+// or price_desc to change), limit= at a time from offset=.
+func listingsHandler(listings ListingRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		filter, err := parseListingFilter(c, time.Now())
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		limit, offset := defaultListingLimit, 0
+		if v := c.Query("limit"); v != "" {
+			if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > maxListingLimit {
+				c.JSON(400, gin.H{"error": fmt.Sprintf("invalid limit %q (1 to %d)", v, maxListingLimit)})
+				return
+			}
+		}
+		if v := c.Query("offset"); v != "" {
+			if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+				c.JSON(400, gin.H{"error": fmt.Sprintf("invalid offset %q", v)})
+				return
+			}
+		}
+		var less func(a, b *Listing) bool
+		switch c.DefaultQuery("sort", "newest") {
+		case "newest":
+			less = func(a, b *Listing) bool { return a.CreatedAt.After(b.CreatedAt) }
+		case "oldest":
+			less = func(a, b *Listing) bool { return a.CreatedAt.Before(b.CreatedAt) }
+		case "price_asc":
+			less = func(a, b *Listing) bool { return a.Price < b.Price }
+		case "price_desc":
+			less = func(a, b *Listing) bool { return a.Price > b.Price }
+		default:
+			c.JSON(400, gin.H{"error": fmt.Sprintf("unsupported sort %q (use newest, oldest, price_asc or price_desc)", c.Query("sort"))})
+			return
+		}
+
+		all, err := listings.Listings()
+		if err != nil {
+			log.Printf("⚠️  %v", err)
+			c.JSON(500, gin.H{"error": "could not read the listings, retry later"})
+			return
+		}
+		matches := []*Listing{}
+		for _, l := range all {
+			if filter.match(l) {
+				matches = append(matches, l)
+			}
+		}
+		sort.SliceStable(matches, func(i, j int) bool {
+			if less(matches[i], matches[j]) {
+				return true
+			}
+			if less(matches[j], matches[i]) {
+				return false
+			}
+			return matches[i].ID > matches[j].ID
+		})
+
+		response := ListingsResponse{Total: len(matches), Offset: offset, Limit: limit, Listings: []*Listing{}}
+		if offset < len(matches) {
+			end := min(offset+limit, len(matches))
+			response.Listings = matches[offset:end]
+			response.HasMore = end < len(matches)
+		}
+		c.JSON(200, response)
+	}
+}
+
+// requestListing returns the listing of the :id of the path, answering
+// the request itself if there is none.
+func requestListing(c *gin.Context, listings ListingRepository) (*Listing, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("invalid listing id %q", c.Param("id"))})
+		return nil, false
+	}
+	l, ok, err := listings.Listing(id)
+	switch {
+	case err != nil:
+		log.Printf("⚠️  %v", err)
+		c.JSON(500, gin.H{"error": "could not read the listing, retry later"})
+		return nil, false
+	case !ok:
+		c.JSON(404, gin.H{"error": "Listing not found"})
+		return nil, false
+	}
+	return l, true
+}
+
+// readListingInput decodes the ListingInput of a request body.
+func readListingInput(c *gin.Context) (ListingInput, error) {
+	var in ListingInput
+	dec := json.NewDecoder(io.LimitReader(c.Request.Body, 64<<10))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&in); err != nil {
+		return in, fmt.Errorf("invalid listing: %w", err)
+	}
+	return in, nil
+}
+
+// listingHandler serves GET /api/listings/:id. Expired listings stay
+// readable, e.g. from shared links, until they are purged.
+func listingHandler(listings ListingRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if l, ok := requestListing(c, listings); ok {
+			c.JSON(200, l)
+		}
+	}
+}
+
+// createListingHandler serves POST /api/listings. The price is checked
+// against a suggestion, see checkListingPrice. The first listing of a user
+// without a profile saves its farmer details as the profile.
+func createListingHandler(store *DatasetStore, listings ListingRepository, profiles ProfileRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := requestUserID(c)
+		if userID == "" {
+			c.JSON(401, gin.H{"error": "sign in with your phone number first"})
+			return
+		}
+		in, err := readListingInput(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		profile, hasProfile, err := profiles.Profile(userID)
+		if err != nil {
+			log.Printf("⚠️  %v", err)
+			c.JSON(500, gin.H{"error": "could not read the profile, retry later"})
+			return
+		}
+		if !hasProfile {
+			profile = &FarmerProfile{Phone: c.GetString(ctxPhone)}
+		}
+
+		foodData := store.Load()
+		now := time.Now().UTC()
+		l := &Listing{OwnerID: userID, PostedDate: now.Format("2006-01-02"), CreatedAt: now, UpdatedAt: now}
+		if err := in.apply(l, foodData, profile, now); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		l.PriceCheck = checkListingPrice(foodData, l, now)
+		if err := listings.CreateListing(l); err != nil {
+			log.Printf("⚠️  %v", err)
+			c.JSON(500, gin.H{"error": "could not save the listing, retry later"})
+			return
+		}
+		if !hasProfile {
+			err := profiles.PutProfile(&FarmerProfile{
+				UserID:    userID,
+				Name:      l.FarmerName,
+				Phone:     l.FarmerPhone,
+				Location:  l.FarmerLocation,
+				UpdatedAt: now,
+			})
+			if err != nil {
+				log.Printf("⚠️  %v", err)
+			}
+		}
+		c.Header("Location", fmt.Sprintf("/api/listings/%d", l.ID))
+		c.JSON(201, l)
+	}
+}
+
+// updateListingHandler serves PUT /api/listings/:id, which replaces the
+// details of one of the user's listings. Setting a later expiresAt renews
+// an expired listing.
+func updateListingHandler(store *DatasetStore, listings ListingRepository, profiles ProfileRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := requestUserID(c)
+		if userID == "" {
+			c.JSON(401, gin.H{"error": "sign in with your phone number first"})
+			return
+		}
+		l, ok := requestListing(c, listings)
+		if !ok {
+			return
+		}
+		if l.OwnerID != userID {
+			c.JSON(403, gin.H{"error": "this listing belongs to another user"})
+			return
+		}
+		in, err := readListingInput(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		profile, ok, err := profiles.Profile(userID)
+		if err != nil {
+			log.Printf("⚠️  %v", err)
+			c.JSON(500, gin.H{"error": "could not read the profile, retry later"})
+			return
+		}
+		if !ok {
+			profile = &FarmerProfile{Phone: c.GetString(ctxPhone)}
+		}
+
+		foodData := store.Load()
+		now := time.Now().UTC()
+		if err := in.apply(l, foodData, profile, now); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		l.PriceCheck = checkListingPrice(foodData, l, now)
+		l.UpdatedAt = now
+		if err := listings.PutListing(l); err != nil {
+			log.Printf("⚠️  %v", err)
+			c.JSON(500, gin.H{"error": "could not save the listing, retry later"})
+			return
+		}
+		c.JSON(200, l)
+	}
+}
+
+// deleteListingHandler serves DELETE /api/listings/:id for the owner.
+func deleteListingHandler(listings ListingRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := requestUserID(c)
+		if userID == "" {
+			c.JSON(401, gin.H{"error": "sign in with your phone number first"})
+			return
+		}
+		l, ok := requestListing(c, listings)
+		if !ok {
+			return
+		}
+		if l.OwnerID != userID {
+			c.JSON(403, gin.H{"error": "this listing belongs to another user"})
+			return
+		}
+		if err := listings.DeleteListing(l.ID); err != nil {
+			log.Printf("⚠️  %v", err)
+			c.JSON(500, gin.H{"error": "could not delete the listing, retry later"})
+			return
+		}
+		c.Status(204)
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testListing(name, category, county string, price float64) ListingInput {
+	return ListingInput{
+		Name:       name,
+		Price:      price,
+		Quantity:   "90kg bag",
+		Category:   category,
+		FarmerName: "Wanjiku",
+		County:     county,
+	}
+}
+
+// createListing posts in as token and fails the test unless it is created.
+func createListing(t *testing.T, srv *testServer, token string, in ListingInput) *Listing {
+	t.Helper()
+	var l Listing
+	if code := doJSON(t, srv.router, "POST", "/api/listings", token, in, &l); code != 201 {
+		t.Fatalf("POST /api/listings %q: status %d", in.Name, code)
+	}
+	return &l
+}
+
+// listingNames returns the names of the listings GET target finds.
+func listingNames(t *testing.T, srv *testServer, token, target string) ([]string, ListingsResponse) {
+	t.Helper()
+	var resp ListingsResponse
+	if code := doJSON(t, srv.router, "GET", target, token, nil, &resp); code != 200 {
+		t.Fatalf("GET %s: status %d", target, code)
+	}
+	var names []string
+	for _, l := range resp.Listings {
+		names = append(names, l.Name)
+	}
+	return names, resp
+}
+
+func TestListingWritesNeedSession(t *testing.T) {
+	srv := newTestServer(t)
+	l := createListing(t, srv, srv.signInUser(t, "+254712345678"), testListing("Maize", "Grains", "", 4000))
+	path := fmt.Sprintf("/api/listings/%d", l.ID)
+
+	for _, token := range []string{"", "not a token"} {
+		for _, req := range []struct{ method, target string }{
+			{"POST", "/api/listings"},
+			{"PUT", path},
+			{"DELETE", path},
+		} {
+			code := doJSON(t, srv.router, req.method, req.target, token, testListing("Beans", "Grains", "", 100), nil)
+			if code != 401 {
+				t.Errorf("%s %s with token %q: status %d, want 401", req.method, req.target, token, code)
+			}
+		}
+	}
+	if code := doJSON(t, srv.router, "GET", path, "", nil, nil); code != 200 {
+		t.Errorf("GET %s without a session: status %d, want 200", path, code)
+	}
+}
+
+func TestListingOwnership(t *testing.T) {
+	srv := newTestServer(t)
+	owner := srv.signInUser(t, "+254712345678")
+	other := srv.signInUser(t, "+254722345678")
+	l := createListing(t, srv, owner, testListing("Maize", "Grains", "", 4000))
+	if l.FarmerPhone != "+254712345678" {
+		t.Errorf("farmerPhone %q, want the signed-in phone", l.FarmerPhone)
+	}
+	path := fmt.Sprintf("/api/listings/%d", l.ID)
+
+	if code := doJSON(t, srv.router, "PUT", path, other, testListing("Mine now", "Grains", "", 1), nil); code != 403 {
+		t.Errorf("PUT by another user: status %d, want 403", code)
+	}
+	if code := doJSON(t, srv.router, "DELETE", path, other, nil, nil); code != 403 {
+		t.Errorf("DELETE by another user: status %d, want 403", code)
+	}
+	if got, _, _ := srv.repos.Listings.Listing(l.ID); got == nil || got.Name != "Maize" {
+		t.Fatalf("listing changed by another user: %+v", got)
+	}
+
+	var updated Listing
+	if code := doJSON(t, srv.router, "PUT", path, owner, testListing("White maize", "Grains", "", 4200), &updated); code != 200 {
+		t.Fatalf("PUT by the owner: status %d", code)
+	}
+	if updated.Name != "White maize" || updated.Price != 4200 || updated.OwnerID != l.OwnerID {
+		t.Errorf("updated %+v", updated)
+	}
+	if code := doJSON(t, srv.router, "DELETE", path, owner, nil, nil); code != 204 {
+		t.Fatalf("DELETE by the owner: status %d, want 204", code)
+	}
+	if code := doJSON(t, srv.router, "GET", path, "", nil, nil); code != 404 {
+		t.Errorf("GET after delete: status %d, want 404", code)
+	}
+}
+
+func TestListingExpiry(t *testing.T) {
+	srv := newTestServer(t)
+	owner := srv.signInUser(t, "+254712345678")
+	createListing(t, srv, owner, testListing("Open", "Grains", "", 100))
+	expired := createListing(t, srv, owner, testListing("Expired", "Grains", "", 100))
+	expired.ExpiresAt = time.Now().Add(-time.Hour)
+	if err := srv.repos.Listings.PutListing(expired); err != nil {
+		t.Fatal(err)
+	}
+
+	if names, _ := listingNames(t, srv, "", "/api/listings"); !slices.Equal(names, []string{"Open"}) {
+		t.Errorf("public listings %v, want only the open one", names)
+	}
+	if names, _ := listingNames(t, srv, owner, "/api/listings?mine=true&sort=oldest"); !slices.Equal(names, []string{"Open", "Expired"}) {
+		t.Errorf("own listings %v, want both", names)
+	}
+	if names, _ := listingNames(t, srv, srv.signInUser(t, "+254722345678"), "/api/listings?mine=true"); len(names) != 0 {
+		t.Errorf("another user's own listings %v, want none", names)
+	}
+	if code := doJSON(t, srv.router, "GET", "/api/listings?mine=true", "", nil, nil); code != 400 {
+		t.Errorf("mine=true without a session: status %d, want 400", code)
+	}
+	if code := doJSON(t, srv.router, "GET", fmt.Sprintf("/api/listings/%d", expired.ID), "", nil, nil); code != 200 {
+		t.Errorf("GET of an expired listing: status %d, want 200", code)
+	}
+
+	// Renewing puts it back up
+	in := testListing("Expired", "Grains", "", 100)
+	later := time.Now().Add(7 * 24 * time.Hour)
+	in.ExpiresAt = &later
+	if code := doJSON(t, srv.router, "PUT", fmt.Sprintf("/api/listings/%d", expired.ID), owner, in, nil); code != 200 {
+		t.Fatalf("renew: status %d", code)
+	}
+	if names, _ := listingNames(t, srv, "", "/api/listings?sort=oldest"); !slices.Equal(names, []string{"Open", "Expired"}) {
+		t.Errorf("public listings after renewal %v, want both", names)
+	}
+
+	past := time.Now().Add(-time.Minute)
+	in.ExpiresAt = &past
+	if code := doJSON(t, srv.router, "PUT", fmt.Sprintf("/api/listings/%d", expired.ID), owner, in, nil); code != 400 {
+		t.Errorf("expiresAt in the past: status %d, want 400", code)
+	}
+}
+
+func TestListingFilters(t *testing.T) {
+	srv := newTestServer(t)
+	token := srv.signInUser(t, "+254712345678")
+	for _, in := range []ListingInput{
+		testListing("White maize", "Grains", "Nakuru", 4000),
+		testListing("Rosecoco beans", "Grains", "Nakuru", 9000),
+		testListing("Sukuma wiki", "Vegetables", "Nairobi County", 30),
+		testListing("Fresh milk", "Dairy", "Kisumu", 60),
+	} {
+		createListing(t, srv, token, in)
+	}
+
+	tests := []struct {
+		query string
+		want  []string // in the default order, newest first
+	}{
+		{"", []string{"Fresh milk", "Sukuma wiki", "Rosecoco beans", "White maize"}},
+		{"category=grains", []string{"Rosecoco beans", "White maize"}},
+		{"category=Dairy,Vegetables", []string{"Fresh milk", "Sukuma wiki"}},
+		{"county=nakuru", []string{"Rosecoco beans", "White maize"}},
+		{"county=Nairobi", []string{"Sukuma wiki"}},
+		{"min_price=100", []string{"Rosecoco beans", "White maize"}},
+		{"max_price=60", []string{"Fresh milk", "Sukuma wiki"}},
+		{"min_price=60&max_price=4000", []string{"Fresh milk", "White maize"}},
+		{"q=MAIZE", []string{"White maize"}},
+		{"q=fresh+milk", []string{"Fresh milk"}},
+		{"q=fresh+maize", nil},
+		{"posted_from=2000", []string{"Fresh milk", "Sukuma wiki", "Rosecoco beans", "White maize"}},
+		{"posted_to=2000", nil},
+		{"sort=price_asc", []string{"Sukuma wiki", "Fresh milk", "White maize", "Rosecoco beans"}},
+		{"sort=price_desc&category=grains", []string{"Rosecoco beans", "White maize"}},
+		{"sort=oldest&county=Kisumu", []string{"Fresh milk"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			names, resp := listingNames(t, srv, "", "/api/listings?"+tt.query)
+			if !slices.Equal(names, tt.want) || resp.Total != len(tt.want) {
+				t.Errorf("got %v (total %d), want %v", names, resp.Total, tt.want)
+			}
+		})
+	}
+
+	for _, query := range []string{
+		"category=tools",
+		"min_price=-1",
+		"min_price=100&max_price=10",
+		"posted_from=yesterday",
+		"flagged=maybe",
+		"sort=cheapest",
+	} {
+		if code := doJSON(t, srv.router, "GET", "/api/listings?"+query, "", nil, nil); code != 400 {
+			t.Errorf("%s: status %d, want 400", query, code)
+		}
+	}
+}
+
+func TestListingPaging(t *testing.T) {
+	srv := newTestServer(t)
+	token := srv.signInUser(t, "+254712345678")
+	for price := 10; price <= 50; price += 10 {
+		createListing(t, srv, token, testListing(fmt.Sprint("Lot ", price), "Other", "", float64(price)))
+	}
+
+	tests := []struct {
+		query   string
+		want    string
+		hasMore bool
+	}{
+		{"limit=2", "Lot 10,Lot 20", true},
+		{"limit=2&offset=2", "Lot 30,Lot 40", true},
+		{"limit=2&offset=4", "Lot 50", false},
+		{"limit=5", "Lot 10,Lot 20,Lot 30,Lot 40,Lot 50", false},
+		{"offset=10", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			names, resp := listingNames(t, srv, "", "/api/listings?sort=price_asc&"+tt.query)
+			if got := strings.Join(names, ","); got != tt.want || resp.HasMore != tt.hasMore || resp.Total != 5 {
+				t.Errorf("got %q has_more %v total %d, want %q has_more %v total 5", got, resp.HasMore, resp.Total, tt.want, tt.hasMore)
+			}
+		})
+	}
+
+	for _, query := range []string{"limit=0", fmt.Sprint("limit=", maxListingLimit+1), "offset=-1", "limit=ten"} {
+		if code := doJSON(t, srv.router, "GET", "/api/listings?"+query, "", nil, nil); code != 400 {
+			t.Errorf("%s: status %d, want 400", query, code)
+		}
+	}
+}
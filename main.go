@@ -1,16 +1,25 @@
 package main
 
 import (
+	"crypto/sha1"
 	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"flag"
 	"net/http"
+	"net/url"
 
 	// "encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -39,6 +48,11 @@ func (c Currency) String() string {
 	return [...]string{"KES", "USD"}[c]
 }
 
+// MarshalText makes currencies appear as "KES"/"USD" in JSON responses.
+func (c Currency) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
 type PriceFlag int
 
 const (
@@ -59,15 +73,23 @@ type FoodCategory struct {
 
 // Commodity represents a specific food item
 type Commodity struct {
-	ID          int       `json:"id"`
-	Name        string    `json:"name"`
-	Price       float64   `json:"price"`
-	Currency    Currency  `json:"currency"`
-	PriceFlag   PriceFlag `json:"price_flag"`
-	PriceType   PriceType `json:"price_type"`
-	Unit        string    `json:"unit"`
-	Date        string    `json:"date"`
-	CommodityID int       `json:"commodity_id"` // The original commodity ID from CSV
+	ID        string    `json:"id"` // Deterministic observation ID, see observationID
+	Name      string    `json:"name"`
+	Category  string    `json:"category"`
+	Price     float64   `json:"price"`
+	USDPrice  float64   `json:"usd_price"`
+	Currency  Currency  `json:"currency"`
+	PriceFlag PriceFlag `json:"price_flag"`
+	PriceType PriceType `json:"price_type"`
+	Unit      string    `json:"unit"`
+	Date      string    `json:"date"`
+	// Price per kg, litre or count unit, see normalizePrice
+	NormalizedPrice float64 `json:"normalized_price"`
+	NormalizedUnit  string  `json:"normalized_unit"`
+	UnitEstimated   bool    `json:"unit_estimated"`
+	CPIEstimated    bool    `json:"cpi_estimated,omitempty"` // real price used an extrapolated CPI
+	CommodityID     int     `json:"commodity_id"`            // The original commodity ID from CSV
+	MarketID        int     `json:"market_id"`               // The WFP market ID of the observation
 }
 
 // Location coordinates
@@ -78,6 +100,7 @@ type Location struct {
 
 // MarketData represents a market location
 type MarketData struct {
+	ID             int            `json:"id"` // The WFP market_id
 	Name           string         `json:"name"`
 	Location       Location       `json:"location"`
 	FoodCategories []FoodCategory `json:"food_categories"`
@@ -85,17 +108,86 @@ type MarketData struct {
 	Admin2         string         `json:"admin2"` // County (e.g., "Garissa")
 }
 
+// MarketSummary is a market without its price data
+type MarketSummary struct {
+	ID       int      `json:"id"`
+	Name     string   `json:"name"`
+	Location Location `json:"location"`
+	Admin1   string   `json:"admin1"`
+	Admin2   string   `json:"admin2"`
+}
+
+// Summary returns the market's identifying fields
+func (m MarketData) Summary() MarketSummary {
+	return MarketSummary{ID: m.ID, Name: m.Name, Location: m.Location, Admin1: m.Admin1, Admin2: m.Admin2}
+}
+
+// CommodityInfo describes a WFP commodity across the whole dataset
+type CommodityInfo struct {
+	ID           int      `json:"id"` // The WFP commodity_id
+	Name         string   `json:"name"`
+	Category     string   `json:"category"`
+	Units        []string `json:"units"`
+	PriceTypes   []string `json:"price_types"`
+	MarketIDs    []int    `json:"market_ids"`
+	FirstDate    string   `json:"first_date"`
+	LastDate     string   `json:"last_date"`
+	Observations int      `json:"observations"`
+}
+
+// ObservationResponse is a single price observation with its market
+type ObservationResponse struct {
+	Commodity
+	Market MarketSummary `json:"market"`
+}
+
 // FoodData is the top-level structure
 type FoodData struct {
 	Markets []MarketData `json:"markets"`
 	// Also keep a flat list for quick lookups
 	Commodities []Commodity `json:"-"` // Not exported to JSON
+	// Monthly KES/USD rates implied by price/usdprice
+	FXRates FXRates `json:"-"`
+	// Lookup tables, see buildIndex
+	Index *DataIndex `json:"-"`
+	// Kenya CPI for real prices, nil when no CPI file is configured
+	CPI *IndicatorSeries `json:"-"`
+	// Canonical commodities and their WFP variants
+	Taxonomy *Taxonomy `json:"-"`
+	// ALPS indicator of every series with enough history, see computeAlerts
+	Alerts []PriceAlert `json:"-"`
+	// Baskets of the baskets file, see LoadBaskets
+	Baskets []*Basket `json:"-"`
 }
 
+// LastDate returns the date of the most recent observation
+func (f *FoodData) LastDate() string {
+	last := ""
+	for _, comm := range f.Commodities {
+		if comm.Date > last {
+			last = comm.Date
+		}
+	}
+	return last
+}
+
+// LastYear returns the year of the most recent observation
+func (f *FoodData) LastYear() int {
+	last := f.LastDate()
+	year, _ := strconv.Atoi(last[:min(4, len(last))])
+	return year
+}
 
 type PriceHistoryPoint struct {
-	Date  string  `json:"date"`
-	Price float64 `json:"price"`
+	Date          string  `json:"date"`  // ISO start date of the bucket
+	Label         string  `json:"label"` // e.g. "Jan 2024", "Q1 2024", "2024"
+	Price         float64 `json:"price"`
+	Unit          string  `json:"unit"`
+	OriginalPrice float64 `json:"originalPrice"`
+	OriginalUnit  string  `json:"originalUnit"`
+	Estimated     bool    `json:"estimated"`
+	Observations  int     `json:"observations"` // observations aggregated into the point
+	CPIEstimated  bool    `json:"cpiEstimated,omitempty"`
 }
 
 // CSVRecord represents a single row from the CSV
@@ -119,21 +211,28 @@ type CSVRecord struct {
 }
 
 type MarketPriceResponse struct {
-	ID           string  `json:"id"`
-	Market       string  `json:"market"`
-	Location     string  `json:"location"`
-	Product      string  `json:"product"`  // Changed from 'name' to 'product' to match frontend
-	Price        float64 `json:"price"`
-	Currency     string  `json:"currency"`
-	Unit         string  `json:"unit"`
-	Trend        string  `json:"trend"`
-	TrendPercent float64 `json:"trendPercent"`
-	LastUpdated  string  `json:"lastUpdated"`
-	IsStale      bool    `json:"isStale"`
+	ID            string  `json:"id"`
+	Market        string  `json:"market"`
+	Location      string  `json:"location"`
+	Product       string  `json:"product"`   // Changed from 'name' to 'product' to match frontend
+	Canonical     string  `json:"canonical"` // taxonomy id, e.g. "maize"
+	Variant       string  `json:"variant"`   // e.g. "white"; "" for the generic commodity
+	Price         float64 `json:"price"`
+	Currency      string  `json:"currency"`
+	Unit          string  `json:"unit"`
+	PriceType     string  `json:"priceType"` // Wholesale or Retail
+	OriginalPrice float64 `json:"originalPrice"`
+	OriginalUnit  string  `json:"originalUnit"`
+	Estimated     bool    `json:"estimated"`              // normalization used a typical weight or density
+	CPIEstimated  bool    `json:"cpiEstimated,omitempty"` // real price used an extrapolated CPI
+	Trend         string  `json:"trend"`
+	TrendPercent  float64 `json:"trendPercent"`
+	TrendPeriod   string  `json:"trendPeriod"`   // mom, qoq or yoy
+	TrendBaseDate string  `json:"trendBaseDate"` // date of the compared observation, "" if none
+	LastUpdated   string  `json:"lastUpdated"`
+	IsStale       bool    `json:"isStale"`
 }
 
-var data_file string = "./wfp_food_prices_ken(1).csv"
-
 // ==================== CSV PARSING ====================
 
 // ReadData parses the CSV file and populates the FoodData struct
@@ -145,26 +244,56 @@ func ReadData(file string) (FoodData, error) {
 	}
 	defer f.Close()
 
-	// Create a new CSV reader
+	// Create a new CSV reader. Row width is checked against the column map
+	// instead, so exports with extra trailing columns still parse.
 	reader := csv.NewReader(f)
-	
+	reader.FieldsPerRecord = -1
+
 	// Read the header row
 	header, err := reader.Read()
 	if err != nil {
 		return FoodData{}, fmt.Errorf("failed to read header: %w", err)
 	}
-	
-	fmt.Printf("📊 CSV Headers: %v\n\n", header)
+
+	slog.Debug("price CSV header", "file", file, "columns", header)
+
+	// Build the column map from the header names, or from the HXL hashtag
+	// row HDX ships either in place of the header or right below it.
+	var pending []string
+	var cols columnMap
+	if isHXLRow(header) {
+		cols = mapHXLColumns(header)
+	} else {
+		cols = mapHeaderColumns(header)
+		row, err := reader.Read()
+		if err != nil && err != io.EOF {
+			return FoodData{}, fmt.Errorf("failed to read first row: %w", err)
+		}
+		if isHXLRow(row) {
+			cols = mergeColumns(cols, mapHXLColumns(row))
+		} else {
+			pending = row
+		}
+	}
+	if missing := missingColumns(cols); len(missing) > 0 {
+		return FoodData{}, &SchemaError{File: file, Missing: missing, Header: header}
+	}
 
 	// Map to store unique markets
-	marketMap := make(map[string]*MarketData)
+	marketMap := make(map[int]*MarketData)
 	// Store all commodities for quick lookup
 	var allCommodities []Commodity
 
 	// Read all records
 	lineNum := 1
 	for {
-		record, err := reader.Read()
+		var record []string
+		var err error
+		if pending != nil {
+			record, pending = pending, nil
+		} else {
+			record, err = reader.Read()
+		}
 		if err == io.EOF {
 			break
 		}
@@ -175,40 +304,45 @@ func ReadData(file string) (FoodData, error) {
 		lineNum++
 
 		// Parse the record
-		csvRecord, err := parseCSVRecord(record)
+		csvRecord, err := parseCSVRecord(record, cols)
 		if err != nil {
 			log.Printf("Warning: error parsing line %d: %v", lineNum, err)
 			continue
 		}
 
-		// Create market key
-		marketKey := fmt.Sprintf("%s|%s|%s", csvRecord.Admin1, csvRecord.Admin2, csvRecord.Market)
-
 		// Get or create market
-		market, exists := marketMap[marketKey]
+		market, exists := marketMap[csvRecord.MarketID]
 		if !exists {
 			market = &MarketData{
-				Name:     csvRecord.Market,
-				Admin1:   csvRecord.Admin1,
-				Admin2:   csvRecord.Admin2,
-				Location: Location{Lat: csvRecord.Lat, Long: csvRecord.Long},
+				ID:             csvRecord.MarketID,
+				Name:           csvRecord.Market,
+				Admin1:         csvRecord.Admin1,
+				Admin2:         csvRecord.Admin2,
+				Location:       Location{Lat: csvRecord.Lat, Long: csvRecord.Long},
 				FoodCategories: []FoodCategory{},
 			}
-			marketMap[marketKey] = market
+			marketMap[csvRecord.MarketID] = market
 		}
 
 		// Create commodity
 		commodity := Commodity{
-			ID:          len(allCommodities) + 1,
+			ID:          observationID(csvRecord),
 			Name:        csvRecord.Commodity,
+			Category:    csvRecord.Category,
 			Price:       csvRecord.Price,
+			USDPrice:    csvRecord.USDPrice,
 			Currency:    parseCurrency(csvRecord.Currency),
 			PriceFlag:   parsePriceFlag(csvRecord.PriceFlag),
 			PriceType:   parsePriceType(csvRecord.PriceType),
 			Unit:        csvRecord.Unit,
 			Date:        csvRecord.Date,
 			CommodityID: csvRecord.CommodityID,
+			MarketID:    csvRecord.MarketID,
 		}
+		normalized := normalizePrice(commodity.Name, commodity.Price, commodity.Unit)
+		commodity.NormalizedPrice = normalized.Price
+		commodity.NormalizedUnit = normalized.Unit
+		commodity.UnitEstimated = normalized.Estimated
 		allCommodities = append(allCommodities, commodity)
 
 		// Add commodity to market's category
@@ -219,73 +353,97 @@ func ReadData(file string) (FoodData, error) {
 	foodData := FoodData{
 		Markets:     make([]MarketData, 0, len(marketMap)),
 		Commodities: allCommodities,
+		FXRates:     buildFXRates(allCommodities),
 	}
 
 	for _, market := range marketMap {
 		foodData.Markets = append(foodData.Markets, *market)
 	}
+	// Map iteration order is random; keep the API stable
+	sort.Slice(foodData.Markets, func(i, j int) bool {
+		return foodData.Markets[i].ID < foodData.Markets[j].ID
+	})
+	foodData.Index = buildIndex(&foodData)
 
-	fmt.Printf("✅ Parsed %d markets and %d commodities\n", len(foodData.Markets), len(allCommodities))
+	slog.Info("parsed prices", "file", file, "markets", len(foodData.Markets), "commodities", len(allCommodities))
 	return foodData, nil
 }
 
-// parseCSVRecord converts a CSV row to a CSVRecord struct
-func parseCSVRecord(record []string) (CSVRecord, error) {
-	if len(record) < 16 {
-		return CSVRecord{}, fmt.Errorf("record has %d fields, expected at least 16", len(record))
+// parseCSVRecord converts a CSV row to a CSVRecord struct using the
+// column positions resolved from the header
+func parseCSVRecord(record []string, cols columnMap) (CSVRecord, error) {
+	for _, col := range csvSchema {
+		if col.Required && cols[col.Name] >= len(record) {
+			return CSVRecord{}, fmt.Errorf("record has %d fields, missing column %q", len(record), col.Name)
+		}
 	}
 
 	var csvRecord CSVRecord
 	var err error
 
 	// Basic fields
-	csvRecord.Date = record[0]
-	csvRecord.Admin1 = record[1]
-	csvRecord.Admin2 = record[2]
-	csvRecord.Market = record[3]
-	
+	csvRecord.Date = cols.get(record, "date")
+	csvRecord.Admin1 = cols.get(record, "admin1")
+	csvRecord.Admin2 = cols.get(record, "admin2")
+	csvRecord.Market = cols.get(record, "market")
+
 	// Parse numeric fields
-	csvRecord.MarketID, err = strconv.Atoi(record[4])
+	csvRecord.MarketID, err = strconv.Atoi(cols.get(record, "market_id"))
 	if err != nil {
-		return CSVRecord{}, fmt.Errorf("invalid MarketID: %s", record[4])
+		return CSVRecord{}, fmt.Errorf("invalid MarketID: %s", cols.get(record, "market_id"))
 	}
 
-	csvRecord.Lat, err = strconv.ParseFloat(record[5], 64)
+	csvRecord.Lat, err = strconv.ParseFloat(cols.get(record, "latitude"), 64)
 	if err != nil {
-		return CSVRecord{}, fmt.Errorf("invalid Latitude: %s", record[5])
+		return CSVRecord{}, fmt.Errorf("invalid Latitude: %s", cols.get(record, "latitude"))
 	}
 
-	csvRecord.Long, err = strconv.ParseFloat(record[6], 64)
+	csvRecord.Long, err = strconv.ParseFloat(cols.get(record, "longitude"), 64)
 	if err != nil {
-		return CSVRecord{}, fmt.Errorf("invalid Longitude: %s", record[6])
+		return CSVRecord{}, fmt.Errorf("invalid Longitude: %s", cols.get(record, "longitude"))
 	}
 
-	csvRecord.Category = record[7]
-	csvRecord.Commodity = record[8]
-	
-	csvRecord.CommodityID, err = strconv.Atoi(record[9])
+	csvRecord.Category = cols.get(record, "category")
+	csvRecord.Commodity = cols.get(record, "commodity")
+
+	csvRecord.CommodityID, err = strconv.Atoi(cols.get(record, "commodity_id"))
 	if err != nil {
-		return CSVRecord{}, fmt.Errorf("invalid CommodityID: %s", record[9])
+		return CSVRecord{}, fmt.Errorf("invalid CommodityID: %s", cols.get(record, "commodity_id"))
 	}
 
-	csvRecord.Unit = record[10]
-	csvRecord.PriceFlag = record[11]
-	csvRecord.PriceType = record[12]
-	csvRecord.Currency = record[13]
+	csvRecord.Unit = cols.get(record, "unit")
+	csvRecord.PriceFlag = cols.get(record, "priceflag")
+	csvRecord.PriceType = cols.get(record, "pricetype")
+	csvRecord.Currency = cols.get(record, "currency")
 
-	csvRecord.Price, err = strconv.ParseFloat(record[14], 64)
+	csvRecord.Price, err = strconv.ParseFloat(cols.get(record, "price"), 64)
 	if err != nil {
-		return CSVRecord{}, fmt.Errorf("invalid Price: %s", record[14])
+		return CSVRecord{}, fmt.Errorf("invalid Price: %s", cols.get(record, "price"))
 	}
 
-	csvRecord.USDPrice, err = strconv.ParseFloat(record[15], 64)
-	if err != nil {
-		return CSVRecord{}, fmt.Errorf("invalid USDPrice: %s", record[15])
+	// usdprice is optional; older exports don't carry it
+	if usd := cols.get(record, "usdprice"); usd != "" {
+		csvRecord.USDPrice, err = strconv.ParseFloat(usd, 64)
+		if err != nil {
+			return CSVRecord{}, fmt.Errorf("invalid USDPrice: %s", usd)
+		}
 	}
 
 	return csvRecord, nil
 }
 
+// observationID derives a price observation's ID from the fields that
+// identify it in the WFP dataset, so the same row keeps the same ID across
+// exports regardless of row order.
+func observationID(r CSVRecord) string {
+	key := fmt.Sprintf("%d|%d|%s|%s|%s|%s",
+		r.MarketID, r.CommodityID,
+		strings.ToUpper(r.Unit), strings.ToLower(r.PriceType), strings.ToLower(r.PriceFlag),
+		r.Date)
+	sum := sha1.Sum([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
 // addCommodityToMarket adds a commodity to the appropriate category in a market
 func addCommodityToMarket(market *MarketData, categoryName string, commodity Commodity) {
 	// Find or create category
@@ -321,7 +479,7 @@ func parsePriceFlag(s string) PriceFlag {
 		return Actual
 	case "aggregate":
 		return Aggregate
-	case "composite":
+	case "composite", "actual,aggregate":
 		return Composite
 	default:
 		return Aggregate
@@ -340,27 +498,88 @@ func parsePriceType(s string) PriceType {
 // ==================== API ENDPOINTS ====================
 
 func main() {
+	cfg, err := LoadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	setupLogging(cfg)
+
 	// Parse CSV data
-	fmt.Println("📂 Loading food price data...")
-	foodData, err := ReadData(data_file)
+	log.Println("📂 Loading food price data...")
+	store, err := NewDatasetStore(DataSources{
+		Prices:   cfg.DataFile,
+		CPI:      cfg.CPIFile,
+		Taxonomy: cfg.TaxonomyFile,
+		Baskets:  cfg.BasketsFile,
+	})
 	if err != nil {
 		log.Fatal("Failed to load data:", err)
 	}
+	storage, err := OpenStorage(cfg.DataDir)
+	if err != nil {
+		log.Fatal("Failed to open storage:", err)
+	}
+	defer storage.Close()
+	repos := storage.Repositories()
+	authKey := []byte(cfg.AuthSecret)
+	if len(authKey) == 0 {
+		if authKey, err = storage.AuthKey(); err != nil {
+			log.Fatal("Failed to load the session key:", err)
+		}
+	}
+	smsFile := cfg.SMSFile
+	if smsFile == "" {
+		smsFile = filepath.Join(cfg.DataDir, "sms.log")
+	}
+	sms, err := newSMSSender(cfg.SMSSender, smsFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	auth := NewAuth(repos.Users, repos.Sessions, sms, authKey)
+
+	// Pick up new exports without a restart: poll the file, and reload on
+	// SIGHUP or POST /api/admin/reload
+	if cfg.WatchInterval > 0 {
+		go store.Watch(time.Duration(cfg.WatchInterval))
+	}
+	go store.ReloadOnSignal()
+	go purgeListings(repos.Listings, time.Hour)
+	go recheckListingPrices(store, repos.Listings)
 
 	// Create Gin router
-	router := gin.Default()
-
-	// Enable CORS
-	router.Use(func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
-			return
-		}
-		c.Next()
-	})
+	if cfg.LogLevel != "debug" {
+		gin.SetMode(gin.ReleaseMode)
+	}
+	router, err := newRouter(cfg, store, repos, auth)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if cfg.TLSCert != "" {
+		log.Printf("🚀 Listening on %s (TLS)", cfg.Listen)
+		err = router.RunTLS(cfg.Listen, cfg.TLSCert, cfg.TLSKey)
+	} else {
+		log.Printf("🚀 Listening on %s", cfg.Listen)
+		err = router.Run(cfg.Listen)
+	}
+	log.Fatal(err)
+}
+
+// newRouter sets up the API routes on the dataset, the stored data and
+// phone sign-in, and the UI.
+func newRouter(cfg Config, store *DatasetStore, repos Repositories, auth *Auth) (*gin.Engine, error) {
+	router := gin.New()
+	router.Use(requestLogger(), gin.Recovery())
+	router.Use(corsMiddleware(cfg.CORSOrigins))
+	router.Use(authenticate(auth))
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		return nil, err
+	}
+	syncStore := NewSyncStore(repos.Sync, repos.Cursors)
 
 	// API Routes
 	router.GET("/ping", func(c *gin.Context) {
@@ -369,13 +588,28 @@ func main() {
 		})
 	})
 
-	// Get all markets
+	// Get all markets, with their foods named in lang
 	router.GET("/api/markets", func(c *gin.Context) {
-		c.JSON(200, foodData.Markets)
+		foodData := store.Load()
+		lang, err := parseLangParam(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, foodData.Taxonomy.localizeMarkets(foodData.Markets, lang))
 	})
 
+	// Markets near a point, nearest first
+	router.GET("/api/markets/nearby", nearbyMarketsHandler(store))
+
 	// Get markets by region
 	router.GET("/api/markets/region/:region", func(c *gin.Context) {
+		foodData := store.Load()
+		lang, err := parseLangParam(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
 		region := c.Param("region")
 		var markets []MarketData
 		for _, m := range foodData.Markets {
@@ -383,11 +617,17 @@ func main() {
 				markets = append(markets, m)
 			}
 		}
-		c.JSON(200, markets)
+		c.JSON(200, foodData.Taxonomy.localizeMarkets(markets, lang))
 	})
 
 	// Get markets by county
 	router.GET("/api/markets/county/:county", func(c *gin.Context) {
+		foodData := store.Load()
+		lang, err := parseLangParam(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
 		county := c.Param("county")
 		var markets []MarketData
 		for _, m := range foodData.Markets {
@@ -395,62 +635,195 @@ func main() {
 				markets = append(markets, m)
 			}
 		}
-		c.JSON(200, markets)
+		c.JSON(200, foodData.Taxonomy.localizeMarkets(markets, lang))
 	})
 
 	// Get specific market by name
 	router.GET("/api/market/:name", func(c *gin.Context) {
-		name := c.Param("name")
-		for _, m := range foodData.Markets {
-			if strings.EqualFold(m.Name, name) {
-				c.JSON(200, m)
-				return
-			}
+		foodData := store.Load()
+		lang, err := parseLangParam(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		markets := foodData.MarketsByName(c.Param("name"))
+		if len(markets) == 0 {
+			c.JSON(404, gin.H{"error": "Market not found"})
+			return
+		}
+		c.JSON(200, foodData.Taxonomy.localizeMarket(*markets[0], lang))
+	})
+
+	// Get market by WFP market_id
+	router.GET("/api/markets/id/:id", func(c *gin.Context) {
+		foodData := store.Load()
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "invalid market id"})
+			return
+		}
+		market, ok := foodData.MarketByID(id)
+		if !ok {
+			c.JSON(404, gin.H{"error": "Market not found"})
+			return
+		}
+		lang, err := parseLangParam(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, foodData.Taxonomy.localizeMarket(*market, lang))
+	})
+
+	// Get commodity details by WFP commodity_id
+	router.GET("/api/commodities/id/:id", func(c *gin.Context) {
+		foodData := store.Load()
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "invalid commodity id"})
+			return
+		}
+		lang, err := parseLangParam(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
 		}
-		c.JSON(404, gin.H{"error": "Market not found"})
+		info, ok := commodityInfo(foodData.CommoditySeries(id), id)
+		if !ok {
+			c.JSON(404, gin.H{"error": "Commodity not found"})
+			return
+		}
+		info.Name = foodData.Taxonomy.LocalName(id, info.Name, lang)
+		c.JSON(200, info)
+	})
+
+	// Get a single price observation by its ID
+	router.GET("/api/observations/:id", func(c *gin.Context) {
+		foodData := store.Load()
+		lang, err := parseLangParam(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		comm, ok := foodData.Observation(c.Param("id"))
+		if !ok {
+			c.JSON(404, gin.H{"error": "Observation not found"})
+			return
+		}
+		comm.Name = foodData.Taxonomy.LocalName(comm.CommodityID, comm.Name, lang)
+		response := ObservationResponse{Commodity: comm}
+		if market, ok := foodData.MarketByID(comm.MarketID); ok {
+			response.Market = market.Summary()
+		}
+		c.JSON(200, response)
 	})
 
 	// Get all commodities
 	router.GET("/api/commodities", func(c *gin.Context) {
-		c.JSON(200, foodData.Commodities)
+		foodData := store.Load()
+		opts, err := parsePriceOptions(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		commodities, err := opts.commodities(foodData, foodData.Commodities)
+		if err != nil {
+			c.JSON(422, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, commodities)
 	})
 
+	// Canonical commodities and their WFP variants
+	router.GET("/api/commodities/taxonomy", taxonomyHandler(store))
+
 	// Get commodities by name
 	router.GET("/api/commodities/:name", func(c *gin.Context) {
+		foodData := store.Load()
+		opts, err := parsePriceOptions(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		expand, err := parseVariantsParam(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		// Canonical commodity or exact WFP name, so "maize" is the grain
+		// and not maize flour; variants=true adds every variant. Other
+		// names, in any language or with typos, resolve to the best match
+		// only, which the X-Commodity-* headers describe.
 		name := c.Param("name")
+		filter := foodData.Taxonomy.Filter([]string{name}, expand)
+		if matches := foodData.Taxonomy.Search(name, opts.Lang); len(matches) > 0 {
+			best := matches[0]
+			if len(filter) == 0 {
+				filter = foodData.Taxonomy.Filter([]string{best.ID}, expand)
+			}
+			c.Header("X-Commodity-Id", best.ID)
+			c.Header("X-Commodity-Match", best.MatchType)
+			c.Header("X-Commodity-Matched-Alias", url.PathEscape(best.MatchedAlias))
+		}
+		ids := make([]int, 0, len(filter))
+		for id := range filter {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
 		var commodities []Commodity
-		for _, comm := range foodData.Commodities {
-			if strings.Contains(strings.ToLower(comm.Name), strings.ToLower(name)) {
-				commodities = append(commodities, comm)
+		for _, id := range ids {
+			for _, series := range foodData.CommoditySeries(id) {
+				commodities = append(commodities, series.Observations...)
 			}
 		}
+		commodities, err = opts.commodities(foodData, commodities)
+		if err != nil {
+			c.JSON(422, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(200, commodities)
 	})
 
+	// Ranked commodity and market search, see searchHandler
+	router.GET("/api/search", searchHandler(store))
+
 	// Get prices for a specific commodity in a market
 	router.GET("/api/prices/:market/:commodity", func(c *gin.Context) {
+		foodData := store.Load()
+		opts, err := parsePriceOptions(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		expand, err := parseVariantsParam(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
 		marketName := c.Param("market")
-		commodityName := c.Param("commodity")
-		
+		// Canonical commodity or exact WFP name; variants=true adds every variant
+		filter := foodData.Taxonomy.Filter([]string{c.Param("commodity")}, expand)
+
 		var prices []Commodity
-		for _, market := range foodData.Markets {
-			if strings.EqualFold(market.Name, marketName) {
-				for _, cat := range market.FoodCategories {
-					for _, food := range cat.Foods {
-						if strings.Contains(strings.ToLower(food.Name), strings.ToLower(commodityName)) {
-							prices = append(prices, food)
-						}
-					}
+		if markets := foodData.MarketsByName(marketName); len(markets) > 0 {
+			for _, series := range foodData.MarketSeries(markets[0].ID) {
+				if filter.Match(series.Key.CommodityID) {
+					prices = append(prices, series.Observations...)
 				}
-				break
 			}
 		}
-		
+
+		prices, err = opts.commodities(foodData, prices)
+		if err != nil {
+			c.JSON(422, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(200, prices)
 	})
 
 	// Debug endpoint to see parsed data structure
 	router.GET("/debug", func(c *gin.Context) {
+		foodData := store.Load()
 		summary := gin.H{
 			"total_markets":     len(foodData.Markets),
 			"total_commodities": len(foodData.Commodities),
@@ -462,192 +835,225 @@ func main() {
 
 	// Latest prices endpoint
 	router.GET("/api/prices/latest", func(c *gin.Context) {
+		foodData := store.Load()
+		opts, err := parsePriceOptions(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		period, err := parseTrendPeriod(c.Query("period")) // mom, qoq or yoy
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		expand, err := parseVariantsParam(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		priceType, err := parsePriceTypeParam(c.Query("pricetype"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+
 		// Get query parameters
 		commoditiesParam := c.Query("commodities") // e.g., "maize,beans"
 		marketsParam := c.Query("markets")         // e.g., "all" or "Dagahaley,Kakuma"
-		
-		// Parse commodities filter
-		var commodityFilters []string
-		if commoditiesParam != "" {
-			commodityFilters = strings.Split(strings.ToLower(commoditiesParam), ",")
-		}
-		
+
+		// Parse commodities filter: canonical commodities, with variants=true
+		// also their variants, e.g. "Maize (white)" for maize
+		commodityFilter := foodData.Taxonomy.Filter(splitTerms(commoditiesParam), expand)
+
 		// Parse markets filter
 		var marketFilters []string
 		if marketsParam != "" && marketsParam != "all" {
 			marketFilters = strings.Split(marketsParam, ",")
 		}
-		
-		// Map to store latest price for each (market, commodity) combination
-		latestPrices := make(map[string]*Commodity)
-		
-		// Process all markets
+
+		// Latest price of each matching series, in market order
+		var response []MarketPriceResponse
 		for _, market := range foodData.Markets {
 			// Skip if market not in filter
 			if len(marketFilters) > 0 && !containsMarket(marketFilters, market.Name) {
 				continue
 			}
-			
-			// Process all categories in this market
-			for _, category := range market.FoodCategories {
-				for _, commodity := range category.Foods {
-					// Skip if commodity not in filter
-					if len(commodityFilters) > 0 && !containsCommodity(commodityFilters, commodity.Name) {
-						continue
-					}
-					
-					// Create a unique key for this (market, commodity)
-					key := fmt.Sprintf("%s|%s", market.Name, commodity.Name)
-					
-					// Keep only the latest (by date)
-					existing, exists := latestPrices[key]
-					if !exists || commodity.Date > existing.Date {
-						latestPrices[key] = &commodity
-					}
+			location := fmt.Sprintf("%s, %s", market.Admin2, market.Admin1)
+
+			for _, series := range foodData.MarketSeries(market.ID) {
+				// Skip if commodity or price type not in filter
+				if !commodityFilter.Match(series.Key.CommodityID) || (priceType != nil && series.Key.PriceType != *priceType) {
+					continue
 				}
-			}
-		}
-		
-		// Convert to response format and calculate trends
-		var response []MarketPriceResponse
-		for _, commodity := range latestPrices {
-			// Find market name and location
-			var marketName, location string
-			for _, market := range foodData.Markets {
-				for _, category := range market.FoodCategories {
-					for _, c := range category.Foods {
-						if c.ID == commodity.ID {
-							marketName = market.Name
-							location = fmt.Sprintf("%s, %s", market.Admin2, market.Admin1)
-							break
-						}
-					}
+				commodity := series.Latest()
+				variant, _ := foodData.Taxonomy.Variant(commodity.CommodityID)
+
+				// Normalized price (per kg, litre or unit) in the requested currency
+				normalizedPrice, cpiEstimated, err := opts.convert(foodData, commodity.NormalizedPrice, commodity.Currency, commodity.Date)
+				if err != nil {
+					c.JSON(422, gin.H{"error": err.Error()})
+					return
 				}
+				originalPrice, _, err := opts.convert(foodData, commodity.Price, commodity.Currency, commodity.Date)
+				if err != nil {
+					c.JSON(422, gin.H{"error": err.Error()})
+					return
+				}
+
+				// Calculate trend against the same series one period earlier
+				trend := calculateTrend(series.Observations, commodity, period)
+
+				// Determine if data is stale (older than 30 days)
+				isStale := isDataStale(commodity.Date)
+
+				response = append(response, MarketPriceResponse{
+					ID:            commodity.ID,
+					Market:        market.Name,
+					Location:      location,
+					Product:       foodData.Taxonomy.LocalName(commodity.CommodityID, commodity.Name, opts.Lang), // This matches frontend's 'name' field
+					Canonical:     variant.Canonical.ID,
+					Variant:       variant.Variant,
+					Price:         normalizedPrice,
+					Currency:      opts.Currency.String(),
+					Unit:          commodity.NormalizedUnit,
+					PriceType:     commodity.PriceType.String(),
+					OriginalPrice: originalPrice,
+					OriginalUnit:  commodity.Unit,
+					Estimated:     commodity.UnitEstimated,
+					CPIEstimated:  cpiEstimated,
+					Trend:         trend.Direction,
+					TrendPercent:  trend.Percent,
+					TrendPeriod:   trend.Period,
+					TrendBaseDate: trend.BaseDate,
+					LastUpdated:   formatDate(commodity.Date),
+					IsStale:       isStale,
+				})
 			}
-			
-			// Normalize price to per kg
-			normalizedPrice, normalizedUnit := normalizePrice(commodity.Price, commodity.Unit)
-			
-			// Calculate trend (compare with previous price)
-			trend, trendPercent := calculateTrend(foodData, commodity, marketName)
-			
-			// Determine if data is stale (older than 30 days)
-			isStale := isDataStale(commodity.Date)
-			
-			response = append(response, MarketPriceResponse{
-				ID:           fmt.Sprintf("%d", commodity.ID),
-				Market:       marketName,
-				Location:     location,
-				Product:      commodity.Name,  // This matches frontend's 'name' field
-				Price:        normalizedPrice,
-				Currency:     commodity.Currency.String(),
-				Unit:         normalizedUnit,
-				Trend:        trend,
-				TrendPercent: trendPercent,
-				LastUpdated:  formatDate(commodity.Date),
-				IsStale:      isStale,
-			})
-		}
-		
+		}
+
 		c.JSON(200, response)
 	})
 
+	// Monthly KES/USD rates used by currency=USD
+	router.GET("/api/fx/rates", func(c *gin.Context) {
+		foodData := store.Load()
+		c.JSON(200, foodData.FXRates.Series())
+	})
 
-	router.GET("/api/prices/history", func(c *gin.Context) {
-	marketName := c.Query("market")
-	commodityName := c.Query("commodity")
-	
-	if marketName == "" || commodityName == "" {
-		c.JSON(400, gin.H{"error": "market and commodity parameters are required"})
-		return
-	}
-	
-	var history []PriceHistoryPoint
-	var allPrices []Commodity
-	
-	// Find all prices for this commodity in this market
-	for _, market := range foodData.Markets {
-		if strings.Contains(strings.ToLower(market.Name), strings.ToLower(marketName)) {
-			for _, category := range market.FoodCategories {
-				for _, commodity := range category.Foods {
-					if strings.Contains(strings.ToLower(commodity.Name), strings.ToLower(commodityName)) {
-						allPrices = append(allPrices, commodity)
-					}
-				}
-			}
-			break
-		}
-	}
-	
-	// Sort by date (oldest first)
-	for i := 0; i < len(allPrices)-1; i++ {
-		for j := i + 1; j < len(allPrices); j++ {
-			if allPrices[i].Date > allPrices[j].Date {
-				allPrices[i], allPrices[j] = allPrices[j], allPrices[i]
-			}
+	// Kenya CPI used by real=true, with extrapolated years flagged
+	router.GET("/api/cpi", func(c *gin.Context) {
+		foodData := store.Load()
+		if foodData.CPI == nil {
+			c.JSON(404, gin.H{"error": "No CPI data loaded"})
+			return
 		}
-	}
-	
-	// Take last 12 months or all available
-	startIdx := 0
-	if len(allPrices) > 12 {
-		startIdx = len(allPrices) - 12
-	}
-	
-	for i := startIdx; i < len(allPrices); i++ {
-		commodity := allPrices[i]
-		normalizedPrice, _ := normalizePrice(commodity.Price, commodity.Unit)
-		history = append(history, PriceHistoryPoint{
-			Date:  formatMonth(commodity.Date),
-			Price: normalizedPrice,
+		c.JSON(200, gin.H{
+			"series": foodData.CPI,
+			"values": foodData.CPI.Values(),
 		})
-	}
-	
-	c.JSON(200, history)
-})
+	})
+
+	// Forecast of one series, selected as for /api/prices/history
+	router.GET("/api/prices/forecast", forecastHandler(store))
 
+	// Seasonal price index by region and county
+	router.GET("/api/prices/seasonality", seasonalityHandler(store))
+
+	// Prices rolled up over the markets of each county, region or the country
+	router.GET("/api/prices/aggregate", aggregateHandler(store))
+
+	// Month-by-month price gap between two markets
+	router.GET("/api/analysis/spread", spreadHandler(store, cfg.TransportCostPerKmKg))
+
+	// Market pairs whose price gap most exceeds the cost of transport
+	router.GET("/api/analysis/spread/top", topSpreadsHandler(store, cfg.TransportCostPerKmKg))
+
+	// Retail markup over wholesale by market and county
+	router.GET("/api/analysis/margin", marginHandler(store))
+
+	// Food baskets of the baskets file
+	router.GET("/api/baskets", basketsHandler(store))
+
+	// Cost of a basket per market, for a stored basket or a posted one
+	router.GET("/api/basket/cost", basketCostHandler(store))
+	router.POST("/api/basket/cost", basketCostHandler(store))
+
+	// Monthly cost of a basket in one market
+	router.GET("/api/basket/trend", basketTrendHandler(store))
+	router.POST("/api/basket/trend", basketTrendHandler(store))
+
+	// Price spike alerts (ALPS), computed on every load
+	router.GET("/api/alerts", alertsHandler(store))
+
+	// Where a commodity sells best near a point, net of transport
+	router.GET("/api/prices/best", bestPriceHandler(store, cfg.TransportCostPerKmKg))
+
+	// Price history of one series, see parseHistoryQuery for the options
+	router.GET("/api/prices/history", priceHistoryHandler(store))
+
+	// Phone sign-in with one-time codes, and session tokens
+	registerAuthRoutes(router, auth)
+
+	// Offline changes of the PWA in, server changes since the cursor out
+	router.POST("/api/sync", requireUser(), syncHandler(syncStore))
+
+	// Marketplace listings; writes are limited to the owner
+	router.GET("/api/listings", listingsHandler(repos.Listings))
+	router.GET("/api/listings/price-suggestion", priceSuggestionHandler(store))
+	router.GET("/api/listings/:id", listingHandler(repos.Listings))
+	router.POST("/api/listings", requireUser(), createListingHandler(store, repos.Listings, repos.Profiles))
+	router.PUT("/api/listings/:id", requireUser(), updateListingHandler(store, repos.Listings, repos.Profiles))
+	router.DELETE("/api/listings/:id", requireUser(), deleteListingHandler(repos.Listings))
+
+	registerAdminRoutes(router, store, cfg.AdminToken)
 
 	// Serving the UI
-	router.Static("/assets", "./ui/dist/assets")
-	router.StaticFile("/sw.js", "./ui/dist/sw.js")
-	router.StaticFile("/pwa-512.png", "./ui/dist/pwa-512.png")
-	router.StaticFile("/pwa-192.png", "./ui/dist/pwa-192.png")
-	router.StaticFile("/manifest.webmanifest", "./ui/dist/manifest.webmanifest")
-	router.StaticFile("/favicon.ico", "./ui/dist/favicon.ico")
-	
+	serveUI(router, cfg.StaticRoot)
+
+	return router, nil
+}
+
+// serveUI serves the built PWA from root, falling back to index.html so
+// client-side routes work on reload. An empty root serves the API only.
+func serveUI(router *gin.Engine, root string) {
+	if root != "" {
+		router.Static("/assets", filepath.Join(root, "assets"))
+		router.StaticFile("/sw.js", filepath.Join(root, "sw.js"))
+		router.StaticFile("/pwa-512.png", filepath.Join(root, "pwa-512.png"))
+		router.StaticFile("/pwa-192.png", filepath.Join(root, "pwa-192.png"))
+		router.StaticFile("/manifest.webmanifest", filepath.Join(root, "manifest.webmanifest"))
+		router.StaticFile("/favicon.ico", filepath.Join(root, "favicon.ico"))
+	}
+
 	router.NoRoute(func(c *gin.Context) {
-		if strings.HasPrefix(c.Request.URL.Path, "/api") {
+		if root == "" || strings.HasPrefix(c.Request.URL.Path, "/api") {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
 			return
 		}
 
-		c.File("./ui/dist/index.html")
+		c.File(filepath.Join(root, "index.html"))
 	})
-
-	router.Run() // listens on 0.0.0.0:8080 by default
 }
 
 // ==================== HELPER FUNCTIONS ====================
 
-
 func formatMonth(dateStr string) string {
 	parts := strings.Split(dateStr, "-")
 	if len(parts) != 3 {
 		return dateStr
 	}
-	
-	months := []string{"Jan", "Feb", "Mar", "Apr", "May", "Jun", 
+
+	months := []string{"Jan", "Feb", "Mar", "Apr", "May", "Jun",
 		"Jul", "Aug", "Sep", "Oct", "Nov", "Dec"}
-	
+
 	month, _ := strconv.Atoi(parts[1])
 	if month < 1 || month > 12 {
 		return dateStr
 	}
-	
+
 	return fmt.Sprintf("%s %s", months[month-1], parts[0])
 }
 
-
 func getUniqueRegions(markets []MarketData) []string {
 	regionMap := make(map[string]bool)
 	for _, m := range markets {
@@ -672,102 +1078,56 @@ func getUniqueCounties(markets []MarketData) []string {
 	return counties
 }
 
-func containsMarket(filters []string, marketName string) bool {
-	for _, f := range filters {
-		if strings.EqualFold(f, marketName) {
-			return true
-		}
+// commodityInfo summarizes every series of the given commodity_id
+func commodityInfo(series []*Series, id int) (CommodityInfo, bool) {
+	if len(series) == 0 {
+		return CommodityInfo{}, false
 	}
-	return false
-}
-
-func containsCommodity(filters []string, commodityName string) bool {
-	lowerCommodity := strings.ToLower(commodityName)
-	for _, f := range filters {
-		if strings.Contains(lowerCommodity, strings.ToLower(f)) {
-			return true
-		}
+	info := CommodityInfo{
+		ID:        id,
+		Name:      series[0].Name,
+		Category:  series[0].Category,
+		FirstDate: series[0].Observations[0].Date,
 	}
-	return false
-}
-
-func normalizePrice(price float64, unit string) (float64, string) {
-	unit = strings.TrimSpace(unit)
-	
-	// Handle "90 KG" format
-	if strings.Contains(unit, "KG") && !strings.EqualFold(unit, "KG") {
-		// Extract number from strings like "90 KG"
-		parts := strings.Fields(unit)
-		if len(parts) >= 1 {
-			// Try to parse the first part as a number
-			multiplier, err := strconv.ParseFloat(parts[0], 64)
-			if err == nil && multiplier > 0 {
-				// Convert to per kg
-				return price / multiplier, "kg"
-			}
+	units := make(map[string]bool)
+	priceTypes := make(map[string]bool)
+	markets := make(map[int]bool)
+
+	for _, s := range series {
+		info.Observations += len(s.Observations)
+		if first := s.Observations[0].Date; first < info.FirstDate {
+			info.FirstDate = first
 		}
+		if last := s.Latest().Date; last > info.LastDate {
+			info.LastDate = last
+		}
+		units[s.Latest().Unit] = true
+		priceTypes[s.Key.PriceType.String()] = true
+		markets[s.Key.MarketID] = true
 	}
-	
-	// Handle liters - convert to kg (assuming 1L = 1kg for water-based products)
-	if strings.EqualFold(unit, "L") || strings.EqualFold(unit, "LTR") {
-		return price, "kg"
+
+	for u := range units {
+		info.Units = append(info.Units, u)
 	}
-	
-	// Handle milliliters - convert to kg (500 ML = 0.5 kg)
-	if strings.Contains(strings.ToUpper(unit), "ML") {
-		parts := strings.Fields(unit)
-		if len(parts) >= 1 {
-			ml, err := strconv.ParseFloat(parts[0], 64)
-			if err == nil && ml > 0 {
-				return price * (1000 / ml), "kg"
-			}
-		}
-		return price * 2, "kg"
+	for p := range priceTypes {
+		info.PriceTypes = append(info.PriceTypes, p)
 	}
-	
-	// Handle "Unit" (per item) - keep as is
-	if strings.EqualFold(unit, "UNIT") || strings.EqualFold(unit, "Unit") {
-		return price, "unit"
+	for m := range markets {
+		info.MarketIDs = append(info.MarketIDs, m)
 	}
-	
-	// Default: already per kg or unknown
-	return price, strings.ToLower(unit)
+	sort.Strings(info.Units)
+	sort.Strings(info.PriceTypes)
+	sort.Ints(info.MarketIDs)
+	return info, true
 }
 
-func calculateTrend(foodData FoodData, current *Commodity, marketName string) (string, float64) {
-	// Find previous price for same commodity in same market
-	var previousPrice float64
-	var previousDate string
-	
-	for _, market := range foodData.Markets {
-		if !strings.EqualFold(market.Name, marketName) {
-			continue
-		}
-		
-		for _, category := range market.FoodCategories {
-			for _, commodity := range category.Foods {
-				if commodity.Name == current.Name && commodity.Date < current.Date {
-					if commodity.Date > previousDate {
-						previousDate = commodity.Date
-						previousPrice = commodity.Price
-					}
-				}
-			}
+func containsMarket(filters []string, marketName string) bool {
+	for _, f := range filters {
+		if strings.EqualFold(f, marketName) {
+			return true
 		}
 	}
-	
-	if previousPrice == 0 {
-		return "stable", 0
-	}
-	
-	percentChange := ((current.Price - previousPrice) / previousPrice) * 100
-	
-	if percentChange > 1 {
-		return "up", percentChange
-	} else if percentChange < -1 {
-		return "down", -percentChange
-	}
-	return "stable", 0
+	return false
 }
 
 func isDataStale(dateStr string) bool {
@@ -776,7 +1136,7 @@ func isDataStale(dateStr string) bool {
 	if len(parts) != 3 {
 		return true
 	}
-	
+
 	year, _ := strconv.Atoi(parts[0])
 	// Consider anything before 2026 as stale
 	return year < 2026
@@ -788,14 +1148,14 @@ func formatDate(dateStr string) string {
 	if len(parts) != 3 {
 		return dateStr
 	}
-	
-	months := []string{"Jan", "Feb", "Mar", "Apr", "May", "Jun", 
+
+	months := []string{"Jan", "Feb", "Mar", "Apr", "May", "Jun",
 		"Jul", "Aug", "Sep", "Oct", "Nov", "Dec"}
-	
+
 	month, _ := strconv.Atoi(parts[1])
 	if month < 1 || month > 12 {
 		return dateStr
 	}
-	
+
 	return fmt.Sprintf("%s %s %s", parts[2], months[month-1], parts[0])
-}
\ No newline at end of file
+}
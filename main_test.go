@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+var (
+	testStoreOnce sync.Once
+	testStoreData *DatasetStore
+	testStoreErr  error
+)
+
+// testStore returns the shipped dataset, loaded once for every test.
+func testStore(tb testing.TB) *DatasetStore {
+	tb.Helper()
+	testStoreOnce.Do(func() {
+		testStoreData, testStoreErr = NewDatasetStore(DataSources{
+			Prices:   "wfp_food_prices_ken.csv",
+			CPI:      "kenya.json",
+			Taxonomy: "commodity_taxonomy.json",
+			Baskets:  "baskets.json",
+		})
+	})
+	if testStoreErr != nil {
+		tb.Fatal(testStoreErr)
+	}
+	return testStoreData
+}
+
+// pricesHeader is the header row of a WFP price export.
+const pricesHeader = "date,admin1,admin2,market,market_id,latitude,longitude,category,commodity,commodity_id,unit,priceflag,pricetype,currency,price,usdprice\n"
+
+// csvTestStore loads a dataset of the given rows of a WFP price export.
+func csvTestStore(tb testing.TB, rows string) *DatasetStore {
+	tb.Helper()
+	path := filepath.Join(tb.TempDir(), "prices.csv")
+	if err := os.WriteFile(path, []byte(pricesHeader+rows), 0o644); err != nil {
+		tb.Fatal(err)
+	}
+	store, err := NewDatasetStore(DataSources{Prices: path, Taxonomy: "commodity_taxonomy.json"})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return store
+}
+
+// testServer is the router on the shipped dataset, with empty storage
+// and SMS codes recorded instead of sent.
+type testServer struct {
+	router *gin.Engine
+	repos  Repositories
+	auth   *Auth
+	sms    *recordingSMS
+}
+
+func newTestServer(tb testing.TB) *testServer {
+	tb.Helper()
+	return newTestServerOn(tb, testStore(tb))
+}
+
+// newTestServerOn is newTestServer on the dataset of store.
+func newTestServerOn(tb testing.TB, store *DatasetStore) *testServer {
+	tb.Helper()
+	storage, err := OpenStorage(tb.TempDir())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { storage.Close() })
+	srv := &testServer{
+		repos: storage.Repositories(),
+		sms:   &recordingSMS{last: make(map[string]string)},
+	}
+	srv.auth = NewAuth(srv.repos.Users, srv.repos.Sessions, srv.sms, []byte("test key"))
+	cfg := defaultConfig()
+	cfg.StaticRoot = ""
+	if srv.router, err = newRouter(cfg, store, srv.repos, srv.auth); err != nil {
+		tb.Fatal(err)
+	}
+	return srv
+}
+
+// getJSON serves a GET of target and decodes the body into v, returning
+// the status code.
+func getJSON(tb testing.TB, h http.Handler, target string, v any) int {
+	tb.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+	if v != nil && w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			tb.Fatalf("GET %s: %v", target, err)
+		}
+	}
+	return w.Code
+}
+
+// doJSON serves a request with body encoded as JSON, signed in with
+// token unless it is empty, and decodes a 2xx body into v, returning the
+// status code.
+func doJSON(tb testing.TB, h http.Handler, method, target, token string, body, v any) int {
+	tb.Helper()
+	var r io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		r = bytes.NewReader(raw)
+	}
+	req := httptest.NewRequest(method, target, r)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if v != nil && w.Code/100 == 2 {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			tb.Fatalf("%s %s: %v", method, target, err)
+		}
+	}
+	return w.Code
+}
+
+// signInUser signs phone in through the test server's auth and returns
+// its access token.
+func (srv *testServer) signInUser(tb testing.TB, phone string) string {
+	tb.Helper()
+	return signIn(tb, srv.auth, srv.sms, phone, time.Now()).AccessToken
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== WHOLESALE-RETAIL MARGINS ====================
+
+// MarginPoint is the retail markup over wholesale in one month.
+type MarginPoint struct {
+	Month         string  `json:"month"` // "YYYY-MM"
+	Label         string  `json:"label"`
+	Wholesale     float64 `json:"wholesale"`
+	Retail        float64 `json:"retail"`
+	Margin        float64 `json:"margin"`         // retail - wholesale
+	MarginPercent float64 `json:"margin_percent"` // of the wholesale price
+}
+
+// MarginStats summarizes the margins of a series.
+type MarginStats struct {
+	Months              int     `json:"months"` // months with both prices
+	MeanMargin          float64 `json:"mean_margin"`
+	MeanMarginPercent   float64 `json:"mean_margin_percent"`
+	MedianMarginPercent float64 `json:"median_margin_percent"`
+	LatestMonth         string  `json:"latest_month"`
+	LatestMargin        float64 `json:"latest_margin"`
+	LatestMarginPercent float64 `json:"latest_margin_percent"`
+}
+
+// marginPoints pairs the months in which a market has both a wholesale
+// and a retail price.
+func marginPoints(wholesale, retail MonthlySeries) []MarginPoint {
+	points := []MarginPoint{}
+	for _, p := range spreadPoints(wholesale, retail, nil) {
+		point := MarginPoint{
+			Month:     p.Month,
+			Label:     p.Label,
+			Wholesale: p.FromPrice,
+			Retail:    p.ToPrice,
+			Margin:    p.Gap,
+		}
+		if p.FromPrice > 0 {
+			point.MarginPercent = math.Round(p.Gap/p.FromPrice*1000) / 10
+		}
+		points = append(points, point)
+	}
+	return points
+}
+
+// marginStats summarizes points.
+func marginStats(points []MarginPoint) MarginStats {
+	stats := MarginStats{Months: len(points)}
+	if len(points) == 0 {
+		return stats
+	}
+	sum, sumPct := 0.0, 0.0
+	percents := make([]float64, 0, len(points))
+	for _, p := range points {
+		sum += p.Margin
+		sumPct += p.MarginPercent
+		percents = append(percents, p.MarginPercent)
+	}
+	n := float64(len(points))
+	stats.MeanMargin = sum / n
+	stats.MeanMarginPercent = math.Round(sumPct/n*10) / 10
+	stats.MedianMarginPercent = math.Round(median(percents)*10) / 10
+	last := points[len(points)-1]
+	stats.LatestMonth, stats.LatestMargin, stats.LatestMarginPercent = last.Month, last.Margin, last.MarginPercent
+	return stats
+}
+
+// ==================== MARGIN ENDPOINT ====================
+
+// MarginVariant is the WFP commodity one side of a margin is quoted for.
+type MarginVariant struct {
+	CommodityID int      `json:"commodity_id"`
+	Commodity   string   `json:"commodity"`
+	Units       []string `json:"units"` // as quoted, e.g. "90 KG"
+}
+
+// MarginSeries is the wholesale-retail margin of a commodity in a market.
+// The two sides may be different variants of the commodity, e.g.
+// wholesale "Maize (white)" by the 90 KG bag and retail "Maize" by the kg.
+type MarginSeries struct {
+	Market    MarketSummary `json:"market"`
+	Canonical string        `json:"canonical"`
+	Commodity string        `json:"commodity"` // canonical name
+	Unit      string        `json:"unit"`      // normalized unit
+	Wholesale MarginVariant `json:"wholesale"`
+	Retail    MarginVariant `json:"retail"`
+	Stats     MarginStats   `json:"stats"`
+	Points    []MarginPoint `json:"points"`
+}
+
+// CountyMarginPoint is the margin of a county in one month, between the
+// median wholesale and the median retail price of its markets. The two
+// need not come from the same markets.
+type CountyMarginPoint struct {
+	MarginPoint
+	WholesaleMarkets int `json:"wholesale_markets"`
+	RetailMarkets    int `json:"retail_markets"`
+}
+
+// CountyMargin is a commodity's margin across the markets of a county.
+type CountyMargin struct {
+	County    string              `json:"county"`
+	Region    string              `json:"region"`
+	Canonical string              `json:"canonical"`
+	Commodity string              `json:"commodity"` // canonical name
+	Unit      string              `json:"unit"`
+	Markets   int                 `json:"markets"` // markets with either price
+	Wholesale []MarginVariant     `json:"wholesale"`
+	Retail    []MarginVariant     `json:"retail"`
+	Stats     MarginStats         `json:"stats"`
+	Points    []CountyMarginPoint `json:"points"`
+}
+
+// MarginResponse is the body of /api/analysis/margin.
+type MarginResponse struct {
+	Currency string         `json:"currency"`
+	Real     bool           `json:"real"`
+	BaseYear int            `json:"base_year,omitempty"`
+	From     string         `json:"from,omitempty"`
+	To       string         `json:"to,omitempty"`
+	Series   []MarginSeries `json:"series"`
+	Counties []CountyMargin `json:"counties"`
+}
+
+// marginKey identifies prices whose wholesale and retail sides can be
+// paired: any variants of one canonical commodity, in one normalized unit.
+type marginKey struct {
+	Canonical string
+	Unit      string
+}
+
+// countyMarginKey identifies the prices pooled for a county.
+type countyMarginKey struct {
+	County string
+	Region string
+	marginKey
+}
+
+// countyPrices are the monthly prices of a county's markets, by month and
+// price type, and the variants they were quoted for.
+type countyPrices struct {
+	months   map[string]*[2][]float64 // indexed by PriceType
+	markets  map[int]bool
+	variants [2]map[int]MarginVariant // by PriceType, then commodity_id
+}
+
+// marginCanonical returns the canonical commodity of a WFP commodity_id.
+// Classify gives every commodity of the dataset one, so the fallback only
+// covers taxonomies built by hand.
+func marginCanonical(foodData *FoodData, commodityID int, wfpName string) *CanonicalCommodity {
+	if v, ok := foodData.Taxonomy.Variant(commodityID); ok {
+		return v.Canonical
+	}
+	return &CanonicalCommodity{ID: strconv.Itoa(commodityID), Name: wfpName}
+}
+
+// marginVariant describes the source of one side of a margin.
+func marginVariant(foodData *FoodData, key spreadKey, source spreadSource, lang string) MarginVariant {
+	return MarginVariant{
+		CommodityID: key.CommodityID,
+		Commodity:   foodData.Taxonomy.LocalName(key.CommodityID, source.Name, lang),
+		Units:       source.Units,
+	}
+}
+
+// pairMarginSources pairs each wholesale source of a market with a retail
+// source of the same canonical commodity and normalized unit: the same
+// variant if the market quotes it at retail, else the retail variant
+// sharing the most months with it.
+func pairMarginSources(foodData *FoodData, sources map[spreadKey]spreadSource) [][2]spreadKey {
+	retail := make(map[marginKey][]spreadKey)
+	for key, source := range sources {
+		if key.PriceType == Retail {
+			mk := marginKey{marginCanonical(foodData, key.CommodityID, source.Name).ID, key.Unit}
+			retail[mk] = append(retail[mk], key)
+		}
+	}
+	var pairs [][2]spreadKey
+	for key, wholesale := range sources {
+		if key.PriceType != WholeSale {
+			continue
+		}
+		mk := marginKey{marginCanonical(foodData, key.CommodityID, wholesale.Name).ID, key.Unit}
+		best, bestMonths := spreadKey{}, 0
+		for _, rk := range retail[mk] {
+			months := len(spreadPoints(wholesale.monthly, sources[rk].monthly, nil))
+			switch {
+			case months == 0:
+			case rk.CommodityID == key.CommodityID:
+				best, bestMonths = rk, math.MaxInt
+			case months > bestMonths || (months == bestMonths && rk.CommodityID < best.CommodityID):
+				best, bestMonths = rk, months
+			}
+		}
+		if bestMonths > 0 {
+			pairs = append(pairs, [2]spreadKey{key, best})
+		}
+	}
+	return pairs
+}
+
+// countyMarginPoints pairs the median wholesale and retail prices of
+// every month in which the county has both.
+func countyMarginPoints(prices countyPrices) []CountyMarginPoint {
+	list := make([]string, 0, len(prices.months))
+	for month := range prices.months {
+		list = append(list, month)
+	}
+	sort.Strings(list)
+	points := []CountyMarginPoint{}
+	for _, month := range list {
+		byType := prices.months[month]
+		wholesale, retail := byType[WholeSale], byType[Retail]
+		if len(wholesale) == 0 || len(retail) == 0 {
+			continue
+		}
+		point := CountyMarginPoint{
+			MarginPoint: MarginPoint{
+				Month:     month,
+				Label:     formatMonth(month + "-01"),
+				Wholesale: median(wholesale),
+				Retail:    median(retail),
+			},
+			WholesaleMarkets: len(wholesale),
+			RetailMarkets:    len(retail),
+		}
+		point.Margin = point.Retail - point.Wholesale
+		if point.Wholesale > 0 {
+			point.MarginPercent = math.Round(point.Margin/point.Wholesale*1000) / 10
+		}
+		points = append(points, point)
+	}
+	return points
+}
+
+// sortedVariants lists variants by commodity_id.
+func sortedVariants(variants map[int]MarginVariant) []MarginVariant {
+	list := make([]MarginVariant, 0, len(variants))
+	for _, v := range variants {
+		list = append(list, v)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CommodityID < list[j].CommodityID })
+	return list
+}
+
+// marginHandler serves /api/analysis/margin, which pairs the wholesale
+// and retail prices of each canonical commodity, market and month once
+// normalized, and compares the county medians of the two the same way.
+// commodity=, market= or market_id=, county=, region=, from= and to=
+// narrow the series; a canonical commodity includes its variants unless
+// variants=false. points=false leaves out the months.
+func marginHandler(store *DatasetStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		foodData := store.Load()
+		opts, err := parsePriceOptions(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		// The two sides are often quoted for different variants
+		expand := true
+		if c.Query("variants") != "" {
+			if expand, err = parseVariantsParam(c); err != nil {
+				c.JSON(400, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		from, to, err := spreadWindow(c, "")
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		withPoints := true
+		if v := c.Query("points"); v != "" {
+			if withPoints, err = strconv.ParseBool(v); err != nil {
+				c.JSON(400, gin.H{"error": fmt.Sprintf("invalid points %q", v)})
+				return
+			}
+		}
+		terms := splitTerms(c.Query("commodity"))
+		filter := foodData.Taxonomy.Filter(terms, expand)
+		if len(terms) > 0 && len(filter) == 0 {
+			c.JSON(404, gin.H{"error": "Commodity not found"})
+			return
+		}
+		only := -1
+		q := seriesQuery{Market: c.Query("market")}
+		if v := c.Query("market_id"); v != "" {
+			if q.MarketID, err = strconv.Atoi(v); err != nil {
+				c.JSON(400, gin.H{"error": fmt.Sprintf("invalid market_id %q", v)})
+				return
+			}
+		}
+		if q.Market != "" || q.MarketID != 0 {
+			market, ok := historyMarket(foodData, q)
+			if !ok {
+				c.JSON(404, gin.H{"error": "Market not found"})
+				return
+			}
+			only = market.ID
+		}
+		county, region := c.Query("county"), c.Query("region")
+
+		response := MarginResponse{
+			Currency: opts.Currency.String(),
+			Real:     opts.Real,
+			From:     from,
+			To:       to,
+			Series:   []MarginSeries{},
+			Counties: []CountyMargin{},
+		}
+		if opts.Real {
+			response.BaseYear = opts.baseYear(foodData)
+		}
+		pooled := make(map[countyMarginKey]countyPrices)
+		canonicals := make(map[string]*CanonicalCommodity)
+		for _, market := range foodData.Markets {
+			if (only >= 0 && market.ID != only) ||
+				(county != "" && !strings.EqualFold(market.Admin2, county)) ||
+				(region != "" && !strings.EqualFold(market.Admin1, region)) {
+				continue
+			}
+			sources, err := spreadSources(foodData, opts, market.ID, filter, nil, from, to)
+			if err != nil {
+				c.JSON(422, gin.H{"error": err.Error()})
+				return
+			}
+			for key, source := range sources {
+				canonical := marginCanonical(foodData, key.CommodityID, source.Name)
+				canonicals[canonical.ID] = canonical
+				ck := countyMarginKey{County: market.Admin2, Region: market.Admin1, marginKey: marginKey{canonical.ID, key.Unit}}
+				prices, ok := pooled[ck]
+				if !ok {
+					prices = countyPrices{
+						months:   make(map[string]*[2][]float64),
+						markets:  make(map[int]bool),
+						variants: [2]map[int]MarginVariant{make(map[int]MarginVariant), make(map[int]MarginVariant)},
+					}
+					pooled[ck] = prices
+				}
+				prices.markets[market.ID] = true
+				prices.variants[key.PriceType][key.CommodityID] = marginVariant(foodData, key, source, opts.Lang)
+				for i, v := range source.monthly.Values {
+					if math.IsNaN(v) {
+						continue
+					}
+					month := source.monthly.Month(i)
+					if prices.months[month] == nil {
+						prices.months[month] = &[2][]float64{}
+					}
+					prices.months[month][key.PriceType] = append(prices.months[month][key.PriceType], v)
+				}
+			}
+
+			for _, pair := range pairMarginSources(foodData, sources) {
+				wholesale, retail := sources[pair[0]], sources[pair[1]]
+				points := marginPoints(wholesale.monthly, retail.monthly)
+				canonical := marginCanonical(foodData, pair[0].CommodityID, wholesale.Name)
+				series := MarginSeries{
+					Market:    market.Summary(),
+					Canonical: canonical.ID,
+					Commodity: canonical.localName(opts.Lang),
+					Unit:      pair[0].Unit,
+					Wholesale: marginVariant(foodData, pair[0], wholesale, opts.Lang),
+					Retail:    marginVariant(foodData, pair[1], retail, opts.Lang),
+					Stats:     marginStats(points),
+					Points:    points,
+				}
+				if !withPoints {
+					series.Points = []MarginPoint{}
+				}
+				response.Series = append(response.Series, series)
+			}
+		}
+
+		for ck, prices := range pooled {
+			points := countyMarginPoints(prices)
+			if len(points) == 0 {
+				continue
+			}
+			margins := make([]MarginPoint, len(points))
+			for i, p := range points {
+				margins[i] = p.MarginPoint
+			}
+			cm := CountyMargin{
+				County:    ck.County,
+				Region:    ck.Region,
+				Canonical: ck.Canonical,
+				Commodity: canonicals[ck.Canonical].localName(opts.Lang),
+				Unit:      ck.Unit,
+				Markets:   len(prices.markets),
+				Wholesale: sortedVariants(prices.variants[WholeSale]),
+				Retail:    sortedVariants(prices.variants[Retail]),
+				Stats:     marginStats(margins),
+				Points:    points,
+			}
+			if !withPoints {
+				cm.Points = []CountyMarginPoint{}
+			}
+			response.Counties = append(response.Counties, cm)
+		}
+
+		sort.Slice(response.Series, func(i, j int) bool {
+			a, b := response.Series[i], response.Series[j]
+			switch {
+			case a.Market.Name != b.Market.Name:
+				return a.Market.Name < b.Market.Name
+			case a.Commodity != b.Commodity:
+				return a.Commodity < b.Commodity
+			case a.Unit != b.Unit:
+				return a.Unit < b.Unit
+			}
+			return a.Wholesale.CommodityID < b.Wholesale.CommodityID
+		})
+		sort.Slice(response.Counties, func(i, j int) bool {
+			a, b := response.Counties[i], response.Counties[j]
+			switch {
+			case a.County != b.County:
+				return a.County < b.County
+			case a.Commodity != b.Commodity:
+				return a.Commodity < b.Commodity
+			}
+			return a.Unit < b.Unit
+		})
+		c.JSON(200, response)
+	}
+}
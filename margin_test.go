@@ -0,0 +1,85 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMarginPairsVariants(t *testing.T) {
+	router := gin.New()
+	router.GET("/api/analysis/margin", marginHandler(testStore(t)))
+
+	var all MarginResponse
+	if code := getJSON(t, router, "/api/analysis/margin?points=false", &all); code != 200 {
+		t.Fatalf("status %d", code)
+	}
+	if len(all.Series) < 10 {
+		t.Errorf("%d market series, want wholesale and retail paired across variants", len(all.Series))
+	}
+
+	tests := []struct {
+		query     string
+		canonical string
+		minSeries int
+		sameID    bool // both sides the same WFP commodity
+	}{
+		// Wholesale is "Maize (white, dry)" by the 90 KG bag, retail "Maize" by the kg
+		{"commodity=maize", "maize", 1, false},
+		{"commodity=maize&variants=true", "maize", 1, false},
+		{"commodity=beans", "beans", 1, false},
+		{"commodity=maize&variants=false", "maize", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			var resp MarginResponse
+			if code := getJSON(t, router, "/api/analysis/margin?"+tt.query, &resp); code != 200 {
+				t.Fatalf("status %d", code)
+			}
+			if len(resp.Series) < tt.minSeries {
+				t.Fatalf("%d series, want at least %d", len(resp.Series), tt.minSeries)
+			}
+			for _, s := range resp.Series {
+				if s.Canonical != tt.canonical {
+					t.Errorf("%s: canonical %q, want %q", s.Market.Name, s.Canonical, tt.canonical)
+				}
+				if s.Unit != "kg" {
+					t.Errorf("%s: unit %q, want prices normalized to kg", s.Market.Name, s.Unit)
+				}
+				if tt.sameID && s.Wholesale.CommodityID != s.Retail.CommodityID {
+					t.Errorf("%s: paired %s with %s", s.Market.Name, s.Wholesale.Commodity, s.Retail.Commodity)
+				}
+				if s.Wholesale.Commodity == "" || len(s.Wholesale.Units) == 0 || len(s.Retail.Units) == 0 {
+					t.Errorf("%s: sides not described: %+v / %+v", s.Market.Name, s.Wholesale, s.Retail)
+				}
+				if s.Stats.Months != len(s.Points) {
+					t.Errorf("%s: %d months, %d points", s.Market.Name, s.Stats.Months, len(s.Points))
+				}
+			}
+		})
+	}
+}
+
+func TestMarginPoints(t *testing.T) {
+	wholesale := MonthlySeries{Start: "2024-01", Values: []float64{40, 50, math.NaN(), 60}}
+	retail := MonthlySeries{Start: "2024-02", Values: []float64{60, 70, 66}}
+	points := marginPoints(wholesale, retail)
+	want := []MarginPoint{
+		{Month: "2024-02", Wholesale: 50, Retail: 60, Margin: 10, MarginPercent: 20},
+		{Month: "2024-04", Wholesale: 60, Retail: 66, Margin: 6, MarginPercent: 10},
+	}
+	if len(points) != len(want) {
+		t.Fatalf("got %+v, want %+v", points, want)
+	}
+	for i, p := range points {
+		p.Label = ""
+		if p != want[i] {
+			t.Errorf("point %d: got %+v, want %+v", i, p, want[i])
+		}
+	}
+	stats := marginStats(points)
+	if stats.Months != 2 || stats.MeanMargin != 8 || stats.MeanMarginPercent != 15 || stats.LatestMonth != "2024-04" {
+		t.Errorf("stats %+v", stats)
+	}
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// ==================== LANGUAGES ====================
+
+// languages are the codes accepted by lang= and in taxonomy names, in
+// the order names are searched.
+var languages = []string{
+	"en",  // English
+	"sw",  // Swahili
+	"ki",  // Kikuyu
+	"luo", // Dholuo
+	"kln", // Kalenjin
+}
+
+// parseLangParam reads the optional lang= of any endpoint returning
+// commodity names. "" and "en" keep the WFP names.
+func parseLangParam(c *gin.Context) (string, error) {
+	lang := strings.ToLower(c.Query("lang"))
+	if lang == "" {
+		return "en", nil
+	}
+	if !slices.Contains(languages, lang) {
+		return "", fmt.Errorf("unsupported lang %q (use en, sw, ki, luo or kln)", lang)
+	}
+	return lang, nil
+}
+
+// LocalName returns the name of a WFP commodity in lang: the variant's own
+// name if it has one, else the commodity's name with the WFP variant
+// appended. English, and languages without a name, keep the WFP name.
+func (t *Taxonomy) LocalName(commodityID int, wfpName, lang string) string {
+	v, ok := t.Variant(commodityID)
+	if !ok || lang == "en" {
+		return wfpName
+	}
+	if names := v.Names[lang]; len(names) > 0 {
+		return names[0]
+	}
+	names := v.Canonical.Names[lang]
+	if len(names) == 0 {
+		return wfpName
+	}
+	if v.Generic() {
+		return names[0]
+	}
+	return fmt.Sprintf("%s (%s)", names[0], v.Variant)
+}
+
+// IsName reports whether name, ignoring case, is what LocalName calls a WFP
+// commodity in some language.
+func (t *Taxonomy) IsName(commodityID int, wfpName, name string) bool {
+	for _, lang := range languages {
+		if strings.EqualFold(t.LocalName(commodityID, wfpName, lang), strings.TrimSpace(name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// localizeSeries names a series description in lang.
+func (t *Taxonomy) localizeSeries(info SeriesInfo, lang string) SeriesInfo {
+	info.Commodity = t.LocalName(info.CommodityID, info.Commodity, lang)
+	return info
+}
+
+// localTerm names a commodity query term in lang: by the canonical
+// commodity or WFP variant it selects, or as given for English and terms
+// the taxonomy doesn't know.
+func (t *Taxonomy) localTerm(term, lang string) string {
+	if lang == "en" {
+		return term
+	}
+	if c, ok := t.Canonical(term); ok {
+		return c.localName(lang)
+	}
+	if v, ok := t.variantByName[strings.ToLower(strings.TrimSpace(term))]; ok {
+		return t.LocalName(v.CommodityID, v.WFPName, lang)
+	}
+	return term
+}
+
+// localizeMarket returns a copy of m with its foods named in lang, or m
+// itself for English.
+func (t *Taxonomy) localizeMarket(m MarketData, lang string) MarketData {
+	if lang == "en" {
+		return m
+	}
+	categories := make([]FoodCategory, len(m.FoodCategories))
+	for i, cat := range m.FoodCategories {
+		foods := make([]Commodity, len(cat.Foods))
+		for j, food := range cat.Foods {
+			food.Name = t.LocalName(food.CommodityID, food.Name, lang)
+			foods[j] = food
+		}
+		categories[i] = FoodCategory{Name: cat.Name, Foods: foods}
+	}
+	m.FoodCategories = categories
+	return m
+}
+
+// localizeMarkets names the foods of markets in lang.
+func (t *Taxonomy) localizeMarkets(markets []MarketData, lang string) []MarketData {
+	if lang == "en" || len(markets) == 0 {
+		return markets
+	}
+	local := make([]MarketData, len(markets))
+	for i, m := range markets {
+		local[i] = t.localizeMarket(m, lang)
+	}
+	return local
+}
+
+// localName returns the commodity's display name in lang.
+func (c *CanonicalCommodity) localName(lang string) string {
+	if names := c.Names[lang]; len(names) > 0 {
+		return names[0]
+	}
+	return c.Name
+}
+
+// localized returns a copy of c named in lang, or c itself for English.
+func (c *CanonicalCommodity) localized(lang string) *CanonicalCommodity {
+	if lang == "en" {
+		return c
+	}
+	local := *c
+	local.Name = c.localName(lang)
+	return &local
+}
+
+// ==================== SEARCH ====================
+
+// foldName lower-cases s, strips accents and punctuation and collapses
+// spaces, so "Njũgũ" and "njugu" or "Ring'o" and "ringo" compare equal.
+func foldName(s string) string {
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	folded, _, err := transform.String(t, s)
+	if err != nil {
+		folded = s
+	}
+
+	var b strings.Builder
+	space := false
+	for _, r := range strings.ToLower(folded) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if space && b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			b.WriteRune(r)
+			space = false
+		case r == '\'' || r == '’':
+			// ng'ombe -> ngombe
+		default:
+			space = true
+		}
+	}
+	return b.String()
+}
+
+// levenshtein returns the edit distance between a and b in runes.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+// maxTypos is how many edits a query of n runes may be off by.
+func maxTypos(n int) int {
+	switch {
+	case n <= 3:
+		return 0
+	case n <= 6:
+		return 1
+	}
+	return 2
+}
+
+// round2 rounds a score to two decimals.
+func round2(x float64) float64 {
+	return math.Round(x*100) / 100
+}
+
+// nameMatch scores one alias against a folded query.
+type nameMatch struct {
+	Score    float64 // 1 for an exact match, lower for weaker ones
+	Type     string  // exact, prefix, word, contains or fuzzy
+	Distance int     // edits of a fuzzy match
+}
+
+func matchName(query, alias string) (nameMatch, bool) {
+	switch {
+	case alias == query:
+		return nameMatch{Score: 1, Type: "exact"}, true
+	case len(query) >= 2 && strings.HasPrefix(alias, query):
+		return nameMatch{Score: 0.9, Type: "prefix"}, true
+	}
+	words := strings.Fields(alias)
+	for _, w := range words {
+		if len(query) >= 2 && strings.HasPrefix(w, query) {
+			return nameMatch{Score: 0.85, Type: "word"}, true
+		}
+	}
+	if len(query) >= 3 && strings.Contains(alias, query) {
+		return nameMatch{Score: 0.75, Type: "contains"}, true
+	}
+
+	// Typos: against the whole alias, then against each of its words
+	limit := maxTypos(len([]rune(query)))
+	if limit == 0 {
+		return nameMatch{}, false
+	}
+	if d := levenshtein(query, alias); d <= limit {
+		return nameMatch{Score: round2(0.7 - 0.1*float64(d)), Type: "fuzzy", Distance: d}, true
+	}
+	best := limit + 1
+	for _, w := range words {
+		best = min(best, levenshtein(query, w))
+	}
+	if best <= limit && len(words) > 1 {
+		return nameMatch{Score: round2(0.6 - 0.1*float64(best)), Type: "fuzzy", Distance: best}, true
+	}
+	return nameMatch{}, false
+}
+
+// CommodityMatch is one result of a commodity search.
+type CommodityMatch struct {
+	ID           string  `json:"id"`
+	Name         string  `json:"name"`
+	Category     string  `json:"category"`
+	Form         string  `json:"form"`
+	CommodityIDs []int   `json:"commodity_ids"` // WFP ids of every variant
+	Score        float64 `json:"score"`
+	MatchType    string  `json:"match_type"`
+	MatchedAlias string  `json:"matched_alias"`
+	MatchedLang  string  `json:"matched_lang,omitempty"` // "" when the WFP name matched
+	Distance     int     `json:"distance,omitempty"`
+	Variant      string  `json:"variant,omitempty"` // set when a variant's name matched
+}
+
+// searchAlias is one searchable name of a commodity.
+type searchAlias struct {
+	Text    string // as written in the taxonomy
+	Folded  string
+	Lang    string
+	Variant *CommodityVariant // nil for names of the commodity itself
+}
+
+// aliases lists every name a commodity can be found by.
+func (c *CanonicalCommodity) aliases() []searchAlias {
+	list := []searchAlias{
+		{Text: c.Name, Folded: foldName(c.Name)},
+		{Text: c.ID, Folded: foldName(c.ID)},
+	}
+	for _, lang := range languages {
+		for _, name := range c.Names[lang] {
+			list = append(list, searchAlias{Text: name, Folded: foldName(name), Lang: lang})
+		}
+	}
+	for _, v := range c.Variants {
+		list = append(list, searchAlias{Text: v.WFPName, Folded: foldName(v.WFPName), Variant: v})
+		for _, lang := range languages {
+			for _, name := range v.Names[lang] {
+				list = append(list, searchAlias{Text: name, Folded: foldName(name), Lang: lang, Variant: v})
+			}
+		}
+	}
+	return list
+}
+
+// Search ranks the commodities whose names in any language resemble query.
+// Names are shown in lang.
+func (t *Taxonomy) Search(query, lang string) []CommodityMatch {
+	q := foldName(query)
+	if q == "" {
+		return nil
+	}
+
+	var matches []CommodityMatch
+	observations := make(map[string]int)
+	for _, c := range t.Commodities {
+		var best *CommodityMatch
+		for _, alias := range c.aliases() {
+			m, ok := matchName(q, alias.Folded)
+			if !ok {
+				continue
+			}
+			// Keep the first alias on ties, except that the commodity's own
+			// names win over a variant's
+			if best != nil && (m.Score < best.Score || m.Score == best.Score && (alias.Variant != nil || best.Variant == "")) {
+				continue
+			}
+			best = &CommodityMatch{
+				Score:        m.Score,
+				MatchType:    m.Type,
+				MatchedAlias: alias.Text,
+				MatchedLang:  alias.Lang,
+				Distance:     m.Distance,
+			}
+			if alias.Variant != nil {
+				best.Variant = alias.Variant.WFPName
+			}
+		}
+		if best == nil {
+			continue
+		}
+
+		best.ID = c.ID
+		best.Name = c.localName(lang)
+		best.Category = c.Category
+		best.Form = c.Form
+		for _, v := range c.Variants {
+			best.CommodityIDs = append(best.CommodityIDs, v.commodityIDs()...)
+			observations[c.ID] += v.Observations
+		}
+		matches = append(matches, *best)
+	}
+
+	// Best score first, then the commodity with more data
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return observations[matches[i].ID] > observations[matches[j].ID]
+	})
+	return matches
+}
+
+// MarketMatch is one result of a market search.
+type MarketMatch struct {
+	MarketSummary
+	Score        float64 `json:"score"`
+	MatchType    string  `json:"match_type"`
+	MatchedAlias string  `json:"matched_alias"` // market, county or sub-county name
+	Distance     int     `json:"distance,omitempty"`
+}
+
+// searchMarkets ranks the markets whose name, county or sub-county
+// resembles query. Place names aren't translated, so this only folds case,
+// accents and typos.
+func searchMarkets(markets []MarketData, query string) []MarketMatch {
+	q := foldName(query)
+	if q == "" {
+		return nil
+	}
+
+	var matches []MarketMatch
+	for _, market := range markets {
+		var best *MarketMatch
+		// A county match ranks below a market-name match of the same kind
+		for i, name := range []string{market.Name, market.Admin2, market.Admin1} {
+			m, ok := matchName(q, foldName(name))
+			if !ok {
+				continue
+			}
+			score := m.Score
+			if i > 0 {
+				score = round2(score - 0.05)
+			}
+			if best == nil || score > best.Score {
+				best = &MarketMatch{
+					MarketSummary: market.Summary(),
+					Score:         score,
+					MatchType:     m.Type,
+					MatchedAlias:  name,
+					Distance:      m.Distance,
+				}
+			}
+		}
+		if best != nil {
+			matches = append(matches, *best)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].Name < matches[j].Name
+	})
+	return matches
+}
+
+// searchHandler serves /api/search?q=&type=commodity|market&lang=&limit=.
+func searchHandler(store *DatasetStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		foodData := store.Load()
+		lang, err := parseLangParam(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		query := c.Query("q")
+		if strings.TrimSpace(query) == "" {
+			c.JSON(400, gin.H{"error": "q is required"})
+			return
+		}
+		kind := strings.ToLower(c.Query("type"))
+		if kind != "" && kind != "commodity" && kind != "market" {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("unsupported type %q (use commodity or market)", kind)})
+			return
+		}
+		limit := 10
+		if v := c.Query("limit"); v != "" {
+			if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+				c.JSON(400, gin.H{"error": fmt.Sprintf("invalid limit %q", v)})
+				return
+			}
+		}
+
+		response := gin.H{"query": query, "lang": lang}
+		if kind != "market" {
+			commodities := foodData.Taxonomy.Search(query, lang)
+			response["commodities"] = append([]CommodityMatch{}, commodities[:min(limit, len(commodities))]...)
+		}
+		if kind != "commodity" {
+			markets := searchMarkets(foodData.Markets, query)
+			response["markets"] = append([]MarketMatch{}, markets[:min(limit, len(markets))]...)
+		}
+		c.JSON(200, response)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"testing"
+)
+
+// marketFoods is the part of a MarketData response naming its foods.
+type marketFoods struct {
+	FoodCategories []struct {
+		Foods []struct {
+			Name        string `json:"name"`
+			CommodityID int    `json:"commodity_id"`
+		} `json:"foods"`
+	} `json:"food_categories"`
+}
+
+// foodNames returns the names markets give the foods of commodityID.
+func foodNames(markets []marketFoods, commodityID int) map[string]bool {
+	names := make(map[string]bool)
+	for _, m := range markets {
+		for _, cat := range m.FoodCategories {
+			for _, food := range cat.Foods {
+				if food.CommodityID == commodityID {
+					names[food.Name] = true
+				}
+			}
+		}
+	}
+	return names
+}
+
+func TestMarketsLang(t *testing.T) {
+	srv := newTestServer(t)
+	const maize = 51 // "Maize", "Mahindi" in Swahili
+
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"/api/markets", "Maize"},
+		{"/api/markets?lang=en", "Maize"},
+		{"/api/markets?lang=sw", "Mahindi"},
+		{"/api/markets?lang=SW", "Mahindi"},
+		{"/api/markets/region/coast?lang=sw", "Mahindi"},
+		{"/api/markets/county/mombasa?lang=sw", "Mahindi"},
+	}
+	for _, tt := range tests {
+		var markets []marketFoods
+		if code := getJSON(t, srv.router, tt.target, &markets); code != 200 {
+			t.Fatalf("%s: status %d", tt.target, code)
+		}
+		names := foodNames(markets, maize)
+		if len(names) != 1 || !names[tt.want] {
+			t.Errorf("%s: maize named %v, want %s", tt.target, names, tt.want)
+		}
+	}
+
+	for _, target := range []string{"/api/markets/id/191?lang=sw", "/api/market/mombasa?lang=sw"} {
+		var market marketFoods
+		if code := getJSON(t, srv.router, target, &market); code != 200 {
+			t.Fatalf("%s: status %d", target, code)
+		}
+		if names := foodNames([]marketFoods{market}, maize); len(names) != 1 || !names["Mahindi"] {
+			t.Errorf("%s: maize named %v, want Mahindi", target, names)
+		}
+	}
+
+	// The shared snapshot keeps its English names
+	for _, m := range testStore(t).Load().Markets {
+		for _, cat := range m.FoodCategories {
+			for _, food := range cat.Foods {
+				if food.CommodityID == maize && food.Name != "Maize" {
+					t.Fatalf("snapshot renamed maize to %s", food.Name)
+				}
+			}
+		}
+	}
+	if code := getJSON(t, srv.router, "/api/markets?lang=fr", nil); code != 400 {
+		t.Errorf("lang=fr: status %d, want 400", code)
+	}
+}
+
+func TestSeasonalityLang(t *testing.T) {
+	srv := newTestServer(t)
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"commodity=maize", "maize"},
+		{"commodity=maize&lang=sw", "Mahindi"},
+		{"commodity=mahindi&lang=sw", "Mahindi"},
+		{"commodity=Maize+(white)&lang=sw", "Mahindi (white)"},
+	}
+	for _, tt := range tests {
+		var resp SeasonalityResponse
+		if code := getJSON(t, srv.router, "/api/prices/seasonality?"+tt.query, &resp); code != 200 {
+			t.Fatalf("%s: status %d", tt.query, code)
+		}
+		if resp.Commodity != tt.want {
+			t.Errorf("%s: commodity %q, want %q", tt.query, resp.Commodity, tt.want)
+		}
+	}
+}
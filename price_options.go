@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== PRICE OPTIONS ====================
+
+// priceOptions holds the query parameters shared by every price endpoint
+// that change how prices are presented.
+type priceOptions struct {
+	Currency Currency // currency=KES|USD
+	Real     bool     // real=true deflates prices with the CPI
+	BaseYear int      // base=YYYY for real prices, 0 for the latest published CPI year
+	Lang     string   // lang= for commodity names, see parseLangParam
+}
+
+// parsePriceOptions reads the presentation parameters from the query.
+func parsePriceOptions(c *gin.Context) (priceOptions, error) {
+	var opts priceOptions
+	var err error
+	opts.Currency, err = parseCurrencyParam(c.Query("currency"))
+	if err != nil {
+		return opts, err
+	}
+
+	if v := c.Query("real"); v != "" {
+		if opts.Real, err = strconv.ParseBool(v); err != nil {
+			return opts, fmt.Errorf("invalid real %q (use true or false)", v)
+		}
+	}
+	if v := c.Query("base"); v != "" {
+		if opts.BaseYear, err = strconv.Atoi(v); err != nil || len(v) != 4 {
+			return opts, fmt.Errorf("invalid base year %q", v)
+		}
+	}
+	opts.Lang, err = parseLangParam(c)
+	return opts, err
+}
+
+// convert expresses amount, observed in from on date, as the options ask.
+// Real prices are deflated in KES first and then, if needed, converted at
+// the base year's exchange rate. The flag reports an extrapolated CPI.
+func (o priceOptions) convert(foodData *FoodData, amount float64, from Currency, date string) (float64, bool, error) {
+	if !o.Real {
+		price, err := foodData.FXRates.Convert(amount, from, o.Currency, date)
+		return price, false, err
+	}
+	if foodData.CPI == nil {
+		return 0, false, fmt.Errorf("real prices are unavailable: no CPI data loaded")
+	}
+
+	base := o.baseYear(foodData)
+	kes, err := foodData.FXRates.Convert(amount, from, KES, date)
+	if err != nil {
+		return 0, false, err
+	}
+	deflated, estimated, err := foodData.CPI.Deflate(kes, date, base)
+	if err != nil {
+		return 0, false, err
+	}
+	price, err := foodData.FXRates.Convert(deflated, KES, o.Currency, fmt.Sprintf("%d-07-01", base))
+	return price, estimated, err
+}
+
+// baseYear resolves the base year of real prices.
+func (o priceOptions) baseYear(foodData *FoodData) int {
+	if o.BaseYear != 0 || foodData.CPI == nil {
+		return o.BaseYear
+	}
+	return foodData.CPI.LastObserved
+}
+
+// commodities returns converted and localized copies of list; the dataset
+// is never modified.
+func (o priceOptions) commodities(foodData *FoodData, list []Commodity) ([]Commodity, error) {
+	out := make([]Commodity, len(list))
+	for i, comm := range list {
+		price, estimated, err := o.convert(foodData, comm.Price, comm.Currency, comm.Date)
+		if err != nil {
+			return nil, err
+		}
+		normalized, _, err := o.convert(foodData, comm.NormalizedPrice, comm.Currency, comm.Date)
+		if err != nil {
+			return nil, err
+		}
+		comm.Name = foodData.Taxonomy.LocalName(comm.CommodityID, comm.Name, o.Lang)
+		comm.Price = price
+		comm.NormalizedPrice = normalized
+		comm.Currency = o.Currency
+		comm.CPIEstimated = estimated
+		out[i] = comm
+	}
+	return out, nil
+}
@@ -0,0 +1,554 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ==================== REPOSITORIES ====================
+
+// SyncRepository stores the records the PWA syncs (crops, pests, diary
+// entries and calendar events, one collection per entity) and the
+// results of the idempotency keys it has seen.
+type SyncRepository interface {
+	// Record returns the server copy of a record, tombstones included.
+	Record(userID, entity, id string) (*SyncRecord, bool, error)
+	// KeyResult returns the result first given for an idempotency key.
+	KeyResult(userID, key string) (*appliedKey, bool, error)
+	// Changes returns the records of userID with a Seq after cursor,
+	// oldest first, the cursor to continue from and whether more remain.
+	Changes(userID string, cursor int64, limit int) ([]*SyncRecord, int64, bool, error)
+	// Commit stores records and keys in one atomic write, numbering the
+	// records with the next change sequence numbers in order.
+	Commit(records []*SyncRecord, keys []*appliedKey) error
+	// PruneKeys forgets the idempotency keys first seen before t.
+	PruneKeys(t time.Time) error
+}
+
+// SyncCursorRepository remembers the last cursor sent to each device, so
+// a client that lost its cursor can resume.
+type SyncCursorRepository interface {
+	Cursor(userID, deviceID string) (int64, bool, error)
+	SetCursor(userID, deviceID string, cursor int64) error
+}
+
+// FarmerProfile is a farmer's contact details, as in the PWA's
+// FarmerContactInfo.
+type FarmerProfile struct {
+	UserID       string    `json:"userId"`
+	Name         string    `json:"name"`
+	Phone        string    `json:"phone"`
+	Email        string    `json:"email"`
+	Location     string    `json:"location"`
+	FarmName     string    `json:"farmName,omitempty"`
+	YearsFarming int       `json:"yearsFarming,omitempty"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+// ProfileRepository stores farmer profiles by user.
+type ProfileRepository interface {
+	Profile(userID string) (*FarmerProfile, bool, error)
+	PutProfile(p *FarmerProfile) error
+}
+
+// ListingRepository stores marketplace listings.
+type ListingRepository interface {
+	Listing(id int64) (*Listing, bool, error)
+	// Listings returns every listing, expired ones included.
+	Listings() ([]*Listing, error)
+	// CreateListing stores a new listing under the next free ID, which it
+	// sets on l.
+	CreateListing(l *Listing) error
+	PutListing(l *Listing) error
+	// SetPriceCheck replaces the price check of a listing, unless the
+	// listing was deleted or updated since updatedAt.
+	SetPriceCheck(id int64, updatedAt time.Time, check *ListingPriceCheck) error
+	DeleteListing(id int64) error
+	// PurgeListings deletes the listings that expired before t.
+	PurgeListings(t time.Time) error
+}
+
+// User is a person signed in with their phone number.
+type User struct {
+	ID        string    `json:"id"`
+	Phone     string    `json:"phone"` // E.164, e.g. "+254712345678"
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// UserRepository stores users by phone number.
+type UserRepository interface {
+	UserByPhone(phone string) (*User, bool, error)
+	PutUser(u *User) error
+}
+
+// Session is a sign-in of a user on one device, kept alive by refreshing.
+type Session struct {
+	ID          string    `json:"id"`
+	UserID      string    `json:"user_id"`
+	Phone       string    `json:"phone"`
+	RefreshHash string    `json:"refresh_hash"`        // SHA-256 of the current refresh token's secret
+	PrevHash    string    `json:"prev_hash,omitempty"` // of the refresh token it replaced, to detect reuse
+	CreatedAt   time.Time `json:"created_at"`
+	RefreshedAt time.Time `json:"refreshed_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// SessionRepository stores sessions by ID.
+type SessionRepository interface {
+	Session(id string) (*Session, bool, error)
+	PutSession(s *Session) error
+	// UpdateSession calls fn with the session with id, nil if there is
+	// none, and stores the session fn returns, deleting it for nil, all
+	// in one transaction. Nothing is written if fn fails.
+	UpdateSession(id string, fn func(s *Session) (*Session, error)) error
+	DeleteSession(id string) error
+	// PurgeSessions deletes the sessions that expired before t.
+	PurgeSessions(t time.Time) error
+}
+
+// Repositories are the repositories of a store.
+type Repositories struct {
+	Sync     SyncRepository
+	Cursors  SyncCursorRepository
+	Profiles ProfileRepository
+	Listings ListingRepository
+	Users    UserRepository
+	Sessions SessionRepository
+}
+
+// ==================== STORAGE REPOSITORIES ====================
+
+// Collections of the embedded storage.
+const (
+	collMeta        = "meta"
+	collSyncKeys    = "sync_keys"
+	collSyncCursors = "sync_cursors"
+	collProfiles    = "profiles"
+	collListings    = "listings"
+	collUsers       = "users"
+	collSessions    = "sessions"
+)
+
+// Meta keys.
+const (
+	metaSyncSeq   = "sync_seq"   // last sync change sequence number
+	metaListingID = "listing_id" // last listing ID
+	metaAuthKey   = "auth_key"   // key signing session tokens, unless configured
+)
+
+// syncCollection is the collection of an entity's synced records.
+func syncCollection(entity string) string {
+	return "sync_" + entity
+}
+
+// storageKey joins the parts of a composite key. The separator can't
+// appear in user IDs, entity names or record IDs.
+func storageKey(parts ...string) string {
+	return strings.Join(parts, "\x1f")
+}
+
+// storageSyncRepository is the SyncRepository of the embedded storage.
+type storageSyncRepository struct {
+	s *Storage
+}
+
+// storageCursorRepository is the SyncCursorRepository of the embedded storage.
+type storageCursorRepository struct {
+	s *Storage
+}
+
+// storageProfileRepository is the ProfileRepository of the embedded storage.
+type storageProfileRepository struct {
+	s *Storage
+}
+
+// storageListingRepository is the ListingRepository of the embedded storage.
+type storageListingRepository struct {
+	s *Storage
+}
+
+// storageUserRepository is the UserRepository of the embedded storage.
+type storageUserRepository struct {
+	s *Storage
+}
+
+// storageSessionRepository is the SessionRepository of the embedded storage.
+type storageSessionRepository struct {
+	s *Storage
+}
+
+// Repositories returns the repositories backed by s.
+func (s *Storage) Repositories() Repositories {
+	return Repositories{
+		Sync:     storageSyncRepository{s},
+		Cursors:  storageCursorRepository{s},
+		Profiles: storageProfileRepository{s},
+		Listings: storageListingRepository{s},
+		Users:    storageUserRepository{s},
+		Sessions: storageSessionRepository{s},
+	}
+}
+
+func (r storageSyncRepository) Record(userID, entity, id string) (*SyncRecord, bool, error) {
+	var record SyncRecord
+	var ok bool
+	err := r.s.View(func(tx *Tx) error {
+		var err error
+		ok, err = tx.Get(syncCollection(entity), storageKey(userID, id), &record)
+		return err
+	})
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	return &record, true, nil
+}
+
+func (r storageSyncRepository) KeyResult(userID, key string) (*appliedKey, bool, error) {
+	var k appliedKey
+	var ok bool
+	err := r.s.View(func(tx *Tx) error {
+		var err error
+		ok, err = tx.Get(collSyncKeys, storageKey(userID, key), &k)
+		return err
+	})
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	return &k, true, nil
+}
+
+func (r storageSyncRepository) Changes(userID string, cursor int64, limit int) ([]*SyncRecord, int64, bool, error) {
+	var changes []*SyncRecord
+	var seq int64
+	prefix := storageKey(userID, "")
+	err := r.s.View(func(tx *Tx) error {
+		if _, err := tx.Get(collMeta, metaSyncSeq, &seq); err != nil {
+			return err
+		}
+		for entity := range syncEntities {
+			err := tx.Scan(syncCollection(entity), func(key string, raw json.RawMessage) error {
+				if !strings.HasPrefix(key, prefix) {
+					return nil
+				}
+				var record SyncRecord
+				if err := json.Unmarshal(raw, &record); err != nil {
+					return fmt.Errorf("sync record %q: %w", key, err)
+				}
+				if record.Seq > cursor {
+					changes = append(changes, &record)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, false, err
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Seq < changes[j].Seq })
+	if len(changes) > limit {
+		return changes[:limit], changes[limit-1].Seq, true, nil
+	}
+	return changes, max(seq, cursor), false, nil
+}
+
+func (r storageSyncRepository) Commit(records []*SyncRecord, keys []*appliedKey) error {
+	return r.s.Update(func(tx *Tx) error {
+		var seq int64
+		if _, err := tx.Get(collMeta, metaSyncSeq, &seq); err != nil {
+			return err
+		}
+		for _, record := range records {
+			seq++
+			record.Seq = seq
+			if err := tx.Put(syncCollection(record.Entity), storageKey(record.UserID, record.ID), record); err != nil {
+				return err
+			}
+		}
+		for _, k := range keys {
+			if err := tx.Put(collSyncKeys, storageKey(k.UserID, k.Key), k); err != nil {
+				return err
+			}
+		}
+		if len(records) == 0 {
+			return nil
+		}
+		return tx.Put(collMeta, metaSyncSeq, seq)
+	})
+}
+
+func (r storageSyncRepository) PruneKeys(t time.Time) error {
+	return r.s.Update(func(tx *Tx) error {
+		var expired []string
+		err := tx.Scan(collSyncKeys, func(key string, raw json.RawMessage) error {
+			var k appliedKey
+			if err := json.Unmarshal(raw, &k); err != nil {
+				return fmt.Errorf("sync key %q: %w", key, err)
+			}
+			if k.At.Before(t) {
+				expired = append(expired, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range expired {
+			if err := tx.Delete(collSyncKeys, key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r storageCursorRepository) Cursor(userID, deviceID string) (int64, bool, error) {
+	var cursor int64
+	var ok bool
+	err := r.s.View(func(tx *Tx) error {
+		var err error
+		ok, err = tx.Get(collSyncCursors, storageKey(userID, deviceID), &cursor)
+		return err
+	})
+	return cursor, ok, err
+}
+
+func (r storageCursorRepository) SetCursor(userID, deviceID string, cursor int64) error {
+	return r.s.Update(func(tx *Tx) error {
+		return tx.Put(collSyncCursors, storageKey(userID, deviceID), cursor)
+	})
+}
+
+func (r storageProfileRepository) Profile(userID string) (*FarmerProfile, bool, error) {
+	var p FarmerProfile
+	var ok bool
+	err := r.s.View(func(tx *Tx) error {
+		var err error
+		ok, err = tx.Get(collProfiles, userID, &p)
+		return err
+	})
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	return &p, true, nil
+}
+
+func (r storageProfileRepository) PutProfile(p *FarmerProfile) error {
+	return r.s.Update(func(tx *Tx) error {
+		return tx.Put(collProfiles, p.UserID, p)
+	})
+}
+
+func listingKey(id int64) string {
+	return strconv.FormatInt(id, 10)
+}
+
+func (r storageListingRepository) Listing(id int64) (*Listing, bool, error) {
+	var l Listing
+	var ok bool
+	err := r.s.View(func(tx *Tx) error {
+		var err error
+		ok, err = tx.Get(collListings, listingKey(id), &l)
+		return err
+	})
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	return &l, true, nil
+}
+
+func (r storageListingRepository) Listings() ([]*Listing, error) {
+	var listings []*Listing
+	err := r.s.View(func(tx *Tx) error {
+		return tx.Scan(collListings, func(key string, raw json.RawMessage) error {
+			var l Listing
+			if err := json.Unmarshal(raw, &l); err != nil {
+				return fmt.Errorf("listing %q: %w", key, err)
+			}
+			listings = append(listings, &l)
+			return nil
+		})
+	})
+	return listings, err
+}
+
+func (r storageListingRepository) CreateListing(l *Listing) error {
+	return r.s.Update(func(tx *Tx) error {
+		var id int64
+		if _, err := tx.Get(collMeta, metaListingID, &id); err != nil {
+			return err
+		}
+		id++
+		l.ID = id
+		if err := tx.Put(collListings, listingKey(id), l); err != nil {
+			return err
+		}
+		return tx.Put(collMeta, metaListingID, id)
+	})
+}
+
+func (r storageListingRepository) PutListing(l *Listing) error {
+	return r.s.Update(func(tx *Tx) error {
+		return tx.Put(collListings, listingKey(l.ID), l)
+	})
+}
+
+func (r storageListingRepository) SetPriceCheck(id int64, updatedAt time.Time, check *ListingPriceCheck) error {
+	return r.s.Update(func(tx *Tx) error {
+		var l Listing
+		ok, err := tx.Get(collListings, listingKey(id), &l)
+		if err != nil || !ok || !l.UpdatedAt.Equal(updatedAt) {
+			return err
+		}
+		l.PriceCheck = check
+		return tx.Put(collListings, listingKey(id), &l)
+	})
+}
+
+func (r storageListingRepository) DeleteListing(id int64) error {
+	return r.s.Update(func(tx *Tx) error {
+		return tx.Delete(collListings, listingKey(id))
+	})
+}
+
+func (r storageListingRepository) PurgeListings(t time.Time) error {
+	return r.s.Update(func(tx *Tx) error {
+		var expired []string
+		err := tx.Scan(collListings, func(key string, raw json.RawMessage) error {
+			var l Listing
+			if err := json.Unmarshal(raw, &l); err != nil {
+				return fmt.Errorf("listing %q: %w", key, err)
+			}
+			if l.ExpiresAt.Before(t) {
+				expired = append(expired, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range expired {
+			if err := tx.Delete(collListings, key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r storageUserRepository) UserByPhone(phone string) (*User, bool, error) {
+	var u User
+	var ok bool
+	err := r.s.View(func(tx *Tx) error {
+		var err error
+		ok, err = tx.Get(collUsers, phone, &u)
+		return err
+	})
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	return &u, true, nil
+}
+
+func (r storageUserRepository) PutUser(u *User) error {
+	return r.s.Update(func(tx *Tx) error {
+		return tx.Put(collUsers, u.Phone, u)
+	})
+}
+
+func (r storageSessionRepository) Session(id string) (*Session, bool, error) {
+	var session Session
+	var ok bool
+	err := r.s.View(func(tx *Tx) error {
+		var err error
+		ok, err = tx.Get(collSessions, id, &session)
+		return err
+	})
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	return &session, true, nil
+}
+
+func (r storageSessionRepository) PutSession(session *Session) error {
+	return r.s.Update(func(tx *Tx) error {
+		return tx.Put(collSessions, session.ID, session)
+	})
+}
+
+func (r storageSessionRepository) UpdateSession(id string, fn func(s *Session) (*Session, error)) error {
+	return r.s.Update(func(tx *Tx) error {
+		var current *Session
+		var session Session
+		if ok, err := tx.Get(collSessions, id, &session); err != nil {
+			return err
+		} else if ok {
+			current = &session
+		}
+		next, err := fn(current)
+		if err != nil {
+			return err
+		}
+		if next == nil {
+			return tx.Delete(collSessions, id)
+		}
+		return tx.Put(collSessions, id, next)
+	})
+}
+
+func (r storageSessionRepository) DeleteSession(id string) error {
+	return r.s.Update(func(tx *Tx) error {
+		return tx.Delete(collSessions, id)
+	})
+}
+
+func (r storageSessionRepository) PurgeSessions(t time.Time) error {
+	return r.s.Update(func(tx *Tx) error {
+		var expired []string
+		err := tx.Scan(collSessions, func(key string, raw json.RawMessage) error {
+			var session Session
+			if err := json.Unmarshal(raw, &session); err != nil {
+				return fmt.Errorf("session %q: %w", key, err)
+			}
+			if session.ExpiresAt.Before(t) {
+				expired = append(expired, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range expired {
+			if err := tx.Delete(collSessions, key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// AuthKey returns the key signing session tokens, generating and storing
+// one on first use so sessions survive restarts.
+func (s *Storage) AuthKey() ([]byte, error) {
+	var key []byte
+	err := s.Update(func(tx *Tx) error {
+		ok, err := tx.Get(collMeta, metaAuthKey, &key)
+		if err != nil || ok {
+			return err
+		}
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return err
+		}
+		return tx.Put(collMeta, metaAuthKey, key)
+	})
+	return key, err
+}
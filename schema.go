@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ==================== CSV SCHEMA ====================
+
+// csvColumn describes one column ReadData knows how to use. A column is
+// matched either by one of its header names or by its HXL hashtag.
+type csvColumn struct {
+	Name     string   // canonical name, used as the key in columnMap
+	Headers  []string // accepted header spellings (compared case-insensitively)
+	HXL      string   // HXL hashtag with attributes, e.g. "#loc+market+name"
+	Required bool
+}
+
+// csvSchema lists the columns of the WFP/HDX food price export.
+var csvSchema = []csvColumn{
+	{Name: "date", Headers: []string{"date"}, HXL: "#date", Required: true},
+	{Name: "admin1", Headers: []string{"admin1", "region"}, HXL: "#adm1+name", Required: true},
+	{Name: "admin2", Headers: []string{"admin2", "county"}, HXL: "#adm2+name", Required: true},
+	{Name: "market", Headers: []string{"market", "market_name"}, HXL: "#loc+market+name", Required: true},
+	{Name: "market_id", Headers: []string{"market_id"}, HXL: "#loc+market+code", Required: true},
+	{Name: "latitude", Headers: []string{"latitude", "lat"}, HXL: "#geo+lat", Required: true},
+	{Name: "longitude", Headers: []string{"longitude", "lon", "long"}, HXL: "#geo+lon", Required: true},
+	{Name: "category", Headers: []string{"category"}, HXL: "#item+type", Required: true},
+	{Name: "commodity", Headers: []string{"commodity"}, HXL: "#item+name", Required: true},
+	{Name: "commodity_id", Headers: []string{"commodity_id"}, HXL: "#item+code", Required: true},
+	{Name: "unit", Headers: []string{"unit"}, HXL: "#item+unit", Required: true},
+	{Name: "priceflag", Headers: []string{"priceflag", "price_flag"}, HXL: "#item+price+flag", Required: true},
+	{Name: "pricetype", Headers: []string{"pricetype", "price_type"}, HXL: "#item+price+type", Required: true},
+	{Name: "currency", Headers: []string{"currency"}, HXL: "#currency+code", Required: true},
+	{Name: "price", Headers: []string{"price"}, HXL: "#value", Required: true},
+	{Name: "usdprice", Headers: []string{"usdprice", "usd_price"}, HXL: "#value+usd"},
+}
+
+// columnMap maps a canonical column name to its position in a record.
+type columnMap map[string]int
+
+// get returns the trimmed value of the named column, or "" when the
+// column is absent from the file or the record is too short.
+func (m columnMap) get(record []string, name string) string {
+	idx, ok := m[name]
+	if !ok || idx >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[idx])
+}
+
+// has reports whether the named column was found in the file.
+func (m columnMap) has(name string) bool {
+	_, ok := m[name]
+	return ok
+}
+
+// SchemaError is returned by ReadData when the file lacks required columns.
+type SchemaError struct {
+	File    string
+	Missing []string
+	Header  []string
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("missing required column(s) %s (found: %s)",
+		strings.Join(e.Missing, ", "), strings.Join(e.Header, ", "))
+}
+
+// isHXLRow reports whether a row is an HXL hashtag row: every non-empty
+// cell starts with '#', and at least one cell is non-empty.
+func isHXLRow(row []string) bool {
+	seen := false
+	for _, cell := range row {
+		cell = strings.TrimSpace(cell)
+		if cell == "" {
+			continue
+		}
+		if !strings.HasPrefix(cell, "#") {
+			return false
+		}
+		seen = true
+	}
+	return seen
+}
+
+// normalizeHXLTag lowercases a tag and sorts its attributes so that
+// "#item+price+type" and "#item +type +price" compare equal.
+func normalizeHXLTag(tag string) string {
+	tag = strings.ToLower(strings.Join(strings.Fields(tag), ""))
+	parts := strings.Split(tag, "+")
+	if len(parts) == 0 {
+		return tag
+	}
+	attrs := parts[1:]
+	sort.Strings(attrs)
+	return strings.Join(append([]string{parts[0]}, attrs...), "+")
+}
+
+// mapHeaderColumns locates schema columns by header name.
+func mapHeaderColumns(header []string) columnMap {
+	cols := make(columnMap)
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+		for _, col := range csvSchema {
+			if cols.has(col.Name) {
+				continue
+			}
+			for _, name := range col.Headers {
+				if h == name {
+					cols[col.Name] = i
+					break
+				}
+			}
+		}
+	}
+	return cols
+}
+
+// mapHXLColumns locates schema columns by HXL hashtag. Extra attributes
+// on a tag are tolerated as long as the schema attributes are present,
+// but an exact match always wins.
+func mapHXLColumns(tags []string) columnMap {
+	cols := make(columnMap)
+	normalized := make([]string, len(tags))
+	for i, t := range tags {
+		normalized[i] = normalizeHXLTag(strings.TrimPrefix(t, "\ufeff"))
+	}
+
+	for _, col := range csvSchema {
+		want := normalizeHXLTag(col.HXL)
+		for i, tag := range normalized {
+			if tag == want {
+				cols[col.Name] = i
+				break
+			}
+		}
+	}
+
+	for _, col := range csvSchema {
+		if cols.has(col.Name) {
+			continue
+		}
+		wantParts := strings.Split(normalizeHXLTag(col.HXL), "+")
+		for i, tag := range normalized {
+			if hxlTagCovers(tag, wantParts) && !columnTaken(cols, i) {
+				cols[col.Name] = i
+				break
+			}
+		}
+	}
+	return cols
+}
+
+// hxlTagCovers reports whether tag has the hashtag and all attributes in want.
+func hxlTagCovers(tag string, want []string) bool {
+	parts := strings.Split(tag, "+")
+	if len(parts) == 0 || parts[0] != want[0] {
+		return false
+	}
+	have := make(map[string]bool, len(parts))
+	for _, p := range parts[1:] {
+		have[p] = true
+	}
+	for _, w := range want[1:] {
+		if !have[w] {
+			return false
+		}
+	}
+	return true
+}
+
+func columnTaken(cols columnMap, idx int) bool {
+	for _, i := range cols {
+		if i == idx {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeColumns fills columns missing from primary with those found in fallback.
+func mergeColumns(primary, fallback columnMap) columnMap {
+	for name, idx := range fallback {
+		if !primary.has(name) {
+			primary[name] = idx
+		}
+	}
+	return primary
+}
+
+// missingColumns returns the required schema columns absent from cols.
+func missingColumns(cols columnMap) []string {
+	var missing []string
+	for _, col := range csvSchema {
+		if col.Required && !cols.has(col.Name) {
+			missing = append(missing, col.Name)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// hdxTags are the hashtags of the HDX food price export, in its order.
+var hdxTags = []string{
+	"#date", "#adm1+name", "#adm2+name", "#loc+market+name", "#loc+market+code",
+	"#geo+lat", "#geo+lon", "#item+type", "#item+name", "#item+code", "#item+unit",
+	"#item+price+flag", "#item+price+type", "#currency+code", "#value", "#value+usd",
+}
+
+func TestNormalizeHXLTag(t *testing.T) {
+	tests := []struct{ tag, want string }{
+		{"#item+price+type", "#item+price+type"},
+		{"#item +type +price", "#item+price+type"},
+		{"#ITEM+Type+Price", "#item+price+type"},
+		{"#date", "#date"},
+	}
+	for _, tt := range tests {
+		if got := normalizeHXLTag(tt.tag); got != tt.want {
+			t.Errorf("normalizeHXLTag(%q) = %q, want %q", tt.tag, got, tt.want)
+		}
+	}
+}
+
+func TestIsHXLRow(t *testing.T) {
+	tests := []struct {
+		row  []string
+		want bool
+	}{
+		{hdxTags, true},
+		{[]string{"#date", "", " #value "}, true},
+		{[]string{"date", "#value"}, false},
+		{[]string{"", " "}, false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := isHXLRow(tt.row); got != tt.want {
+			t.Errorf("isHXLRow(%q) = %v, want %v", tt.row, got, tt.want)
+		}
+	}
+}
+
+func TestMapHXLColumns(t *testing.T) {
+	tests := []struct {
+		name    string
+		tags    []string
+		want    map[string]int // expected positions of some columns
+		missing []string
+	}{
+		{
+			name: "HDX export",
+			tags: hdxTags,
+			want: map[string]int{"date": 0, "market": 3, "market_id": 4, "pricetype": 12, "price": 14, "usdprice": 15},
+		},
+		{
+			name: "reordered attributes and case",
+			tags: []string{"\ufeff#DATE", "#adm1+NAME", "#adm2 +name", "#loc+name+market", "#loc +code +market",
+				"#geo+lat", "#geo+lon", "#item+type", "#item+name", "#item+code", "#item+unit",
+				"#item+flag+price", "#item+type+price", "#currency+code", "#value"},
+			want: map[string]int{"date": 0, "admin1": 1, "admin2": 2, "market": 3, "market_id": 4, "priceflag": 11, "pricetype": 12, "price": 14},
+		},
+		{
+			name: "extra attributes",
+			tags: []string{"#date+reported", "#adm1+name+v_pcode", "#adm2+name", "#loc+market+name+en", "#loc+market+code",
+				"#geo+lat", "#geo+lon", "#item+type", "#item+name", "#item+code", "#item+unit",
+				"#item+price+flag", "#item+price+type", "#currency+code", "#value+local"},
+			want: map[string]int{"date": 0, "admin1": 1, "market": 3, "price": 14},
+		},
+		{
+			name: "exact match beats a covering tag before it",
+			tags: []string{"#date", "#adm1+name", "#adm2+name", "#loc+market+name", "#loc+market+code",
+				"#geo+lat", "#geo+lon", "#item+type", "#item+name", "#item+code", "#item+unit",
+				"#item+price+flag", "#item+price+type+retail", "#item+price+type", "#currency+code", "#value+usd", "#value"},
+			want: map[string]int{"pricetype": 13, "usdprice": 15, "price": 16},
+		},
+		{
+			name:    "a column is used once",
+			tags:    slices.DeleteFunc(slices.Clone(hdxTags), func(tag string) bool { return tag == "#value" }),
+			want:    map[string]int{"usdprice": 14},
+			missing: []string{"price"},
+		},
+		{
+			name:    "missing columns",
+			tags:    []string{"#date", "#loc+market+name", "#value", "#item+unit"},
+			want:    map[string]int{"date": 0, "market": 1, "price": 2, "unit": 3},
+			missing: []string{"admin1", "admin2", "market_id", "latitude", "longitude", "category", "commodity", "commodity_id", "priceflag", "pricetype", "currency"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cols := mapHXLColumns(tt.tags)
+			for name, want := range tt.want {
+				if got, ok := cols[name]; !ok || got != want {
+					t.Errorf("%s at %d (found %v), want %d", name, got, ok, want)
+				}
+			}
+			if got := missingColumns(cols); !slices.Equal(got, tt.missing) {
+				t.Errorf("missing %v, want %v", got, tt.missing)
+			}
+		})
+	}
+}
+
+func TestMapHeaderColumns(t *testing.T) {
+	cols := mapHeaderColumns([]string{"\ufeffDate", "Region", "County", "Market_Name", "market_id", "Lat", "Long",
+		"category", "commodity", "commodity_id", "unit", "price_flag", "PRICE_TYPE", "currency", "price", "market"})
+	want := map[string]int{"date": 0, "admin1": 1, "admin2": 2, "market": 3, "latitude": 5, "longitude": 6, "priceflag": 11, "pricetype": 12, "price": 14}
+	for name, i := range want {
+		if cols[name] != i {
+			t.Errorf("%s at %d, want %d", name, cols[name], i)
+		}
+	}
+	if cols.has("usdprice") {
+		t.Error("usdprice found in a file without it")
+	}
+	if missing := missingColumns(cols); len(missing) != 0 {
+		t.Errorf("missing %v", missing)
+	}
+}
+
+func TestReadDataSchemaError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prices.csv")
+	csv := "date,market,price\n#date,#loc+market+name,#value\n2024-01-15,Kakuma,50\n"
+	if err := os.WriteFile(path, []byte(csv), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := ReadData(path)
+	var schemaErr *SchemaError
+	if !errors.As(err, &schemaErr) {
+		t.Fatalf("got %v, want a SchemaError", err)
+	}
+	if slices.Contains(schemaErr.Missing, "date") || !slices.Contains(schemaErr.Missing, "commodity") {
+		t.Errorf("missing %v", schemaErr.Missing)
+	}
+	if !strings.Contains(err.Error(), "commodity") {
+		t.Errorf("error %q doesn't name the missing column", err)
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== SEASONALITY ====================
+
+const (
+	seasonalityMaxGap = 2 // longest gap, in months, filled by interpolation
+	harvestWindow     = 6 // months before the cheapest month searched for the pre-harvest peak
+)
+
+// monthLabels are the short month names used in labels.
+var monthLabels = []string{"Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec"}
+
+// seasonalRatios holds the ratio of each month of a series to its 2x12
+// centred moving average, NaN where the average can't be computed or the
+// month itself was interpolated.
+type seasonalRatios struct {
+	marketID int
+	admin1   string
+	admin2   string
+	monthly  MonthlySeries
+	ratios   []float64
+}
+
+// ratiosToMovingAverage computes the seasonal ratios of m. The moving
+// average needs the six months either side of a month.
+func ratiosToMovingAverage(m MonthlySeries) []float64 {
+	ratios := make([]float64, len(m.Values))
+	for i := range ratios {
+		ratios[i] = math.NaN()
+		if i < 6 || i+6 >= len(m.Values) || m.Filled[i] {
+			continue
+		}
+		window := m.Values[i-6 : i+7]
+		if observed(window) < len(window) {
+			continue
+		}
+		sum := (window[0] + window[12]) / 2
+		for _, v := range window[1:12] {
+			sum += v
+		}
+		if sum > 0 {
+			ratios[i] = m.Values[i] / (sum / 12)
+		}
+	}
+	return ratios
+}
+
+// SeasonalMonth is one calendar month of a seasonal profile.
+type SeasonalMonth struct {
+	Month  int      `json:"month"` // 1-12
+	Label  string   `json:"label"` // e.g. "Jan"
+	Index  *float64 `json:"index"` // mean ratio to the moving average, nil without data
+	Ratios int      `json:"ratios"`
+}
+
+// PostHarvestDip is how far prices typically fall from their pre-harvest
+// peak to the cheapest month.
+type PostHarvestDip struct {
+	Percent float64 `json:"percent"` // average fall from the peak, in percent
+	Years   int     `json:"years"`   // seasons averaged
+}
+
+// SeasonalProfile is the seasonal index of a group of series. The index
+// is rescaled to average 1 when every month has data, so 1.08 is a month
+// 8% dearer than the year's average.
+type SeasonalProfile struct {
+	Series         int             `json:"series"`
+	Markets        int             `json:"markets"`
+	Months         []SeasonalMonth `json:"months"`
+	CheapestMonth  string          `json:"cheapest_month"`
+	DearestMonth   string          `json:"dearest_month"`
+	Amplitude      float64         `json:"amplitude"` // dearest over cheapest month, in percent
+	PostHarvestDip *PostHarvestDip `json:"post_harvest_dip"`
+}
+
+// seasonalProfile averages the ratios of group by calendar month. It
+// reports false when no series has a ratio.
+func seasonalProfile(group []seasonalRatios) (SeasonalProfile, bool) {
+	var sums [12]float64
+	var counts [12]int
+	markets := make(map[int]bool)
+	for _, s := range group {
+		markets[s.marketID] = true
+		for i, r := range s.ratios {
+			if !math.IsNaN(r) {
+				cm := s.monthly.calendarMonth(i) - 1
+				sums[cm] += r
+				counts[cm]++
+			}
+		}
+	}
+
+	index := make([]float64, 12)
+	known, total := 0, 0.0
+	for cm := range index {
+		index[cm] = math.NaN()
+		if counts[cm] > 0 {
+			index[cm] = sums[cm] / float64(counts[cm])
+			known++
+			total += index[cm]
+		}
+	}
+	if known == 0 {
+		return SeasonalProfile{}, false
+	}
+	if known == 12 {
+		for cm := range index {
+			index[cm] /= total / 12
+		}
+	}
+
+	profile := SeasonalProfile{Series: len(group), Markets: len(markets)}
+	cheapest, dearest := -1, -1
+	for cm, v := range index {
+		month := SeasonalMonth{Month: cm + 1, Label: monthLabels[cm], Ratios: counts[cm]}
+		if !math.IsNaN(v) {
+			rounded := math.Round(v*1000) / 1000
+			month.Index = &rounded
+			if cheapest < 0 || v < index[cheapest] {
+				cheapest = cm
+			}
+			if dearest < 0 || v > index[dearest] {
+				dearest = cm
+			}
+		}
+		profile.Months = append(profile.Months, month)
+	}
+	profile.CheapestMonth = monthLabels[cheapest]
+	profile.DearestMonth = monthLabels[dearest]
+	profile.Amplitude = math.Round((index[dearest]/index[cheapest]-1)*1000) / 10
+	profile.PostHarvestDip = postHarvestDip(group, cheapest+1)
+	return profile, true
+}
+
+// postHarvestDip averages, over every season of every series, the fall
+// from the highest ratio in the harvestWindow months before the cheapest
+// calendar month to the ratio of that month.
+func postHarvestDip(group []seasonalRatios, cheapest int) *PostHarvestDip {
+	sum, n := 0.0, 0
+	for _, s := range group {
+		for i, r := range s.ratios {
+			if math.IsNaN(r) || s.monthly.calendarMonth(i) != cheapest {
+				continue
+			}
+			peak := math.NaN()
+			for j := max(0, i-harvestWindow); j < i; j++ {
+				if !math.IsNaN(s.ratios[j]) && !(s.ratios[j] <= peak) {
+					peak = s.ratios[j]
+				}
+			}
+			if !math.IsNaN(peak) {
+				sum += (peak - r) / peak
+				n++
+			}
+		}
+	}
+	if n == 0 {
+		return nil
+	}
+	return &PostHarvestDip{Percent: math.Round(sum/float64(n)*1000) / 10, Years: n}
+}
+
+// ==================== SEASONALITY ENDPOINT ====================
+
+// CountySeasonality is the profile of one county (Admin2).
+type CountySeasonality struct {
+	County string `json:"county"`
+	SeasonalProfile
+}
+
+// RegionSeasonality is the profile of one region (Admin1) and its counties.
+type RegionSeasonality struct {
+	Region string `json:"region"`
+	SeasonalProfile
+	Counties []CountySeasonality `json:"counties"`
+}
+
+// SeasonalityResponse is the body of /api/prices/seasonality.
+type SeasonalityResponse struct {
+	Commodity string              `json:"commodity"`
+	Region    string              `json:"region,omitempty"`
+	County    string              `json:"county,omitempty"`
+	PriceType string              `json:"price_type,omitempty"`
+	Profile   SeasonalProfile     `json:"profile"` // every series matching the filters
+	Regions   []RegionSeasonality `json:"regions"`
+}
+
+// seasonalityHandler serves /api/prices/seasonality?commodity=&county=.
+// region=, pricetype= and variants= narrow the series further, and lang=
+// names the commodity. Ratios are unit-free, so series in different
+// units are pooled.
+func seasonalityHandler(store *DatasetStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		foodData := store.Load()
+		lang, err := parseLangParam(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		expand, err := parseVariantsParam(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		priceType, err := parsePriceTypeParam(c.Query("pricetype"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		commodity := c.Query("commodity")
+		if commodity == "" {
+			c.JSON(400, gin.H{"error": "commodity is required"})
+			return
+		}
+		filter := foodData.Taxonomy.Filter([]string{commodity}, expand)
+		if len(filter) == 0 {
+			c.JSON(404, gin.H{"error": "Commodity not found"})
+			return
+		}
+		county, region := c.Query("county"), c.Query("region")
+
+		var all []seasonalRatios
+		for _, series := range foodData.AllSeries() {
+			if !filter.Match(series.Key.CommodityID) || (priceType != nil && series.Key.PriceType != *priceType) {
+				continue
+			}
+			market, ok := foodData.MarketByID(series.Key.MarketID)
+			if !ok ||
+				(county != "" && !strings.EqualFold(market.Admin2, county)) ||
+				(region != "" && !strings.EqualFold(market.Admin1, region)) {
+				continue
+			}
+			monthly := monthlySeries(series.Observations, seasonalityMaxGap)
+			all = append(all, seasonalRatios{
+				marketID: market.ID,
+				admin1:   market.Admin1,
+				admin2:   market.Admin2,
+				monthly:  monthly,
+				ratios:   ratiosToMovingAverage(monthly),
+			})
+		}
+
+		profile, ok := seasonalProfile(all)
+		if !ok {
+			c.JSON(422, gin.H{"error": "not enough monthly history for a seasonal profile (13 consecutive months needed)"})
+			return
+		}
+		response := SeasonalityResponse{
+			Commodity: foodData.Taxonomy.localTerm(commodity, lang),
+			Region:    region,
+			County:    county,
+			Profile:   profile,
+			Regions:   []RegionSeasonality{},
+		}
+		if priceType != nil {
+			response.PriceType = priceType.String()
+		}
+
+		byRegion := make(map[string][]seasonalRatios)
+		for _, s := range all {
+			byRegion[s.admin1] = append(byRegion[s.admin1], s)
+		}
+		for name, group := range byRegion {
+			p, ok := seasonalProfile(group)
+			if !ok {
+				continue
+			}
+			r := RegionSeasonality{Region: name, SeasonalProfile: p, Counties: []CountySeasonality{}}
+			byCounty := make(map[string][]seasonalRatios)
+			for _, s := range group {
+				byCounty[s.admin2] = append(byCounty[s.admin2], s)
+			}
+			for name, group := range byCounty {
+				if p, ok := seasonalProfile(group); ok {
+					r.Counties = append(r.Counties, CountySeasonality{County: name, SeasonalProfile: p})
+				}
+			}
+			sort.Slice(r.Counties, func(i, j int) bool { return r.Counties[i].County < r.Counties[j].County })
+			response.Regions = append(response.Regions, r)
+		}
+		sort.Slice(response.Regions, func(i, j int) bool { return response.Regions[i].Region < response.Regions[j].Region })
+		c.JSON(200, response)
+	}
+}
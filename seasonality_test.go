@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+// harvestPattern is a seasonal index averaging 1, dearest in May before
+// the long-rains harvest and cheapest in August after it.
+var harvestPattern = []float64{1.00, 1.05, 1.10, 1.15, 1.20, 1.10, 0.95, 0.80, 0.85, 0.90, 0.95, 0.95}
+
+// patternRatios repeats pattern over years, from January.
+func patternRatios(pattern []float64, years int) []float64 {
+	var ratios []float64
+	for i := 0; i < years*12; i++ {
+		ratios = append(ratios, pattern[i%12])
+	}
+	return ratios
+}
+
+func TestRatiosToMovingAverage(t *testing.T) {
+	flat := MonthlySeries{Start: "2022-01"}
+	for i := 0; i < 25; i++ {
+		flat.Values = append(flat.Values, 100)
+		flat.Filled = append(flat.Filled, false)
+	}
+	ratios := ratiosToMovingAverage(flat)
+	for i, r := range ratios {
+		// Six months either side are needed
+		if want := i >= 6 && i < 19; want != !math.IsNaN(r) || (want && r != 1) {
+			t.Errorf("flat month %d: ratio %v", i, r)
+		}
+	}
+
+	// A periodic series has its own pattern as ratios
+	seasonal := MonthlySeries{Start: "2022-01"}
+	for _, r := range patternRatios(harvestPattern, 3) {
+		seasonal.Values = append(seasonal.Values, 200*r)
+		seasonal.Filled = append(seasonal.Filled, false)
+	}
+	seasonal.Filled[12] = true
+	seasonal.Values[20] = math.NaN()
+	ratios = ratiosToMovingAverage(seasonal)
+	tests := []struct {
+		month int
+		want  float64 // NaN for none
+	}{
+		{5, math.NaN()},
+		{6, harvestPattern[6]},
+		{7, harvestPattern[7]},
+		{12, math.NaN()}, // interpolated
+		{13, 1.05},       // window holds an interpolated month
+		{14, math.NaN()}, // window holds the missing month
+		{20, math.NaN()},
+		{26, math.NaN()},
+		{27, 1.15},
+		{29, 1.10},
+		{30, math.NaN()},
+	}
+	for _, tt := range tests {
+		got := ratios[tt.month]
+		if math.IsNaN(tt.want) != math.IsNaN(got) || (!math.IsNaN(got) && math.Abs(got-tt.want) > 1e-9) {
+			t.Errorf("month %d: ratio %v, want %v", tt.month, got, tt.want)
+		}
+	}
+}
+
+func TestSeasonalProfile(t *testing.T) {
+	noDecember := patternRatios(harvestPattern, 3)
+	for i := 11; i < len(noDecember); i += 12 {
+		noDecember[i] = math.NaN()
+	}
+	// Starting in August, the first season has no peak before it
+	fromAugust := patternRatios(harvestPattern, 3)[7:]
+	// months places the ratios of a series in the calendar
+	months := func(start string, n int) MonthlySeries {
+		return MonthlySeries{Start: start, Values: make([]float64, n), Filled: make([]bool, n)}
+	}
+
+	tests := []struct {
+		name              string
+		group             []seasonalRatios
+		series, markets   int
+		cheapest, dearest string
+		amplitude         float64
+		dip               *PostHarvestDip
+		december          *float64
+	}{
+		{
+			name:   "one series",
+			group:  []seasonalRatios{{marketID: 1, monthly: months("2022-01", 36), ratios: patternRatios(harvestPattern, 3)}},
+			series: 1, markets: 1, cheapest: "Aug", dearest: "May", amplitude: 50,
+			// From 1.20 in May to 0.80 in August
+			dip:      &PostHarvestDip{Percent: 33.3, Years: 3},
+			december: &harvestPattern[11],
+		},
+		{
+			name: "two series of a market",
+			group: []seasonalRatios{
+				{marketID: 1, monthly: months("2022-01", 36), ratios: patternRatios(harvestPattern, 3)},
+				{marketID: 1, monthly: months("2022-08", len(fromAugust)), ratios: fromAugust},
+			},
+			series: 2, markets: 1, cheapest: "Aug", dearest: "May", amplitude: 50,
+			dip:      &PostHarvestDip{Percent: 33.3, Years: 5},
+			december: &harvestPattern[11],
+		},
+		{
+			name:   "a month without ratios",
+			group:  []seasonalRatios{{marketID: 1, monthly: months("2022-01", 36), ratios: noDecember}},
+			series: 1, markets: 1, cheapest: "Aug", dearest: "May", amplitude: 50,
+			dip: &PostHarvestDip{Percent: 33.3, Years: 3},
+		},
+		{
+			name:   "no season before the cheapest month",
+			group:  []seasonalRatios{{marketID: 2, monthly: months("2022-08", 1), ratios: fromAugust[:1]}},
+			series: 1, markets: 1, cheapest: "Aug", dearest: "Aug", amplitude: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, ok := seasonalProfile(tt.group)
+			if !ok {
+				t.Fatal("no profile")
+			}
+			if p.Series != tt.series || p.Markets != tt.markets || len(p.Months) != 12 {
+				t.Errorf("%d series of %d markets, %d months", p.Series, p.Markets, len(p.Months))
+			}
+			if p.CheapestMonth != tt.cheapest || p.DearestMonth != tt.dearest || p.Amplitude != tt.amplitude {
+				t.Errorf("cheapest %s, dearest %s, amplitude %v; want %s, %s, %v",
+					p.CheapestMonth, p.DearestMonth, p.Amplitude, tt.cheapest, tt.dearest, tt.amplitude)
+			}
+			if (p.PostHarvestDip == nil) != (tt.dip == nil) || (tt.dip != nil && *p.PostHarvestDip != *tt.dip) {
+				t.Errorf("post-harvest dip %+v, want %+v", p.PostHarvestDip, tt.dip)
+			}
+			dec := p.Months[11]
+			if (dec.Index == nil) != (tt.december == nil) || (tt.december != nil && math.Abs(*dec.Index-*tt.december) > 1e-9) {
+				t.Errorf("December index %v, want %v", dec.Index, tt.december)
+			}
+		})
+	}
+
+	if _, ok := seasonalProfile([]seasonalRatios{{ratios: []float64{math.NaN()}}}); ok {
+		t.Error("profile without ratios")
+	}
+	if _, ok := seasonalProfile(nil); ok {
+		t.Error("profile of no series")
+	}
+}
+
+// seasonalityRows price maize from 2021 to 2024 with harvestPattern, at
+// 100 retail in Mombasa and 200 retail in Nakuru, and flat at 80 wholesale
+// in Mombasa. Beans have a single year, too short for a profile.
+func seasonalityRows() string {
+	var rows strings.Builder
+	for i, r := range patternRatios(harvestPattern, 4) {
+		date := fmt.Sprintf("%d-%02d-15", 2021+i/12, i%12+1)
+		fmt.Fprintf(&rows, "%s,Coast,Mombasa,Kongowea,1,-4.04,39.68,cereals and tubers,Maize,51,KG,actual,Retail,KES,%v,1\n", date, 100*r)
+		fmt.Fprintf(&rows, "%s,Rift Valley,Nakuru,Nakuru,2,-0.28,36.07,cereals and tubers,Maize,51,KG,actual,Retail,KES,%v,1\n", date, 200*r)
+		fmt.Fprintf(&rows, "%s,Coast,Mombasa,Kongowea,1,-4.04,39.68,cereals and tubers,Maize,51,KG,actual,Wholesale,KES,80,1\n", date)
+		if i < 12 {
+			fmt.Fprintf(&rows, "%s,Coast,Mombasa,Kongowea,1,-4.04,39.68,pulses and nuts,Beans,66,KG,actual,Retail,KES,120,1\n", date)
+		}
+	}
+	return rows.String()
+}
+
+func TestSeasonalityEndpoint(t *testing.T) {
+	srv := newTestServerOn(t, csvTestStore(t, seasonalityRows()))
+
+	var resp SeasonalityResponse
+	if code := getJSON(t, srv.router, "/api/prices/seasonality?commodity=maize&pricetype=retail", &resp); code != 200 {
+		t.Fatalf("status %d", code)
+	}
+	p := resp.Profile
+	if p.Series != 2 || p.Markets != 2 || p.CheapestMonth != "Aug" || p.DearestMonth != "May" || p.Amplitude != 50 {
+		t.Errorf("profile of %d series in %d markets, %s to %s by %v%%", p.Series, p.Markets, p.CheapestMonth, p.DearestMonth, p.Amplitude)
+	}
+	for cm, m := range p.Months {
+		if m.Index == nil || math.Abs(*m.Index-harvestPattern[cm]) > 1e-9 {
+			t.Errorf("%s: index %v, want %v", m.Label, m.Index, harvestPattern[cm])
+		}
+	}
+	// Ratios start in July 2021, so the first season falls from July's
+	// 0.95, and the last ends before the moving average does
+	if want := (PostHarvestDip{Percent: 27.5, Years: 6}); p.PostHarvestDip == nil || *p.PostHarvestDip != want {
+		t.Errorf("post-harvest dip %+v, want %+v", p.PostHarvestDip, want)
+	}
+	if resp.PriceType != "Retail" || len(resp.Regions) != 2 ||
+		resp.Regions[0].Region != "Coast" || resp.Regions[0].Counties[0].County != "Mombasa" ||
+		resp.Regions[1].Region != "Rift Valley" || resp.Regions[1].Counties[0].County != "Nakuru" {
+		t.Errorf("regions %+v", resp.Regions)
+	}
+
+	tests := []struct {
+		query           string
+		series, regions int
+	}{
+		{"commodity=maize", 3, 2},
+		{"commodity=maize&region=coast", 2, 1},
+		{"commodity=maize&county=Nakuru", 1, 1},
+		{"commodity=maize&pricetype=wholesale", 1, 1},
+	}
+	for _, tt := range tests {
+		var resp SeasonalityResponse
+		if code := getJSON(t, srv.router, "/api/prices/seasonality?"+tt.query, &resp); code != 200 {
+			t.Fatalf("%s: status %d", tt.query, code)
+		}
+		if resp.Profile.Series != tt.series || len(resp.Regions) != tt.regions {
+			t.Errorf("%s: %d series in %d regions, want %d in %d", tt.query, resp.Profile.Series, len(resp.Regions), tt.series, tt.regions)
+		}
+	}
+
+	errors := []struct {
+		query  string
+		status int
+	}{
+		{"", 400},
+		{"commodity=maize&pricetype=farmgate", 400},
+		{"commodity=tractor", 404},
+		{"commodity=beans", 422},
+		{"commodity=maize&county=Kisumu", 422},
+	}
+	for _, tt := range errors {
+		if code := getJSON(t, srv.router, "/api/prices/seasonality?"+tt.query, nil); code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.query, code, tt.status)
+		}
+	}
+}
@@ -0,0 +1,546 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== MARKET SPREADS ====================
+
+// spreadKey identifies the prices of a market that can be compared with
+// another market's: one commodity, normalized unit and price type. Series
+// in different raw units are merged once normalized.
+type spreadKey struct {
+	CommodityID int
+	Unit        string // normalized unit
+	PriceType   PriceType
+}
+
+// spreadSource is a market's converted monthly prices of one spreadKey.
+type spreadSource struct {
+	Name    string   // WFP commodity name
+	Units   []string // units the prices were quoted in, e.g. "90 KG"
+	monthly MonthlySeries
+}
+
+// spreadSources converts and resamples the prices of a market that pass
+// filter and priceType, dated from..to.
+func spreadSources(foodData *FoodData, opts priceOptions, marketID int, filter commodityFilter, priceType *PriceType, from, to string) (map[spreadKey]spreadSource, error) {
+	merged := make(map[spreadKey][]Commodity)
+	names := make(map[spreadKey]string)
+	units := make(map[spreadKey][]string)
+	for _, series := range foodData.MarketSeries(marketID) {
+		if !filter.Match(series.Key.CommodityID) || (priceType != nil && series.Key.PriceType != *priceType) {
+			continue
+		}
+		for _, comm := range series.Between(from, to) {
+			key := spreadKey{CommodityID: comm.CommodityID, Unit: comm.NormalizedUnit, PriceType: comm.PriceType}
+			merged[key] = append(merged[key], comm)
+			names[key] = series.Name
+			if !slices.Contains(units[key], comm.Unit) {
+				units[key] = append(units[key], comm.Unit)
+			}
+		}
+	}
+
+	sources := make(map[spreadKey]spreadSource, len(merged))
+	for key, obs := range merged {
+		sort.SliceStable(obs, func(i, j int) bool { return obs[i].Date < obs[j].Date })
+		converted, err := opts.commodities(foodData, obs)
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(units[key])
+		sources[key] = spreadSource{Name: names[key], Units: units[key], monthly: monthlySeries(converted, 0)}
+	}
+	return sources, nil
+}
+
+// SpreadPoint is the gap between two markets in one month.
+type SpreadPoint struct {
+	Month          string  `json:"month"` // "YYYY-MM"
+	Label          string  `json:"label"`
+	FromPrice      float64 `json:"from_price"`
+	ToPrice        float64 `json:"to_price"`
+	Gap            float64 `json:"gap"`             // to_price - from_price
+	AboveThreshold bool    `json:"above_threshold"` // the gap pays for moving produce from -> to
+}
+
+// SpreadStats summarizes the monthly gaps of a market pair.
+type SpreadStats struct {
+	Months       int      `json:"months"`   // months both markets have a price
+	MeanGap      float64  `json:"mean_gap"` // to minus from
+	MeanGapPct   float64  `json:"mean_gap_percent"`
+	Volatility   float64  `json:"volatility"`          // standard deviation of the gap
+	Threshold    *float64 `json:"threshold"`           // transport cost per unit; nil if the unit's weight is unknown
+	ShareAbove   *float64 `json:"share_above"`         // share of months the gap exceeded the threshold
+	ShareReverse *float64 `json:"share_reverse"`       // share of months the reverse gap exceeded it
+	TransportEst bool     `json:"transport_estimated"` // the unit's weight was assumed
+	LatestMonth  string   `json:"latest_month"`
+	LatestGap    float64  `json:"latest_gap"`
+}
+
+// spreadPoints lines up the months in which both a and b have a price.
+func spreadPoints(a, b MonthlySeries, threshold *float64) []SpreadPoint {
+	points := []SpreadPoint{}
+	for i, from := range a.Values {
+		month := a.Month(i)
+		j := monthsBetween(b.Start, month)
+		if math.IsNaN(from) || j < 0 || j >= len(b.Values) || math.IsNaN(b.Values[j]) {
+			continue
+		}
+		to := b.Values[j]
+		point := SpreadPoint{
+			Month:     month,
+			Label:     formatMonth(month + "-01"),
+			FromPrice: from,
+			ToPrice:   to,
+			Gap:       to - from,
+		}
+		point.AboveThreshold = threshold != nil && point.Gap > *threshold
+		points = append(points, point)
+	}
+	return points
+}
+
+// spreadStats summarizes points; threshold may be nil.
+func spreadStats(points []SpreadPoint, threshold *float64) SpreadStats {
+	stats := SpreadStats{Months: len(points), Threshold: threshold}
+	if len(points) == 0 {
+		return stats
+	}
+	sum, sumFrom := 0.0, 0.0
+	above, reverse := 0, 0
+	for _, p := range points {
+		sum += p.Gap
+		sumFrom += p.FromPrice
+		if threshold != nil && p.Gap > *threshold {
+			above++
+		}
+		if threshold != nil && -p.Gap > *threshold {
+			reverse++
+		}
+	}
+	n := float64(len(points))
+	stats.MeanGap = sum / n
+	if sumFrom > 0 {
+		stats.MeanGapPct = math.Round(stats.MeanGap/(sumFrom/n)*1000) / 10
+	}
+	for _, p := range points {
+		stats.Volatility += (p.Gap - stats.MeanGap) * (p.Gap - stats.MeanGap)
+	}
+	stats.Volatility = math.Sqrt(stats.Volatility / math.Max(1, n-1))
+	if threshold != nil {
+		shareAbove := math.Round(float64(above)/n*1000) / 1000
+		shareReverse := math.Round(float64(reverse)/n*1000) / 1000
+		stats.ShareAbove, stats.ShareReverse = &shareAbove, &shareReverse
+	}
+	last := points[len(points)-1]
+	stats.LatestMonth, stats.LatestGap = last.Month, last.Gap
+	return stats
+}
+
+// transportThreshold is the cost of moving one normalized unit of
+// commodity distanceKm at costPerKmKg.
+func transportThreshold(commodity, unit string, distanceKm, costPerKmKg float64) (*float64, bool) {
+	kg, estimated, ok := kgPerNormalizedUnit(commodity, unit)
+	if !ok {
+		return nil, false
+	}
+	cost := costPerKmKg * distanceKm * kg
+	return &cost, estimated
+}
+
+// parseTransportCostParam reads transport_cost=, the transport cost in
+// KES per km per kg, falling back to def.
+func parseTransportCostParam(c *gin.Context, def float64) (float64, error) {
+	v := c.Query("transport_cost")
+	if v == "" {
+		return def, nil
+	}
+	cost, err := strconv.ParseFloat(v, 64)
+	if err != nil || cost < 0 {
+		return 0, fmt.Errorf("invalid transport_cost %q", v)
+	}
+	return cost, nil
+}
+
+// spreadWindow reads from= and to=.
+func spreadWindow(c *gin.Context, defaultFrom string) (string, string, error) {
+	from, to := c.DefaultQuery("from", defaultFrom), c.Query("to")
+	for _, d := range []string{from, to} {
+		if d != "" && !datePattern.MatchString(d) {
+			return "", "", fmt.Errorf("invalid date %q (use YYYY, YYYY-MM or YYYY-MM-DD)", d)
+		}
+	}
+	return from, to, nil
+}
+
+// ==================== SPREAD ENDPOINTS ====================
+
+// SpreadSeries describes the compared prices of a pair.
+type SpreadSeries struct {
+	CommodityID int    `json:"commodity_id"`
+	Commodity   string `json:"commodity"`
+	Unit        string `json:"unit"` // normalized unit
+	PriceType   string `json:"price_type"`
+	Months      int    `json:"months"` // months both markets have a price
+}
+
+// SpreadResponse is the body of /api/analysis/spread.
+type SpreadResponse struct {
+	FromMarket           MarketSummary  `json:"from_market"`
+	ToMarket             MarketSummary  `json:"to_market"`
+	DistanceKm           float64        `json:"distance_km"`
+	Currency             string         `json:"currency"`
+	Real                 bool           `json:"real"`
+	BaseYear             int            `json:"base_year,omitempty"`
+	TransportCostPerKmKg float64        `json:"transport_cost_per_km_kg"` // in Currency
+	Series               SpreadSeries   `json:"series"`
+	Alternatives         []SpreadSeries `json:"alternatives"` // other comparable series of the pair
+	Stats                SpreadStats    `json:"stats"`
+	Points               []SpreadPoint  `json:"points"`
+}
+
+// spreadHandler serves /api/analysis/spread?commodity=&from_market=&to_market=
+// (or from_market_id=/to_market_id=). The gap is to minus from, so a
+// positive gap pays for moving produce from -> to once it exceeds the
+// transport cost. pricetype=, variants=, from=, to= and transport_cost=
+// are optional.
+func spreadHandler(store *DatasetStore, costPerKmKg float64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		foodData := store.Load()
+		opts, err := parsePriceOptions(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		expand, err := parseVariantsParam(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		priceType, err := parsePriceTypeParam(c.Query("pricetype"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		perKmKg, err := parseTransportCostParam(c, costPerKmKg)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		from, to, err := spreadWindow(c, "")
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		commodity := c.Query("commodity")
+		if commodity == "" {
+			c.JSON(400, gin.H{"error": "commodity is required"})
+			return
+		}
+
+		var markets [2]*MarketData
+		for i, side := range []string{"from_market", "to_market"} {
+			q := seriesQuery{Market: c.Query(side)}
+			if v := c.Query(side + "_id"); v != "" {
+				if q.MarketID, err = strconv.Atoi(v); err != nil {
+					c.JSON(400, gin.H{"error": fmt.Sprintf("invalid %s_id %q", side, v)})
+					return
+				}
+			}
+			if q.Market == "" && q.MarketID == 0 {
+				c.JSON(400, gin.H{"error": fmt.Sprintf("%s or %s_id is required", side, side)})
+				return
+			}
+			var ok bool
+			if markets[i], ok = historyMarket(foodData, q); !ok {
+				c.JSON(404, gin.H{"error": fmt.Sprintf("Market not found: %s", side)})
+				return
+			}
+		}
+
+		filter := foodData.Taxonomy.Filter([]string{commodity}, expand)
+		if len(filter) == 0 {
+			c.JSON(404, gin.H{"error": "Commodity not found"})
+			return
+		}
+		fromSources, err := spreadSources(foodData, opts, markets[0].ID, filter, priceType, from, to)
+		if err != nil {
+			c.JSON(422, gin.H{"error": err.Error()})
+			return
+		}
+		toSources, err := spreadSources(foodData, opts, markets[1].ID, filter, priceType, from, to)
+		if err != nil {
+			c.JSON(422, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Compare the series the two markets share the most months of
+		type candidate struct {
+			key    spreadKey
+			series SpreadSeries
+			points []SpreadPoint
+		}
+		var candidates []candidate
+		for key, a := range fromSources {
+			b, ok := toSources[key]
+			if !ok {
+				continue
+			}
+			points := spreadPoints(a.monthly, b.monthly, nil)
+			if len(points) == 0 {
+				continue
+			}
+			candidates = append(candidates, candidate{
+				key:    key,
+				points: points,
+				series: SpreadSeries{
+					CommodityID: key.CommodityID,
+					Commodity:   foodData.Taxonomy.LocalName(key.CommodityID, a.Name, opts.Lang),
+					Unit:        key.Unit,
+					PriceType:   key.PriceType.String(),
+					Months:      len(points),
+				},
+			})
+		}
+		if len(candidates) == 0 {
+			c.JSON(404, gin.H{"error": "The two markets have no month with a price of this commodity in common"})
+			return
+		}
+		sort.Slice(candidates, func(i, j int) bool {
+			a, b := candidates[i], candidates[j]
+			if a.series.Months != b.series.Months {
+				return a.series.Months > b.series.Months
+			}
+			return a.points[len(a.points)-1].Month > b.points[len(b.points)-1].Month
+		})
+		best := candidates[0]
+
+		distance := distanceKm(markets[0].Location, markets[1].Location)
+		cost, _, err := opts.convert(foodData, perKmKg, KES, foodData.LastDate())
+		if err != nil {
+			c.JSON(422, gin.H{"error": err.Error()})
+			return
+		}
+		threshold, estimated := transportThreshold(fromSources[best.key].Name, best.key.Unit, distance, cost)
+		points := spreadPoints(fromSources[best.key].monthly, toSources[best.key].monthly, threshold)
+
+		response := SpreadResponse{
+			FromMarket:           markets[0].Summary(),
+			ToMarket:             markets[1].Summary(),
+			DistanceKm:           distance,
+			Currency:             opts.Currency.String(),
+			Real:                 opts.Real,
+			TransportCostPerKmKg: cost,
+			Series:               best.series,
+			Alternatives:         []SpreadSeries{},
+			Stats:                spreadStats(points, threshold),
+			Points:               points,
+		}
+		response.Stats.TransportEst = estimated
+		if opts.Real {
+			response.BaseYear = opts.baseYear(foodData)
+		}
+		for _, alt := range candidates[1:] {
+			response.Alternatives = append(response.Alternatives, alt.series)
+		}
+		c.JSON(200, response)
+	}
+}
+
+// TopSpread is one market pair of /api/analysis/spread/top, oriented so
+// that From is on average the cheaper market.
+type TopSpread struct {
+	FromMarket    MarketSummary `json:"from_market"`
+	ToMarket      MarketSummary `json:"to_market"`
+	DistanceKm    float64       `json:"distance_km"`
+	Series        SpreadSeries  `json:"series"`
+	Stats         SpreadStats   `json:"stats"`
+	NetGap        float64       `json:"net_gap"`         // mean gap minus the transport cost
+	NetGapPercent float64       `json:"net_gap_percent"` // of the mean from price; the ranking key
+}
+
+// TopSpreadsResponse is the body of /api/analysis/spread/top.
+type TopSpreadsResponse struct {
+	Currency             string      `json:"currency"`
+	Real                 bool        `json:"real"`
+	BaseYear             int         `json:"base_year,omitempty"`
+	From                 string      `json:"from,omitempty"`
+	To                   string      `json:"to,omitempty"`
+	MaxDistanceKm        float64     `json:"max_distance_km"`
+	MinMonths            int         `json:"min_months"`
+	TransportCostPerKmKg float64     `json:"transport_cost_per_km_kg"` // in Currency
+	Pairs                int         `json:"pairs"`                    // comparable pairs ranked
+	Skipped              int         `json:"skipped"`                  // pairs in a unit of unknown weight
+	Spreads              []TopSpread `json:"spreads"`
+}
+
+// topSpreadsHandler serves /api/analysis/spread/top, which ranks the
+// market pairs within max_distance_km (default 300) by how far their
+// mean price gap exceeds the cost of moving produce between them.
+// commodity= is optional; from= defaults to two years before the latest
+// data, and pairs need min_months (default 6) months in common.
+func topSpreadsHandler(store *DatasetStore, costPerKmKg float64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		foodData := store.Load()
+		opts, err := parsePriceOptions(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		expand, err := parseVariantsParam(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		priceType, err := parsePriceTypeParam(c.Query("pricetype"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		perKmKg, err := parseTransportCostParam(c, costPerKmKg)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		lastDate := foodData.LastDate()
+		defaultFrom := ""
+		if lastDate != "" {
+			defaultFrom = addMonths(monthOf(lastDate), -23)
+		}
+		from, to, err := spreadWindow(c, defaultFrom)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		maxDistance := 300.0
+		if v := c.Query("max_distance_km"); v != "" {
+			if maxDistance, err = strconv.ParseFloat(v, 64); err != nil || maxDistance <= 0 {
+				c.JSON(400, gin.H{"error": fmt.Sprintf("invalid max_distance_km %q", v)})
+				return
+			}
+		}
+		minMonths := 6
+		if v := c.Query("min_months"); v != "" {
+			if minMonths, err = strconv.Atoi(v); err != nil || minMonths < 1 {
+				c.JSON(400, gin.H{"error": fmt.Sprintf("invalid min_months %q", v)})
+				return
+			}
+		}
+		limit := 10
+		if v := c.Query("limit"); v != "" {
+			if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+				c.JSON(400, gin.H{"error": fmt.Sprintf("invalid limit %q", v)})
+				return
+			}
+		}
+		terms := splitTerms(c.Query("commodity"))
+		filter := foodData.Taxonomy.Filter(terms, expand)
+		if len(terms) > 0 && len(filter) == 0 {
+			c.JSON(404, gin.H{"error": "Commodity not found"})
+			return
+		}
+		cost, _, err := opts.convert(foodData, perKmKg, KES, lastDate)
+		if err != nil {
+			c.JSON(422, gin.H{"error": err.Error()})
+			return
+		}
+
+		sources := make([]map[spreadKey]spreadSource, len(foodData.Markets))
+		for i, market := range foodData.Markets {
+			if sources[i], err = spreadSources(foodData, opts, market.ID, filter, priceType, from, to); err != nil {
+				c.JSON(422, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		response := TopSpreadsResponse{
+			Currency:             opts.Currency.String(),
+			Real:                 opts.Real,
+			From:                 from,
+			To:                   to,
+			MaxDistanceKm:        maxDistance,
+			MinMonths:            minMonths,
+			TransportCostPerKmKg: cost,
+			Spreads:              []TopSpread{},
+		}
+		if opts.Real {
+			response.BaseYear = opts.baseYear(foodData)
+		}
+		markets := foodData.Markets
+		for i := range markets {
+			for j := i + 1; j < len(markets); j++ {
+				distance := distanceKm(markets[i].Location, markets[j].Location)
+				if distance > maxDistance {
+					continue
+				}
+				for key, a := range sources[i] {
+					b, ok := sources[j][key]
+					if !ok {
+						continue
+					}
+					threshold, estimated := transportThreshold(a.Name, key.Unit, distance, cost)
+					points := spreadPoints(a.monthly, b.monthly, threshold)
+					if len(points) < minMonths {
+						continue
+					}
+					if threshold == nil {
+						response.Skipped++
+						continue
+					}
+					cheap, dear := &markets[i], &markets[j]
+					stats := spreadStats(points, threshold)
+					if stats.MeanGap < 0 {
+						cheap, dear = dear, cheap
+						points = spreadPoints(b.monthly, a.monthly, threshold)
+						stats = spreadStats(points, threshold)
+					}
+					stats.TransportEst = estimated
+
+					spread := TopSpread{
+						FromMarket: cheap.Summary(),
+						ToMarket:   dear.Summary(),
+						DistanceKm: distance,
+						Series: SpreadSeries{
+							CommodityID: key.CommodityID,
+							Commodity:   foodData.Taxonomy.LocalName(key.CommodityID, a.Name, opts.Lang),
+							Unit:        key.Unit,
+							PriceType:   key.PriceType.String(),
+							Months:      len(points),
+						},
+						Stats:  stats,
+						NetGap: stats.MeanGap - *threshold,
+					}
+					sumFrom := 0.0
+					for _, p := range points {
+						sumFrom += p.FromPrice
+					}
+					if sumFrom > 0 {
+						spread.NetGapPercent = math.Round(spread.NetGap/(sumFrom/float64(len(points)))*1000) / 10
+					}
+					response.Spreads = append(response.Spreads, spread)
+				}
+			}
+		}
+
+		response.Pairs = len(response.Spreads)
+		sort.Slice(response.Spreads, func(i, j int) bool {
+			a, b := response.Spreads[i], response.Spreads[j]
+			if a.NetGapPercent != b.NetGapPercent {
+				return a.NetGapPercent > b.NetGapPercent
+			}
+			return a.DistanceKm < b.DistanceKm
+		})
+		if len(response.Spreads) > limit {
+			response.Spreads = response.Spreads[:limit]
+		}
+		c.JSON(200, response)
+	}
+}
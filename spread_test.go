@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestSpreadStats(t *testing.T) {
+	a := MonthlySeries{Start: "2024-01", Values: []float64{100, 110, math.NaN(), 120}}
+	b := MonthlySeries{Start: "2024-02", Values: []float64{130, 140, 100, 150}}
+	threshold := func(v float64) *float64 { return &v }
+	share := func(v float64) *float64 { return &v }
+
+	tests := []struct {
+		name       string
+		a, b       MonthlySeries
+		threshold  *float64
+		months     []string
+		above      []bool
+		mean       float64
+		pct        float64
+		volatility float64
+		shareAbove *float64
+		reverse    *float64
+		latest     float64
+	}{
+		{
+			// February is 20 dearer at b, April 20 cheaper; March and May
+			// lack a price at a
+			name: "threshold", a: a, b: b, threshold: threshold(15),
+			months: []string{"2024-02", "2024-04"}, above: []bool{true, false},
+			mean: 0, pct: 0, volatility: math.Sqrt(800),
+			shareAbove: share(0.5), reverse: share(0.5), latest: -20,
+		},
+		{
+			name: "threshold above every gap", a: a, b: b, threshold: threshold(25),
+			months: []string{"2024-02", "2024-04"}, above: []bool{false, false},
+			volatility: math.Sqrt(800), shareAbove: share(0), reverse: share(0), latest: -20,
+		},
+		{
+			name: "unknown weight", a: a, b: b,
+			months: []string{"2024-02", "2024-04"}, above: []bool{false, false},
+			volatility: math.Sqrt(800), latest: -20,
+		},
+		{
+			name: "reversed", a: b, b: a, threshold: threshold(15),
+			months: []string{"2024-02", "2024-04"}, above: []bool{false, true},
+			volatility: math.Sqrt(800), shareAbove: share(0.5), reverse: share(0.5), latest: 20,
+		},
+		{
+			// 10 dearer on 100 and 20 on 110
+			name:   "one way",
+			a:      MonthlySeries{Start: "2024-01", Values: []float64{100, 110}},
+			b:      MonthlySeries{Start: "2024-01", Values: []float64{110, 130}},
+			months: []string{"2024-01", "2024-02"}, above: []bool{false, true}, threshold: threshold(15),
+			mean: 15, pct: 14.3, volatility: math.Sqrt(50), shareAbove: share(0.5), reverse: share(0), latest: 20,
+		},
+		{
+			name: "no month in common", threshold: threshold(15),
+			a: MonthlySeries{Start: "2024-01", Values: []float64{100}},
+			b: MonthlySeries{Start: "2024-02", Values: []float64{100}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			points := spreadPoints(tt.a, tt.b, tt.threshold)
+			if points == nil || len(points) != len(tt.months) {
+				t.Fatalf("points %+v, want months %v", points, tt.months)
+			}
+			for i, p := range points {
+				if p.Month != tt.months[i] || p.Gap != p.ToPrice-p.FromPrice || p.AboveThreshold != tt.above[i] {
+					t.Errorf("point %+v, want %s above=%v", p, tt.months[i], tt.above[i])
+				}
+			}
+
+			stats := spreadStats(points, tt.threshold)
+			if stats.Months != len(tt.months) || stats.MeanGap != tt.mean || stats.MeanGapPct != tt.pct ||
+				math.Abs(stats.Volatility-tt.volatility) > 1e-9 {
+				t.Errorf("%d months, mean %v (%v%%), volatility %v; want %d, %v (%v%%), %v",
+					stats.Months, stats.MeanGap, stats.MeanGapPct, stats.Volatility, len(tt.months), tt.mean, tt.pct, tt.volatility)
+			}
+			sameShare := func(got, want *float64) bool { return (got == nil) == (want == nil) && (got == nil || *got == *want) }
+			if stats.Threshold != tt.threshold || !sameShare(stats.ShareAbove, tt.shareAbove) || !sameShare(stats.ShareReverse, tt.reverse) {
+				t.Errorf("threshold %v: shares %v and %v, want %v and %v", tt.threshold, stats.ShareAbove, stats.ShareReverse, tt.shareAbove, tt.reverse)
+			}
+			if len(points) > 0 && (stats.LatestMonth != tt.months[len(tt.months)-1] || stats.LatestGap != tt.latest) {
+				t.Errorf("latest %s %v, want %v", stats.LatestMonth, stats.LatestGap, tt.latest)
+			}
+		})
+	}
+}
+
+// spreadRows price retail maize from January to June 2024 at three
+// markets along the equator: Near at 50, Far at 70 about 150km east of
+// it and Farther at 55 another 100km east. Far quotes 90kg bags from
+// April. Near and Far also have three months of wholesale.
+func spreadRows() string {
+	var rows strings.Builder
+	row := func(market string, id int, long float64, month int, unit, priceType string, price float64) {
+		fmt.Fprintf(&rows, "2024-%02d-15,Eastern,Kitui,%s,%d,0,%v,cereals and tubers,Maize,51,%s,actual,%s,KES,%v,1\n",
+			month, market, id, long, unit, priceType, price)
+	}
+	for month := 1; month <= 6; month++ {
+		row("Near", 1, 0.45, month, "KG", "Retail", 50)
+		if month < 4 {
+			row("Far", 2, 1.8, month, "KG", "Retail", 70)
+			row("Near", 1, 0.45, month, "KG", "Wholesale", 40)
+			row("Far", 2, 1.8, month, "KG", "Wholesale", 45)
+		} else {
+			row("Far", 2, 1.8, month, "90 KG", "Retail", 70*90)
+		}
+		row("Farther", 3, 2.7, month, "KG", "Retail", 55)
+	}
+	return rows.String()
+}
+
+func TestSpreadSources(t *testing.T) {
+	foodData := csvTestStore(t, spreadRows()).Load()
+	filter := foodData.Taxonomy.Filter([]string{"maize"}, false)
+	sources, err := spreadSources(foodData, priceOptions{Currency: KES}, 2, filter, nil, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	retail := sources[spreadKey{CommodityID: 51, Unit: "kg", PriceType: Retail}]
+	if len(sources) != 2 || fmt.Sprint(retail.Units) != "[90 KG KG]" || retail.monthly.Valid() != 6 {
+		t.Fatalf("sources %+v", sources)
+	}
+	for i, v := range retail.monthly.Values {
+		if math.Abs(v-70) > 1e-9 {
+			t.Errorf("%s: %v a kg, want 70", retail.monthly.Month(i), v)
+		}
+	}
+}
+
+func TestSpreadEndpoint(t *testing.T) {
+	srv := newTestServerOn(t, csvTestStore(t, spreadRows()))
+	distance := distanceKm(Location{Long: 0.45}, Location{Long: 1.8})
+
+	tests := []struct {
+		name       string
+		query      string
+		priceType  string
+		months     int
+		mean       float64
+		threshold  float64
+		shareAbove float64
+		reverse    float64
+	}{
+		{"the series with the most months", "from_market=Near&to_market=Far&transport_cost=0.1", "Retail", 6, 20, 0.1 * distance, 1, 0},
+		{"the other way", "from_market=Far&to_market=Near&transport_cost=0.1", "Retail", 6, -20, 0.1 * distance, 0, 1},
+		{"a gap below the transport cost", "from_market=Near&to_market=Far&transport_cost=0.2", "Retail", 6, 20, 0.2 * distance, 0, 0},
+		{"wholesale", "from_market_id=1&to_market_id=2&pricetype=wholesale&transport_cost=0", "Wholesale", 3, 5, 0, 1, 0},
+		{"date range", "from_market=Near&to_market=Far&from=2024-05&transport_cost=0.1", "Retail", 2, 20, 0.1 * distance, 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resp SpreadResponse
+			if code := getJSON(t, srv.router, "/api/analysis/spread?commodity=maize&"+tt.query, &resp); code != 200 {
+				t.Fatalf("status %d", code)
+			}
+			s := resp.Stats
+			if resp.Series.PriceType != tt.priceType || s.Months != tt.months || len(resp.Points) != tt.months || s.MeanGap != tt.mean {
+				t.Errorf("%s of %d months, mean gap %v; want %s of %d, %v", resp.Series.PriceType, s.Months, s.MeanGap, tt.priceType, tt.months, tt.mean)
+			}
+			if s.Threshold == nil || math.Abs(*s.Threshold-tt.threshold) > 1e-9 || *s.ShareAbove != tt.shareAbove || *s.ShareReverse != tt.reverse {
+				t.Errorf("threshold %v, shares %v and %v; want %v, %v and %v", s.Threshold, s.ShareAbove, s.ShareReverse, tt.threshold, tt.shareAbove, tt.reverse)
+			}
+			if math.Abs(resp.DistanceKm-distance) > 1e-9 {
+				t.Errorf("%v km apart, want %v", resp.DistanceKm, distance)
+			}
+		})
+	}
+
+	var resp SpreadResponse
+	if code := getJSON(t, srv.router, "/api/analysis/spread?commodity=maize&from_market=Near&to_market=Far", &resp); code != 200 {
+		t.Fatalf("status %d", code)
+	}
+	// An earlier transport_cost= doesn't change the default
+	if resp.TransportCostPerKmKg != defaultConfig().TransportCostPerKmKg || len(resp.Alternatives) != 1 || resp.Alternatives[0].Months != 3 {
+		t.Errorf("transport cost %v, alternatives %+v", resp.TransportCostPerKmKg, resp.Alternatives)
+	}
+
+	errors := []struct {
+		query  string
+		status int
+	}{
+		{"from_market=Near&to_market=Far", 400},
+		{"commodity=maize&to_market=Far", 400},
+		{"commodity=maize&from_market_id=one&to_market=Far", 400},
+		{"commodity=maize&from_market=Near&to_market=Far&transport_cost=-1", 400},
+		{"commodity=maize&from_market=Near&to_market=Atlantis", 404},
+		{"commodity=tractor&from_market=Near&to_market=Far", 404},
+		{"commodity=maize&from_market=Near&to_market=Far&from=2025", 404},
+	}
+	for _, tt := range errors {
+		if code := getJSON(t, srv.router, "/api/analysis/spread?"+tt.query, nil); code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.query, code, tt.status)
+		}
+	}
+}
+
+func TestTopSpreads(t *testing.T) {
+	srv := newTestServerOn(t, csvTestStore(t, spreadRows()))
+
+	type pair struct {
+		from, to string
+		pct      float64
+	}
+	// At 0.1 a km, Near -> Far nets 20 less 15 on 50 (10%), Farther -> Far
+	// 15 less 10 on 55 (9.1%) and Near -> Farther 5 less 25 on 50 (-40%)
+	tests := []struct {
+		query string
+		pairs []pair
+	}{
+		{"transport_cost=0.1&pricetype=retail", []pair{{"Near", "Far", 10}, {"Farther", "Far", 9.1}, {"Near", "Farther", -40}}},
+		{"transport_cost=0.1&pricetype=retail&limit=1", []pair{{"Near", "Far", 10}}},
+		{"transport_cost=0.1&pricetype=retail&max_distance_km=200", []pair{{"Near", "Far", 10}, {"Farther", "Far", 9.1}}},
+		{"transport_cost=0&pricetype=retail", []pair{{"Near", "Far", 40}, {"Farther", "Far", 27.3}, {"Near", "Farther", 10}}},
+		{"transport_cost=0&pricetype=wholesale&min_months=3", []pair{{"Near", "Far", 12.5}}},
+		{"transport_cost=0&pricetype=wholesale", nil},
+		{"transport_cost=0.1&pricetype=retail&from=2024-05&min_months=2", []pair{{"Near", "Far", 10}, {"Farther", "Far", 9.1}, {"Near", "Farther", -40}}},
+	}
+	for _, tt := range tests {
+		var resp TopSpreadsResponse
+		if code := getJSON(t, srv.router, "/api/analysis/spread/top?commodity=maize&"+tt.query, &resp); code != 200 {
+			t.Fatalf("%s: status %d", tt.query, code)
+		}
+		var got []pair
+		for _, s := range resp.Spreads {
+			got = append(got, pair{s.FromMarket.Name, s.ToMarket.Name, s.NetGapPercent})
+			if s.NetGap != s.Stats.MeanGap-*s.Stats.Threshold || s.Stats.MeanGap < 0 {
+				t.Errorf("%s: %s -> %s nets %v of a mean gap %v", tt.query, s.FromMarket.Name, s.ToMarket.Name, s.NetGap, s.Stats.MeanGap)
+			}
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.pairs) {
+			t.Errorf("%s: %v, want %v", tt.query, got, tt.pairs)
+		}
+	}
+
+	for _, query := range []string{"max_distance_km=0", "min_months=0", "limit=none", "transport_cost=free", "commodity=tractor"} {
+		if code := getJSON(t, srv.router, "/api/analysis/spread/top?"+query, nil); code == 200 {
+			t.Errorf("%s: status 200", query)
+		}
+	}
+}
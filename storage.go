@@ -0,0 +1,454 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// ==================== EMBEDDED STORAGE ====================
+
+// storageSchema is the version of the collections' layout. Bump it and add
+// a migration to storageMigrations when stored documents change shape.
+const storageSchema = 1
+
+const (
+	storageSnapshotFile = "snapshot.json"
+	storageLogFile      = "wal.log"
+	storageLockFile     = "LOCK"
+	compactEntries      = 1000    // log entries that trigger a compaction
+	compactBytes        = 8 << 20 // log size that triggers a compaction
+)
+
+// storageMigrations upgrade the collections from the schema version they
+// are keyed by to the next one.
+var storageMigrations = map[int]func(collections) error{}
+
+// collections holds the JSON documents of every collection by key.
+type collections map[string]map[string]json.RawMessage
+
+// snapshotFile is the on-disk format of a snapshot.
+type snapshotFile struct {
+	Schema      int         `json:"schema"`
+	Seq         int64       `json:"seq"` // last log entry included
+	Collections collections `json:"collections"`
+}
+
+// logOp is one write of a transaction. A nil Value deletes the key.
+type logOp struct {
+	Collection string          `json:"c"`
+	Key        string          `json:"k"`
+	Value      json.RawMessage `json:"v,omitempty"`
+}
+
+// logEntry is one committed transaction.
+type logEntry struct {
+	Seq int64   `json:"seq"`
+	Ops []logOp `json:"ops"`
+}
+
+// Storage is an embedded document store for data written through the
+// API. Every committed transaction is appended to a write-ahead log as a
+// single checksummed line and synced before it becomes visible; the log
+// is folded into a snapshot file once it grows. After a crash the
+// snapshot is loaded and the log replayed, dropping a torn last line.
+// Only one process may open a directory at a time; OpenStorage locks it.
+type Storage struct {
+	dir  string
+	lock *os.File // holds the lock on the directory until Close
+
+	mu         sync.RWMutex
+	schema     int
+	seq        int64
+	data       collections
+	log        *os.File
+	logEntries int
+	logBytes   int64
+}
+
+// errStorageLocked is returned when another process has the directory open.
+var errStorageLocked = errors.New("in use by another process")
+
+// OpenStorage opens the store in dir, creating it if needed, and migrates
+// it to the current schema. It fails if another process has dir open.
+func OpenStorage(dir string) (s *Storage, err error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("storage: %w", err)
+	}
+	lock, err := os.OpenFile(filepath.Join(dir, storageLockFile), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("storage: %w", err)
+	}
+	if err := lockFile(lock); err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("storage %s: %w", dir, err)
+	}
+	defer func() {
+		if err != nil {
+			lock.Close()
+		}
+	}()
+	s = &Storage{dir: dir, lock: lock, schema: storageSchema, data: make(collections)}
+
+	fresh := false
+	raw, err := os.ReadFile(s.path(storageSnapshotFile))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		fresh = true
+	case err != nil:
+		return nil, fmt.Errorf("storage: %w", err)
+	default:
+		var snap snapshotFile
+		if err := json.Unmarshal(raw, &snap); err != nil {
+			return nil, fmt.Errorf("storage %s: %w", s.path(storageSnapshotFile), err)
+		}
+		if snap.Schema > storageSchema {
+			return nil, fmt.Errorf("storage %s: schema %d is newer than this server's %d", dir, snap.Schema, storageSchema)
+		}
+		s.schema, s.seq = snap.Schema, snap.Seq
+		if snap.Collections != nil {
+			s.data = snap.Collections
+		}
+	}
+
+	if err := s.replay(); err != nil {
+		return nil, err
+	}
+	migrated := false
+	for s.schema < storageSchema {
+		if migrate := storageMigrations[s.schema]; migrate != nil {
+			if err := migrate(s.data); err != nil {
+				return nil, fmt.Errorf("storage: migrating schema %d: %w", s.schema, err)
+			}
+		}
+		s.schema++
+		migrated = true
+	}
+
+	if s.log, err = os.OpenFile(s.path(storageLogFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644); err != nil {
+		return nil, fmt.Errorf("storage: %w", err)
+	}
+	if fresh || migrated || s.logEntries >= compactEntries {
+		if err := s.compact(); err != nil {
+			s.log.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *Storage) path(name string) string {
+	return filepath.Join(s.dir, name)
+}
+
+// replay applies the log entries newer than the snapshot. A last line
+// that is incomplete or fails its checksum was torn by a crash and is cut
+// off; a bad line followed by more data means the log is corrupt.
+func (s *Storage) replay() error {
+	path := s.path(storageLogFile)
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("storage: %w", err)
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var offset int64
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("storage %s: %w", path, err)
+		}
+		if len(line) == 0 {
+			break
+		}
+		entry, ok := decodeLogLine(line)
+		if !ok {
+			if _, perr := r.Peek(1); perr == nil {
+				return fmt.Errorf("storage %s: corrupt entry at offset %d", path, offset)
+			}
+			log.Printf("⚠️  storage: dropping torn entry at the end of %s", path)
+			if err := os.Truncate(path, offset); err != nil {
+				return fmt.Errorf("storage: %w", err)
+			}
+			break
+		}
+		offset += int64(len(line))
+		s.logEntries++
+		if entry.Seq <= s.seq {
+			continue // already in the snapshot
+		}
+		if entry.Seq != s.seq+1 {
+			return fmt.Errorf("storage %s: entry %d follows %d", path, entry.Seq, s.seq)
+		}
+		s.apply(entry)
+	}
+	s.logBytes = offset
+	return nil
+}
+
+// encodeLogLine writes an entry as "<crc32> <json>\n".
+func encodeLogLine(entry logEntry) ([]byte, error) {
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	return fmt.Appendf(nil, "%08x %s\n", crc32.ChecksumIEEE(raw), raw), nil
+}
+
+// decodeLogLine parses a line of encodeLogLine, reporting false if it is
+// incomplete or damaged.
+func decodeLogLine(line []byte) (logEntry, bool) {
+	var entry logEntry
+	line, ok := bytes.CutSuffix(line, []byte("\n"))
+	if !ok || len(line) < 10 || line[8] != ' ' {
+		return entry, false
+	}
+	sum, err := strconv.ParseUint(string(line[:8]), 16, 32)
+	if err != nil || uint32(sum) != crc32.ChecksumIEEE(line[9:]) {
+		return entry, false
+	}
+	if err := json.Unmarshal(line[9:], &entry); err != nil {
+		return entry, false
+	}
+	return entry, true
+}
+
+// apply makes a committed entry visible.
+func (s *Storage) apply(entry logEntry) {
+	for _, op := range entry.Ops {
+		docs := s.data[op.Collection]
+		if op.Value == nil {
+			delete(docs, op.Key)
+			if len(docs) == 0 {
+				delete(s.data, op.Collection)
+			}
+			continue
+		}
+		if docs == nil {
+			docs = make(map[string]json.RawMessage)
+			s.data[op.Collection] = docs
+		}
+		docs[op.Key] = op.Value
+	}
+	s.seq = entry.Seq
+}
+
+// compact writes a snapshot of everything committed and empties the log.
+// The snapshot records the last entry it includes, so a crash between the
+// two steps only replays entries that are skipped.
+func (s *Storage) compact() error {
+	raw, err := json.Marshal(snapshotFile{Schema: s.schema, Seq: s.seq, Collections: s.data})
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.path(storageSnapshotFile), raw); err != nil {
+		return fmt.Errorf("storage: snapshot: %w", err)
+	}
+	if err := s.log.Truncate(0); err != nil {
+		return fmt.Errorf("storage: %w", err)
+	}
+	if err := s.log.Sync(); err != nil {
+		return fmt.Errorf("storage: %w", err)
+	}
+	s.logEntries, s.logBytes = 0, 0
+	return nil
+}
+
+// writeFileAtomic replaces path with data through a synced temporary
+// file in the same directory.
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	// Make the rename itself durable
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// Compact folds the log into a new snapshot.
+func (s *Storage) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.log == nil {
+		return errors.New("storage is closed")
+	}
+	return s.compact()
+}
+
+// Close closes the log. Committed data is already on disk.
+func (s *Storage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.log == nil {
+		return nil
+	}
+	err := s.log.Close()
+	s.log = nil
+	// Closing the file releases the lock
+	if cerr := s.lock.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// View runs fn with a read-only transaction.
+func (s *Storage) View(fn func(tx *Tx) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return fn(&Tx{s: s})
+}
+
+// Update runs fn with a read-write transaction and commits its writes as
+// one log entry, all or nothing. Nothing is written if fn fails.
+func (s *Storage) Update(fn func(tx *Tx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.log == nil {
+		return errors.New("storage is closed")
+	}
+
+	tx := &Tx{s: s, writable: true, pending: make(map[[2]string]json.RawMessage)}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if len(tx.ops) == 0 {
+		return nil
+	}
+	entry := logEntry{Seq: s.seq + 1, Ops: tx.ops}
+	line, err := encodeLogLine(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := s.log.Write(line); err != nil {
+		s.log.Truncate(s.logBytes) // don't leave half an entry behind
+		return fmt.Errorf("storage: %w", err)
+	}
+	if err := s.log.Sync(); err != nil {
+		s.log.Truncate(s.logBytes)
+		return fmt.Errorf("storage: %w", err)
+	}
+	s.apply(entry)
+	s.logEntries++
+	s.logBytes += int64(len(line))
+
+	if s.logEntries >= compactEntries || s.logBytes >= compactBytes {
+		if err := s.compact(); err != nil {
+			// The log still has everything; try again after the next write
+			log.Printf("⚠️  %v", err)
+		}
+	}
+	return nil
+}
+
+// Tx is a transaction of View or Update. Reads see the transaction's own
+// writes.
+type Tx struct {
+	s        *Storage
+	writable bool
+	ops      []logOp
+	pending  map[[2]string]json.RawMessage // collection, key -> value, nil when deleted
+}
+
+// Get decodes the document at key into v, reporting false if there is none.
+func (tx *Tx) Get(collection, key string, v any) (bool, error) {
+	raw, ok := tx.pending[[2]string{collection, key}]
+	if !ok {
+		raw, ok = tx.s.data[collection][key]
+	}
+	if !ok || raw == nil {
+		return false, nil
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return false, fmt.Errorf("storage %s/%s: %w", collection, key, err)
+	}
+	return true, nil
+}
+
+// Put stores v at key.
+func (tx *Tx) Put(collection, key string, v any) error {
+	if !tx.writable {
+		return errors.New("storage: read-only transaction")
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tx.ops = append(tx.ops, logOp{Collection: collection, Key: key, Value: raw})
+	tx.pending[[2]string{collection, key}] = raw
+	return nil
+}
+
+// Delete removes key.
+func (tx *Tx) Delete(collection, key string) error {
+	if !tx.writable {
+		return errors.New("storage: read-only transaction")
+	}
+	tx.ops = append(tx.ops, logOp{Collection: collection, Key: key})
+	tx.pending[[2]string{collection, key}] = nil
+	return nil
+}
+
+// Scan calls fn with every document of collection in key order, stopping
+// at the first error.
+func (tx *Tx) Scan(collection string, fn func(key string, raw json.RawMessage) error) error {
+	docs := tx.s.data[collection]
+	keys := make([]string, 0, len(docs))
+	for key := range docs {
+		if _, ok := tx.pending[[2]string{collection, key}]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	for k, raw := range tx.pending {
+		if k[0] == collection && raw != nil {
+			keys = append(keys, k[1])
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		raw, ok := tx.pending[[2]string{collection, key}]
+		if !ok {
+			raw = docs[key]
+		}
+		if err := fn(key, raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func openTestStorage(t *testing.T, dir string) *Storage {
+	t.Helper()
+	s, err := OpenStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func putDoc(t *testing.T, s *Storage, key, value string) {
+	t.Helper()
+	if err := s.Update(func(tx *Tx) error { return tx.Put("docs", key, value) }); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkDocs compares the docs collection with want.
+func checkDocs(t *testing.T, s *Storage, want map[string]string) {
+	t.Helper()
+	got := make(map[string]string)
+	err := s.View(func(tx *Tx) error {
+		return tx.Scan("docs", func(key string, raw json.RawMessage) error {
+			var v string
+			if err := json.Unmarshal(raw, &v); err != nil {
+				return err
+			}
+			got[key] = v
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("docs %v, want %v", got, want)
+	}
+}
+
+func appendFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStorageReplay(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestStorage(t, dir)
+	putDoc(t, s, "a", "1")
+	putDoc(t, s, "b", "2")
+	if err := s.Update(func(tx *Tx) error {
+		if err := tx.Put("docs", "c", "3"); err != nil {
+			return err
+		}
+		return tx.Delete("docs", "a")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	failed := errors.New("rolled back")
+	if err := s.Update(func(tx *Tx) error {
+		tx.Put("docs", "d", "4")
+		return failed
+	}); !errors.Is(err, failed) {
+		t.Fatalf("got %v, want %v", err, failed)
+	}
+	s.Close()
+
+	s = openTestStorage(t, dir)
+	defer s.Close()
+	checkDocs(t, s, map[string]string{"b": "2", "c": "3"})
+}
+
+func TestStorageTornTail(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestStorage(t, dir)
+	putDoc(t, s, "a", "1")
+	s.Close()
+
+	logPath := filepath.Join(dir, storageLogFile)
+	before, err := os.Stat(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, err := encodeLogLine(logEntry{Seq: 2, Ops: []logOp{{Collection: "docs", Key: "b", Value: []byte(`"2"`)}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		tail []byte
+	}{
+		{"half a line", line[:len(line)/2]},
+		{"no newline", line[:len(line)-1]},
+		{"bad checksum", append([]byte("00000000"), line[8:]...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			appendFile(t, logPath, tt.tail)
+			s := openTestStorage(t, dir)
+			checkDocs(t, s, map[string]string{"a": "1"})
+			if after, err := os.Stat(logPath); err != nil || after.Size() != before.Size() {
+				t.Fatalf("log not cut back to %d bytes: %v, %v", before.Size(), after, err)
+			}
+			s.Close()
+		})
+	}
+
+	// Writes after the cut land where the torn entry was
+	s = openTestStorage(t, dir)
+	putDoc(t, s, "b", "2")
+	s.Close()
+	s = openTestStorage(t, dir)
+	defer s.Close()
+	checkDocs(t, s, map[string]string{"a": "1", "b": "2"})
+}
+
+func TestStorageCorruptLog(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestStorage(t, dir)
+	putDoc(t, s, "a", "1")
+	s.Close()
+
+	line, err := encodeLogLine(logEntry{Seq: 3, Ops: []logOp{{Collection: "docs", Key: "c", Value: []byte(`"3"`)}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A damaged entry with more after it is not a torn tail
+	appendFile(t, filepath.Join(dir, storageLogFile), append([]byte("garbage\n"), line...))
+	if _, err := OpenStorage(dir); err == nil || !strings.Contains(err.Error(), "corrupt") {
+		t.Fatalf("got %v, want a corrupt log", err)
+	}
+}
+
+func TestStorageReplayAfterCompaction(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestStorage(t, dir)
+	putDoc(t, s, "a", "1")
+	putDoc(t, s, "b", "2")
+	if err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	putDoc(t, s, "c", "3")
+	if err := s.Update(func(tx *Tx) error { return tx.Delete("docs", "a") }); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s = openTestStorage(t, dir)
+	checkDocs(t, s, map[string]string{"b": "2", "c": "3"})
+	putDoc(t, s, "d", "4")
+	s.Close()
+
+	s = openTestStorage(t, dir)
+	defer s.Close()
+	checkDocs(t, s, map[string]string{"b": "2", "c": "3", "d": "4"})
+}
+
+func TestStorageCompactionThreshold(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestStorage(t, dir)
+	for i := 0; i < compactEntries; i++ {
+		putDoc(t, s, fmt.Sprint(i), fmt.Sprint(i))
+	}
+	if info, err := os.Stat(filepath.Join(dir, storageLogFile)); err != nil || info.Size() != 0 {
+		t.Fatalf("log not folded into the snapshot after %d entries: %v, %v", compactEntries, info, err)
+	}
+	putDoc(t, s, "last", "x")
+	s.Close()
+
+	s = openTestStorage(t, dir)
+	defer s.Close()
+	var v string
+	if err := s.View(func(tx *Tx) error {
+		for _, key := range []string{"0", fmt.Sprint(compactEntries - 1), "last"} {
+			if ok, err := tx.Get("docs", key, &v); !ok || err != nil {
+				return fmt.Errorf("%s: %v, %v", key, ok, err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A crash after the snapshot is renamed into place but before the log is
+// truncated leaves entries in the log that the snapshot already has.
+func TestStorageCrashBeforeLogTruncation(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, storageLogFile)
+	s := openTestStorage(t, dir)
+	putDoc(t, s, "a", "1")
+	if err := s.Update(func(tx *Tx) error { return tx.Delete("docs", "a") }); err != nil {
+		t.Fatal(err)
+	}
+	putDoc(t, s, "b", "2")
+	stale, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	if err := os.WriteFile(logPath, stale, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openTestStorage(t, dir)
+	checkDocs(t, s, map[string]string{"b": "2"})
+	putDoc(t, s, "c", "3")
+	s.Close()
+
+	s = openTestStorage(t, dir)
+	defer s.Close()
+	checkDocs(t, s, map[string]string{"b": "2", "c": "3"})
+}
+
+func TestStorageSchema(t *testing.T) {
+	writeSnapshot := func(t *testing.T, dir string, schema int) {
+		t.Helper()
+		raw := fmt.Sprintf(`{"schema":%d,"seq":1,"collections":{"docs":{"a":"1"}}}`, schema)
+		if err := os.WriteFile(filepath.Join(dir, storageSnapshotFile), []byte(raw), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("newer", func(t *testing.T) {
+		dir := t.TempDir()
+		writeSnapshot(t, dir, storageSchema+1)
+		if _, err := OpenStorage(dir); err == nil || !strings.Contains(err.Error(), "newer") {
+			t.Fatalf("got %v, want a schema error", err)
+		}
+		// The failed open doesn't keep the directory locked
+		os.Remove(filepath.Join(dir, storageSnapshotFile))
+		s := openTestStorage(t, dir)
+		s.Close()
+	})
+
+	t.Run("older", func(t *testing.T) {
+		dir := t.TempDir()
+		writeSnapshot(t, dir, storageSchema-1)
+		storageMigrations[storageSchema-1] = func(c collections) error {
+			c["docs"]["migrated"] = []byte(`"yes"`)
+			return nil
+		}
+		defer delete(storageMigrations, storageSchema-1)
+
+		s := openTestStorage(t, dir)
+		checkDocs(t, s, map[string]string{"a": "1", "migrated": "yes"})
+		s.Close()
+
+		// The migrated data was saved at the current schema
+		raw, err := os.ReadFile(filepath.Join(dir, storageSnapshotFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(raw), fmt.Sprintf(`"schema":%d`, storageSchema)) {
+			t.Fatalf("snapshot %s, want schema %d", raw, storageSchema)
+		}
+	})
+}
+
+func TestStorageLocked(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestStorage(t, dir)
+	if _, err := OpenStorage(dir); !errors.Is(err, errStorageLocked) {
+		t.Fatalf("second open: got %v, want %v", err, errStorageLocked)
+	}
+	s.Close()
+
+	s = openTestStorage(t, dir)
+	s.Close()
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== SYNC STORE ====================
+
+// Entities the PWA syncs, as named in its sync queue.
+var syncEntities = map[string]bool{
+	"crop":     true,
+	"pest":     true,
+	"diary":    true,
+	"calendar": true,
+}
+
+// Sync operations.
+const (
+	OpCreate = "create"
+	OpUpdate = "update"
+	OpDelete = "delete"
+)
+
+// Outcomes of a sync operation.
+const (
+	SyncApplied  = "applied"  // the record now holds the op's data
+	SyncConflict = "conflict" // the server's copy won; see the returned record
+	SyncRejected = "rejected" // the op is invalid
+)
+
+const (
+	maxSyncOps     = 500                 // per request
+	maxSyncChanges = 500                 // per response
+	maxSyncBody    = 4 << 20             // bytes
+	syncKeyTTL     = 30 * 24 * time.Hour // how long idempotency keys are remembered
+)
+
+// SyncRecord is the server copy of a client record. Deleted records stay
+// as tombstones so other devices learn about the delete.
+type SyncRecord struct {
+	UserID    string          `json:"user_id,omitempty"`
+	Entity    string          `json:"entity"`
+	ID        string          `json:"id"` // client-generated
+	Version   int             `json:"version"`
+	UpdatedAt time.Time       `json:"updated_at"` // client clock of the last applied change
+	Deleted   bool            `json:"deleted,omitempty"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	Seq       int64           `json:"seq"` // server change sequence, see SyncResponse.Cursor
+}
+
+// SyncOp is one queued change of a client.
+type SyncOp struct {
+	IdempotencyKey string          `json:"idempotency_key"`
+	Op             string          `json:"op"` // create, update or delete
+	Entity         string          `json:"entity"`
+	ID             string          `json:"id"`
+	BaseVersion    int             `json:"base_version"` // version the client last saw, 0 for new records
+	UpdatedAt      time.Time       `json:"updated_at"`
+	Data           json.RawMessage `json:"data,omitempty"`
+}
+
+// SyncResult is the outcome of one SyncOp.
+type SyncResult struct {
+	IdempotencyKey string      `json:"idempotency_key"`
+	Status         string      `json:"status"`
+	Error          string      `json:"error,omitempty"`
+	Record         *SyncRecord `json:"record,omitempty"`    // the server copy after the op
+	Duplicate      bool        `json:"duplicate,omitempty"` // a retry; this is the first attempt's result
+}
+
+// appliedKey remembers the result of an idempotency key.
+type appliedKey struct {
+	UserID string     `json:"user_id,omitempty"`
+	Key    string     `json:"key"`
+	At     time.Time  `json:"at"`
+	Result SyncResult `json:"result"`
+}
+
+// recordKey identifies a record.
+type recordKey struct {
+	UserID string
+	Entity string
+	ID     string
+}
+
+// SyncStore applies sync batches on top of the sync repositories.
+type SyncStore struct {
+	records SyncRepository
+	cursors SyncCursorRepository
+
+	mu        sync.Mutex // serializes batches
+	lastPrune time.Time
+}
+
+// NewSyncStore returns a SyncStore keeping its records in records and the
+// cursors of devices in cursors.
+func NewSyncStore(records SyncRepository, cursors SyncCursorRepository) *SyncStore {
+	return &SyncStore{records: records, cursors: cursors}
+}
+
+// errNoSyncUser is returned for ops without a user. Records are never
+// shared between users, so there is no anonymous namespace to sync into.
+var errNoSyncUser = errors.New("sync needs a signed-in user")
+
+// Apply runs the ops of userID in order and commits the batch in one
+// write. If the commit fails, nothing of the batch is kept.
+func (s *SyncStore) Apply(userID string, ops []SyncOp, now time.Time) ([]SyncResult, error) {
+	if userID == "" {
+		return nil, errNoSyncUser
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastPrune) > time.Hour {
+		if err := s.records.PruneKeys(now.Add(-syncKeyTTL)); err != nil {
+			return nil, err
+		}
+		s.lastPrune = now
+	}
+
+	results := make([]SyncResult, 0, len(ops))
+	batch := make(map[recordKey]*SyncRecord) // latest version of each record changed so far
+	seen := make(map[string]*appliedKey)
+	var changed []*SyncRecord
+	var keys []*appliedKey
+	for _, op := range ops {
+		if op.IdempotencyKey != "" {
+			k, ok := seen[op.IdempotencyKey]
+			if !ok {
+				var err error
+				if k, ok, err = s.records.KeyResult(userID, op.IdempotencyKey); err != nil {
+					return nil, err
+				}
+			}
+			if ok {
+				result := k.Result
+				result.Duplicate = true
+				results = append(results, result)
+				continue
+			}
+		}
+
+		result := SyncResult{IdempotencyKey: op.IdempotencyKey, Status: SyncRejected}
+		if err := op.validate(); err != nil {
+			result.Error = err.Error()
+			results = append(results, result)
+			continue
+		}
+		key := recordKey{userID, op.Entity, op.ID}
+		current, ok := batch[key]
+		if !ok {
+			var err error
+			if current, _, err = s.records.Record(userID, op.Entity, op.ID); err != nil {
+				return nil, err
+			}
+		}
+		result = resolveSyncOp(userID, op, current, now)
+		if result.Status == SyncApplied {
+			batch[key] = result.Record
+			changed = append(changed, result.Record)
+		}
+		if op.IdempotencyKey != "" {
+			k := &appliedKey{UserID: userID, Key: op.IdempotencyKey, At: now, Result: result}
+			seen[op.IdempotencyKey] = k
+			keys = append(keys, k)
+		}
+		results = append(results, result)
+	}
+
+	if len(changed) > 0 || len(keys) > 0 {
+		if err := s.records.Commit(changed, keys); err != nil {
+			return nil, fmt.Errorf("sync store: %w", err)
+		}
+	}
+	return results, nil
+}
+
+// resolveSyncOp applies a valid op to current, the server copy (nil if
+// there is none). An op based on the current version always applies. One
+// based on an older version, i.e. made while another device changed the
+// record, applies only if it was made later than the change it would
+// overwrite; otherwise the server copy wins.
+func resolveSyncOp(userID string, op SyncOp, current *SyncRecord, now time.Time) SyncResult {
+	result := SyncResult{IdempotencyKey: op.IdempotencyKey}
+	if op.UpdatedAt.IsZero() {
+		op.UpdatedAt = now
+	}
+
+	if current != nil {
+		stale := op.BaseVersion != current.Version
+		if op.Op == OpCreate && !current.Deleted && op.BaseVersion == 0 {
+			stale = true // the id is taken
+		}
+		if stale && !op.UpdatedAt.After(current.UpdatedAt) {
+			result.Status = SyncConflict
+			result.Error = fmt.Sprintf("server has version %d, changed %s", current.Version, current.UpdatedAt.Format(time.RFC3339))
+			result.Record = current
+			return result
+		}
+	} else {
+		// Updates of unknown records create them, and deletes leave a
+		// tombstone so the id stays deleted on every device
+		current = &SyncRecord{}
+	}
+
+	record := &SyncRecord{
+		UserID:    userID,
+		Entity:    op.Entity,
+		ID:        op.ID,
+		Version:   current.Version + 1,
+		UpdatedAt: op.UpdatedAt,
+		Deleted:   op.Op == OpDelete,
+	}
+	if !record.Deleted {
+		record.Data = op.Data
+	}
+	result.Status = SyncApplied
+	result.Record = record
+	return result
+}
+
+// validate checks an op before it is applied.
+func (op SyncOp) validate() error {
+	switch op.Op {
+	case OpCreate, OpUpdate, OpDelete:
+	default:
+		return fmt.Errorf("unsupported op %q (use create, update or delete)", op.Op)
+	}
+	if !syncEntities[op.Entity] {
+		return fmt.Errorf("unsupported entity %q", op.Entity)
+	}
+	if op.ID == "" || len(op.ID) > 128 || strings.ContainsRune(op.ID, 0x1f) {
+		return fmt.Errorf("id must be 1 to 128 characters")
+	}
+	if op.BaseVersion < 0 {
+		return fmt.Errorf("base_version must not be negative")
+	}
+	if op.Op != OpDelete {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(op.Data, &fields); err != nil || fields == nil {
+			return fmt.Errorf("data must be a JSON object")
+		}
+	}
+	return nil
+}
+
+// Changes returns the records of userID changed after cursor, see
+// SyncRepository.Changes.
+func (s *SyncStore) Changes(userID string, cursor int64, limit int) ([]*SyncRecord, int64, bool, error) {
+	if userID == "" {
+		return nil, 0, false, errNoSyncUser
+	}
+	return s.records.Changes(userID, cursor, limit)
+}
+
+// ==================== SYNC ENDPOINT ====================
+
+// SyncRequest is the body of POST /api/sync.
+type SyncRequest struct {
+	Cursor   string   `json:"cursor"`    // from the previous response, "" on first sync
+	DeviceID string   `json:"device_id"` // optional; without a cursor, resumes from the device's last one
+	Ops      []SyncOp `json:"ops"`
+}
+
+// SyncResponse is the answer to POST /api/sync.
+type SyncResponse struct {
+	Results []SyncResult  `json:"results"` // one per op, in order
+	Changes []*SyncRecord `json:"changes"` // changed since the request's cursor, including this batch
+	Cursor  string        `json:"cursor"`  // pass back on the next sync
+	HasMore bool          `json:"has_more"`
+}
+
+// syncHandler serves POST /api/sync. It applies the client's queued ops
+// in order, then returns what changed on the server since its cursor.
+// Resending a batch is safe: ops whose idempotency key was already seen
+// return their first result.
+func syncHandler(store *SyncStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Only the session identifies the user, see authenticate
+		userID := requestUserID(c)
+		if userID == "" {
+			c.JSON(401, gin.H{"error": "sign in with your phone number first"})
+			return
+		}
+		var req SyncRequest
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSyncBody)
+		if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("invalid sync request: %v", err)})
+			return
+		}
+		if len(req.Ops) > maxSyncOps {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("too many ops (at most %d per request)", maxSyncOps)})
+			return
+		}
+		var cursor int64
+		switch {
+		case req.Cursor != "":
+			var err error
+			if cursor, err = strconv.ParseInt(req.Cursor, 10, 64); err != nil || cursor < 0 {
+				c.JSON(400, gin.H{"error": fmt.Sprintf("invalid cursor %q", req.Cursor)})
+				return
+			}
+		case req.DeviceID != "":
+			var err error
+			if cursor, _, err = store.cursors.Cursor(userID, req.DeviceID); err != nil {
+				log.Printf("⚠️  %v", err)
+				c.JSON(500, gin.H{"error": "could not read the sync cursor, retry later"})
+				return
+			}
+		}
+
+		results, err := store.Apply(userID, req.Ops, time.Now().UTC())
+		if err != nil {
+			log.Printf("⚠️  %v", err)
+			c.JSON(500, gin.H{"error": "could not save the changes, retry later"})
+			return
+		}
+		changes, next, more, err := store.Changes(userID, cursor, maxSyncChanges)
+		if err != nil {
+			log.Printf("⚠️  %v", err)
+			c.JSON(500, gin.H{"error": "could not read the changes, retry later"})
+			return
+		}
+		if req.DeviceID != "" {
+			if err := store.cursors.SetCursor(userID, req.DeviceID, next); err != nil {
+				log.Printf("⚠️  %v", err)
+			}
+		}
+		response := SyncResponse{
+			Results: results,
+			Changes: changes,
+			Cursor:  strconv.FormatInt(next, 10),
+			HasMore: more,
+		}
+		if response.Changes == nil {
+			response.Changes = []*SyncRecord{}
+		}
+		c.JSON(200, response)
+	}
+}
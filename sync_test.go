@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+var syncT0 = time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC)
+
+func cropOp(key, op, id string, base int, at time.Duration, name string) SyncOp {
+	o := SyncOp{IdempotencyKey: key, Op: op, Entity: "crop", ID: id, BaseVersion: base, UpdatedAt: syncT0.Add(at)}
+	if op != OpDelete {
+		o.Data = json.RawMessage(fmt.Sprintf(`{"name":%q}`, name))
+	}
+	return o
+}
+
+// postSync posts req as token and fails the test unless it succeeds.
+func postSync(t *testing.T, srv *testServer, token string, req SyncRequest) SyncResponse {
+	t.Helper()
+	var resp SyncResponse
+	if code := doJSON(t, srv.router, "POST", "/api/sync", token, req, &resp); code != 200 {
+		t.Fatalf("POST /api/sync: status %d", code)
+	}
+	if len(resp.Results) != len(req.Ops) {
+		t.Fatalf("%d results for %d ops", len(resp.Results), len(req.Ops))
+	}
+	return resp
+}
+
+func TestSyncRequiresSession(t *testing.T) {
+	srv := newTestServer(t)
+	req := SyncRequest{Ops: []SyncOp{cropOp("k1", OpCreate, "a", 0, 0, "maize")}}
+	for _, token := range []string{"", "not a token"} {
+		if code := doJSON(t, srv.router, "POST", "/api/sync", token, req, nil); code != 401 {
+			t.Errorf("token %q: status %d, want 401", token, code)
+		}
+	}
+}
+
+func TestSyncIdempotency(t *testing.T) {
+	srv := newTestServer(t)
+	token := srv.signInUser(t, "+254712345678")
+	req := SyncRequest{Ops: []SyncOp{
+		cropOp("k1", OpCreate, "a", 0, 0, "maize"),
+		cropOp("k1", OpCreate, "a", 0, time.Minute, "beans"), // retried within the batch
+		cropOp("k2", OpUpdate, "a", 1, time.Minute, "sorghum"),
+		cropOp("k3", "rename", "a", 1, time.Minute, "millet"),
+	}}
+
+	first := postSync(t, srv, token, req)
+	wantStatus := []string{SyncApplied, SyncApplied, SyncApplied, SyncRejected}
+	for i, r := range first.Results {
+		if r.Status != wantStatus[i] || r.Duplicate != (i == 1) {
+			t.Errorf("op %d: %s duplicate=%v, want %s duplicate=%v", i, r.Status, r.Duplicate, wantStatus[i], i == 1)
+		}
+	}
+	if v := first.Results[1].Record.Version; v != 1 {
+		t.Errorf("retried create: version %d, want the first attempt's 1", v)
+	}
+	if len(first.Changes) != 1 || first.Changes[0].Version != 2 || string(first.Changes[0].Data) != `{"name":"sorghum"}` {
+		t.Fatalf("changes %+v, want crop a at version 2", first.Changes)
+	}
+
+	// The whole batch again, as after a lost response. Invalid ops aren't
+	// remembered; they are rejected again.
+	second := postSync(t, srv, token, req)
+	for i, r := range second.Results {
+		if r.Duplicate != (r.Status != SyncRejected) || r.Status != first.Results[i].Status {
+			t.Errorf("resent op %d: %s duplicate=%v, want the first result", i, r.Status, r.Duplicate)
+		}
+	}
+	if len(second.Changes) != 1 || second.Changes[0].Version != 2 {
+		t.Fatalf("changes after resending %+v, want crop a still at version 2", second.Changes)
+	}
+
+	// Keys are per user
+	other := postSync(t, srv, srv.signInUser(t, "+254722345678"), SyncRequest{Ops: req.Ops[:1]})
+	if r := other.Results[0]; r.Duplicate || r.Status != SyncApplied || r.Record.Version != 1 {
+		t.Errorf("another user's op: %+v, want applied", r)
+	}
+}
+
+func TestSyncConflicts(t *testing.T) {
+	tests := []struct {
+		name        string
+		setup       []SyncOp // applied before op
+		op          SyncOp
+		wantStatus  string
+		wantVersion int
+		wantDeleted bool
+		wantName    string
+	}{
+		{
+			name:        "update of the current version",
+			setup:       []SyncOp{cropOp("", OpCreate, "a", 0, 0, "maize")},
+			op:          cropOp("", OpUpdate, "a", 1, -time.Hour, "beans"),
+			wantStatus:  SyncApplied,
+			wantVersion: 2,
+			wantName:    "beans",
+		},
+		{
+			name: "stale update made after the server copy",
+			setup: []SyncOp{
+				cropOp("", OpCreate, "a", 0, 0, "maize"),
+				cropOp("", OpUpdate, "a", 1, time.Minute, "beans"),
+			},
+			op:          cropOp("", OpUpdate, "a", 1, 2*time.Minute, "sorghum"),
+			wantStatus:  SyncApplied,
+			wantVersion: 3,
+			wantName:    "sorghum",
+		},
+		{
+			name: "stale update made before the server copy",
+			setup: []SyncOp{
+				cropOp("", OpCreate, "a", 0, 0, "maize"),
+				cropOp("", OpUpdate, "a", 1, 2*time.Minute, "beans"),
+			},
+			op:          cropOp("", OpUpdate, "a", 1, time.Minute, "sorghum"),
+			wantStatus:  SyncConflict,
+			wantVersion: 2,
+			wantName:    "beans",
+		},
+		{
+			name: "stale update at the same time",
+			setup: []SyncOp{
+				cropOp("", OpCreate, "a", 0, 0, "maize"),
+				cropOp("", OpUpdate, "a", 1, time.Minute, "beans"),
+			},
+			op:          cropOp("", OpUpdate, "a", 1, time.Minute, "sorghum"),
+			wantStatus:  SyncConflict,
+			wantVersion: 2,
+			wantName:    "beans",
+		},
+		{
+			name:        "create of a taken id",
+			setup:       []SyncOp{cropOp("", OpCreate, "a", 0, time.Minute, "maize")},
+			op:          cropOp("", OpCreate, "a", 0, 0, "beans"),
+			wantStatus:  SyncConflict,
+			wantVersion: 1,
+			wantName:    "maize",
+		},
+		{
+			name: "create on a tombstone made after the delete",
+			setup: []SyncOp{
+				cropOp("", OpCreate, "a", 0, 0, "maize"),
+				cropOp("", OpDelete, "a", 1, time.Minute, ""),
+			},
+			op:          cropOp("", OpCreate, "a", 0, 2*time.Minute, "beans"),
+			wantStatus:  SyncApplied,
+			wantVersion: 3,
+			wantName:    "beans",
+		},
+		{
+			name: "create on a tombstone made before the delete",
+			setup: []SyncOp{
+				cropOp("", OpCreate, "a", 0, 0, "maize"),
+				cropOp("", OpDelete, "a", 1, 2*time.Minute, ""),
+			},
+			op:          cropOp("", OpCreate, "a", 0, time.Minute, "beans"),
+			wantStatus:  SyncConflict,
+			wantVersion: 2,
+			wantDeleted: true,
+		},
+		{
+			name:        "delete of an unknown record",
+			op:          cropOp("", OpDelete, "a", 0, 0, ""),
+			wantStatus:  SyncApplied,
+			wantVersion: 1,
+			wantDeleted: true,
+		},
+		{
+			name:        "update of an unknown record",
+			op:          cropOp("", OpUpdate, "a", 0, 0, "maize"),
+			wantStatus:  SyncApplied,
+			wantVersion: 1,
+			wantName:    "maize",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t)
+			token := srv.signInUser(t, "+254712345678")
+			for _, op := range tt.setup {
+				if r := postSync(t, srv, token, SyncRequest{Ops: []SyncOp{op}}).Results[0]; r.Status != SyncApplied {
+					t.Fatalf("setup %s: %+v", op.Op, r)
+				}
+			}
+
+			resp := postSync(t, srv, token, SyncRequest{Ops: []SyncOp{tt.op}})
+			r := resp.Results[0]
+			if r.Status != tt.wantStatus {
+				t.Fatalf("status %s (%s), want %s", r.Status, r.Error, tt.wantStatus)
+			}
+			name := ""
+			if r.Record.Data != nil {
+				var data struct{ Name string }
+				if err := json.Unmarshal(r.Record.Data, &data); err != nil {
+					t.Fatal(err)
+				}
+				name = data.Name
+			}
+			if r.Record.Version != tt.wantVersion || r.Record.Deleted != tt.wantDeleted || name != tt.wantName {
+				t.Errorf("record version %d deleted=%v %q, want %d deleted=%v %q",
+					r.Record.Version, r.Record.Deleted, name, tt.wantVersion, tt.wantDeleted, tt.wantName)
+			}
+			if len(resp.Changes) != 1 || resp.Changes[0].Version != tt.wantVersion {
+				t.Errorf("changes %+v, want the record at version %d", resp.Changes, tt.wantVersion)
+			}
+		})
+	}
+}
+
+func TestSyncPaging(t *testing.T) {
+	srv := newTestServer(t)
+	token := srv.signInUser(t, "+254712345678")
+	const total = maxSyncChanges + 100
+	for start := 0; start < total; start += maxSyncOps {
+		var ops []SyncOp
+		for i := start; i < total && i < start+maxSyncOps; i++ {
+			ops = append(ops, cropOp(fmt.Sprint("k", i), OpCreate, fmt.Sprint(i), 0, 0, "maize"))
+		}
+		postSync(t, srv, token, SyncRequest{Ops: ops})
+	}
+
+	seen := make(map[string]bool)
+	cursor := ""
+	for page := 0; ; page++ {
+		resp := postSync(t, srv, token, SyncRequest{Cursor: cursor, DeviceID: "phone"})
+		wantMore := page == 0
+		if resp.HasMore != wantMore {
+			t.Fatalf("page %d: has_more %v, want %v", page, resp.HasMore, wantMore)
+		}
+		for _, r := range resp.Changes {
+			if seen[r.ID] {
+				t.Fatalf("page %d repeats record %s", page, r.ID)
+			}
+			seen[r.ID] = true
+		}
+		cursor = resp.Cursor
+		if !resp.HasMore {
+			break
+		}
+	}
+	if len(seen) != total {
+		t.Fatalf("%d records over the pages, want %d", len(seen), total)
+	}
+
+	// Nothing new since the last cursor, also when the device resumes
+	// without one
+	for _, req := range []SyncRequest{{Cursor: cursor}, {DeviceID: "phone"}} {
+		if resp := postSync(t, srv, token, req); len(resp.Changes) != 0 || resp.HasMore || resp.Cursor != cursor {
+			t.Errorf("%+v: %d changes, has_more %v, cursor %s; want none at %s", req, len(resp.Changes), resp.HasMore, resp.Cursor, cursor)
+		}
+	}
+
+	// Another user sees none of them
+	other := postSync(t, srv, srv.signInUser(t, "+254722345678"), SyncRequest{})
+	if len(other.Changes) != 0 {
+		t.Errorf("another user got %d changes", len(other.Changes))
+	}
+}
+
+func TestSyncBadRequests(t *testing.T) {
+	srv := newTestServer(t)
+	token := srv.signInUser(t, "+254712345678")
+	tests := []struct {
+		name string
+		req  SyncRequest
+	}{
+		{"bad cursor", SyncRequest{Cursor: "yesterday"}},
+		{"negative cursor", SyncRequest{Cursor: "-1"}},
+		{"too many ops", SyncRequest{Ops: make([]SyncOp, maxSyncOps+1)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := doJSON(t, srv.router, "POST", "/api/sync", token, tt.req, nil); code != 400 {
+				t.Errorf("status %d, want 400", code)
+			}
+		})
+	}
+}
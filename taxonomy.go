@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== COMMODITY TAXONOMY ====================
+
+// Commodity forms.
+const (
+	FormRaw       = "raw"
+	FormProcessed = "processed"
+)
+
+// Taxonomy groups the WFP commodity names into canonical commodities, so
+// "Maize (white)" and "Maize (white, dry)" are variants of maize while
+// "Maize flour" is a commodity of its own.
+type Taxonomy struct {
+	Version     int                   `json:"version"`
+	Commodities []*CanonicalCommodity `json:"commodities"`
+
+	byID          map[string]*CanonicalCommodity // canonical id
+	byName        map[string]*CanonicalCommodity // lower-cased canonical name
+	byAlias       map[string]*CanonicalCommodity // folded name in any language
+	variantByID   map[int]*CommodityVariant      // WFP commodity_id
+	variantByName map[string]*CommodityVariant   // lower-cased WFP name
+}
+
+// CanonicalCommodity is one commodity of the taxonomy.
+type CanonicalCommodity struct {
+	ID            string              `json:"id"`   // e.g. "maize-flour"
+	Name          string              `json:"name"` // e.g. "Maize flour"
+	Category      string              `json:"category"`
+	Form          string              `json:"form"`                     // raw or processed
+	ProcessedFrom string              `json:"processed_from,omitempty"` // id of the raw commodity
+	Unclassified  bool                `json:"unclassified,omitempty"`   // in the data but not the taxonomy file
+	Names         map[string][]string `json:"names,omitempty"`          // by language, display name first
+	Variants      []*CommodityVariant `json:"variants"`
+}
+
+// CommodityVariant maps a WFP commodity onto its canonical commodity.
+type CommodityVariant struct {
+	CommodityID  int    `json:"commodity_id"`
+	WFPName      string `json:"wfp_name"`
+	Variant      string `json:"variant,omitempty"` // e.g. "white, dry"; empty for the generic commodity
+	Form         string `json:"form,omitempty"`    // defaults to the commodity's form
+	Observations int    `json:"observations"`      // rows in the current dataset
+
+	Names map[string][]string `json:"names,omitempty"` // names of this variant only, e.g. "Nyama ya mbuzi"
+
+	Canonical *CanonicalCommodity `json:"-"`
+
+	renumbered []int // other commodity_ids the WFP name appeared under
+}
+
+// commodityIDs returns the variant's commodity_id and the ones it was
+// renumbered to in the dataset.
+func (v *CommodityVariant) commodityIDs() []int {
+	return append([]int{v.CommodityID}, v.renumbered...)
+}
+
+// Generic reports whether the variant is the unqualified commodity, e.g.
+// "Maize" rather than "Maize (white)".
+func (v *CommodityVariant) Generic() bool {
+	return v.Variant == ""
+}
+
+// LoadTaxonomy reads and validates a taxonomy file. An empty path gives
+// an empty taxonomy, in which every WFP commodity ends up unclassified.
+func LoadTaxonomy(path string) (*Taxonomy, error) {
+	t := &Taxonomy{}
+	if path != "" {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open file: %w", err)
+		}
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(t); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if err := t.init(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return t, nil
+}
+
+// init validates the taxonomy and builds its lookup tables.
+func (t *Taxonomy) init() error {
+	t.byID = make(map[string]*CanonicalCommodity)
+	t.byName = make(map[string]*CanonicalCommodity)
+	t.byAlias = make(map[string]*CanonicalCommodity)
+	t.variantByID = make(map[int]*CommodityVariant)
+	t.variantByName = make(map[string]*CommodityVariant)
+
+	var errs []error
+	for _, c := range t.Commodities {
+		switch {
+		case c.ID == "" || c.Name == "":
+			errs = append(errs, fmt.Errorf("commodity %q: id and name are required", c.ID))
+		case t.byID[c.ID] != nil:
+			errs = append(errs, fmt.Errorf("commodity %q: duplicate id", c.ID))
+		case t.byName[strings.ToLower(c.Name)] != nil:
+			errs = append(errs, fmt.Errorf("commodity %q: duplicate name %q", c.ID, c.Name))
+		}
+		if c.Form != FormRaw && c.Form != FormProcessed {
+			errs = append(errs, fmt.Errorf("commodity %q: form must be raw or processed", c.ID))
+		}
+		if len(c.Variants) == 0 {
+			errs = append(errs, fmt.Errorf("commodity %q: no variants", c.ID))
+		}
+		t.byID[c.ID] = c
+		t.byName[strings.ToLower(c.Name)] = c
+		errs = append(errs, checkNames(c.ID, c.Names)...)
+		for _, lang := range languages {
+			for _, name := range c.Names[lang] {
+				// Names may be shared, e.g. "nyanya"; the first commodity wins
+				if alias := foldName(name); t.byAlias[alias] == nil {
+					t.byAlias[alias] = c
+				}
+			}
+		}
+
+		for _, v := range c.Variants {
+			v.Canonical = c
+			if v.Form == "" {
+				v.Form = c.Form
+			}
+			switch {
+			case v.CommodityID == 0 || v.WFPName == "":
+				errs = append(errs, fmt.Errorf("commodity %q: variants need commodity_id and wfp_name", c.ID))
+			case t.variantByID[v.CommodityID] != nil:
+				errs = append(errs, fmt.Errorf("commodity %q: commodity_id %d is already mapped", c.ID, v.CommodityID))
+			case v.Form != FormRaw && v.Form != FormProcessed:
+				errs = append(errs, fmt.Errorf("commodity %q: variant %q: form must be raw or processed", c.ID, v.WFPName))
+			}
+			t.variantByID[v.CommodityID] = v
+			t.variantByName[strings.ToLower(v.WFPName)] = v
+			errs = append(errs, checkNames(c.ID, v.Names)...)
+		}
+	}
+	for _, c := range t.Commodities {
+		if c.ProcessedFrom != "" && t.byID[c.ProcessedFrom] == nil {
+			errs = append(errs, fmt.Errorf("commodity %q: processed_from %q is not in the taxonomy", c.ID, c.ProcessedFrom))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// checkNames validates the names of a commodity or variant.
+func checkNames(id string, names map[string][]string) []error {
+	var errs []error
+	for lang, list := range names {
+		if !slices.Contains(languages, lang) {
+			errs = append(errs, fmt.Errorf("commodity %q: unsupported language %q", id, lang))
+		}
+		for _, name := range list {
+			if foldName(name) == "" {
+				errs = append(errs, fmt.Errorf("commodity %q: empty %s name", id, lang))
+			}
+		}
+	}
+	return errs
+}
+
+// Classify maps every commodity of the dataset onto the taxonomy and
+// counts its observations. WFP commodities the taxonomy file doesn't know
+// become unclassified commodities of their own, so new names in an export
+// are still served.
+func (t *Taxonomy) Classify(commodities []Commodity) {
+	for _, v := range t.variantByID {
+		v.Observations = 0
+	}
+	for _, comm := range commodities {
+		v, ok := t.variantByID[comm.CommodityID]
+		if !ok {
+			// Same name under a new commodity_id
+			if v, ok = t.variantByName[strings.ToLower(comm.Name)]; ok && comm.CommodityID != 0 {
+				t.variantByID[comm.CommodityID] = v
+				v.renumbered = append(v.renumbered, comm.CommodityID)
+			}
+		}
+		if !ok {
+			v = t.addUnclassified(comm)
+		}
+		v.Observations++
+	}
+}
+
+func (t *Taxonomy) addUnclassified(comm Commodity) *CommodityVariant {
+	id := slugify(comm.Name)
+	if t.byID[id] != nil {
+		id += "-" + strconv.Itoa(comm.CommodityID)
+	}
+	c := &CanonicalCommodity{
+		ID:           id,
+		Name:         comm.Name,
+		Category:     comm.Category,
+		Form:         FormRaw,
+		Unclassified: true,
+	}
+	v := &CommodityVariant{CommodityID: comm.CommodityID, WFPName: comm.Name, Form: FormRaw, Canonical: c}
+	c.Variants = []*CommodityVariant{v}
+
+	t.Commodities = append(t.Commodities, c)
+	t.byID[c.ID] = c
+	if t.byName[strings.ToLower(c.Name)] == nil {
+		t.byName[strings.ToLower(c.Name)] = c
+	}
+	t.variantByID[comm.CommodityID] = v
+	t.variantByName[strings.ToLower(comm.Name)] = v
+	return v
+}
+
+// Variant returns the taxonomy entry of a WFP commodity_id.
+func (t *Taxonomy) Variant(commodityID int) (*CommodityVariant, bool) {
+	v, ok := t.variantByID[commodityID]
+	return v, ok
+}
+
+// Canonical returns the commodity with the given id or name, ignoring
+// case. Names in every language count, e.g. "mahindi" for maize.
+func (t *Taxonomy) Canonical(term string) (*CanonicalCommodity, bool) {
+	term = strings.ToLower(strings.TrimSpace(term))
+	if c, ok := t.byID[term]; ok {
+		return c, true
+	}
+	if c, ok := t.byName[term]; ok {
+		return c, true
+	}
+	c, ok := t.byAlias[foldName(term)]
+	return c, ok
+}
+
+// ==================== FILTERING ====================
+
+// commodityFilter is the set of WFP commodity_ids a query selects. A nil
+// filter selects everything.
+type commodityFilter map[int]bool
+
+// Match reports whether the filter selects a WFP commodity_id.
+func (f commodityFilter) Match(commodityID int) bool {
+	return f == nil || f[commodityID]
+}
+
+// Filter resolves query terms into a commodityFilter. A canonical id or
+// name selects the generic variant of the commodity, or every variant
+// with expand or when the commodity has no generic variant. An exact WFP
+// name selects just that variant. Terms matching nothing select nothing,
+// so no terms at all gives a nil filter.
+func (t *Taxonomy) Filter(terms []string, expand bool) commodityFilter {
+	if len(terms) == 0 {
+		return nil
+	}
+	f := make(commodityFilter)
+	for _, term := range terms {
+		if c, ok := t.Canonical(term); ok {
+			hasGeneric := false
+			for _, v := range c.Variants {
+				hasGeneric = hasGeneric || v.Generic()
+			}
+			for _, v := range c.Variants {
+				if expand || !hasGeneric || v.Generic() {
+					for _, id := range v.commodityIDs() {
+						f[id] = true
+					}
+				}
+			}
+			continue
+		}
+		if v, ok := t.variantByName[strings.ToLower(strings.TrimSpace(term))]; ok {
+			for _, id := range v.commodityIDs() {
+				f[id] = true
+			}
+		}
+	}
+	return f
+}
+
+// parseVariantsParam reads the optional variants=true|false of price endpoints.
+func parseVariantsParam(c *gin.Context) (bool, error) {
+	v := c.Query("variants")
+	if v == "" {
+		return false, nil
+	}
+	expand, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid variants %q (use true or false)", v)
+	}
+	return expand, nil
+}
+
+// splitTerms splits a comma-separated query value, dropping empty terms.
+func splitTerms(s string) []string {
+	var terms []string
+	for _, term := range strings.Split(s, ",") {
+		if term = strings.TrimSpace(term); term != "" {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// slugify turns a WFP name into an id, e.g. "Maize (white)" -> "maize-white".
+func slugify(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return b.String()
+}
+
+// ==================== TAXONOMY ENDPOINT ====================
+
+// taxonomyHandler serves /api/commodities/taxonomy, optionally narrowed
+// with category= and form= and named in lang=.
+func taxonomyHandler(store *DatasetStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		foodData := store.Load()
+		lang, err := parseLangParam(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		category := strings.ToLower(c.Query("category"))
+		form := strings.ToLower(c.Query("form"))
+		if form != "" && form != FormRaw && form != FormProcessed {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("unsupported form %q (use raw or processed)", form)})
+			return
+		}
+
+		commodities := []*CanonicalCommodity{}
+		for _, comm := range foodData.Taxonomy.Commodities {
+			if category != "" && strings.ToLower(comm.Category) != category {
+				continue
+			}
+			if form != "" && comm.Form != form {
+				continue
+			}
+			commodities = append(commodities, comm.localized(lang))
+		}
+		sort.SliceStable(commodities, func(i, j int) bool {
+			if commodities[i].Category != commodities[j].Category {
+				return commodities[i].Category < commodities[j].Category
+			}
+			return commodities[i].Name < commodities[j].Name
+		})
+
+		c.JSON(200, gin.H{
+			"version":     foodData.Taxonomy.Version,
+			"commodities": commodities,
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestClassifyRenumbered(t *testing.T) {
+	// An export that moved Maize from commodity_id 51 to 9051 half way
+	store := csvTestStore(t, `2024-01-15,Coast,Mombasa,Mombasa,191,-4.05,39.67,cereals and tubers,Maize,51,KG,actual,Retail,KES,50,0.38
+2024-02-15,Coast,Mombasa,Mombasa,191,-4.05,39.67,cereals and tubers,Maize,9051,KG,actual,Retail,KES,55,0.42
+2024-02-15,Coast,Mombasa,Mombasa,191,-4.05,39.67,cereals and tubers,Maize (white),67,KG,actual,Retail,KES,60,0.46
+2024-02-15,Coast,Mombasa,Mombasa,191,-4.05,39.67,cereals and tubers,Sorghum (new),9999,KG,actual,Retail,KES,70,0.53
+`)
+	taxonomy := store.Load().Taxonomy
+
+	v, ok := taxonomy.Variant(9051)
+	if !ok || v.WFPName != "Maize" || v.Canonical.ID != "maize" || v.Observations != 2 {
+		t.Fatalf("commodity_id 9051 classified as %+v", v)
+	}
+
+	tests := []struct {
+		terms  []string
+		expand bool
+		want   []int
+	}{
+		{[]string{"maize"}, false, []int{51, 9051}},
+		{[]string{"Mahindi"}, false, []int{51, 9051}},
+		{[]string{"Maize"}, false, []int{51, 9051}},
+		{[]string{"maize"}, true, []int{51, 9051, 67}},
+		{[]string{"Maize (white)"}, false, []int{67}},
+		{[]string{"Sorghum (new)"}, false, []int{9999}},
+	}
+	for _, tt := range tests {
+		f := taxonomy.Filter(tt.terms, tt.expand)
+		for _, id := range tt.want {
+			if !f.Match(id) {
+				t.Errorf("Filter(%q, %v) leaves out commodity_id %d", tt.terms, tt.expand, id)
+			}
+		}
+		if len(f) < len(tt.want) {
+			t.Errorf("Filter(%q, %v) = %v, want %v", tt.terms, tt.expand, f, tt.want)
+		}
+	}
+
+	var prices []float64
+	f := taxonomy.Filter([]string{"maize"}, false)
+	for _, series := range store.Load().AllSeries() {
+		if f.Match(series.Key.CommodityID) {
+			for _, comm := range series.Observations {
+				prices = append(prices, comm.Price)
+			}
+		}
+	}
+	slices.Sort(prices)
+	if !slices.Equal(prices, []float64{50, 55}) {
+		t.Errorf("maize prices %v, want 50 and 55", prices)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// ==================== MONTHLY SERIES ====================
+
+// MonthlySeries is a price series resampled to one value per calendar
+// month, the input of the seasonal models (alerts, forecasts, seasonality).
+// Months without a price are NaN unless they were interpolated.
+type MonthlySeries struct {
+	Start  string    // "YYYY-MM" of Values[0]
+	Values []float64 // mean normalized price of the month
+	Filled []bool    // the value was interpolated over a gap
+}
+
+// monthlySeries resamples observations, oldest first, to months. Gaps of
+// up to maxGap months between two prices are filled linearly; longer ones
+// stay NaN.
+func monthlySeries(obs []Commodity, maxGap int) MonthlySeries {
+	if len(obs) == 0 {
+		return MonthlySeries{}
+	}
+	start := monthOf(obs[0].Date)
+	n := monthsBetween(start, monthOf(obs[len(obs)-1].Date)) + 1
+
+	sums := make([]float64, n)
+	counts := make([]int, n)
+	for _, comm := range obs {
+		i := monthsBetween(start, monthOf(comm.Date))
+		sums[i] += comm.NormalizedPrice
+		counts[i]++
+	}
+
+	m := MonthlySeries{Start: start, Values: make([]float64, n), Filled: make([]bool, n)}
+	for i := range sums {
+		m.Values[i] = math.NaN()
+		if counts[i] > 0 {
+			m.Values[i] = sums[i] / float64(counts[i])
+		}
+	}
+
+	// Interpolate short gaps between two observed months
+	last := -1
+	for i, v := range m.Values {
+		if math.IsNaN(v) {
+			continue
+		}
+		if gap := i - last - 1; last >= 0 && gap > 0 && gap <= maxGap {
+			for j := last + 1; j < i; j++ {
+				w := float64(j-last) / float64(i-last)
+				m.Values[j] = m.Values[last]*(1-w) + v*w
+				m.Filled[j] = true
+			}
+		}
+		last = i
+	}
+	return m
+}
+
+// Month returns the "YYYY-MM" of Values[i].
+func (m MonthlySeries) Month(i int) string {
+	return addMonths(m.Start, i)
+}
+
+// Valid returns the number of months with a value, observed or filled.
+func (m MonthlySeries) Valid() int {
+	n := 0
+	for _, v := range m.Values {
+		if !math.IsNaN(v) {
+			n++
+		}
+	}
+	return n
+}
+
+// calendarMonth returns 1-12 for Values[i].
+func (m MonthlySeries) calendarMonth(i int) int {
+	var year, month int
+	fmt.Sscanf(m.Start, "%d-%d", &year, &month)
+	return (month-1+i)%12 + 1
+}
+
+// addMonths returns month ("YYYY-MM") shifted by n months.
+func addMonths(month string, n int) string {
+	var year, mon int
+	fmt.Sscanf(month, "%d-%d", &year, &mon)
+	total := year*12 + mon - 1 + n
+	return fmt.Sprintf("%04d-%02d", total/12, total%12+1)
+}
+
+// ==================== REGRESSION ====================
+
+// olsFit returns the least-squares coefficients of y on the columns of x
+// (one row per observation) by solving the normal equations.
+func olsFit(x [][]float64, y []float64) ([]float64, error) {
+	if len(x) == 0 {
+		return nil, fmt.Errorf("no observations")
+	}
+	k := len(x[0])
+	if len(x) < k {
+		return nil, fmt.Errorf("%d observations for %d coefficients", len(x), k)
+	}
+
+	// Augmented matrix [X'X | X'y]
+	a := make([][]float64, k)
+	for i := range a {
+		a[i] = make([]float64, k+1)
+	}
+	for r, row := range x {
+		for i := 0; i < k; i++ {
+			for j := 0; j < k; j++ {
+				a[i][j] += row[i] * row[j]
+			}
+			a[i][k] += row[i] * y[r]
+		}
+	}
+
+	// Gaussian elimination with partial pivoting
+	for col := 0; col < k; col++ {
+		pivot := col
+		for r := col + 1; r < k; r++ {
+			if math.Abs(a[r][col]) > math.Abs(a[pivot][col]) {
+				pivot = r
+			}
+		}
+		if math.Abs(a[pivot][col]) < 1e-9 {
+			return nil, fmt.Errorf("singular design matrix")
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		for r := col + 1; r < k; r++ {
+			f := a[r][col] / a[col][col]
+			for c := col; c <= k; c++ {
+				a[r][c] -= f * a[col][c]
+			}
+		}
+	}
+	beta := make([]float64, k)
+	for i := k - 1; i >= 0; i-- {
+		sum := a[i][k]
+		for j := i + 1; j < k; j++ {
+			sum -= a[i][j] * beta[j]
+		}
+		beta[i] = sum / a[i][i]
+	}
+	return beta, nil
+}
+
+// dot returns the inner product of two equally long vectors.
+func dot(a, b []float64) float64 {
+	sum := 0.0
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ==================== PRICE SERIES ====================
+
+// SeriesKey identifies one price series: the same commodity, in the same
+// market, quoted in the same unit, price type and price flag. Prices are
+// only comparable within a series.
+type SeriesKey struct {
+	MarketID    int
+	CommodityID int
+	Unit        string
+	PriceType   PriceType
+	PriceFlag   PriceFlag
+}
+
+// SeriesKey returns the series the observation belongs to.
+func (c Commodity) SeriesKey() SeriesKey {
+	return SeriesKey{
+		MarketID:    c.MarketID,
+		CommodityID: c.CommodityID,
+		Unit:        strings.ToUpper(c.Unit),
+		PriceType:   c.PriceType,
+		PriceFlag:   c.PriceFlag,
+	}
+}
+
+// ==================== TRENDS ====================
+
+// trendPeriod is a comparison window. The base observation must be at
+// least Months and at most Months+MaxGap months older than the current
+// one; anything older is not reported as a change.
+type trendPeriod struct {
+	Name   string
+	Months int
+	MaxGap int
+}
+
+var trendPeriods = map[string]trendPeriod{
+	"mom": {Name: "mom", Months: 1, MaxGap: 1},
+	"qoq": {Name: "qoq", Months: 3, MaxGap: 1},
+	"yoy": {Name: "yoy", Months: 12, MaxGap: 2},
+}
+
+// parseTrendPeriod validates a period= query value. Empty means "mom".
+func parseTrendPeriod(s string) (trendPeriod, error) {
+	if s == "" {
+		return trendPeriods["mom"], nil
+	}
+	p, ok := trendPeriods[strings.ToLower(s)]
+	if !ok {
+		return trendPeriod{}, fmt.Errorf("unsupported period %q (use mom, qoq or yoy)", s)
+	}
+	return p, nil
+}
+
+// Trend is the change of a price against an earlier observation of the
+// same series.
+type Trend struct {
+	Direction string  // "up", "down", "stable", or "unknown" without a base
+	Percent   float64 // absolute change in percent
+	Period    string
+	BaseDate  string
+	BasePrice float64 // normalized
+}
+
+// calculateTrend compares current with the observation in series closest
+// to one period earlier. series must only hold observations of current's
+// series; prices are compared after unit normalization.
+func calculateTrend(series []Commodity, current Commodity, period trendPeriod) Trend {
+	trend := Trend{Direction: "unknown", Period: period.Name}
+
+	var base *Commodity
+	bestDistance := 0
+	for i := range series {
+		gap := monthsBetween(series[i].Date, current.Date)
+		if gap < period.Months || gap > period.Months+period.MaxGap {
+			continue
+		}
+		distance := gap - period.Months
+		if base == nil || distance < bestDistance || (distance == bestDistance && series[i].Date > base.Date) {
+			base, bestDistance = &series[i], distance
+		}
+	}
+	if base == nil || base.NormalizedPrice <= 0 {
+		return trend
+	}
+
+	trend.BaseDate = base.Date
+	trend.BasePrice = base.NormalizedPrice
+	percentChange := (current.NormalizedPrice - base.NormalizedPrice) / base.NormalizedPrice * 100
+
+	switch {
+	case percentChange > 1:
+		trend.Direction, trend.Percent = "up", percentChange
+	case percentChange < -1:
+		trend.Direction, trend.Percent = "down", -percentChange
+	default:
+		trend.Direction = "stable"
+	}
+	return trend
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func priceOn(date string, price float64) Commodity {
+	return Commodity{Date: date, NormalizedPrice: price}
+}
+
+func TestCalculateTrend(t *testing.T) {
+	current := priceOn("2024-06-15", 110)
+	tests := []struct {
+		name      string
+		series    []Commodity
+		period    string
+		direction string
+		percent   float64
+		baseDate  string
+	}{
+		{"previous month", []Commodity{priceOn("2024-04-15", 50), priceOn("2024-05-15", 100), current}, "mom", "up", 10, "2024-05-15"},
+		{"gap within MaxGap", []Commodity{priceOn("2024-04-15", 100), current}, "mom", "up", 10, "2024-04-15"},
+		{"gap beyond MaxGap", []Commodity{priceOn("2024-03-15", 100), current}, "mom", "unknown", 0, ""},
+		{"same month only", []Commodity{priceOn("2024-06-01", 100), current}, "mom", "unknown", 0, ""},
+		{"closest to one period wins", []Commodity{priceOn("2024-04-15", 50), priceOn("2024-05-01", 100), current}, "mom", "up", 10, "2024-05-01"},
+		{"latest of the closest month", []Commodity{priceOn("2024-05-01", 50), priceOn("2024-05-20", 100), current}, "mom", "up", 10, "2024-05-20"},
+		{"down", []Commodity{priceOn("2024-05-15", 137.5), current}, "mom", "down", 20, "2024-05-15"},
+		{"stable within 1%", []Commodity{priceOn("2024-05-15", 109.5), current}, "mom", "stable", 0, "2024-05-15"},
+		{"quarter", []Commodity{priceOn("2024-03-15", 100), priceOn("2024-05-15", 200), current}, "qoq", "up", 10, "2024-03-15"},
+		{"quarter with a month's gap", []Commodity{priceOn("2024-02-15", 100), current}, "qoq", "up", 10, "2024-02-15"},
+		{"quarter beyond MaxGap", []Commodity{priceOn("2024-01-15", 100), current}, "qoq", "unknown", 0, ""},
+		{"year", []Commodity{priceOn("2023-06-15", 100), priceOn("2024-01-15", 200), current}, "yoy", "up", 10, "2023-06-15"},
+		{"year with two months' gap", []Commodity{priceOn("2023-04-15", 100), current}, "yoy", "up", 10, "2023-04-15"},
+		{"year beyond MaxGap", []Commodity{priceOn("2023-03-15", 100), current}, "yoy", "unknown", 0, ""},
+		{"base without a price", []Commodity{priceOn("2024-05-15", 0), current}, "mom", "unknown", 0, ""},
+		{"no history", []Commodity{current}, "mom", "unknown", 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			period, err := parseTrendPeriod(tt.period)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := calculateTrend(tt.series, current, period)
+			if got.Direction != tt.direction || math.Abs(got.Percent-tt.percent) > 1e-9 || got.BaseDate != tt.baseDate || got.Period != tt.period {
+				t.Errorf("got %s %.2f%% from %q (%s), want %s %.2f%% from %q",
+					got.Direction, got.Percent, got.BaseDate, got.Period, tt.direction, tt.percent, tt.baseDate)
+			}
+		})
+	}
+}
+
+func TestParseTrendPeriod(t *testing.T) {
+	for s, want := range map[string]string{"": "mom", "QoQ": "qoq", "yoy": "yoy"} {
+		if p, err := parseTrendPeriod(s); err != nil || p.Name != want {
+			t.Errorf("parseTrendPeriod(%q) = %+v, %v; want %s", s, p, err, want)
+		}
+	}
+	if _, err := parseTrendPeriod("weekly"); err == nil {
+		t.Error("parsed an unsupported period")
+	}
+}
@@ -11,7 +11,7 @@ interface MarketPrice {
   price: number;
   currency: string;
   unit: string;
-  trend: 'up' | 'down' | 'stable';
+  trend: 'up' | 'down' | 'stable' | 'unknown';
   trendPercent: number;
   lastUpdated: string;
   isStale: boolean;
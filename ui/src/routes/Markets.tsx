@@ -35,7 +35,7 @@ interface MarketPrice {
   price: number;
   currency: string;
   unit: string;
-  trend: 'up' | 'down' | 'stable';
+  trend: 'up' | 'down' | 'stable' | 'unknown';
   trendPercent: number;
   lastUpdated: string;
   isStale: boolean;
@@ -43,6 +43,7 @@ interface MarketPrice {
 
 interface PriceHistoryPoint {
   date: string;
+  label: string;
   price: number;
 }
 
@@ -167,6 +168,7 @@ export default function MarketsPage() {
       const monthIndex = (currentMonth - i + 12) % 12;
       mockHistory.push({
         date: months[monthIndex],
+        label: months[monthIndex],
         price: 40 + Math.random() * 10,
       });
     }
@@ -431,7 +433,7 @@ export default function MarketsPage() {
                             stroke="var(--color-border)"
                           />
                           <XAxis
-                            dataKey="date"
+                            dataKey="label"
                             stroke="var(--color-muted-foreground)"
                             tick={{ fontSize: 12 }}
                           />
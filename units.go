@@ -0,0 +1,203 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ==================== UNIT REGISTRY ====================
+
+// unitDimension is what a unit measures.
+type unitDimension int
+
+const (
+	dimMass unitDimension = iota
+	dimVolume
+	dimCount
+)
+
+// unitDef describes a unit symbol relative to the base unit of its dimension
+// ("kg" for mass, "l" for volume). Count units are their own base.
+type unitDef struct {
+	Dimension unitDimension
+	Base      string
+	Factor    float64 // base units per one of this unit
+}
+
+// unitRegistry maps upper-cased unit symbols, as they appear in WFP
+// exports, to their definitions.
+var unitRegistry = map[string]unitDef{
+	"G":      {dimMass, "kg", 0.001},
+	"GR":     {dimMass, "kg", 0.001},
+	"KG":     {dimMass, "kg", 1},
+	"MT":     {dimMass, "kg", 1000},
+	"ML":     {dimVolume, "l", 0.001},
+	"CL":     {dimVolume, "l", 0.01},
+	"L":      {dimVolume, "l", 1},
+	"LTR":    {dimVolume, "l", 1},
+	"LITRE":  {dimVolume, "l", 1},
+	"UNIT":   {dimCount, "unit", 1},
+	"PIECE":  {dimCount, "unit", 1},
+	"DOZEN":  {dimCount, "unit", 12},
+	"BUNCH":  {dimCount, "bunch", 1},
+	"HEAD":   {dimCount, "head", 1},
+	"BAG":    {dimCount, "bag", 1},
+	"TIN":    {dimCount, "tin", 1},
+	"PACKET": {dimCount, "packet", 1},
+}
+
+// commodityFactor holds conversion factors that depend on what is being
+// measured. Every conversion through one of these is an estimate.
+type commodityFactor struct {
+	BaseUnit   string             // preferred normalized unit, "" for the dimension default
+	KgPerLitre float64            // density, for mass <-> volume
+	KgPerCount map[string]float64 // typical weight of one count unit, keyed by base ("bunch", ...)
+}
+
+// commodityFactors is keyed by commodity name without its variant, see
+// commodityBaseName, so "Maize (white)" gets the factors of "maize" but
+// "Maize flour" doesn't.
+var commodityFactors = map[string]commodityFactor{
+	"milk":          {BaseUnit: "l", KgPerLitre: 1.03},
+	"oil":           {BaseUnit: "l", KgPerLitre: 0.92},
+	"bananas":       {KgPerCount: map[string]float64{"unit": 0.15, "bunch": 12}},
+	"kale":          {KgPerCount: map[string]float64{"bunch": 0.3}},
+	"spinach":       {KgPerCount: map[string]float64{"bunch": 0.25}},
+	"cowpea leaves": {KgPerCount: map[string]float64{"bunch": 0.25}},
+	"cabbage":       {KgPerCount: map[string]float64{"head": 1.5}},
+	"bread":         {KgPerCount: map[string]float64{"unit": 0.4}},
+	"maize":         {KgPerCount: map[string]float64{"bag": 90}},
+	"beans":         {KgPerCount: map[string]float64{"bag": 90}},
+	"potatoes":      {KgPerCount: map[string]float64{"bag": 50}},
+}
+
+// ParsedUnit is a WFP unit string split into quantity and unit.
+type ParsedUnit struct {
+	Quantity float64
+	Symbol   string // upper-cased registry key
+	Def      unitDef
+}
+
+// unitPattern matches "KG", "90 KG", "0.5 L", "500ML" and "90 KG bag".
+var unitPattern = regexp.MustCompile(`^\s*(\d+(?:[.,]\d+)?)?\s*([A-Za-z]+)(?:\s+bags?)?\s*$`)
+
+// parseUnit parses a WFP unit string. The second result is false for
+// units the registry doesn't know.
+func parseUnit(unit string) (ParsedUnit, bool) {
+	m := unitPattern.FindStringSubmatch(unit)
+	if m == nil {
+		return ParsedUnit{}, false
+	}
+	symbol := strings.ToUpper(m[2])
+	def, ok := unitRegistry[symbol]
+	if !ok {
+		return ParsedUnit{}, false
+	}
+
+	quantity := 1.0
+	if m[1] != "" {
+		q, err := strconv.ParseFloat(strings.Replace(m[1], ",", ".", 1), 64)
+		if err != nil || q <= 0 {
+			return ParsedUnit{}, false
+		}
+		quantity = q
+	}
+	return ParsedUnit{Quantity: quantity, Symbol: symbol, Def: def}, true
+}
+
+// commodityBaseName lower-cases a WFP commodity name and drops its
+// variant: "maize" for "Maize (white, dry)", "maize flour" for
+// "Maize flour (white)".
+func commodityBaseName(commodityName string) string {
+	name, _, _ := strings.Cut(commodityName, "(")
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// factorFor returns the conversion factors for a commodity, if any.
+func factorFor(commodityName string) (commodityFactor, bool) {
+	f, ok := commodityFactors[commodityBaseName(commodityName)]
+	return f, ok
+}
+
+// ==================== NORMALIZATION ====================
+
+// NormalizedPrice is a price expressed per base unit next to the
+// original observation. Estimated is set when a commodity-specific
+// factor (density, typical weight) was used rather than an exact one.
+type NormalizedPrice struct {
+	Price         float64 `json:"price"`
+	Unit          string  `json:"unit"`
+	OriginalPrice float64 `json:"original_price"`
+	OriginalUnit  string  `json:"original_unit"`
+	Estimated     bool    `json:"estimated"`
+}
+
+// normalizePrice expresses price, quoted per unit, per kg, per litre or
+// per count unit. Mass and volume conversions are exact. Count units and
+// cross-dimension conversions go through commodityFactors and are flagged
+// as estimated. Unknown units are passed through unchanged.
+func normalizePrice(commodityName string, price float64, unit string) NormalizedPrice {
+	result := NormalizedPrice{
+		Price:         price,
+		Unit:          strings.ToLower(strings.TrimSpace(unit)),
+		OriginalPrice: price,
+		OriginalUnit:  unit,
+	}
+
+	parsed, ok := parseUnit(unit)
+	if !ok {
+		return result
+	}
+
+	// Price per one base unit of the observed dimension
+	perBase := price / (parsed.Quantity * parsed.Def.Factor)
+	base := parsed.Def.Base
+	result.Price, result.Unit = perBase, base
+
+	factor, hasFactor := factorFor(commodityName)
+	if !hasFactor {
+		return result
+	}
+
+	switch parsed.Def.Dimension {
+	case dimCount:
+		if kg, ok := factor.KgPerCount[base]; ok && kg > 0 {
+			result.Price, result.Unit, result.Estimated = perBase/kg, "kg", true
+			if factor.BaseUnit == "l" && factor.KgPerLitre > 0 {
+				result.Price, result.Unit = perBase/kg*factor.KgPerLitre, "l"
+			}
+		}
+	case dimMass:
+		if factor.BaseUnit == "l" && factor.KgPerLitre > 0 {
+			result.Price, result.Unit, result.Estimated = perBase*factor.KgPerLitre, "l", true
+		}
+	case dimVolume:
+		if factor.BaseUnit == "kg" && factor.KgPerLitre > 0 {
+			result.Price, result.Unit, result.Estimated = perBase/factor.KgPerLitre, "kg", true
+		}
+	}
+	return result
+}
+
+// kgPerNormalizedUnit returns the mass of one normalized unit of a
+// commodity, for costs quoted per kg. Litres use the commodity's density,
+// or water's when it has none; either way the result is an estimate. Count
+// units without a typical weight have no mass.
+func kgPerNormalizedUnit(commodityName, unit string) (float64, bool, bool) {
+	switch unit {
+	case "kg":
+		return 1, false, true
+	case "l":
+		if factor, ok := factorFor(commodityName); ok && factor.KgPerLitre > 0 {
+			return factor.KgPerLitre, true, true
+		}
+		return 1, true, true
+	}
+	if factor, ok := factorFor(commodityName); ok {
+		if kg, ok := factor.KgPerCount[unit]; ok && kg > 0 {
+			return kg, true, true
+		}
+	}
+	return 0, false, false
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseUnit(t *testing.T) {
+	tests := []struct {
+		unit     string
+		quantity float64
+		symbol   string
+		ok       bool
+	}{
+		{"400 G", 400, "G", true},
+		{"500 ML", 500, "ML", true},
+		{"500ML", 500, "ML", true},
+		{"Bunch", 1, "BUNCH", true},
+		{"90 KG", 90, "KG", true},
+		{"90 KG bag", 90, "KG", true},
+		{"0,5 L", 0.5, "L", true},
+		{"KG", 1, "KG", true},
+		{"0 KG", 0, "", false},
+		{"Sack", 0, "", false},
+		{"", 0, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.unit, func(t *testing.T) {
+			got, ok := parseUnit(tt.unit)
+			if ok != tt.ok || got.Quantity != tt.quantity || got.Symbol != tt.symbol {
+				t.Errorf("parseUnit(%q) = %v %q, %v; want %v %q, %v",
+					tt.unit, got.Quantity, got.Symbol, ok, tt.quantity, tt.symbol, tt.ok)
+			}
+		})
+	}
+}
+
+func TestNormalizePrice(t *testing.T) {
+	tests := []struct {
+		commodity string
+		price     float64
+		unit      string
+		want      float64
+		wantUnit  string
+		estimated bool
+	}{
+		{"Bread", 60, "400 G", 150, "kg", false},
+		{"Milk (UHT)", 60, "500 ML", 120, "l", false},
+		{"Kale", 15, "Bunch", 50, "kg", true},
+		{"Maize (white)", 4500, "90 KG", 50, "kg", false},
+		{"Maize (white)", 4500, "Bag", 50, "kg", true},
+		{"Maize flour", 4500, "Bag", 4500, "bag", false},
+		{"Maize flour (white)", 4500, "Bag", 4500, "bag", false},
+		{"Oil (vegetable)", 200, "KG", 184, "l", true},
+		{"Milk (cow, fresh)", 103, "KG", 106.09, "l", true},
+		{"Sugar", 150, "Sack", 150, "sack", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.commodity+" "+tt.unit, func(t *testing.T) {
+			got := normalizePrice(tt.commodity, tt.price, tt.unit)
+			if math.Abs(got.Price-tt.want) > 1e-9 || got.Unit != tt.wantUnit || got.Estimated != tt.estimated {
+				t.Errorf("got %v/%s estimated=%v, want %v/%s estimated=%v",
+					got.Price, got.Unit, got.Estimated, tt.want, tt.wantUnit, tt.estimated)
+			}
+			if got.OriginalPrice != tt.price || got.OriginalUnit != tt.unit {
+				t.Errorf("original %v %q, want %v %q", got.OriginalPrice, got.OriginalUnit, tt.price, tt.unit)
+			}
+		})
+	}
+}
+
+func TestKgPerNormalizedUnit(t *testing.T) {
+	tests := []struct {
+		commodity, unit string
+		kg              float64
+		estimated, ok   bool
+	}{
+		{"Maize (white)", "kg", 1, false, true},
+		{"Maize (white)", "bag", 90, true, true},
+		{"Maize flour", "bag", 0, false, false},
+		{"Oil (vegetable)", "l", 0.92, true, true},
+		{"Fuel (diesel)", "l", 1, true, true},
+		{"Cabbage", "head", 1.5, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.commodity+" "+tt.unit, func(t *testing.T) {
+			kg, estimated, ok := kgPerNormalizedUnit(tt.commodity, tt.unit)
+			if kg != tt.kg || estimated != tt.estimated || ok != tt.ok {
+				t.Errorf("got %v, %v, %v; want %v, %v, %v", kg, estimated, ok, tt.kg, tt.estimated, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// ==================== WORLD BANK INDICATORS ====================
+
+// wbPage is the paging header of a World Bank API v2 response.
+type wbPage struct {
+	Page        int    `json:"page"`
+	Pages       int    `json:"pages"`
+	PerPage     int    `json:"per_page"`
+	Total       int    `json:"total"`
+	SourceID    string `json:"sourceid"`
+	LastUpdated string `json:"lastupdated"`
+}
+
+// wbObservation is one entry of a World Bank API v2 response. Value is
+// null for years the indicator hasn't been published for yet.
+type wbObservation struct {
+	Indicator struct {
+		ID    string `json:"id"`
+		Value string `json:"value"`
+	} `json:"indicator"`
+	Country struct {
+		ID    string `json:"id"`
+		Value string `json:"value"`
+	} `json:"country"`
+	CountryISO3 string   `json:"countryiso3code"`
+	Date        string   `json:"date"`
+	Value       *float64 `json:"value"`
+	Unit        string   `json:"unit"`
+	ObsStatus   string   `json:"obs_status"`
+	Decimal     int      `json:"decimal"`
+}
+
+// IndicatorSeries is an annual World Bank indicator for one country.
+type IndicatorSeries struct {
+	Indicator     string `json:"indicator"`      // e.g. "FP.CPI.TOTL"
+	Name          string `json:"name"`           // e.g. "Consumer price index (2010 = 100)"
+	Country       string `json:"country"`        // ISO3 code
+	LastUpdated   string `json:"last_updated"`   // as published by the World Bank
+	Incomplete    bool   `json:"incomplete"`     // the file holds only some of the response pages
+	LastObserved  int    `json:"last_observed"`  // latest year with a published value
+	FirstObserved int    `json:"first_observed"` // earliest year with a published value
+
+	values    map[int]float64
+	estimated map[int]bool
+}
+
+// IndicatorValue is one year of an IndicatorSeries.
+type IndicatorValue struct {
+	Year      int     `json:"year"`
+	Value     float64 `json:"value"`
+	Estimated bool    `json:"estimated"`
+}
+
+// LoadWorldBankIndicator reads a saved World Bank API v2 JSON response:
+// a two-element array of the paging header and the observations. Null
+// values are skipped.
+func LoadWorldBankIndicator(path string) (*IndicatorSeries, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+
+	var parts []json.RawMessage
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return nil, fmt.Errorf("%s: not a World Bank API response: %w", path, err)
+	}
+	if len(parts) != 2 {
+		// The API answers errors with a single {"message": ...} element
+		return nil, fmt.Errorf("%s: expected [page, observations], got %d elements", path, len(parts))
+	}
+
+	var page wbPage
+	if err := json.Unmarshal(parts[0], &page); err != nil {
+		return nil, fmt.Errorf("%s: invalid page header: %w", path, err)
+	}
+	var observations []wbObservation
+	if err := json.Unmarshal(parts[1], &observations); err != nil {
+		return nil, fmt.Errorf("%s: invalid observations: %w", path, err)
+	}
+
+	series := &IndicatorSeries{
+		LastUpdated: page.LastUpdated,
+		Incomplete:  page.Pages > 1 || (page.Total > 0 && len(observations) < page.Total),
+		values:      make(map[int]float64),
+		estimated:   make(map[int]bool),
+	}
+	for _, obs := range observations {
+		if series.Indicator == "" {
+			series.Indicator = obs.Indicator.ID
+			series.Name = obs.Indicator.Value
+			series.Country = obs.CountryISO3
+		}
+		if obs.Value == nil {
+			continue
+		}
+		year, err := strconv.Atoi(obs.Date)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid year %q", path, obs.Date)
+		}
+		series.values[year] = *obs.Value
+		if series.LastObserved == 0 || year > series.LastObserved {
+			series.LastObserved = year
+		}
+		if series.FirstObserved == 0 || year < series.FirstObserved {
+			series.FirstObserved = year
+		}
+	}
+	if len(series.values) == 0 {
+		return nil, fmt.Errorf("%s: no published values", path)
+	}
+	return series, nil
+}
+
+// ExtendTo fills the years after the last published one, up to year, by
+// compounding the average annual growth of the last three published
+// years. Filled years are flagged as estimated.
+func (s *IndicatorSeries) ExtendTo(year int) {
+	last := s.LastObserved
+	if year <= last {
+		return
+	}
+
+	growth := 0.0
+	n := 0
+	for y := last; y > last-3; y-- {
+		prev, okPrev := s.values[y-1]
+		cur, okCur := s.values[y]
+		if !okPrev || !okCur || prev <= 0 || s.estimated[y] {
+			break
+		}
+		growth += math.Log(cur / prev)
+		n++
+	}
+	if n > 0 {
+		growth /= float64(n)
+	}
+
+	for y := last + 1; y <= year; y++ {
+		s.values[y] = s.values[y-1] * math.Exp(growth)
+		s.estimated[y] = true
+	}
+}
+
+// Value returns the indicator for a year and whether it was extrapolated.
+func (s *IndicatorSeries) Value(year int) (float64, bool, bool) {
+	v, ok := s.values[year]
+	return v, s.estimated[year], ok
+}
+
+// Values returns every year of the series in order.
+func (s *IndicatorSeries) Values() []IndicatorValue {
+	out := make([]IndicatorValue, 0, len(s.values))
+	for year, v := range s.values {
+		out = append(out, IndicatorValue{Year: year, Value: v, Estimated: s.estimated[year]})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Year < out[j].Year })
+	return out
+}
+
+// ==================== DEFLATION ====================
+
+// Deflate expresses amount, observed on date, in base-year prices using
+// the series as a price index. The second result reports whether either
+// year's index was extrapolated.
+func (s *IndicatorSeries) Deflate(amount float64, date string, base int) (float64, bool, error) {
+	year, err := strconv.Atoi(date[:min(4, len(date))])
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid date %q", date)
+	}
+	observed, estObserved, ok := s.Value(year)
+	if !ok || observed <= 0 {
+		return 0, false, fmt.Errorf("no CPI value for %d", year)
+	}
+	reference, estBase, ok := s.Value(base)
+	if !ok || reference <= 0 {
+		return 0, false, fmt.Errorf("no CPI value for base year %d", base)
+	}
+	return amount * reference / observed, estObserved || estBase, nil
+}
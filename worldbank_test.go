@@ -0,0 +1,148 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testIndicator returns a series with the given published values.
+func testIndicator(values map[int]float64) *IndicatorSeries {
+	s := &IndicatorSeries{values: make(map[int]float64), estimated: make(map[int]bool)}
+	for year, v := range values {
+		s.values[year] = v
+		if s.LastObserved == 0 || year > s.LastObserved {
+			s.LastObserved = year
+		}
+		if s.FirstObserved == 0 || year < s.FirstObserved {
+			s.FirstObserved = year
+		}
+	}
+	return s
+}
+
+func TestIndicatorExtendTo(t *testing.T) {
+	tests := []struct {
+		name   string
+		values map[int]float64
+		to     int
+		want   map[int]float64 // estimated years
+	}{
+		{
+			name:   "steady growth",
+			values: map[int]float64{2019: 50, 2020: 100, 2021: 110, 2022: 121, 2023: 133.1},
+			to:     2025,
+			want:   map[int]float64{2024: 146.41, 2025: 161.051},
+		},
+		{
+			name:   "average of the last three years",
+			values: map[int]float64{2019: 100, 2020: 100, 2021: 200, 2022: 200},
+			to:     2023,
+			want:   map[int]float64{2023: 200 * math.Pow(2, 1.0/3)},
+		},
+		{
+			name:   "stops at a gap",
+			values: map[int]float64{2018: 10, 2020: 100, 2021: 110},
+			to:     2022,
+			want:   map[int]float64{2022: 121},
+		},
+		{
+			name:   "one year stays flat",
+			values: map[int]float64{2021: 120},
+			to:     2023,
+			want:   map[int]float64{2022: 120, 2023: 120},
+		},
+		{
+			name:   "nothing to extend",
+			values: map[int]float64{2021: 100, 2022: 110},
+			to:     2022,
+			want:   map[int]float64{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testIndicator(tt.values)
+			s.ExtendTo(tt.to)
+			for _, v := range s.Values() {
+				want, estimated := tt.want[v.Year]
+				if !estimated {
+					want = tt.values[v.Year]
+				}
+				if v.Estimated != estimated || math.Abs(v.Value-want) > 1e-9 {
+					t.Errorf("%d: %v estimated=%v, want %v estimated=%v", v.Year, v.Value, v.Estimated, want, estimated)
+				}
+			}
+			if n := len(s.Values()); n != len(tt.values)+len(tt.want) {
+				t.Errorf("%d years, want %d", n, len(tt.values)+len(tt.want))
+			}
+		})
+	}
+}
+
+func TestIndicatorDeflate(t *testing.T) {
+	s := testIndicator(map[int]float64{2020: 100, 2021: 110, 2022: 125})
+	s.ExtendTo(2023)
+
+	tests := []struct {
+		amount    float64
+		date      string
+		base      int
+		want      float64
+		estimated bool
+		ok        bool
+	}{
+		{250, "2022-05-15", 2020, 200, false, true},
+		{200, "2020-01-15", 2022, 250, false, true},
+		{110, "2021", 2021, 110, false, true},
+		{100, "2023-01-15", 2022, 100 / math.Sqrt(1.25), true, true},
+		{100, "2022-01-15", 2023, 100 * math.Sqrt(1.25), true, true},
+		{100, "2019-01-15", 2020, 0, false, false},
+		{100, "2020-01-15", 2030, 0, false, false},
+		{100, "soon", 2020, 0, false, false},
+	}
+	for _, tt := range tests {
+		got, estimated, err := s.Deflate(tt.amount, tt.date, tt.base)
+		if (err == nil) != tt.ok || math.Abs(got-tt.want) > 1e-9 || estimated != tt.estimated {
+			t.Errorf("Deflate(%v, %q, %d) = %v, %v, %v; want %v, %v, ok=%v",
+				tt.amount, tt.date, tt.base, got, estimated, err, tt.want, tt.estimated, tt.ok)
+		}
+	}
+}
+
+func TestLoadWorldBankIndicator(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		ok   bool
+	}{
+		{"response", `[{"page":1,"pages":1,"total":3},[
+			{"indicator":{"id":"FP.CPI.TOTL","value":"CPI"},"countryiso3code":"KEN","date":"2024","value":null},
+			{"indicator":{"id":"FP.CPI.TOTL","value":"CPI"},"countryiso3code":"KEN","date":"2023","value":120},
+			{"indicator":{"id":"FP.CPI.TOTL","value":"CPI"},"countryiso3code":"KEN","date":"2022","value":110}]]`, true},
+		{"API error", `[{"message":[{"id":"120","value":"Invalid value"}]}]`, false},
+		{"no values", `[{"page":1,"pages":1,"total":1},[{"date":"2024","value":null}]]`, false},
+		{"bad year", `[{"page":1,"pages":1,"total":1},[{"date":"MRV","value":1}]]`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "cpi.json")
+			if err := os.WriteFile(path, []byte(tt.body), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			s, err := LoadWorldBankIndicator(path)
+			if (err == nil) != tt.ok {
+				t.Fatalf("got %v, want ok=%v", err, tt.ok)
+			}
+			if !tt.ok {
+				return
+			}
+			if s.Indicator != "FP.CPI.TOTL" || s.Country != "KEN" || s.FirstObserved != 2022 || s.LastObserved != 2023 || s.Incomplete {
+				t.Errorf("series %+v", s)
+			}
+			if _, _, ok := s.Value(2024); ok {
+				t.Error("null value loaded")
+			}
+		})
+	}
+}